@@ -0,0 +1,53 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package admin
+
+import (
+	"net/http"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+)
+
+type AdminHandlers struct {
+	registry *etcdregistry.EtcdRegistry
+}
+
+func NewAdminHandlers(registry *etcdregistry.EtcdRegistry) *AdminHandlers {
+	return &AdminHandlers{registry}
+}
+
+// RotateKeysHandler re-encrypts all encrypted values of the registry with the primary key
+func (a *AdminHandlers) RotateKeysHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	if !helper.IsAdmin(req) {
+		helper.HandleError(writer, logger, 403, "Rotating keys needs the admin token")
+		return
+	}
+
+	report, err := a.registry.RotateKeys()
+	if err == etcdregistry.ErrNoEncryptionKey {
+		helper.HandleError(writer, logger, 400, "No encryption key configured, see the -encryptionkeyfile flag")
+		return
+	} else if err != nil {
+		helper.HandleError(writer, logger, 500, "Error rotating keys: %v", err)
+		return
+	}
+
+	helper.HandleSuccess(writer, logger, report, "Rotated %v entries to key %v, %v conflicts", report.Rotated, report.PrimaryKeyId, report.Conflicts)
+}
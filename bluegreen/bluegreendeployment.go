@@ -16,16 +16,12 @@ limitations under the License.
 package bluegreen
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"time"
 
-	"strings"
-
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/cluster"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/events"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/k8s"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
 	"k8s.io/client-go/pkg/api/v1"
@@ -67,6 +63,15 @@ func (bluegreen *bluegreen) Deploy() error {
 		return err
 	}
 
+	// created before the proxy is switched, errors can't be returned afterwards
+	if descriptor.DisruptionBudget != nil {
+		logger.Println("Creating / Updating disruption budget")
+		if err := bluegreen.clusterManager.CreateOrUpdateDisruptionBudget(); err != nil {
+			logger.Println(err.Error())
+			return err
+		}
+	}
+
 	if descriptor.Frontend != "" && len(service.Spec.Ports) > 0 {
 		if err := bluegreen.clusterManager.Config.IngressConfigurator.
 			CreateOrUpdateProxy(deployment, service, logger); err != nil {
@@ -86,13 +91,21 @@ func (bluegreen *bluegreen) Deploy() error {
 	logger.Println("Cleaning up old deployments")
 	bluegreen.clusterManager.CleanUpOldDeployments()
 
+	// autoscaling or the disruption budget might have been removed from the descriptor
+	if descriptor.Autoscaling == nil {
+		bluegreen.clusterManager.DeleteAutoscaler()
+	}
+	if descriptor.DisruptionBudget == nil {
+		bluegreen.clusterManager.DeleteDisruptionBudget()
+	}
+
 	// set status of previous deployment to undeployed
 	if deployments, err := bluegreen.clusterManager.Registry.GetDeployments(deployment.Descriptor.Namespace); err != nil {
 		logger.Println("WARNING: couldn't update old deployment status to UNDEPLOYED")
 	} else {
 		for _, oldDeployment := range deployments {
 			if oldDeployment.Id != deployment.Id &&
-				oldDeployment.Status == types.DEPLOYMENTSTATUS_DEPLOYED &&
+				(oldDeployment.Status == types.DEPLOYMENTSTATUS_DEPLOYED || oldDeployment.Status == types.DEPLOYMENTSTATUS_PAUSED) &&
 				oldDeployment.Descriptor.AppName == deployment.Descriptor.AppName {
 
 				logger.Println("Updating deployment status of old deployment")
@@ -108,6 +121,9 @@ func (bluegreen *bluegreen) Deploy() error {
 
 					logger.Println("WARNING: couldn't update old deployment logs")
 				}
+
+				bluegreen.clusterManager.Config.Events.Publish(events.NewDeploymentEvent(events.EVENT_DEPLOYMENT_UNDEPLOYED,
+					oldDeployment, "Undeployed during deployment of %v", deployment.Id))
 			}
 		}
 	}
@@ -121,6 +137,9 @@ func (bluegreen *bluegreen) Deploy() error {
 		logger.Println("WARNING: couldn't update deployment status to DEPLOYED!")
 	}
 
+	bluegreen.clusterManager.Config.Events.Publish(events.NewDeploymentEvent(events.EVENT_DEPLOYMENT_SUCCEEDED,
+		deployment, "Deployment of %v version %v successful", descriptor.AppName, deployment.Version))
+
 	logger.Println("Blue-green deployment successful")
 	return nil
 }
@@ -134,6 +153,13 @@ func (bluegreen *bluegreen) createReplicationController() error {
 		return err
 	}
 
+	// the autoscaler is moved before waiting, so it can scale the new version when it gets load
+	if descriptor.Autoscaling != nil {
+		if err := bluegreen.clusterManager.CreateOrUpdateAutoscaler(); err != nil {
+			return err
+		}
+	}
+
 	if descriptor.Replicas == 0 {
 		return nil
 	}
@@ -185,12 +211,32 @@ func (bluegreen *bluegreen) checkPods(name, version string, healthChan chan bool
 					return
 				}
 
-				nrOfPods := k8s.CountRunningPods(pods.Items)
+				// the autoscaler can change the replicas while waiting
+				rc, getErr := bluegreen.clusterManager.Config.K8sClient.GetReplicationController(descriptor.Namespace, name)
+				if getErr != nil {
+					bluegreen.clusterManager.Logger.Printf(fmt.Sprintf("Error getting replication controller for new deployment: %v\n", getErr))
+					healthChan <- false
+
+					return
+				}
+				replicas := descriptor.Replicas
+				if rc.Spec.Replicas != nil {
+					replicas = int(*rc.Spec.Replicas)
+				}
+
+				// pods which are scaled down are not waited for
+				activePods := []v1.Pod{}
+				for _, pod := range pods.Items {
+					if pod.DeletionTimestamp == nil {
+						activePods = append(activePods, pod)
+					}
+				}
+				nrOfPods := k8s.CountRunningPods(activePods)
 
-				if nrOfPods == descriptor.Replicas {
+				if nrOfPods == replicas {
 					healthy := true
 
-					for _, pod := range pods.Items {
+					for _, pod := range activePods {
 						if !bluegreen.checkPodHealth(&pod) {
 							healthy = false
 							break
@@ -217,61 +263,16 @@ func (bluegreen *bluegreen) checkPods(name, version string, healthChan chan bool
 
 func (bluegreen *bluegreen) checkPodHealth(pod *v1.Pod) bool {
 
-	descriptor := bluegreen.clusterManager.Deployment.Descriptor
-
-	var resp *http.Response
-	var err error
-
-	port := cluster.FindHealthcheckPort(pod)
-	url := bluegreen.clusterManager.GetHealthcheckUrl(pod.Status.PodIP, port)
-
-	//bluegreen.deployer.Logger.Printf("Checking pod health with healthcheck type %s on url %s",
-	//	descriptor.HealthCheckType, url);
-
-	if strings.EqualFold(descriptor.HealthCheckType, "simple") {
-		resp, err = http.Get(url)
-		if err != nil {
-			bluegreen.logHealth(pod, "{\"simplehealthcheck\": \"http get failed\"}")
-			return false
-		}
-		if resp.StatusCode != 200 {
-			bluegreen.logHealth(pod, "{\"simplehealthcheck\": \"http get statuscode != 200\"}")
-			return false
-		}
-		bluegreen.logHealth(pod, "{\"simplehealthcheck\": \"http get success\"}")
-		return true
-	} else {
-		// default to healthcheck type "probe"
-		resp, err = http.Post(url, "application/json", nil)
-		if err != nil {
-			bluegreen.logHealth(pod, "{\"probehealthcheck\": \"failed: "+err.Error()+"\"}")
-			return false
-		}
-		defer resp.Body.Close()
-
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			bluegreen.logHealth(pod, "{\"probehealthcheck\": \"failed: "+err.Error()+"\"}")
-			return false
-		}
-
-		bluegreen.logHealth(pod, string(body))
-
-		var dat = HealthCheckEvent{}
-		if err := json.Unmarshal(body, &dat); err != nil {
-			bluegreen.clusterManager.Logger.Println("Error parsing healthcheck: " + err.Error())
-			return false
-		}
-
-		return dat.Healthy
+	healthy, health, err := cluster.CheckPodHealth(bluegreen.clusterManager.Deployment.Descriptor, pod)
+	bluegreen.logHealth(pod, health)
+	if err != nil {
+		bluegreen.clusterManager.Logger.Println("Error parsing healthcheck: " + err.Error())
 	}
+
+	return healthy
 }
 
 func (bluegreen *bluegreen) logHealth(pod *v1.Pod, health string) {
 	descriptor := bluegreen.clusterManager.Deployment.Descriptor
 	bluegreen.clusterManager.Config.EtcdRegistry.StoreHealth(descriptor.Namespace, bluegreen.clusterManager.Deployment.Id, pod.Name, health)
 }
-
-type HealthCheckEvent struct {
-	Healthy bool `json:"healthy,omitempty"`
-}
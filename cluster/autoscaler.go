@@ -0,0 +1,224 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cluster
+
+import (
+	"encoding/json"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	autoscalingapi "k8s.io/client-go/pkg/apis/autoscaling"
+	autoscaling "k8s.io/client-go/pkg/apis/autoscaling/v1"
+	"k8s.io/client-go/pkg/apis/autoscaling/v2alpha1"
+)
+
+// BuildAutoscaler returns the Horizontal Pod Autoscaler of the app, scaling the replication controller of the deployment,
+// without creating it. An existing autoscaler is updated. It returns nil if the descriptor has no autoscaling.
+func (cm *ClusterManager) BuildAutoscaler(existing *autoscaling.HorizontalPodAutoscaler) *autoscaling.HorizontalPodAutoscaler {
+	descriptor := cm.Deployment.Descriptor
+	settings := descriptor.Autoscaling
+	if settings == nil {
+		return nil
+	}
+
+	hpa := existing
+	if hpa == nil {
+		hpa = new(autoscaling.HorizontalPodAutoscaler)
+		hpa.Name = descriptor.AppName
+	}
+	if hpa.Labels == nil {
+		hpa.Labels = map[string]string{}
+	}
+	hpa.Labels["app"] = descriptor.AppName
+
+	minReplicas := int32(settings.MinReplicas)
+	hpa.Spec = autoscaling.HorizontalPodAutoscalerSpec{
+		ScaleTargetRef: autoscaling.CrossVersionObjectReference{
+			Kind:       "ReplicationController",
+			Name:       cm.Deployment.GetVersionedName(),
+			APIVersion: "v1",
+		},
+		MinReplicas: &minReplicas,
+		MaxReplicas: int32(settings.MaxReplicas),
+	}
+	if settings.TargetCPUUtilization > 0 {
+		cpu := int32(settings.TargetCPUUtilization)
+		hpa.Spec.TargetCPUUtilizationPercentage = &cpu
+	}
+
+	// autoscaling/v1 only knows CPU targets, other metrics are given with an annotation
+	delete(hpa.Annotations, autoscalingapi.MetricSpecsAnnotation)
+	if settings.TargetMemoryUtilization > 0 {
+		memory := int32(settings.TargetMemoryUtilization)
+		metrics, _ := json.Marshal([]v2alpha1.MetricSpec{{
+			Type:     v2alpha1.ResourceMetricSourceType,
+			Resource: &v2alpha1.ResourceMetricSource{Name: v1.ResourceMemory, TargetAverageUtilization: &memory},
+		}})
+		if hpa.Annotations == nil {
+			hpa.Annotations = map[string]string{}
+		}
+		hpa.Annotations[autoscalingapi.MetricSpecsAnnotation] = string(metrics)
+	}
+
+	return hpa
+}
+
+// CreateOrUpdateAutoscaler moves the autoscaler of the app to the replication controller of the deployment.
+// The previous autoscaler is restored by CleanupFailedDeployment.
+func (cm *ClusterManager) CreateOrUpdateAutoscaler() error {
+	namespace := cm.Deployment.Descriptor.Namespace
+
+	existing, err := cm.Config.K8sClient.GetHorizontalPodAutoscaler(namespace, cm.Deployment.Descriptor.AppName)
+	if statusError, isStatus := err.(*errors.StatusError); isStatus && statusError.Status().Reason == meta.StatusReasonNotFound {
+		cm.Logger.Printf("Creating autoscaler %v", cm.Deployment.Descriptor.AppName)
+		if _, err := cm.Config.K8sClient.CreateHorizontalPodAutoscaler(namespace, cm.BuildAutoscaler(nil)); err != nil {
+			return err
+		}
+		cm.autoscalerChanged = true
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	previous := *existing
+	previous.Annotations = copyMap(existing.Annotations)
+	cm.Logger.Printf("Moving autoscaler %v to %v", existing.Name, cm.Deployment.GetVersionedName())
+	if _, err := cm.Config.K8sClient.UpdateHorizontalPodAutoscaler(namespace, cm.BuildAutoscaler(existing)); err != nil {
+		return err
+	}
+	cm.previousAutoscaler = &previous
+	cm.autoscalerChanged = true
+	return nil
+}
+
+// restoreAutoscaler moves a changed autoscaler back to the previous version, or deletes it if it was created
+func (cm *ClusterManager) restoreAutoscaler() {
+	if !cm.autoscalerChanged {
+		return
+	}
+	namespace := cm.Deployment.Descriptor.Namespace
+	name := cm.Deployment.Descriptor.AppName
+
+	if cm.previousAutoscaler == nil {
+		cm.Logger.Printf("  Deleting autoscaler %v", name)
+		cm.DeleteAutoscaler()
+		return
+	}
+
+	current, err := cm.Config.K8sClient.GetHorizontalPodAutoscaler(namespace, name)
+	if err != nil {
+		cm.Logger.Printf("  Error getting autoscaler %v: %v", name, err)
+		return
+	}
+	current.Spec = cm.previousAutoscaler.Spec
+	current.Annotations = cm.previousAutoscaler.Annotations
+	cm.Logger.Printf("  Moving autoscaler %v back to %v", name, current.Spec.ScaleTargetRef.Name)
+	if _, err := cm.Config.K8sClient.UpdateHorizontalPodAutoscaler(namespace, current); err != nil {
+		cm.Logger.Printf("  Error restoring autoscaler %v: %v", name, err)
+	}
+}
+
+// DeleteAutoscaler deletes the autoscaler of the app, if it exists
+func (cm *ClusterManager) DeleteAutoscaler() {
+	descriptor := cm.Deployment.Descriptor
+	err := cm.Config.K8sClient.DeleteHorizontalPodAutoscaler(descriptor.Namespace, descriptor.AppName)
+	if statusError, isStatus := err.(*errors.StatusError); isStatus && statusError.Status().Reason == meta.StatusReasonNotFound {
+		return
+	} else if err != nil {
+		cm.Logger.Printf("Error deleting autoscaler %v: %v", descriptor.AppName, err)
+		return
+	}
+	cm.Logger.Printf("Deleted autoscaler %v", descriptor.AppName)
+}
+
+// StartReplicas returns the number of replicas the replication controller of the deployment starts with.
+// With autoscaling it starts with the replicas of the running version within the min and max replicas,
+// so a deployment doesn't scale down an app under load. Without cluster access it's the min replicas.
+// Without autoscaling it keeps the replicas the running deployment was scaled to, unless the descriptor changed them.
+func (cm *ClusterManager) StartReplicas() (int, error) {
+	descriptor := cm.Deployment.Descriptor
+	settings := descriptor.Autoscaling
+	if settings == nil {
+		if cm.Deployment.Replicas > 0 {
+			return cm.Deployment.Replicas, nil
+		}
+		scaled, err := cm.scaledReplicas()
+		if err != nil || scaled == 0 {
+			return descriptor.Replicas, err
+		}
+		return scaled, nil
+	}
+
+	replicas := 0
+	if cm.Config != nil && cm.Config.K8sClient != nil {
+		controllers, err := cm.FindOldReplicationControllers()
+		if err != nil {
+			return 0, err
+		}
+		for _, rc := range controllers {
+			if rc.DeletionTimestamp == nil && rc.Spec.Replicas != nil && int(*rc.Spec.Replicas) > replicas {
+				replicas = int(*rc.Spec.Replicas)
+			}
+		}
+	}
+
+	if replicas < settings.MinReplicas {
+		replicas = settings.MinReplicas
+	}
+	if replicas > settings.MaxReplicas {
+		replicas = settings.MaxReplicas
+	}
+	return replicas, nil
+}
+
+// ResolveStartReplicas sets the replicas of the replication controller of the deployment, see StartReplicas
+func (cm *ClusterManager) ResolveStartReplicas() error {
+	replicas, err := cm.StartReplicas()
+	if err != nil {
+		return err
+	}
+	cm.startReplicas = replicas
+
+	// the kept scale is recorded, so it's kept by the next deployment too
+	if cm.Deployment.Descriptor.Autoscaling == nil && cm.Deployment.Replicas == 0 && replicas != cm.Deployment.Descriptor.Replicas {
+		cm.Logger.Printf("Keeping the scale of %v replicas of the running deployment", replicas)
+		cm.Deployment.Replicas = replicas
+	}
+	return nil
+}
+
+// replicas returns the replicas of the replication controller of the deployment
+func (cm *ClusterManager) replicas() int {
+	if cm.startReplicas > 0 {
+		return cm.startReplicas
+	}
+	if settings := cm.Deployment.Descriptor.Autoscaling; settings != nil {
+		return settings.MinReplicas
+	}
+	return cm.Deployment.GetReplicas()
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	result := map[string]string{}
+	for key, val := range m {
+		result[key] = val
+	}
+	return result
+}
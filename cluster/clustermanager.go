@@ -18,34 +18,51 @@ package cluster
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/secrets"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
 	"k8s.io/apimachinery/pkg/api/errors"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/pkg/api/v1"
+	autoscaling "k8s.io/client-go/pkg/apis/autoscaling/v1"
 )
 
 const DNS952LabelFmt string = "[a-z]([-a-z0-9]*[a-z0-9])?"
 
 var dns952LabelRegexp = regexp.MustCompile("^" + DNS952LabelFmt + "$")
 
+// name of the volume with the config files of the deployment
+const CONFIGFILES_VOLUME = "deployer-config-files"
+
+// don't let a hanging pod block deployments or the health monitor
+var healthClient = &http.Client{Timeout: 10 * time.Second}
+
 type ClusterManager struct {
 	Config     *helper.DeployerConfig
 	Deployment *types.Deployment
 	Registry   *etcdregistry.EtcdRegistry
 	Logger     logger.Logger
+
+	// replicas of the new replication controller with autoscaling, see StartReplicas
+	startReplicas int
+	// autoscaler before it was moved to the deployment, nil if it was created
+	previousAutoscaler *autoscaling.HorizontalPodAutoscaler
+	autoscalerChanged  bool
 }
 
 func NewClusterManager(config helper.DeployerConfig, deployment *types.Deployment, registry *etcdregistry.EtcdRegistry, logger logger.Logger) *ClusterManager {
 
-	return &ClusterManager{&config, deployment, registry, logger}
+	return &ClusterManager{Config: &config, Deployment: deployment, Registry: registry, Logger: logger}
 
 }
 
@@ -53,6 +70,153 @@ func (cm *ClusterManager) CreateReplicationController() (*v1.ReplicationControll
 
 	descriptor := cm.Deployment.Descriptor
 
+	if len(descriptor.SecretEnvironment) > 0 {
+		if err := cm.createOrUpdateEnvironmentSecret(); err != nil {
+			cm.Logger.Printf("Error while creating environment secret: %v", err)
+			return nil, err
+		}
+	}
+
+	// without resolved values the secret of the previous deployment is used, e.g. when healing drift
+	if len(descriptor.ProviderSecrets) > 0 {
+		if err := cm.createOrUpdateSecret(cm.BuildProviderSecret()); err != nil {
+			cm.Logger.Printf("Error while creating provider secret: %v", err)
+			return nil, err
+		}
+	}
+
+	if err := cm.ResolveStartReplicas(); err != nil {
+		cm.Logger.Printf("Error while getting replicas of the running version: %v", err)
+		return nil, err
+	}
+
+	if configMap := cm.BuildConfigMap(); configMap != nil {
+		if err := cm.createOrUpdateConfigMap(configMap); err != nil {
+			cm.Logger.Printf("Error while creating config map: %v", err)
+			return nil, err
+		}
+	}
+
+	ctrl := cm.BuildReplicationController()
+
+	result, err := cm.Config.K8sClient.CreateReplicationController(descriptor.Namespace, ctrl)
+	if err != nil {
+		cm.Logger.Println("Error while creating replication controller")
+		return result, err
+	}
+
+	cm.Logger.Printf("Replication Controller %v created\n", result.ObjectMeta.Name)
+	return result, nil
+
+}
+
+// BuildEnvironmentSecret returns the secret holding the secret environment vars of the app, without creating it
+func (cm *ClusterManager) BuildEnvironmentSecret() *v1.Secret {
+	descriptor := cm.Deployment.Descriptor
+
+	secret := new(v1.Secret)
+	secret.Name = descriptor.EnvironmentSecretName()
+	secret.Labels = map[string]string{"app": descriptor.AppName}
+	secret.Type = v1.SecretTypeOpaque
+	secret.Data = map[string][]byte{}
+	for key, val := range descriptor.SecretEnvironment {
+		secret.Data[key] = []byte(val)
+	}
+	return secret
+}
+
+// BuildProviderSecret returns the secret holding the values of the secret references of the app, without creating it
+func (cm *ClusterManager) BuildProviderSecret() *v1.Secret {
+	descriptor := cm.Deployment.Descriptor
+
+	secret := new(v1.Secret)
+	secret.Name = descriptor.ProviderSecretName()
+	secret.Labels = map[string]string{"app": descriptor.AppName}
+	secret.Type = v1.SecretTypeOpaque
+	secret.Data = map[string][]byte{}
+	for key, val := range descriptor.ProviderSecrets {
+		secret.Data[key] = []byte(val)
+	}
+	return secret
+}
+
+func (cm *ClusterManager) createOrUpdateEnvironmentSecret() error {
+	return cm.createOrUpdateSecret(cm.BuildEnvironmentSecret())
+}
+
+func (cm *ClusterManager) createOrUpdateSecret(secret *v1.Secret) error {
+	namespace := cm.Deployment.Descriptor.Namespace
+
+	existing, err := cm.Config.K8sClient.GetSecret(namespace, secret.Name)
+	if statusError, isStatus := err.(*errors.StatusError); isStatus && statusError.Status().Reason == meta.StatusReasonNotFound {
+		cm.Logger.Printf("Creating secret %v", secret.Name)
+		_, err = cm.Config.K8sClient.CreateSecret(namespace, secret)
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	// keep keys of removed vars, pods of the running version might still reference them
+	if existing.Data == nil {
+		existing.Data = map[string][]byte{}
+	}
+	for key, val := range secret.Data {
+		existing.Data[key] = val
+	}
+	cm.Logger.Printf("Updating secret %v", secret.Name)
+	_, err = cm.Config.K8sClient.UpdateSecret(namespace, existing)
+	return err
+}
+
+// BuildConfigMap returns the versioned config map with the config files of the deployment, without creating it.
+// It returns nil if the descriptor has no config files.
+func (cm *ClusterManager) BuildConfigMap() *v1.ConfigMap {
+	descriptor := cm.Deployment.Descriptor
+	if len(descriptor.ConfigFiles) == 0 {
+		return nil
+	}
+
+	name := cm.Deployment.GetVersionedName()
+	configMap := new(v1.ConfigMap)
+	configMap.Name = name
+	configMap.Labels = map[string]string{
+		"name":    name,
+		"version": cm.Deployment.Version,
+		"app":     descriptor.AppName,
+	}
+	configMap.Data = map[string]string{}
+	for _, file := range descriptor.ConfigFiles {
+		configMap.Data[file.Name] = file.Content
+	}
+	return configMap
+}
+
+func (cm *ClusterManager) createOrUpdateConfigMap(configMap *v1.ConfigMap) error {
+	namespace := cm.Deployment.Descriptor.Namespace
+
+	// a config map of the same version can be left over from a failed deployment
+	existing, err := cm.Config.K8sClient.GetConfigMap(namespace, configMap.Name)
+	if statusError, isStatus := err.(*errors.StatusError); isStatus && statusError.Status().Reason == meta.StatusReasonNotFound {
+		cm.Logger.Printf("Creating config map %v", configMap.Name)
+		_, err = cm.Config.K8sClient.CreateConfigMap(namespace, configMap)
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	existing.Labels = configMap.Labels
+	existing.Data = configMap.Data
+	cm.Logger.Printf("Updating config map %v", configMap.Name)
+	_, err = cm.Config.K8sClient.UpdateConfigMap(namespace, existing)
+	return err
+}
+
+// BuildReplicationController returns the replication controller of the deployment, without creating it.
+// Note that it adds the deployer's env vars to the containers of the descriptor.
+func (cm *ClusterManager) BuildReplicationController() *v1.ReplicationController {
+
+	descriptor := cm.Deployment.Descriptor
+
 	rcName := cm.Deployment.GetVersionedName()
 	ctrl := new(v1.ReplicationController)
 	ctrl.Name = rcName
@@ -92,6 +256,12 @@ func (cm *ClusterManager) CreateReplicationController() (*v1.ReplicationControll
 		for key, val := range descriptor.Environment {
 			container.Env = append(container.Env, v1.EnvVar{Name: key, Value: val})
 		}
+		for key := range descriptor.SecretEnvironment {
+			container.Env = append(container.Env, v1.EnvVar{Name: key, ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{Name: descriptor.EnvironmentSecretName()},
+				Key:                  key,
+			}}})
+		}
 
 		containers = append(containers, container)
 	}
@@ -101,7 +271,7 @@ func (cm *ClusterManager) CreateReplicationController() (*v1.ReplicationControll
 	bytes, _ := json.MarshalIndent(descriptor.PodSpec, "", "  ")
 	fmt.Printf("%v", string(bytes))
 
-	replicas := int32(descriptor.Replicas)
+	replicas := int32(cm.replicas())
 
 	ctrl.Spec = v1.ReplicationControllerSpec{
 		Selector: map[string]string{
@@ -118,23 +288,84 @@ func (cm *ClusterManager) CreateReplicationController() (*v1.ReplicationControll
 					"app":     descriptor.AppName,
 				},
 			},
-			Spec: descriptor.PodSpec,
+			Spec: cm.mountConfigFiles(cm.referenceProviderSecrets(descriptor.PodSpec)),
 		},
 	}
 
-	result, err := cm.Config.K8sClient.CreateReplicationController(descriptor.Namespace, ctrl)
-	if err != nil {
-		cm.Logger.Println("Error while creating replication controller")
-		return result, err
+	return ctrl
+}
+
+// referenceProviderSecrets returns a copy of the pod spec, with env vars which reference a secret provider
+// injected from the provider secret of the app. The descriptor keeps the references for later deployments.
+func (cm *ClusterManager) referenceProviderSecrets(podSpec v1.PodSpec) v1.PodSpec {
+	if cm.Config == nil || cm.Config.SecretResolver == nil {
+		return podSpec
 	}
+	resolver := cm.Config.SecretResolver
 
-	cm.Logger.Printf("Replication Controller %v created\n", result.ObjectMeta.Name)
-	return result, nil
+	descriptor := cm.Deployment.Descriptor
+	containers := []v1.Container{}
+	for _, container := range podSpec.Containers {
+		env := []v1.EnvVar{}
+		for _, envVar := range container.Env {
+			if _, isReference := resolver.Parse(envVar.Value); isReference {
+				envVar = v1.EnvVar{Name: envVar.Name, ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{
+					LocalObjectReference: v1.LocalObjectReference{Name: descriptor.ProviderSecretName()},
+					Key:                  secrets.SecretKey(container.Name, envVar.Name),
+				}}}
+			}
+			env = append(env, envVar)
+		}
+		container.Env = env
+		containers = append(containers, container)
+	}
+	podSpec.Containers = containers
+	return podSpec
+}
+
+// mountConfigFiles returns a copy of the pod spec, with the config files mounted into all containers.
+// The descriptor is not changed, so later deployments don't mount them twice.
+func (cm *ClusterManager) mountConfigFiles(podSpec v1.PodSpec) v1.PodSpec {
+	descriptor := cm.Deployment.Descriptor
+	if len(descriptor.ConfigFiles) == 0 {
+		return podSpec
+	}
 
+	volumes := make([]v1.Volume, len(podSpec.Volumes), len(podSpec.Volumes)+1)
+	copy(volumes, podSpec.Volumes)
+	podSpec.Volumes = append(volumes, v1.Volume{
+		Name: CONFIGFILES_VOLUME,
+		VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{
+			LocalObjectReference: v1.LocalObjectReference{Name: cm.Deployment.GetVersionedName()},
+		}},
+	})
+
+	containers := []v1.Container{}
+	for _, container := range podSpec.Containers {
+		mounts := make([]v1.VolumeMount, len(container.VolumeMounts), len(container.VolumeMounts)+len(descriptor.ConfigFiles))
+		copy(mounts, container.VolumeMounts)
+		for _, file := range descriptor.ConfigFiles {
+			mounts = append(mounts, v1.VolumeMount{Name: CONFIGFILES_VOLUME, MountPath: file.MountPath, SubPath: file.Name, ReadOnly: true})
+		}
+		container.VolumeMounts = mounts
+		containers = append(containers, container)
+	}
+	podSpec.Containers = containers
+	return podSpec
 }
 
 func (cm *ClusterManager) CreateService() (*v1.Service, error) {
 
+	srv := cm.BuildService()
+
+	cm.Logger.Println("Creating Service")
+
+	return cm.Config.K8sClient.CreateService(cm.Deployment.Descriptor.Namespace, srv)
+}
+
+// BuildService returns the versioned service of the deployment, without creating it
+func (cm *ClusterManager) BuildService() *v1.Service {
+
 	descriptor := cm.Deployment.Descriptor
 
 	name := cm.Deployment.GetVersionedName()
@@ -168,9 +399,7 @@ func (cm *ClusterManager) CreateService() (*v1.Service, error) {
 		SessionAffinity: "None",
 	}
 
-	cm.Logger.Println("Creating Service")
-
-	return cm.Config.K8sClient.CreateService(descriptor.Namespace, srv)
+	return srv
 }
 
 func (cm *ClusterManager) CreateOrUpdatePersistentService() (*v1.Service, error) {
@@ -182,20 +411,9 @@ func (cm *ClusterManager) CreateOrUpdatePersistentService() (*v1.Service, error)
 	if err == nil {
 		cm.Logger.Printf("Persistent service %v already exists on IP %v", svc.Name, svc.Spec.ClusterIP)
 
-		// update port, they might have changed
-		cm.Logger.Println("Updating persistent service ports")
-		newPorts := getPorts(descriptor.PodSpec.Containers)
-		svc.Spec.Ports = newPorts
-
-		cm.Logger.Println("Updating persistent service version selector")
+		cm.Logger.Println("Updating persistent service ports and version selector")
 		deployment.OldVersion = svc.Spec.Selector["version"]
-		svc.Spec.Selector["version"] = deployment.Version
-
-		// update session affinity, will be handled by nginx
-		svc.Spec.SessionAffinity = "None"
-
-		// update service type, used to be NodePort, which is not needed
-		svc.Spec.Type = v1.ServiceTypeClusterIP
+		svc = cm.BuildPersistentService(svc)
 
 		_, err := cm.Config.K8sClient.UpdateService(descriptor.Namespace, svc)
 		if err != nil {
@@ -206,28 +424,7 @@ func (cm *ClusterManager) CreateOrUpdatePersistentService() (*v1.Service, error)
 		return svc, nil
 
 	} else if statusError, isStatus := err.(*errors.StatusError); isStatus && statusError.Status().Reason == meta.StatusReasonNotFound {
-		svc := new(v1.Service)
-		svc.Name = descriptor.AppName
-
-		labels := make(map[string]string)
-		labels["app"] = descriptor.AppName
-		labels["name"] = descriptor.AppName
-		labels["persistent"] = "true"
-
-		svc.Labels = labels
-
-		ports := getPorts(descriptor.PodSpec.Containers)
-
-		selector := make(map[string]string)
-		selector["app"] = descriptor.AppName
-		selector["version"] = deployment.Version
-
-		svc.Spec = v1.ServiceSpec{
-			Selector:        selector,
-			Ports:           ports,
-			Type:            v1.ServiceTypeClusterIP,
-			SessionAffinity: "None",
-		}
+		svc := cm.BuildPersistentService(nil)
 
 		created, err := cm.Config.K8sClient.CreateService(descriptor.Namespace, svc)
 
@@ -240,6 +437,57 @@ func (cm *ClusterManager) CreateOrUpdatePersistentService() (*v1.Service, error)
 	}
 }
 
+// BuildPersistentService returns the persistent service pointing to the deployment's version, without creating or updating it.
+// The existing service is updated in place, a new service is returned if there is none.
+func (cm *ClusterManager) BuildPersistentService(existing *v1.Service) *v1.Service {
+
+	deployment := cm.Deployment
+	descriptor := deployment.Descriptor
+
+	if existing != nil {
+		// update port, they might have changed
+		existing.Spec.Ports = getPorts(descriptor.PodSpec.Containers)
+
+		if existing.Spec.Selector == nil {
+			existing.Spec.Selector = make(map[string]string)
+		}
+		existing.Spec.Selector["version"] = deployment.Version
+
+		// update session affinity, will be handled by nginx
+		existing.Spec.SessionAffinity = "None"
+
+		// update service type, used to be NodePort, which is not needed
+		existing.Spec.Type = v1.ServiceTypeClusterIP
+
+		return existing
+	}
+
+	svc := new(v1.Service)
+	svc.Name = descriptor.AppName
+
+	labels := make(map[string]string)
+	labels["app"] = descriptor.AppName
+	labels["name"] = descriptor.AppName
+	labels["persistent"] = "true"
+
+	svc.Labels = labels
+
+	ports := getPorts(descriptor.PodSpec.Containers)
+
+	selector := make(map[string]string)
+	selector["app"] = descriptor.AppName
+	selector["version"] = deployment.Version
+
+	svc.Spec = v1.ServiceSpec{
+		Selector:        selector,
+		Ports:           ports,
+		Type:            v1.ServiceTypeClusterIP,
+		SessionAffinity: "None",
+	}
+
+	return svc
+}
+
 func (cm *ClusterManager) DeleteOrResetPersistentService() {
 
 	deployment := cm.Deployment
@@ -350,6 +598,28 @@ func (cm *ClusterManager) FindOldServices() ([]v1.Service, error) {
 	return result, nil
 }
 
+// FindOldConfigMaps returns the config maps of the other versions of the app
+func (cm *ClusterManager) FindOldConfigMaps() ([]v1.ConfigMap, error) {
+
+	descriptor := cm.Deployment.Descriptor
+
+	result := []v1.ConfigMap{}
+
+	selector := map[string]string{"app": descriptor.AppName}
+	configMaps, err := cm.Config.K8sClient.ListConfigMapsWithSelector(descriptor.Namespace, selector)
+	if err != nil {
+		return result, err
+	}
+
+	for _, configMap := range configMaps.Items {
+		if configMap.Labels["version"] != cm.Deployment.Version {
+			result = append(result, configMap)
+		}
+	}
+
+	return result, nil
+}
+
 func (cm *ClusterManager) CleanUpOldDeployments() {
 	cm.Logger.Println("Looking for old ReplicationControllers...")
 	controllers, err := cm.FindOldReplicationControllers()
@@ -382,6 +652,16 @@ func (cm *ClusterManager) CleanUpOldDeployments() {
 			}
 		}
 	}
+
+	cm.Logger.Println("Looking for old ConfigMaps...")
+	configMaps, err := cm.FindOldConfigMaps()
+	if err == nil {
+		for _, configMap := range configMaps {
+			if configMap.Name != "" {
+				cm.deleteConfigMap(configMap.Name)
+			}
+		}
+	}
 }
 
 func (cm *ClusterManager) DeletePod(pod v1.Pod) {
@@ -399,6 +679,12 @@ func (cm *ClusterManager) deleteService(service v1.Service) {
 	cm.Config.K8sClient.DeleteService(descriptor.Namespace, service.Name)
 }
 
+func (cm *ClusterManager) deleteConfigMap(name string) {
+	descriptor := cm.Deployment.Descriptor
+	cm.Logger.Printf("Deleting ConfigMap %v", name)
+	cm.Config.K8sClient.DeleteConfigMap(descriptor.Namespace, name)
+}
+
 func FindHealthcheckPort(pod *v1.Pod) int32 {
 
 	ports := pod.Spec.Containers[0].Ports
@@ -423,8 +709,10 @@ func FindHealthcheckPort(pod *v1.Pod) int32 {
 }
 
 func (cm *ClusterManager) GetHealthcheckUrl(host string, port int32) string {
+	return GetHealthcheckUrl(cm.Deployment.Descriptor, host, port)
+}
 
-	descriptor := cm.Deployment.Descriptor
+func GetHealthcheckUrl(descriptor *types.Descriptor, host string, port int32) string {
 
 	var healthUrl string
 	if descriptor.HealthCheckPath != "" {
@@ -440,6 +728,50 @@ func (cm *ClusterManager) GetHealthcheckUrl(host string, port int32) string {
 	return fmt.Sprintf("http://%v:%v/%v", host, port, healthUrl)
 }
 
+// CheckPodHealth runs the healthcheck configured in the descriptor against the given pod.
+// It returns whether the pod is healthy and the healthcheck data which should be stored for the pod.
+// An error is only returned when the response of a probe healthcheck could not be parsed.
+func CheckPodHealth(descriptor *types.Descriptor, pod *v1.Pod) (bool, string, error) {
+
+	port := FindHealthcheckPort(pod)
+	url := GetHealthcheckUrl(descriptor, pod.Status.PodIP, port)
+
+	if strings.EqualFold(descriptor.HealthCheckType, "simple") {
+		resp, err := healthClient.Get(url)
+		if err != nil {
+			return false, "{\"simplehealthcheck\": \"http get failed\"}", nil
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return false, "{\"simplehealthcheck\": \"http get statuscode != 200\"}", nil
+		}
+		return true, "{\"simplehealthcheck\": \"http get success\"}", nil
+	} else {
+		// default to healthcheck type "probe"
+		resp, err := healthClient.Post(url, "application/json", nil)
+		if err != nil {
+			return false, "{\"probehealthcheck\": \"failed: " + err.Error() + "\"}", nil
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return false, "{\"probehealthcheck\": \"failed: " + err.Error() + "\"}", nil
+		}
+
+		var dat = HealthCheckEvent{}
+		if err := json.Unmarshal(body, &dat); err != nil {
+			return false, string(body), err
+		}
+
+		return dat.Healthy, string(body), nil
+	}
+}
+
+type HealthCheckEvent struct {
+	Healthy bool `json:"healthy,omitempty"`
+}
+
 func (cm *ClusterManager) findRcForDeployment() (*v1.ReplicationController, error) {
 	descriptor := cm.Deployment.Descriptor
 	return cm.Config.K8sClient.GetReplicationController(descriptor.Namespace, cm.Deployment.GetVersionedName())
@@ -460,6 +792,7 @@ func (cm *ClusterManager) CleanupFailedDeployment() {
 	cm.Logger.Println("Cleaning up resources created by deployment")
 
 	cm.DeleteOrResetPersistentService()
+	cm.restoreAutoscaler()
 
 	rc, err := cm.findRcForDeployment()
 	if err == nil {
@@ -480,6 +813,51 @@ func (cm *ClusterManager) CleanupFailedDeployment() {
 		cm.Logger.Printf("  Deleting Service %v", service.Name)
 		cm.deleteService(*service)
 	}
+
+	if len(cm.Deployment.Descriptor.ConfigFiles) > 0 {
+		cm.deleteConfigMap(cm.Deployment.GetVersionedName())
+	}
+}
+
+// ResolveVersion returns the version of the deployment. For autoincrement versions it is based on the version of the
+// active replication controller. Orphaned replication controllers are only deleted if deleteOrphans is true.
+func (cm *ClusterManager) ResolveVersion(deleteOrphans bool) (string, error) {
+	if cm.Deployment.Version != "000" {
+		return cm.Deployment.Version, nil
+	}
+
+	rc, err := cm.FindOldReplicationControllers()
+	if err != nil {
+		return "", fmt.Errorf("Error getting replication controllers for determining next version: %v", err.Error())
+	} else if len(rc) == 0 {
+		return "1", nil
+	}
+
+	// sometimes we have orphaned RCs, sort them out
+	var activeRcs = []v1.ReplicationController{}
+	for _, ctrl := range rc {
+		if ctrl.DeletionTimestamp == nil {
+			activeRcs = append(activeRcs, ctrl)
+		} else if deleteOrphans {
+			cm.Logger.Printf("Note: found orphaned replication controller %v, will try to finally delete it...\n", ctrl.Name)
+			cm.Config.K8sClient.DeleteReplicationController(ctrl.Namespace, ctrl.Name)
+		}
+	}
+
+	if len(activeRcs) == 0 {
+		return "1", nil
+	} else if len(activeRcs) > 1 {
+		return "", fmt.Errorf("Could not determine next deployment version, more than a singe Replication Controller found")
+	}
+
+	var ctrl = activeRcs[0]
+	cm.Logger.Println(ctrl.Name)
+	versionString := ctrl.Labels["version"]
+	newVersion, err := DetermineNewVersion(versionString)
+	if err != nil {
+		return "", fmt.Errorf("Could not determine next deployment version based on current version %v", err.Error())
+	}
+	return newVersion, nil
 }
 
 func DetermineNewVersion(oldVersion string) (string, error) {
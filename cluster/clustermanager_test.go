@@ -19,7 +19,10 @@ import (
 	"strings"
 	"testing"
 
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/secrets"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/pkg/api/v1"
 )
 
@@ -96,6 +99,19 @@ func TestValidateDeployment(t *testing.T) {
 	}
 }
 
+func TestValidateDisruptionBudget(t *testing.T) {
+	minAvailable := intstr.FromString("120%")
+	descriptor := types.Descriptor{DisruptionBudget: &types.DisruptionBudget{MinAvailable: &minAvailable}}
+	if err := descriptor.Validate(); err == nil || !strings.Contains(err.Error(), "must be a positive number or a percentage") {
+		t.Errorf("Expected invalid percentage, got %v", err)
+	}
+
+	descriptor.DisruptionBudget = &types.DisruptionBudget{}
+	if err := descriptor.Validate(); err == nil || !strings.Contains(err.Error(), "either minAvailable or maxUnavailable") {
+		t.Errorf("Expected missing budget, got %v", err)
+	}
+}
+
 func TestDetermineNextVersionIncorrect(t *testing.T) {
 	newVersion, err := DetermineNewVersion("1.1a")
 	if err == nil {
@@ -190,3 +206,243 @@ func TestFindHealthcheckPort_NoHealthPort(t *testing.T) {
 		t.Error("Invalid port found for pod")
 	}
 }
+
+func TestBuildPersistentService(t *testing.T) {
+
+	clusterManager := ClusterManager{
+		Deployment: &types.Deployment{
+			Version: "2",
+			Descriptor: &types.Descriptor{
+				AppName: "myapp",
+				PodSpec: v1.PodSpec{Containers: []v1.Container{
+					{Ports: []v1.ContainerPort{{Name: "http", ContainerPort: 8080}}},
+				}},
+			},
+		},
+	}
+
+	created := clusterManager.BuildPersistentService(nil)
+	if created.Name != "myapp" || created.Labels["persistent"] != "true" || created.Spec.Selector["version"] != "2" {
+		t.Errorf("Unexpected new persistent service: %+v", created)
+	}
+
+	existing := &v1.Service{Spec: v1.ServiceSpec{
+		Type:     v1.ServiceTypeNodePort,
+		Selector: map[string]string{"app": "myapp", "version": "1"},
+	}}
+	updated := clusterManager.BuildPersistentService(existing)
+	if updated.Spec.Selector["version"] != "2" || updated.Spec.Type != v1.ServiceTypeClusterIP {
+		t.Errorf("Unexpected updated persistent service: %+v", updated)
+	}
+	if len(updated.Spec.Ports) != 1 || updated.Spec.Ports[0].TargetPort.StrVal != "http" {
+		t.Errorf("Unexpected ports: %+v", updated.Spec.Ports)
+	}
+}
+
+func TestBuildReplicationControllerWithSecretEnvironment(t *testing.T) {
+
+	clusterManager := ClusterManager{
+		Deployment: &types.Deployment{
+			Version: "2",
+			Descriptor: &types.Descriptor{
+				AppName:           "myapp",
+				Environment:       map[string]string{"DB_HOST": "db"},
+				SecretEnvironment: map[string]string{"DB_PASSWORD": "secret"},
+				PodSpec:           v1.PodSpec{Containers: []v1.Container{{Name: "myapp"}}},
+			},
+		},
+	}
+
+	ctrl := clusterManager.BuildReplicationController()
+	found := false
+	for _, env := range ctrl.Spec.Template.Spec.Containers[0].Env {
+		if env.Value == "secret" {
+			t.Errorf("Secret value in replication controller: %+v", env)
+		}
+		if env.Name == "DB_PASSWORD" {
+			found = true
+			if env.ValueFrom == nil || env.ValueFrom.SecretKeyRef == nil || env.ValueFrom.SecretKeyRef.Name != "myapp-environment" || env.ValueFrom.SecretKeyRef.Key != "DB_PASSWORD" {
+				t.Errorf("Unexpected secret env var: %+v", env)
+			}
+		}
+	}
+	if !found {
+		t.Error("Secret env var not injected")
+	}
+
+	secret := clusterManager.BuildEnvironmentSecret()
+	if secret.Name != "myapp-environment" || string(secret.Data["DB_PASSWORD"]) != "secret" || len(secret.Data) != 1 {
+		t.Errorf("Unexpected environment secret: %+v", secret)
+	}
+}
+
+func TestBuildReplicationControllerWithSecretReferences(t *testing.T) {
+
+	resolver := secrets.NewResolver()
+	resolver.Register("vault", secrets.NewVaultProvider("http://localhost:8200", ""))
+	clusterManager := ClusterManager{
+		Config: &helper.DeployerConfig{SecretResolver: resolver},
+		Deployment: &types.Deployment{
+			Version: "2",
+			Descriptor: &types.Descriptor{
+				AppName: "myapp",
+				PodSpec: v1.PodSpec{Containers: []v1.Container{{Name: "web", Env: []v1.EnvVar{
+					{Name: "DB_PASSWORD", Value: "vault:secret/data/app#dbpass"},
+				}}}},
+			},
+		},
+	}
+
+	ctrl := clusterManager.BuildReplicationController()
+	env := ctrl.Spec.Template.Spec.Containers[0].Env[0]
+	if env.Value != "" || env.ValueFrom == nil || env.ValueFrom.SecretKeyRef.Name != "myapp-secrets" || env.ValueFrom.SecretKeyRef.Key != "web-DB_PASSWORD" {
+		t.Errorf("Unexpected env var: %+v", env)
+	}
+	if clusterManager.Deployment.Descriptor.PodSpec.Containers[0].Env[0].Value != "vault:secret/data/app#dbpass" {
+		t.Error("Reference was removed from the descriptor")
+	}
+}
+
+func TestBuildReplicationControllerWithConfigFiles(t *testing.T) {
+
+	clusterManager := ClusterManager{
+		Deployment: &types.Deployment{
+			Version: "2",
+			Descriptor: &types.Descriptor{
+				AppName:     "myapp",
+				ConfigFiles: []types.ConfigFile{{Name: "app.conf", MountPath: "/etc/myapp/app.conf", Content: "debug=true"}},
+				PodSpec:     v1.PodSpec{Containers: []v1.Container{{Name: "myapp"}, {Name: "sidecar"}}},
+			},
+		},
+	}
+
+	configMap := clusterManager.BuildConfigMap()
+	if configMap.Name != "myapp-2" || configMap.Labels["version"] != "2" || configMap.Data["app.conf"] != "debug=true" {
+		t.Errorf("Unexpected config map: %+v", configMap)
+	}
+
+	ctrl := clusterManager.BuildReplicationController()
+	volumes := ctrl.Spec.Template.Spec.Volumes
+	if len(volumes) != 1 || volumes[0].ConfigMap == nil || volumes[0].ConfigMap.Name != "myapp-2" {
+		t.Errorf("Unexpected volumes: %+v", volumes)
+	}
+	for _, container := range ctrl.Spec.Template.Spec.Containers {
+		mounts := container.VolumeMounts
+		if len(mounts) != 1 || mounts[0].MountPath != "/etc/myapp/app.conf" || mounts[0].SubPath != "app.conf" || !mounts[0].ReadOnly {
+			t.Errorf("Unexpected mounts of container %v: %+v", container.Name, mounts)
+		}
+	}
+
+	// the descriptor is stored with the deployment, and must not get the mounts
+	if len(clusterManager.Deployment.Descriptor.PodSpec.Volumes) != 0 || len(clusterManager.Deployment.Descriptor.PodSpec.Containers[0].VolumeMounts) != 0 {
+		t.Error("Config files mounted into the descriptor")
+	}
+}
+
+func TestBuildAutoscaler(t *testing.T) {
+
+	clusterManager := ClusterManager{
+		Deployment: &types.Deployment{
+			Version: "3",
+			Descriptor: &types.Descriptor{
+				AppName:     "myapp",
+				Autoscaling: &types.Autoscaling{MinReplicas: 2, MaxReplicas: 8, TargetCPUUtilization: 70, TargetMemoryUtilization: 80},
+			},
+		},
+	}
+
+	hpa := clusterManager.BuildAutoscaler(nil)
+	if hpa.Name != "myapp" || hpa.Spec.ScaleTargetRef.Name != "myapp-3" || *hpa.Spec.MinReplicas != 2 || hpa.Spec.MaxReplicas != 8 || *hpa.Spec.TargetCPUUtilizationPercentage != 70 {
+		t.Errorf("Unexpected autoscaler: %+v", hpa)
+	}
+	if !strings.Contains(hpa.Annotations["autoscaling.alpha.kubernetes.io/metrics"], `"targetAverageUtilization":80`) {
+		t.Errorf("Memory target missing: %v", hpa.Annotations)
+	}
+
+	// without cluster the replication controller starts with the min replicas
+	if ctrl := clusterManager.BuildReplicationController(); *ctrl.Spec.Replicas != 2 {
+		t.Errorf("Unexpected replicas %v", *ctrl.Spec.Replicas)
+	}
+
+	clusterManager.Deployment.Descriptor.Autoscaling = nil
+	if hpa := clusterManager.BuildAutoscaler(nil); hpa != nil {
+		t.Errorf("Unexpected autoscaler without autoscaling: %+v", hpa)
+	}
+}
+
+func TestBuildDisruptionBudget(t *testing.T) {
+
+	maxUnavailable := intstr.FromString("25%")
+	clusterManager := ClusterManager{
+		Deployment: &types.Deployment{
+			Version: "3",
+			Descriptor: &types.Descriptor{
+				AppName:          "myapp",
+				Replicas:         4,
+				DisruptionBudget: &types.DisruptionBudget{MaxUnavailable: &maxUnavailable},
+			},
+		},
+	}
+
+	pdb := clusterManager.BuildDisruptionBudget()
+	if pdb.Name != "myapp" || pdb.Spec.MinAvailable.String() != "75%" || pdb.Spec.Selector.MatchLabels["app"] != "myapp" || len(pdb.Spec.Selector.MatchLabels) != 1 {
+		t.Errorf("Unexpected disruption budget: %+v", pdb)
+	}
+
+	maxUnavailable = intstr.FromInt(1)
+	if pdb := clusterManager.BuildDisruptionBudget(); pdb.Spec.MinAvailable.IntValue() != 3 {
+		t.Errorf("Unexpected minAvailable %v", pdb.Spec.MinAvailable.String())
+	}
+	if pdb := clusterManager.buildDisruptionBudget(6); pdb.Spec.MinAvailable.IntValue() != 5 {
+		t.Errorf("Unexpected minAvailable %v after scaling", pdb.Spec.MinAvailable.String())
+	}
+	if pdb := clusterManager.buildDisruptionBudget(0); pdb.Spec.MinAvailable.IntValue() != 0 {
+		t.Errorf("Unexpected minAvailable %v when paused", pdb.Spec.MinAvailable.String())
+	}
+
+	clusterManager.Deployment.Descriptor.DisruptionBudget = nil
+	if pdb := clusterManager.BuildDisruptionBudget(); pdb != nil {
+		t.Errorf("Unexpected disruption budget: %+v", pdb)
+	}
+}
+
+func TestKeptReplicas(t *testing.T) {
+	running := &types.Deployment{Id: "1", Status: types.DEPLOYMENTSTATUS_DEPLOYED, Replicas: 5, Descriptor: &types.Descriptor{Replicas: 2}}
+	undeployed := &types.Deployment{Id: "0", Status: types.DEPLOYMENTSTATUS_UNDEPLOYED, Replicas: 8, Descriptor: &types.Descriptor{Replicas: 2}}
+	deployments := []*types.Deployment{undeployed, running}
+
+	deployment := &types.Deployment{Id: "2", Descriptor: &types.Descriptor{Replicas: 2}}
+	if replicas := KeptReplicas(deployments, deployment); replicas != 5 {
+		t.Errorf("Expected the scale of the running deployment, got %v", replicas)
+	}
+
+	deployment.Descriptor.Replicas = 3
+	if replicas := KeptReplicas(deployments, deployment); replicas != 0 {
+		t.Errorf("Expected the descriptor to override the scale, got %v", replicas)
+	}
+
+	running.Replicas = 0
+	deployment.Descriptor.Replicas = 2
+	if replicas := KeptReplicas(deployments, deployment); replicas != 0 {
+		t.Errorf("Expected no scale to keep, got %v", replicas)
+	}
+}
+
+func TestNewRunningPods(t *testing.T) {
+	newPod := func(name string, phase v1.PodPhase) v1.Pod {
+		pod := v1.Pod{}
+		pod.Name = name
+		pod.Status.Phase = phase
+		return pod
+	}
+	pods := []v1.Pod{
+		newPod("old-1", v1.PodRunning),
+		newPod("new-1", v1.PodRunning),
+		newPod("new-2", v1.PodPending),
+	}
+
+	result := NewRunningPods(pods, map[string]bool{"old-1": true, "old-2": true})
+	if len(result) != 1 || result[0].Name != "new-1" {
+		t.Errorf("Expected only the new running pod, got %+v", result)
+	}
+}
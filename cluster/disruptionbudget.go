@@ -0,0 +1,121 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cluster
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	policy "k8s.io/client-go/pkg/apis/policy/v1beta1"
+)
+
+// BuildDisruptionBudget returns the PodDisruptionBudget of the app, selecting the pods of all versions, without creating it.
+// It returns nil if the descriptor has no disruption budget.
+func (cm *ClusterManager) BuildDisruptionBudget() *policy.PodDisruptionBudget {
+	return cm.buildDisruptionBudget(cm.replicas())
+}
+
+func (cm *ClusterManager) buildDisruptionBudget(replicas int) *policy.PodDisruptionBudget {
+	descriptor := cm.Deployment.Descriptor
+	if descriptor.DisruptionBudget == nil {
+		return nil
+	}
+
+	pdb := new(policy.PodDisruptionBudget)
+	pdb.Name = descriptor.AppName
+	pdb.Labels = map[string]string{"app": descriptor.AppName}
+	pdb.Spec = policy.PodDisruptionBudgetSpec{
+		MinAvailable: cm.minAvailable(replicas),
+		Selector:     &meta.LabelSelector{MatchLabels: map[string]string{"app": descriptor.AppName}},
+	}
+	return pdb
+}
+
+// minAvailable returns the minAvailable of the disruption budget. The policy/v1beta1 API has no maxUnavailable yet,
+// a percentage is converted to the remaining percentage, a number is subtracted from the replicas.
+func (cm *ClusterManager) minAvailable(replicas int) intstr.IntOrString {
+	budget := cm.Deployment.Descriptor.DisruptionBudget
+	if budget.MinAvailable != nil {
+		return *budget.MinAvailable
+	}
+
+	maxUnavailable := *budget.MaxUnavailable
+	if maxUnavailable.Type == intstr.String {
+		percentage, _ := strconv.Atoi(strings.TrimSuffix(maxUnavailable.StrVal, "%"))
+		return intstr.FromString(fmt.Sprintf("%v%%", 100-percentage))
+	}
+	minAvailable := replicas - int(maxUnavailable.IntVal)
+	if minAvailable < 0 {
+		minAvailable = 0
+	}
+	return intstr.FromInt(minAvailable)
+}
+
+// CreateOrUpdateDisruptionBudget creates the disruption budget of the app, or recreates it if it changed,
+// since the policy/v1beta1 API doesn't allow updates of the spec
+func (cm *ClusterManager) CreateOrUpdateDisruptionBudget() error {
+	return cm.createOrUpdateDisruptionBudget(cm.BuildDisruptionBudget())
+}
+
+// ScaleDisruptionBudget updates the disruption budget of the app, if it has one, after its running version was scaled to the given replicas,
+// since the minAvailable of a numeric maxUnavailable depends on the replicas
+func (cm *ClusterManager) ScaleDisruptionBudget(replicas int) error {
+	if cm.Deployment.Descriptor.DisruptionBudget == nil {
+		return nil
+	}
+	return cm.createOrUpdateDisruptionBudget(cm.buildDisruptionBudget(replicas))
+}
+
+func (cm *ClusterManager) createOrUpdateDisruptionBudget(pdb *policy.PodDisruptionBudget) error {
+	namespace := cm.Deployment.Descriptor.Namespace
+
+	existing, err := cm.Config.K8sClient.GetPodDisruptionBudget(namespace, pdb.Name)
+	if statusError, isStatus := err.(*errors.StatusError); isStatus && statusError.Status().Reason == meta.StatusReasonNotFound {
+		cm.Logger.Printf("Creating disruption budget %v", pdb.Name)
+		_, err = cm.Config.K8sClient.CreatePodDisruptionBudget(namespace, pdb)
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	if reflect.DeepEqual(existing.Spec, pdb.Spec) {
+		return nil
+	}
+	cm.Logger.Printf("Recreating disruption budget %v", pdb.Name)
+	if err := cm.Config.K8sClient.DeletePodDisruptionBudget(namespace, pdb.Name); err != nil {
+		return err
+	}
+	_, err = cm.Config.K8sClient.CreatePodDisruptionBudget(namespace, pdb)
+	return err
+}
+
+// DeleteDisruptionBudget deletes the disruption budget of the app, if it exists
+func (cm *ClusterManager) DeleteDisruptionBudget() {
+	descriptor := cm.Deployment.Descriptor
+	err := cm.Config.K8sClient.DeletePodDisruptionBudget(descriptor.Namespace, descriptor.AppName)
+	if statusError, isStatus := err.(*errors.StatusError); isStatus && statusError.Status().Reason == meta.StatusReasonNotFound {
+		return
+	} else if err != nil {
+		cm.Logger.Printf("Error deleting disruption budget %v: %v", descriptor.AppName, err)
+		return
+	}
+	cm.Logger.Printf("Deleted disruption budget %v", descriptor.AppName)
+}
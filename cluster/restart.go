@@ -0,0 +1,143 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// RestartPods replaces the pods of the deployment in batches of the given size, without changing the version.
+// The replication controller starts a new pod for each deleted pod, and the next batch is only deleted when the new pods
+// are running and pass the healthcheck of the descriptor. It stops when the new pods don't become healthy within the health timeout.
+func (cm *ClusterManager) RestartPods(batchSize int) error {
+	pods, err := cm.listVersionPods()
+	if err != nil {
+		return err
+	}
+	if len(pods) == 0 {
+		cm.Logger.Println("No pods to restart")
+		return nil
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	originals := map[string]bool{}
+	for _, pod := range pods {
+		originals[pod.Name] = true
+	}
+
+	namespace := cm.Deployment.Descriptor.Namespace
+	for start := 0; start < len(pods); start += batchSize {
+		end := start + batchSize
+		if end > len(pods) {
+			end = len(pods)
+		}
+
+		for _, pod := range pods[start:end] {
+			cm.Logger.Printf("Deleting pod %v", pod.Name)
+			err := cm.Config.K8sClient.DeletePod(namespace, pod.Name)
+			if statusError, isStatus := err.(*errors.StatusError); isStatus && statusError.Status().Reason == meta.StatusReasonNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+		}
+
+		cm.Logger.Printf("Waiting up to %v seconds for %v new pods to start and to become healthy", cm.Config.HealthTimeout, end)
+		if err := cm.waitForNewPods(originals, end); err != nil {
+			return err
+		}
+		cm.Logger.Printf("Restarted %v of %v pods", end, len(pods))
+	}
+	return nil
+}
+
+// WaitForPods waits until the given number of pods of the deployment are running and healthy
+func (cm *ClusterManager) WaitForPods(count int) error {
+	return cm.waitForNewPods(map[string]bool{}, count)
+}
+
+// waitForNewPods waits until the given number of pods, which are not one of the original pods, are running and healthy
+func (cm *ClusterManager) waitForNewPods(originals map[string]bool, count int) error {
+	descriptor := cm.Deployment.Descriptor
+	checkHealth := descriptor.UseHealthCheck && !descriptor.IgnoreHealthCheck
+	timeout := time.After(time.Duration(cm.Config.HealthTimeout) * time.Second)
+
+	for {
+		pods, err := cm.listVersionPods()
+		if err != nil {
+			return err
+		}
+
+		healthy := 0
+		for _, pod := range NewRunningPods(pods, originals) {
+			if !checkHealth {
+				healthy++
+				continue
+			}
+			podHealthy, health, err := CheckPodHealth(descriptor, &pod)
+			cm.Config.EtcdRegistry.StoreHealth(descriptor.Namespace, cm.Deployment.Id, pod.Name, health)
+			if err != nil {
+				cm.Logger.Println("Error parsing healthcheck: " + err.Error())
+			}
+			if podHealthy {
+				healthy++
+			}
+		}
+		if healthy >= count {
+			return nil
+		}
+
+		select {
+		case <-timeout:
+			return fmt.Errorf("Timeout waiting for new pods to become healthy, %v of %v are healthy", healthy, count)
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
+
+// listVersionPods returns the pods of the version of the deployment which are not terminating
+func (cm *ClusterManager) listVersionPods() ([]v1.Pod, error) {
+	selector := map[string]string{"name": cm.Deployment.GetVersionedName(), "version": cm.Deployment.Version}
+	pods, err := cm.Config.K8sClient.ListPodsWithSelector(cm.Deployment.Descriptor.Namespace, selector)
+	if err != nil {
+		return nil, err
+	}
+	active := []v1.Pod{}
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp == nil {
+			active = append(active, pod)
+		}
+	}
+	return active, nil
+}
+
+// NewRunningPods returns the running pods which are not one of the original pods
+func NewRunningPods(pods []v1.Pod, originals map[string]bool) []v1.Pod {
+	result := []v1.Pod{}
+	for _, pod := range pods {
+		if !originals[pod.Name] && pod.Status.Phase == v1.PodRunning {
+			result = append(result, pod)
+		}
+	}
+	return result
+}
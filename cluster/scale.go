@@ -0,0 +1,65 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cluster
+
+import (
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+)
+
+// Scale sets the replicas of the replication controller of the deployment
+func (cm *ClusterManager) Scale(replicas int) error {
+	namespace := cm.Deployment.Descriptor.Namespace
+
+	rc, err := cm.Config.K8sClient.GetReplicationController(namespace, cm.Deployment.GetVersionedName())
+	if err != nil {
+		return err
+	}
+	value := int32(replicas)
+	rc.Spec.Replicas = &value
+	_, err = cm.Config.K8sClient.UpdateReplicationController(namespace, rc)
+	return err
+}
+
+// scaledReplicas returns the replicas the running deployment of the app was scaled to, see KeptReplicas
+func (cm *ClusterManager) scaledReplicas() (int, error) {
+	if cm.Registry == nil {
+		return 0, nil
+	}
+	descriptor := cm.Deployment.Descriptor
+	deployments, err := cm.Registry.GetDeploymentsByAppName(descriptor.Namespace, descriptor.AppName)
+	if err == etcdregistry.ErrDeploymentNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return KeptReplicas(deployments, cm.Deployment), nil
+}
+
+// KeptReplicas returns the replicas the running deployment was scaled to, if the new deployment doesn't override them
+// with other replicas in its descriptor, else 0.
+func KeptReplicas(deployments []*types.Deployment, deployment *types.Deployment) int {
+	for _, current := range deployments {
+		if current.Id == deployment.Id || current.Replicas == 0 ||
+			(current.Status != types.DEPLOYMENTSTATUS_DEPLOYED && current.Status != types.DEPLOYMENTSTATUS_PAUSED) {
+			continue
+		}
+		if current.Descriptor.Replicas == deployment.Descriptor.Replicas {
+			return current.Replicas
+		}
+	}
+	return 0
+}
@@ -0,0 +1,174 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package defaults
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+)
+
+// fields which identify a descriptor, and so can't have defaults
+var protectedFields = []string{"id", "namespace", "appName", "created", "lastModified", "revision", "modifiedBy", "webhooks"}
+
+// Effective returns the descriptor merged over the namespace defaults, merged over the global defaults.
+// The given descriptor is not modified.
+func Effective(registry *etcdregistry.EtcdRegistry, descriptor *types.Descriptor) (*types.Descriptor, error) {
+	global, err := registry.GetDefaults("")
+	if err != nil && err != etcdregistry.ErrDefaultsNotFound {
+		return nil, err
+	}
+	namespace, err := registry.GetDefaults(descriptor.Namespace)
+	if err != nil && err != etcdregistry.ErrDefaultsNotFound {
+		return nil, err
+	}
+
+	return Apply(descriptor, global, namespace)
+}
+
+// Apply merges the descriptor over the given defaults, later defaults override earlier ones
+func Apply(descriptor *types.Descriptor, defaults ...map[string]interface{}) (*types.Descriptor, error) {
+	bytes, err := json.Marshal(descriptor)
+	if err != nil {
+		return nil, err
+	}
+	var values interface{}
+	if err := json.Unmarshal(bytes, &values); err != nil {
+		return nil, err
+	}
+
+	// merge the most specific defaults first, so defaults without name in lists also apply to elements added by other defaults
+	for i := len(defaults) - 1; i >= 0; i-- {
+		if defaults[i] != nil {
+			values = Merge(removeProtected(defaults[i]), values)
+		}
+	}
+
+	if bytes, err = json.Marshal(values); err != nil {
+		return nil, err
+	}
+	effective := &types.Descriptor{}
+	if err := json.Unmarshal(bytes, effective); err != nil {
+		return nil, fmt.Errorf("defaults don't fit descriptor: %v", err.Error())
+	}
+	return effective, nil
+}
+
+// Merge returns the values merged over the defaults. Objects are merged recursively, other values replace the defaults.
+// Lists of objects with a "name" (e.g. containers, env vars, imagePullSecrets) are merged by name: defaults without a name
+// apply to all elements, named defaults to the element with that name, and are added if there is none.
+func Merge(defaults interface{}, values interface{}) interface{} {
+	switch typed := values.(type) {
+	case nil:
+		return defaults
+	case map[string]interface{}:
+		defaultMap, ok := defaults.(map[string]interface{})
+		if !ok {
+			return typed
+		}
+		result := map[string]interface{}{}
+		for key, value := range defaultMap {
+			result[key] = value
+		}
+		for key, value := range typed {
+			result[key] = Merge(defaultMap[key], value)
+		}
+		return result
+	case []interface{}:
+		defaultList, ok := defaults.([]interface{})
+		if !ok || !isObjectList(defaultList) || !isObjectList(typed) {
+			return typed
+		}
+		return mergeList(defaultList, typed)
+	default:
+		return typed
+	}
+}
+
+func mergeList(defaults []interface{}, values []interface{}) []interface{} {
+	templates := []interface{}{}
+	named := map[string]interface{}{}
+	for _, d := range defaults {
+		if name, ok := d.(map[string]interface{})["name"].(string); ok && name != "" {
+			named[name] = d
+		} else {
+			templates = append(templates, d)
+		}
+	}
+
+	result := []interface{}{}
+	present := map[string]bool{}
+	for _, value := range values {
+		name, _ := value.(map[string]interface{})["name"].(string)
+		present[name] = true
+
+		merged := value
+		if d, ok := named[name]; ok {
+			merged = Merge(d, merged)
+		}
+		for _, template := range templates {
+			merged = Merge(template, merged)
+		}
+		result = append(result, merged)
+	}
+
+	for _, d := range defaults {
+		if name, ok := d.(map[string]interface{})["name"].(string); ok && name != "" && !present[name] {
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+func isObjectList(list []interface{}) bool {
+	for _, element := range list {
+		if _, ok := element.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks that the defaults fit a descriptor, and don't contain identifying fields
+func Validate(defaults map[string]interface{}) error {
+	for _, field := range protectedFields {
+		if _, ok := defaults[field]; ok {
+			return fmt.Errorf("field %v can't have a default", field)
+		}
+	}
+
+	bytes, err := json.Marshal(defaults)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(bytes, &types.Descriptor{}); err != nil {
+		return fmt.Errorf("defaults don't fit descriptor: %v", err.Error())
+	}
+	return nil
+}
+
+func removeProtected(defaults map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	for key, value := range defaults {
+		result[key] = value
+	}
+	for _, field := range protectedFields {
+		delete(result, field)
+	}
+	return result
+}
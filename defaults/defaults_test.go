@@ -0,0 +1,123 @@
+package defaults
+
+import (
+	"encoding/json"
+	"testing"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func parse(t *testing.T, s string) map[string]interface{} {
+	result := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(s), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestApply(t *testing.T) {
+	global := parse(t, `{
+		"useCompression": true,
+		"replicas": 2,
+		"podspec": {
+			"imagePullSecrets": [{"name": "registry"}],
+			"containers": [{"resources": {"requests": {"cpu": "100m", "memory": "128Mi"}}}]
+		}
+	}`)
+	namespace := parse(t, `{
+		"replicas": 3,
+		"appName": "ignored",
+		"podspec": {
+			"containers": [{"name": "sidecar", "image": "proxy:1.0"}]
+		}
+	}`)
+
+	descriptor := &types.Descriptor{
+		Namespace: "test",
+		AppName:   "myapp",
+		PodSpec: v1.PodSpec{
+			ImagePullSecrets: []v1.LocalObjectReference{{Name: "private"}},
+			Containers: []v1.Container{{
+				Name:  "myapp",
+				Image: "user/myapp:1.0",
+				Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+					v1.ResourceMemory: resource.MustParse("512Mi"),
+				}},
+			}},
+		},
+	}
+
+	effective, err := Apply(descriptor, global, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if effective.AppName != "myapp" || effective.Replicas != 3 || !effective.UseCompression {
+		t.Errorf("Unexpected effective descriptor %+v", effective)
+	}
+	if len(effective.PodSpec.ImagePullSecrets) != 2 {
+		t.Errorf("Expected both image pull secrets, got %v", effective.PodSpec.ImagePullSecrets)
+	}
+	if len(effective.PodSpec.Containers) != 2 || effective.PodSpec.Containers[1].Name != "sidecar" {
+		t.Fatalf("Expected app container and sidecar, got %+v", effective.PodSpec.Containers)
+	}
+	requests := effective.PodSpec.Containers[0].Resources.Requests
+	if cpu := requests[v1.ResourceCPU]; cpu.String() != "100m" {
+		t.Errorf("Expected default cpu request, got %v", cpu.String())
+	}
+	if memory := requests[v1.ResourceMemory]; memory.String() != "512Mi" {
+		t.Errorf("Expected memory request of the app, got %v", memory.String())
+	}
+	if len(descriptor.PodSpec.ImagePullSecrets) != 1 {
+		t.Error("Expected original descriptor to be unchanged")
+	}
+}
+
+func TestApplyExplicitZeros(t *testing.T) {
+	global := parse(t, `{"useCompression": true, "useHealthCheck": true, "healthCheckPort": 8080}`)
+
+	descriptor := &types.Descriptor{}
+	if err := json.Unmarshal([]byte(`{"namespace": "test", "appName": "myapp", "useCompression": false, "healthCheckPort": 0}`), descriptor); err != nil {
+		t.Fatal(err)
+	}
+	// explicit zeros are kept when the descriptor is stored
+	bytes, err := json.Marshal(descriptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := &types.Descriptor{}
+	if err := json.Unmarshal(bytes, stored); err != nil {
+		t.Fatal(err)
+	}
+
+	effective, err := Apply(stored, global)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if effective.UseCompression || effective.HealthCheckPort != 0 || !effective.UseHealthCheck {
+		t.Errorf("Expected explicit zeros to override the defaults, got %+v", effective)
+	}
+
+	// a changed value replaces the explicit zero
+	stored.UseCompression = true
+	if bytes, err = json.Marshal(stored); err != nil {
+		t.Fatal(err)
+	}
+	if values := parse(t, string(bytes)); values["useCompression"] != true || values["healthCheckPort"] != float64(0) {
+		t.Errorf("Unexpected values %v", values)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(parse(t, `{"useCompression": true}`)); err != nil {
+		t.Errorf("Expected valid defaults, got %v", err)
+	}
+	if err := Validate(parse(t, `{"namespace": "other"}`)); err == nil {
+		t.Error("Expected namespace to be rejected")
+	}
+	if err := Validate(parse(t, `{"replicas": "many"}`)); err == nil {
+		t.Error("Expected wrong type to be rejected")
+	}
+}
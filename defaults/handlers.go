@@ -0,0 +1,144 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package defaults
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"github.com/gorilla/mux"
+)
+
+type DefaultsHandlers struct {
+	registry *etcdregistry.EtcdRegistry
+}
+
+func NewDefaultsHandlers(registry *etcdregistry.EtcdRegistry) *DefaultsHandlers {
+	return &DefaultsHandlers{registry}
+}
+
+// GetDefaultsHandler returns the defaults of a namespace, or the global defaults without namespace parameter
+func (d *DefaultsHandlers) GetDefaultsHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	//TODO check namespaces of user
+	namespace := req.URL.Query().Get("namespace")
+
+	defaults, err := d.registry.GetDefaults(namespace)
+	if err == etcdregistry.ErrDefaultsNotFound {
+		helper.HandleNotFound(writer, logger, "No defaults found for %v", scope(namespace))
+		return
+	} else if err != nil {
+		helper.HandleError(writer, logger, 500, "Error getting defaults for %v: %v", scope(namespace), err)
+		return
+	}
+
+	helper.HandleSuccess(writer, logger, defaults, "Got defaults for %v", scope(namespace))
+}
+
+func (d *DefaultsHandlers) UpdateDefaultsHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	//TODO check namespaces of user, global defaults need admin rights
+	namespace := req.URL.Query().Get("namespace")
+
+	defer req.Body.Close()
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		helper.HandleError(writer, logger, 500, "Error reading body: %v", err)
+		return
+	}
+
+	defaults := map[string]interface{}{}
+	if err := json.Unmarshal(body, &defaults); err != nil {
+		helper.HandleError(writer, logger, 400, "Error parsing body: %v", err)
+		return
+	}
+	if err := Validate(defaults); err != nil {
+		helper.HandleError(writer, logger, 400, "Invalid defaults: %v", err)
+		return
+	}
+
+	if err := d.registry.StoreDefaults(namespace, defaults); err != nil {
+		helper.HandleError(writer, logger, 500, "Error storing defaults for %v: %v", scope(namespace), err)
+		return
+	}
+
+	helper.HandleSuccess(writer, logger, "", "Defaults for %v updated", scope(namespace))
+}
+
+func (d *DefaultsHandlers) DeleteDefaultsHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	//TODO check namespaces of user, global defaults need admin rights
+	namespace := req.URL.Query().Get("namespace")
+
+	err := d.registry.DeleteDefaults(namespace)
+	if err == etcdregistry.ErrDefaultsNotFound {
+		helper.HandleNotFound(writer, logger, "No defaults found for %v", scope(namespace))
+		return
+	} else if err != nil {
+		helper.HandleError(writer, logger, 500, "Error deleting defaults for %v: %v", scope(namespace), err)
+		return
+	}
+
+	helper.HandleSuccess(writer, logger, "", "Defaults for %v deleted", scope(namespace))
+}
+
+// EffectiveDescriptorHandler returns the descriptor as it would be deployed, merged with the defaults
+func (d *DefaultsHandlers) EffectiveDescriptorHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	//TODO check namespaces of user
+	namespace := req.URL.Query().Get("namespace")
+	if namespace == "" {
+		helper.HandleError(writer, logger, 400, "Namespace parameter missing")
+		return
+	}
+
+	id := mux.Vars(req)["id"]
+	descriptor, err := d.registry.GetDescriptorById(namespace, id)
+	if err == etcdregistry.ErrDescriptorNotFound {
+		helper.HandleNotFound(writer, logger, "Descriptor %v not found", id)
+		return
+	} else if err != nil {
+		helper.HandleError(writer, logger, 500, "Error getting descriptor %v: %v", id, err)
+		return
+	}
+
+	effective, err := Effective(d.registry, descriptor)
+	if err != nil {
+		helper.HandleError(writer, logger, 500, "Error applying defaults to descriptor %v: %v", id, err)
+		return
+	}
+	if err := effective.SetDefaults().Validate(); err != nil {
+		helper.HandleError(writer, logger, 422, "Effective descriptor %v is invalid: %v", id, err)
+		return
+	}
+
+	helper.HandleSuccess(writer, logger, helper.RedactDescriptor(req, d.registry.SensitiveFields(), effective), "Got effective descriptor %v", id)
+}
+
+func scope(namespace string) string {
+	if namespace == "" {
+		return "all namespaces"
+	}
+	return "namespace " + namespace
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"sync"
 
 	"crypto/tls"
@@ -12,13 +13,27 @@ import (
 	"net"
 	"time"
 
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/admin"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/defaults"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/deployments"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/descriptors"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/drift"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/encryption"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/environment"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/events"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/eventstream"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/importer"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/k8s"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/migration"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/monitoring"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/notifications"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/proxies"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/secrets"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/sweeper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/variables"
 	etcd "github.com/coreos/etcd/client"
 	"github.com/gorilla/mux"
 )
@@ -26,10 +41,39 @@ import (
 var kubernetesurl, etcdUrl, port, kubernetesUsername, kubernetesPassword string
 var healthTimeout int
 var proxyReloadSleep int
+var healthInterval, healthHistory int
+var notificationAttempts, notificationBackoff int
+var driftInterval int
+var driftHealing bool
+var gcInterval, gcKeepDeployments, gcLogDays int
+var gcDryRun bool
+var encryptionKeyFile string
+var adminToken string
+var sensitiveFields string
+var vaultAddr, vaultToken string
+var secretsInterval int
+var secretsRedeploy bool
+var maintenanceBackend string
 var skipServerCertValidation bool
 var registry *etcdregistry.EtcdRegistry
+var eventBus *events.Bus
+var monitor *monitoring.Monitor
 var descriptorHandlers *descriptors.DescriptorHandlers
+var defaultsHandlers *defaults.DefaultsHandlers
+var variableHandlers *variables.VariableHandlers
+var environmentHandlers *environment.EnvironmentHandlers
 var deploymentHandlers *deployments.DeploymentHandlers
+var monitorHandlers *monitoring.MonitorHandlers
+var dispatcher *notifications.Dispatcher
+var notificationHandlers *notifications.NotificationHandlers
+var eventStreamHandlers *eventstream.EventStreamHandlers
+var importHandlers *importer.ImportHandlers
+var reconciler *drift.Reconciler
+var driftHandlers *drift.DriftHandlers
+var gcSweeper *sweeper.Sweeper
+var sweeperHandlers *sweeper.SweeperHandlers
+var adminHandlers *admin.AdminHandlers
+var secretRefresher *secrets.Refresher
 
 type deploymentStatus struct {
 	Success   bool   `json:"success"`
@@ -45,7 +89,25 @@ func init() {
 	flag.StringVar(&kubernetesPassword, "kubernetespassword", "noauth", "Username to authenticate against Kubernetes API server.")
 	flag.IntVar(&healthTimeout, "timeout", 60, "Timeout in seconds for health checks")
 	flag.IntVar(&proxyReloadSleep, "proxysleep", 20, "Seconds to wait for proxy to reload config")
+	flag.IntVar(&healthInterval, "healthinterval", 30, "Seconds between health checks of deployed apps, 0 disables the health monitor")
+	flag.IntVar(&healthHistory, "healthhistory", 20, "Number of health check results to keep per pod")
+	flag.IntVar(&notificationAttempts, "notificationattempts", 5, "Number of attempts for delivering a notification")
+	flag.IntVar(&notificationBackoff, "notificationbackoff", 2, "Seconds to wait before the first retry of a failed notification, doubled on every retry")
+	flag.IntVar(&driftInterval, "driftinterval", 300, "Seconds between drift checks of deployed apps, 0 disables the drift reconciler")
+	flag.BoolVar(&driftHealing, "drifthealing", false, "Heal detected drift back to the descriptor")
+	flag.IntVar(&gcInterval, "gcinterval", 3600, "Seconds between garbage collection sweeps, 0 disables the sweeper")
+	flag.IntVar(&gcKeepDeployments, "gckeepdeployments", 10, "Number of deployments kept per app, 0 keeps all")
+	flag.IntVar(&gcLogDays, "gclogdays", 30, "Days after which logs of deployments which are not deployed are deleted, 0 keeps all")
+	flag.BoolVar(&gcDryRun, "gcdryrun", false, "Only log what the garbage collection sweeper would delete")
 	flag.BoolVar(&skipServerCertValidation, "skipServerCertValidation", false, "Skip server certificate validation")
+	flag.StringVar(&encryptionKeyFile, "encryptionkeyfile", "", "File with the base64 encoded 32 byte encryption keys, one [id:]key per line with the primary key first, defaults to the "+encryption.ENV_KEY+" environment variable")
+	flag.StringVar(&adminToken, "admintoken", os.Getenv("DEPLOYER_ADMIN_TOKEN"), "Token which admin requests send in the "+helper.HEADER_ADMIN_TOKEN+" header for reading sensitive values and the admin endpoints, defaults to DEPLOYER_ADMIN_TOKEN, without token they are disabled")
+	flag.StringVar(&sensitiveFields, "sensitivefields", "email,password", "Comma separated descriptor fields which are stored encrypted: email, password, env or env.NAME")
+	flag.StringVar(&vaultAddr, "vaultaddr", os.Getenv("VAULT_ADDR"), "Address of the Vault server for resolving vault: secret references, defaults to VAULT_ADDR")
+	flag.StringVar(&vaultToken, "vaulttoken", os.Getenv("VAULT_TOKEN"), "Token for reading secrets from Vault, defaults to VAULT_TOKEN")
+	flag.IntVar(&secretsInterval, "secretsinterval", 300, "Seconds between checks for changed secrets of deployed apps, 0 disables the refresher")
+	flag.BoolVar(&secretsRedeploy, "secretsredeploy", false, "Redeploy apps when their referenced secrets changed")
+	flag.StringVar(&maintenanceBackend, "maintenancebackend", "", "Service and port in the namespace of the app, like maintenance:80, which the Ingress of paused apps points at, without it they answer with 503")
 
 	exampleUsage := "Missing required argument %v. Example usage: ./deployer_linux_amd64 -kubernetes http://[kubernetes-api-url]:8080 -etcd http://[etcd-url]:2379 -deployport 8000"
 
@@ -87,8 +149,30 @@ func init() {
 		log.Fatalf("Could not initialize etcd client! %v", err.Error())
 	}
 
+	eventBus = events.NewBus()
+
 	etcdApi := etcd.NewKeysAPI(etcdClient)
 	registry = etcdregistry.NewEtcdRegistry(etcdApi)
+	registry.SetEventBus(eventBus)
+
+	keyring, err := encryption.LoadKeyring(encryptionKeyFile)
+	if err != nil {
+		log.Fatalf("Could not read encryption keys! %v", err.Error())
+	}
+	fields, err := types.ParseSensitiveFields(sensitiveFields)
+	if err != nil {
+		log.Fatalf("Invalid sensitive fields! %v", err.Error())
+	}
+	registry.SetSensitiveFields(fields)
+	helper.SetAdminToken(adminToken)
+	if !helper.AdminEnabled() {
+		log.Println("No admin token configured, sensitive values are always redacted and the admin endpoints are disabled")
+	}
+	if keyring != nil {
+		registry.SetKeyring(keyring)
+	} else if len(fields) > 0 {
+		log.Println("No encryption key configured, sensitive fields are stored unencrypted")
+	}
 
 	k8sConfig := k8s.K8sConfig{
 		ApiServerUrl: kubernetesurl,
@@ -99,15 +183,29 @@ func init() {
 	}
 
 	ingressConfigurator := proxies.NewIngressConfigurator(k8sClient, proxyReloadSleep, healthTimeout)
+	if maintenanceBackend != "" {
+		backend, err := proxies.ParseMaintenanceBackend(maintenanceBackend)
+		if err != nil {
+			log.Fatalf("Invalid maintenance backend: %v", err.Error())
+		}
+		ingressConfigurator.SetMaintenanceBackend(backend)
+	}
 
 	mutexes := map[string]*sync.Mutex{}
 
+	secretResolver := secrets.NewResolver()
+	if vaultAddr != "" {
+		secretResolver.Register("vault", secrets.NewVaultProvider(vaultAddr, vaultToken))
+	}
+
 	deployerConfig := helper.DeployerConfig{
 		HealthTimeout:       healthTimeout,
 		K8sClient:           k8sClient,
 		EtcdRegistry:        registry,
 		IngressConfigurator: ingressConfigurator,
 		Mutexes:             mutexes,
+		Events:              eventBus,
+		SecretResolver:      secretResolver,
 	}
 
 	if err := migration.Migrate(etcdApi, deployerConfig); err != nil {
@@ -115,7 +213,32 @@ func init() {
 	}
 
 	descriptorHandlers = descriptors.NewDescriptorHandlers(registry)
+	defaultsHandlers = defaults.NewDefaultsHandlers(registry)
+	variableHandlers = variables.NewVariableHandlers(registry)
+	environmentHandlers = environment.NewEnvironmentHandlers(registry)
+	adminHandlers = admin.NewAdminHandlers(registry)
 	deploymentHandlers = deployments.NewDeploymentHandlers(deployerConfig)
+	importHandlers = importer.NewImportHandlers(deployerConfig)
+
+	monitor = monitoring.NewMonitor(deployerConfig, healthInterval, healthHistory)
+	monitorHandlers = monitoring.NewMonitorHandlers(registry, monitor)
+
+	reconciler = drift.NewReconciler(deployerConfig, driftInterval, driftHealing)
+	driftHandlers = drift.NewDriftHandlers(registry, reconciler)
+
+	gcSweeper = sweeper.NewSweeper(deployerConfig, gcInterval, sweeper.Retention{KeepDeployments: gcKeepDeployments, LogDays: gcLogDays}, gcDryRun)
+	sweeperHandlers = sweeper.NewSweeperHandlers(gcSweeper)
+
+	var redeploy secrets.RedeployFunc
+	if secretsRedeploy {
+		redeploy = deploymentHandlers.Redeploy
+	}
+	secretRefresher = secrets.NewRefresher(registry, secretResolver, eventBus, secretsInterval, redeploy)
+
+	dispatcher = notifications.NewDispatcher(registry, notificationAttempts, time.Duration(notificationBackoff)*time.Second)
+	notificationHandlers = notifications.NewNotificationHandlers(registry)
+
+	eventStreamHandlers = eventstream.NewEventStreamHandlers(eventBus)
 }
 
 func main() {
@@ -128,9 +251,30 @@ func main() {
 	r.HandleFunc("/descriptors/{id}/", descriptorHandlers.GetDescriptorHandler).Methods("GET")
 	r.HandleFunc("/descriptors/{id}/", descriptorHandlers.UpdateDescriptorHandler).Methods("PUT")
 	r.HandleFunc("/descriptors/{id}/", descriptorHandlers.DeleteDescriptorHandler).Methods("DELETE")
+	r.HandleFunc("/descriptors/{id}/revisions", descriptorHandlers.ListRevisionsHandler).Methods("GET")
+	r.HandleFunc("/descriptors/{id}/revisions/diff", descriptorHandlers.DiffRevisionsHandler).Methods("GET")
+	r.HandleFunc("/descriptors/{id}/revisions/{revision:[0-9]+}", descriptorHandlers.GetRevisionHandler).Methods("GET")
+	r.HandleFunc("/descriptors/{id}/revisions/{revision:[0-9]+}/restore", descriptorHandlers.RestoreRevisionHandler).Methods("POST")
+	r.HandleFunc("/descriptors/{id}/effective", defaultsHandlers.EffectiveDescriptorHandler).Methods("GET")
 	r.HandleFunc("/descriptors/validate", descriptorHandlers.DoValidationHandler).Methods("POST")
+	r.HandleFunc("/descriptors/import", importHandlers.ImportHandler).Methods("POST")
+
+	r.HandleFunc("/defaults", defaultsHandlers.GetDefaultsHandler).Methods("GET")
+	r.HandleFunc("/defaults", defaultsHandlers.UpdateDefaultsHandler).Methods("PUT")
+	r.HandleFunc("/defaults", defaultsHandlers.DeleteDefaultsHandler).Methods("DELETE")
+
+	r.HandleFunc("/variables/", variableHandlers.ListVariablesHandler).Methods("GET")
+	r.HandleFunc("/variables/{name}", variableHandlers.SetVariableHandler).Methods("PUT")
+	r.HandleFunc("/variables/{name}", variableHandlers.DeleteVariableHandler).Methods("DELETE")
+
+	r.HandleFunc("/environment/", environmentHandlers.ListEnvironmentVarsHandler).Methods("GET")
+	r.HandleFunc("/environment/{name}", environmentHandlers.SetEnvironmentVarHandler).Methods("PUT")
+	r.HandleFunc("/environment/{name}", environmentHandlers.DeleteEnvironmentVarHandler).Methods("DELETE")
+	r.HandleFunc("/environment/{name}/affected", environmentHandlers.AffectedAppsHandler).Methods("GET")
 
 	r.HandleFunc("/deployments/", deploymentHandlers.CreateDeploymentHandler).Methods("POST")
+	r.HandleFunc("/deployments/plan", deploymentHandlers.PlanDeploymentHandler).Methods("POST")
+	r.HandleFunc("/deployments/manifests", deploymentHandlers.RenderManifestsHandler).Methods("POST")
 	r.HandleFunc("/deployments/", deploymentHandlers.ListDeploymentsHandler).Methods("GET")
 	r.HandleFunc("/deployments/", deploymentHandlers.DeleteDeploymentsHandler).Methods("DELETE")
 	r.HandleFunc("/deployments/{id}/", deploymentHandlers.GetDeploymentHandler).Methods("GET")
@@ -138,12 +282,61 @@ func main() {
 	r.HandleFunc("/deployments/{id}/logs", deploymentHandlers.GetLogsHandler).Methods("GET")
 	r.HandleFunc("/deployments/{id}/", deploymentHandlers.UpdateDeploymentHandler).Methods("PUT")
 	r.HandleFunc("/deployments/{id}/", deploymentHandlers.DeleteDeploymentHandler).Methods("DELETE")
+	r.HandleFunc("/deployments/{id}/scale", deploymentHandlers.ScaleDeploymentHandler).Methods("PUT")
+	r.HandleFunc("/deployments/{id}/restart", deploymentHandlers.RestartDeploymentHandler).Methods("POST")
+	r.HandleFunc("/deployments/{id}/pause", deploymentHandlers.PauseDeploymentHandler).Methods("POST")
+	r.HandleFunc("/deployments/{id}/resume", deploymentHandlers.ResumeDeploymentHandler).Methods("POST")
+
+	r.HandleFunc("/webhooks/{key}", deploymentHandlers.WebhookHandler).Methods("POST")
 
 	r.HandleFunc("/stream/deployments/{id}/logs", deploymentHandlers.StreamLogsHandler)
 
+	r.HandleFunc("/apps/{name}/status", monitorHandlers.AppStatusHandler).Methods("GET")
+	r.HandleFunc("/apps/{name}/images", deploymentHandlers.UpdateImagesHandler).Methods("PATCH")
+
+	r.HandleFunc("/drift", driftHandlers.DriftReportHandler).Methods("GET")
+	r.HandleFunc("/drift/heal", driftHandlers.HealDriftHandler).Methods("POST")
+
+	r.HandleFunc("/gc", sweeperHandlers.SweepHandler).Methods("POST")
+
+	if helper.AdminEnabled() {
+		r.HandleFunc("/admin/rotatekeys", adminHandlers.RotateKeysHandler).Methods("POST")
+	}
+
+	r.HandleFunc("/notifications/", notificationHandlers.CreateNotificationHandler).Methods("POST")
+	r.HandleFunc("/notifications/", notificationHandlers.ListNotificationsHandler).Methods("GET")
+	r.HandleFunc("/notifications/{id}/", notificationHandlers.GetNotificationHandler).Methods("GET")
+	r.HandleFunc("/notifications/{id}/", notificationHandlers.UpdateNotificationHandler).Methods("PUT")
+	r.HandleFunc("/notifications/{id}/", notificationHandlers.DeleteNotificationHandler).Methods("DELETE")
+	r.HandleFunc("/notifications/{id}/deliveries", notificationHandlers.ListDeliveriesHandler).Methods("GET")
+
+	r.HandleFunc("/events", eventStreamHandlers.StreamEventsHandler).Methods("GET")
+
+	go logEvents()
+	go dispatcher.Run(eventBus)
+	if healthInterval > 0 {
+		go monitor.Run()
+	}
+	if driftInterval > 0 {
+		go reconciler.Run()
+	}
+	if gcInterval > 0 {
+		go gcSweeper.Run()
+	}
+	if secretsInterval > 0 && vaultAddr != "" {
+		go secretRefresher.Run()
+	}
+
 	fmt.Printf("Deployer starting and listening on port %v\n", port)
 	if err := http.ListenAndServe(":"+port, r); err != nil {
 		log.Fatal(err)
 	}
 
 }
+
+// log all events as JSON, so they can be picked up by log based alerting
+func logEvents() {
+	for event := range eventBus.Subscribe() {
+		log.Printf("EVENT %v", event.String())
+	}
+}
@@ -21,12 +21,13 @@ import (
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/bluegreen"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/cluster"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/events"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/secrets"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
 	"k8s.io/apimachinery/pkg/api/errors"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/pkg/api/v1"
 )
 
 type Deployer struct {
@@ -56,50 +57,30 @@ func (deployer *Deployer) deploy(deployment *types.Deployment, logger logger.Log
 
 	clusterManager := cluster.NewClusterManager(deployer.Config, deployment, deployer.Registry, logger)
 	if deployment.Version == "000" {
-		rc, err := clusterManager.FindOldReplicationControllers()
+		newVersion, err := clusterManager.ResolveVersion(true)
 		if err != nil {
-			deployer.handleError(logger, deployment, "Error getting replication controllers for determining next version: %v", err.Error())
+			deployer.handleError(logger, deployment, "%v", err.Error())
 			return
-		} else if len(rc) == 0 {
-			clusterManager.Deployment.Version = "1"
-		} else {
-
-			// sometimes we have orphaned RCs, sort them out
-			var activeRcs = []v1.ReplicationController{}
-			for _, ctrl := range rc {
-				if ctrl.DeletionTimestamp == nil {
-					activeRcs = append(activeRcs, ctrl)
-				} else {
-					logger.Printf("Note: found orphaned replication controller %v, will try to finally delete it...\n", ctrl.Name)
-					clusterManager.Config.K8sClient.DeleteReplicationController(ctrl.Namespace, ctrl.Name)
-				}
-			}
-
-			if len(activeRcs) == 0 {
-				clusterManager.Deployment.Version = "1"
-			} else if len(activeRcs) > 1 {
-				deployer.handleError(logger, deployment, "Could not determine next deployment version, more than a singe Replication Controller found")
-				return
-			} else {
-				var ctrl = activeRcs[0]
-				logger.Println(ctrl.Name)
-				versionString := ctrl.Labels["version"]
-				newVersion, err := cluster.DetermineNewVersion(versionString)
-				if err != nil {
-					deployer.handleError(logger, deployment, "Could not determine next deployment version based on current version %v", err.Error())
-					return
-				} else {
-					logger.Printf("New deployment version: %v", newVersion)
-					clusterManager.Deployment.Version = newVersion
-				}
-			}
 		}
+		logger.Printf("New deployment version: %v", newVersion)
+		clusterManager.Deployment.Version = newVersion
 	}
 
-	var err error
-	clusterManager.Deployment.Descriptor.Environment, err = deployer.Registry.GetEnvironmentVars()
+	environment, err := deployer.Registry.GetEnvironmentVars(deployment.Descriptor.Namespace, deployment.Descriptor.AppName)
 	if err != nil {
-		logger.Println("No environment vars found")
+		deployer.handleError(logger, deployment, "Error getting environment vars: %v", err.Error())
+		return
+	}
+	clusterManager.Deployment.Descriptor.SetEnvironment(environment)
+
+	if deployer.Config.SecretResolver.HasReferences(deployment.Descriptor) {
+		values, err := deployer.Config.SecretResolver.Resolve(deployment.Descriptor)
+		if err != nil {
+			deployer.handleError(logger, deployment, "Error resolving secrets: %v", err.Error())
+			return
+		}
+		clusterManager.Deployment.Descriptor.ProviderSecrets = values
+		deployment.SecretsHash = secrets.Hash(values)
 	}
 
 	var deploymentError error
@@ -132,6 +113,7 @@ func (deployer *Deployer) deploy(deployment *types.Deployment, logger logger.Log
 	if deploymentError != nil {
 		deployer.handleError(logger, deployment, "Deployment failed! %v\n", deploymentError.Error())
 		clusterManager.CleanupFailedDeployment()
+		deployer.Config.Events.Publish(events.NewDeploymentEvent(events.EVENT_DEPLOYMENT_ROLLEDBACK, deployment, "Rolled back failed deployment of version %v", deployment.Version))
 	}
 }
 
@@ -142,4 +124,5 @@ func (d *Deployer) handleError(logger logger.Logger, deployment *types.Deploymen
 	logger.Println(msg)
 	deployment.Status = types.DEPLOYMENTSTATUS_FAILURE
 	d.Registry.UpdateDeployment(deployment)
+	d.Config.Events.Publish(events.NewDeploymentEvent(events.EVENT_DEPLOYMENT_FAILED, deployment, msg))
 }
@@ -26,11 +26,14 @@ import (
 	"sort"
 	"strconv"
 
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/defaults"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/descriptors"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/events"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/variables"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/satori/go.uuid"
@@ -146,7 +149,7 @@ func (d *DeploymentHandlers) ListDeploymentsHandler(writer http.ResponseWriter,
 		}
 	}
 
-	helper.HandleSuccess(writer, logger, deployments, "Successfully listed deployments")
+	helper.HandleSuccess(writer, logger, helper.RedactDeployments(req, d.registry.SensitiveFields(), deployments), "Successfully listed deployments")
 }
 
 func limitDeployments(deployments []*types.Deployment, limit int) []*types.Deployment {
@@ -200,9 +203,9 @@ func (d *DeploymentHandlers) DeleteDeploymentsHandler(writer http.ResponseWriter
 
 	var deployed *types.Deployment
 	for _, deployment := range deployments {
-		if deployment.Status == types.DEPLOYMENTSTATUS_DEPLOYED {
+		if deployment.Status == types.DEPLOYMENTSTATUS_DEPLOYED || deployment.Status == types.DEPLOYMENTSTATUS_PAUSED {
 			deployed = deployment
-		} else if deployment.Status == types.DEPLOYMENTSTATUS_DEPLOYING || deployment.Status == types.DEPLOYMENTSTATUS_UNDEPLOYING {
+		} else if deployment.IsBusy() {
 			myLogger.Println("ignoring ongoing (un)deployment...")
 			continue
 		} else {
@@ -247,7 +250,16 @@ func (d *DeploymentHandlers) GetDeploymentHandler(writer http.ResponseWriter, re
 		return
 	}
 
-	helper.HandleSuccess(writer, logger, deployment, "Deployment %v found.", id)
+	timeout, err := parseWaitTimeout(req)
+	if err != nil {
+		helper.HandleError(writer, logger, 400, "%v", err)
+		return
+	} else if timeout > 0 {
+		d.waitForDeployment(writer, req, deployment, timeout, logger)
+		return
+	}
+
+	helper.HandleSuccess(writer, logger, helper.RedactDeployment(req, d.registry.SensitiveFields(), deployment), "Deployment %v found.", id)
 }
 
 func (d *DeploymentHandlers) GetHealthcheckDataHandler(writer http.ResponseWriter, req *http.Request) {
@@ -367,7 +379,7 @@ func (d *DeploymentHandlers) isBusy(namespace string, id string, logger logger.L
 	if err != nil {
 		return false, err
 	}
-	return deployment.Status == types.DEPLOYMENTSTATUS_DEPLOYING || deployment.Status == types.DEPLOYMENTSTATUS_UNDEPLOYING, nil
+	return deployment.IsBusy(), nil
 }
 
 func (d *DeploymentHandlers) UpdateDeploymentHandler(writer http.ResponseWriter, req *http.Request) {
@@ -444,28 +456,73 @@ func (d *DeploymentHandlers) createDeployment(jsonString []byte) (*types.Deploym
 }
 
 func (d *DeploymentHandlers) deploy(writer http.ResponseWriter, req *http.Request, descriptor *types.Descriptor, myLogger logger.Logger) {
+	timeout, err := parseWaitTimeout(req)
+	if err != nil {
+		helper.HandleError(writer, myLogger, 400, "%v", err)
+		return
+	}
+
+	deployment, err := d.startDeployment(descriptor, variables.Parameters(req.URL.Query()), myLogger)
+	if err != nil {
+		helper.HandleError(writer, myLogger, startErrorStatus(err), "Error starting deployment: %v", err)
+		return
+	}
+
+	d.handleDeploymentStarted(writer, req, deployment, timeout, myLogger)
+}
+
+// startDeployment stores a new deployment of the given descriptor and starts deploying it async.
+// The placeholders of the descriptor are resolved with the namespace variables and the given parameters.
+func (d *DeploymentHandlers) startDeployment(descriptor *types.Descriptor, parameters map[string]string, myLogger logger.Logger) (*types.Deployment, error) {
+
+	// deploy the descriptor as it is merged with the namespace and global defaults
+	effective, err := defaults.Effective(d.registry, descriptor)
+	if err != nil {
+		return nil, err
+	}
+	if err := variables.ResolveWithRegistry(d.registry, effective, parameters); err != nil {
+		return nil, err
+	}
 
 	deployment := &types.Deployment{}
-	deployment.Descriptor = descriptor
+	deployment.Descriptor = effective
+	deployment.DescriptorRevision = descriptor.Revision
+	if len(parameters) > 0 {
+		deployment.Parameters = parameters
+	}
 	deployment.Id = uuid.NewV4().String()
 	deployment.SetVersion()
 	deployment.Status = types.DEPLOYMENTSTATUS_DEPLOYING
 
-	err := d.registry.CreateDeployment(deployment)
+	err = d.registry.CreateDeployment(deployment)
 	if err != nil {
-		helper.HandleError(writer, myLogger, 500, "Error storing deployment: %v", err)
-		return
+		return nil, err
 	}
 
 	myLogger = logger.NewDeploymentLogger(deployment, d.config.EtcdRegistry, myLogger)
 	myLogger.Println("Deployment id: " + deployment.Id)
 
+	d.config.Events.Publish(events.NewDeploymentEvent(events.EVENT_DEPLOYMENT_STARTED, deployment, "Deployment of %v started", deployment.Descriptor.AppName))
+
 	deployer := NewDeployer(d.config)
 
 	// start deployment async
 	go deployer.deploy(deployment, myLogger)
 
-	helper.HandleStarted(writer, myLogger, "/deployments/"+deployment.Id+"/?namespace="+descriptor.Namespace, "Deployment started: %v", deployment.Id)
+	return deployment, nil
+}
+
+// Redeploy starts a new deployment of the descriptor and parameters of the given deployment
+func (d *DeploymentHandlers) Redeploy(deployment *types.Deployment, myLogger logger.Logger) (*types.Deployment, error) {
+	return d.startDeployment(deployment.Descriptor, deployment.Parameters, myLogger)
+}
+
+// startErrorStatus returns 400 for descriptors which can't be resolved, 500 for all other errors
+func startErrorStatus(err error) int {
+	if _, isUnresolved := err.(*variables.UnresolvedError); isUnresolved {
+		return 400
+	}
+	return 500
 }
 
 func (d *DeploymentHandlers) undeploy(writer http.ResponseWriter, req *http.Request, deployment *types.Deployment, myLogger logger.Logger) {
@@ -480,6 +537,16 @@ func (d *DeploymentHandlers) undeploy(writer http.ResponseWriter, req *http.Requ
 	helper.HandleStarted(writer, myLogger, "/deployments/"+deployment.Id, "Undeployment started, namespace %v, appname %v", deployment.Descriptor.Namespace, deployment.Descriptor.AppName)
 }
 
+// lockApp acquires the mutex of the app, which is held by (un)deployments too. It returns the function releasing it.
+func (d *DeploymentHandlers) lockApp(namespace string, appName string, myLogger logger.Logger) func() {
+	mutexKey := namespace + "-" + appName
+	myLogger.Printf("Trying to acquire mutex for %v", mutexKey)
+	mutex := helper.GetMutex(d.config.Mutexes, mutexKey)
+	mutex.Lock()
+	myLogger.Printf("Acquired mutex for %v", mutexKey)
+	return mutex.Unlock
+}
+
 func (d *DeploymentHandlers) getDeployment(namespace string, id string, logger logger.Logger) (*types.Deployment, error) {
 	logger.Printf("Getting deployment %v\n", id)
 
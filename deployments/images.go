@@ -0,0 +1,218 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package deployments
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/variables"
+	"github.com/gorilla/mux"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// UpdateImagesHandler sets the images of the given containers in the descriptor of an app, and deploys the updated descriptor
+func (d *DeploymentHandlers) UpdateImagesHandler(writer http.ResponseWriter, req *http.Request) {
+	myLogger := logger.NewConsoleLogger()
+	myLogger.Println("Updating images")
+
+	//TODO check namespaces of user
+	namespace := req.URL.Query().Get("namespace")
+	if namespace == "" {
+		helper.HandleError(writer, myLogger, 400, "Namespace parameter missing")
+		return
+	}
+
+	appName := mux.Vars(req)["name"]
+	if appName == "" {
+		helper.HandleError(writer, myLogger, 400, "Appname parameter missing")
+		return
+	}
+
+	timeout, err := parseWaitTimeout(req)
+	if err != nil {
+		helper.HandleError(writer, myLogger, 400, "%v", err)
+		return
+	}
+
+	defer req.Body.Close()
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		helper.HandleError(writer, myLogger, 500, "Error reading body: %v", err)
+		return
+	}
+
+	images := make(map[string]string)
+	if err := json.Unmarshal(body, &images); err != nil {
+		helper.HandleError(writer, myLogger, 400, "Error parsing body: %v", err)
+		return
+	}
+	if len(images) == 0 {
+		helper.HandleError(writer, myLogger, 400, "No images given")
+		return
+	}
+
+	// prevent concurrent image updates of the same app from overwriting each other
+	mutex := helper.GetMutex(d.config.Mutexes, "images-"+namespace+"-"+appName)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	descriptors, err := d.registry.GetDescriptorsByAppName(namespace, appName)
+	if err == etcdregistry.ErrDescriptorNotFound {
+		helper.HandleNotFound(writer, myLogger, "No descriptor found for app %v", appName)
+		return
+	} else if err != nil {
+		helper.HandleError(writer, myLogger, 500, "Error getting descriptors of app %v: %v", appName, err)
+		return
+	}
+
+	var descriptor *types.Descriptor
+	descriptorId := req.URL.Query().Get("descriptorId")
+	if descriptorId != "" {
+		for _, candidate := range descriptors {
+			if candidate.Id == descriptorId {
+				descriptor = candidate
+			}
+		}
+		if descriptor == nil {
+			helper.HandleNotFound(writer, myLogger, "Descriptor %v of app %v not found", descriptorId, appName)
+			return
+		}
+	} else if len(descriptors) > 1 {
+		helper.HandleError(writer, myLogger, 400, "App %v has multiple descriptors, descriptorId parameter missing", appName)
+		return
+	} else {
+		descriptor = descriptors[0]
+	}
+
+	// read the descriptor again with its index, so concurrent changes aren't overwritten
+	descriptorId = descriptor.Id
+	descriptor, index, err := d.registry.GetDescriptorWithIndex(namespace, descriptorId)
+	if err == etcdregistry.ErrDescriptorNotFound {
+		helper.HandleNotFound(writer, myLogger, "Descriptor %v of app %v not found", descriptorId, appName)
+		return
+	} else if err != nil {
+		helper.HandleError(writer, myLogger, 500, "Error getting descriptor of app %v: %v", appName, err)
+		return
+	}
+
+	// keep a copy for restoring the descriptor if the deployment can't be started
+	oldDescriptor := *descriptor
+	oldDescriptor.PodSpec.Containers = append([]v1.Container{}, descriptor.PodSpec.Containers...)
+
+	if err := SetImages(descriptor, images); err != nil {
+		helper.HandleError(writer, myLogger, 400, "Error updating images: %v", err)
+		return
+	}
+
+	descriptor.ModifiedBy = helper.GetUser(req)
+	index, err = d.registry.UpdateDescriptorIfUnmodified(descriptor, index)
+	if err == etcdregistry.ErrConcurrentUpdate {
+		helper.HandleError(writer, myLogger, 409, "Descriptor %v was modified in the meantime, try again", descriptor.Id)
+		return
+	} else if err != nil {
+		helper.HandleError(writer, myLogger, 500, "Error updating descriptor: %v", err)
+		return
+	}
+	myLogger.Printf("Updated images of descriptor %v", descriptor.Id)
+
+	deployment, err := d.startDeployment(descriptor, variables.Parameters(req.URL.Query()), myLogger)
+	if err != nil {
+		if _, restoreErr := d.registry.UpdateDescriptorIfUnmodified(&oldDescriptor, index); restoreErr == etcdregistry.ErrConcurrentUpdate {
+			myLogger.Printf("Descriptor %v was modified in the meantime, not restoring its images", descriptor.Id)
+		} else if restoreErr != nil {
+			myLogger.Printf("Error restoring descriptor %v: %v", descriptor.Id, restoreErr)
+		}
+		helper.HandleError(writer, myLogger, startErrorStatus(err), "Error starting deployment: %v", err)
+		return
+	}
+
+	d.handleDeploymentStarted(writer, req, deployment, timeout, myLogger)
+}
+
+// SetImages sets the images of the containers with the given names.
+// Nothing is changed if one of the containers doesn't exist or an image is empty.
+func SetImages(descriptor *types.Descriptor, images map[string]string) error {
+	indexes := make(map[string]int)
+	for i, container := range descriptor.PodSpec.Containers {
+		indexes[container.Name] = i
+	}
+
+	var unknown []string
+	for name, image := range images {
+		if _, ok := indexes[name]; !ok {
+			unknown = append(unknown, name)
+		} else if strings.TrimSpace(image) == "" {
+			return fmt.Errorf("empty image for container %v", name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown container(s) %v", strings.Join(unknown, ", "))
+	}
+
+	for name, image := range images {
+		descriptor.PodSpec.Containers[indexes[name]].Image = image
+	}
+	return nil
+}
+
+// SplitImage splits an image reference like "registry:5000/user/app:1.0" into repository and tag.
+// The tag is empty if the image has none.
+func SplitImage(image string) (string, string) {
+	// ignore digests
+	if index := strings.Index(image, "@"); index >= 0 {
+		image = image[:index]
+	}
+	index := strings.LastIndex(image, ":")
+	if index < 0 || strings.Contains(image[index:], "/") {
+		// no tag, the colon belongs to the registry port
+		return image, ""
+	}
+	return image[:index], image[index+1:]
+}
+
+// MatchesRepository checks if the repository of an image is the given repository.
+// Repositories without registry host match images of any registry, e.g. "user/app" matches "docker.io/user/app".
+func MatchesRepository(imageRepository string, repository string) bool {
+	if imageRepository == repository {
+		return true
+	}
+	return strings.HasSuffix(imageRepository, "/"+repository)
+}
+
+// SetImageTag sets the tag of all containers with an image of the given repository.
+// It returns the number of changed containers.
+func SetImageTag(descriptor *types.Descriptor, repository string, tag string) int {
+	changed := 0
+	for i, container := range descriptor.PodSpec.Containers {
+		imageRepository, _ := SplitImage(container.Image)
+		if !MatchesRepository(imageRepository, repository) {
+			continue
+		}
+		descriptor.PodSpec.Containers[i].Image = imageRepository + ":" + tag
+		changed++
+	}
+	return changed
+}
@@ -0,0 +1,66 @@
+package deployments
+
+import (
+	"testing"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestSplitImage(t *testing.T) {
+	tests := map[string][2]string{
+		"app":                        {"app", ""},
+		"user/app:1.0":               {"user/app", "1.0"},
+		"registry:5000/user/app":     {"registry:5000/user/app", ""},
+		"registry:5000/user/app:1.0": {"registry:5000/user/app", "1.0"},
+		"user/app:1.0@sha256:abc":    {"user/app", "1.0"},
+	}
+	for image, expected := range tests {
+		repository, tag := SplitImage(image)
+		if repository != expected[0] || tag != expected[1] {
+			t.Errorf("Unexpected split of %v: %v %v", image, repository, tag)
+		}
+	}
+}
+
+func TestSetImageTag(t *testing.T) {
+	descriptor := &types.Descriptor{PodSpec: v1.PodSpec{Containers: []v1.Container{
+		{Name: "app", Image: "docker.io/user/app:1.0"},
+		{Name: "sidecar", Image: "user/sidecar:1.0"},
+	}}}
+
+	if changed := SetImageTag(descriptor, "user/app", "2.0"); changed != 1 {
+		t.Errorf("Expected 1 changed container, got %v", changed)
+	}
+	if descriptor.PodSpec.Containers[0].Image != "docker.io/user/app:2.0" || descriptor.PodSpec.Containers[1].Image != "user/sidecar:1.0" {
+		t.Errorf("Unexpected images %+v", descriptor.PodSpec.Containers)
+	}
+
+	if changed := SetImageTag(descriptor, "other/app", "2.0"); changed != 0 {
+		t.Errorf("Expected no changed containers, got %v", changed)
+	}
+	if changed := SetImageTag(descriptor, "", "2.0"); changed != 0 {
+		t.Errorf("Expected no changed containers without repository, got %v", changed)
+	}
+}
+
+func TestSetImages(t *testing.T) {
+	descriptor := &types.Descriptor{PodSpec: v1.PodSpec{Containers: []v1.Container{
+		{Name: "app", Image: "user/app:1.0"},
+		{Name: "sidecar", Image: "user/sidecar:1.0"},
+	}}}
+
+	if err := SetImages(descriptor, map[string]string{"app": "user/app:2.0", "unknown": "user/unknown:2.0"}); err == nil {
+		t.Error("Expected error for unknown container")
+	}
+	if descriptor.PodSpec.Containers[0].Image != "user/app:1.0" {
+		t.Errorf("Expected unchanged images on error, got %v", descriptor.PodSpec.Containers[0].Image)
+	}
+
+	if err := SetImages(descriptor, map[string]string{"sidecar": "user/sidecar:2.0"}); err != nil {
+		t.Fatal(err)
+	}
+	if descriptor.PodSpec.Containers[0].Image != "user/app:1.0" || descriptor.PodSpec.Containers[1].Image != "user/sidecar:2.0" {
+		t.Errorf("Unexpected images %+v", descriptor.PodSpec.Containers)
+	}
+}
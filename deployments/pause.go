@@ -0,0 +1,292 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package deployments
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/cluster"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/events"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"github.com/gorilla/mux"
+)
+
+var ErrNotPaused = errors.New("Deployment is not paused")
+
+func (d *DeploymentHandlers) PauseDeploymentHandler(writer http.ResponseWriter, req *http.Request) {
+	var myLogger logger.Logger
+	myLogger = logger.NewConsoleLogger()
+	myLogger.Println("Pausing deployment")
+
+	deployment, ok := d.getDeploymentWithStatus(writer, req, types.DEPLOYMENTSTATUS_DEPLOYED, ErrNotDeployed, myLogger)
+	if !ok {
+		return
+	}
+
+	myLogger = logger.NewDeploymentLogger(deployment, d.registry, myLogger)
+
+	// start pause async
+	go d.pause(deployment.Descriptor.Namespace, deployment.Id, myLogger)
+
+	helper.HandleStarted(writer, myLogger, "/deployments/"+deployment.Id, "Pause started, namespace %v, appname %v", deployment.Descriptor.Namespace, deployment.Descriptor.AppName)
+}
+
+func (d *DeploymentHandlers) ResumeDeploymentHandler(writer http.ResponseWriter, req *http.Request) {
+	var myLogger logger.Logger
+	myLogger = logger.NewConsoleLogger()
+	myLogger.Println("Resuming deployment")
+
+	deployment, ok := d.getDeploymentWithStatus(writer, req, types.DEPLOYMENTSTATUS_PAUSED, ErrNotPaused, myLogger)
+	if !ok {
+		return
+	}
+
+	myLogger = logger.NewDeploymentLogger(deployment, d.registry, myLogger)
+
+	// start resume async
+	go d.resume(deployment.Descriptor.Namespace, deployment.Id, myLogger)
+
+	helper.HandleStarted(writer, myLogger, "/deployments/"+deployment.Id, "Resume started, namespace %v, appname %v", deployment.Descriptor.Namespace, deployment.Descriptor.AppName)
+}
+
+// getDeploymentWithStatus returns the deployment of the request, or handles the error if it doesn't exist or doesn't have the given status
+func (d *DeploymentHandlers) getDeploymentWithStatus(writer http.ResponseWriter, req *http.Request, status string, statusErr error, myLogger logger.Logger) (*types.Deployment, bool) {
+	//TODO check namespaces of user
+	namespace := req.URL.Query().Get("namespace")
+	if namespace == "" {
+		helper.HandleError(writer, myLogger, 400, "Namespace parameter missing")
+		return nil, false
+	}
+
+	vars := mux.Vars(req)
+	id := vars["id"]
+	if id == "" {
+		helper.HandleError(writer, myLogger, 400, "Missing id")
+		return nil, false
+	}
+
+	deployment, err := d.registry.GetDeploymentById(namespace, id)
+	if err != nil && err != etcdregistry.ErrDeploymentNotFound {
+		helper.HandleError(writer, myLogger, 500, "Error getting deployment for namespace %v with id %v: %v", namespace, id, err)
+		return nil, false
+	} else if err == etcdregistry.ErrDeploymentNotFound {
+		helper.HandleNotFound(writer, myLogger, "Deployment %v not found.", id)
+		return nil, false
+	}
+	if deployment.Status != status {
+		helper.HandleError(writer, myLogger, 409, "Deployment %v has status %v: %v", id, deployment.Status, statusErr)
+		return nil, false
+	}
+	return deployment, true
+}
+
+// pause scales the replication controller of a deployed deployment to zero, after pointing its Ingress at the maintenance backend.
+// The replicas are stored on the deployment for resuming it.
+func (d *DeploymentHandlers) pause(namespace string, id string, myLogger logger.Logger) {
+	deployment, err := d.registry.GetDeploymentById(namespace, id)
+	if err != nil {
+		myLogger.Printf("Error getting deployment %v: %v", id, err)
+		return
+	}
+
+	unlock := d.lockApp(namespace, deployment.Descriptor.AppName, myLogger)
+	defer unlock()
+
+	// the deployment might have been replaced while waiting for the mutex
+	if deployment, err = d.registry.GetDeploymentById(namespace, id); err != nil {
+		myLogger.Printf("Error getting deployment %v: %v", id, err)
+		return
+	}
+	if deployment.Status != types.DEPLOYMENTSTATUS_DEPLOYED {
+		myLogger.Printf("Pause cancelled, deployment %v is not deployed anymore", id)
+		return
+	}
+	descriptor := deployment.Descriptor
+
+	rc, err := d.config.K8sClient.GetReplicationController(namespace, deployment.GetVersionedName())
+	if err != nil {
+		d.handleStatusError(myLogger, deployment, types.DEPLOYMENTSTATUS_DEPLOYED, events.EVENT_PAUSE_FAILED, "Pause failed! Error getting replication controller: %v", err)
+		return
+	}
+	deployment.PausedReplicas = deployment.GetReplicas()
+	if rc.Spec.Replicas != nil {
+		deployment.PausedReplicas = int(*rc.Spec.Replicas)
+	}
+	deployment.Status = types.DEPLOYMENTSTATUS_PAUSING
+	d.registry.UpdateDeployment(deployment)
+
+	clusterManager := cluster.NewClusterManager(d.config, deployment, d.registry, myLogger)
+
+	if descriptor.Frontend != "" {
+		myLogger.Println("Pointing Ingress at the maintenance backend")
+		if err := d.config.IngressConfigurator.SetMaintenance(deployment, myLogger); err != nil {
+			deployment.PausedReplicas = 0
+			d.handleStatusError(myLogger, deployment, types.DEPLOYMENTSTATUS_DEPLOYED, events.EVENT_PAUSE_FAILED, "Pause failed! Error updating Ingress: %v", err)
+			return
+		}
+	}
+
+	// an autoscaler doesn't scale to zero, it's created again on resume
+	if descriptor.Autoscaling != nil {
+		clusterManager.DeleteAutoscaler()
+	}
+
+	myLogger.Printf("Scaling %v from %v to 0 replicas", deployment.GetVersionedName(), deployment.PausedReplicas)
+	if err := clusterManager.Scale(0); err != nil {
+		d.resetPause(deployment, clusterManager, myLogger, "Pause failed! Error scaling replication controller: %v", err)
+		return
+	}
+	// the pods are gone, so failing the pause doesn't help anymore
+	if err := clusterManager.ScaleDisruptionBudget(0); err != nil {
+		myLogger.Printf("Error updating disruption budget: %v", err)
+	}
+
+	deployment.Status = types.DEPLOYMENTSTATUS_PAUSED
+	if err := d.registry.UpdateDeployment(deployment); err != nil {
+		myLogger.Println("WARNING: couldn't update deployment status to PAUSED!")
+	}
+	myLogger.Println("Pause successful")
+	d.config.Events.Publish(events.NewDeploymentEvent(events.EVENT_DEPLOYMENT_PAUSED, deployment,
+		"Paused %v version %v", descriptor.AppName, deployment.Version))
+}
+
+// resetPause restores the Ingress and the autoscaler of a deployment which couldn't be paused
+func (d *DeploymentHandlers) resetPause(deployment *types.Deployment, clusterManager *cluster.ClusterManager, myLogger logger.Logger, msg string, args ...interface{}) {
+	if deployment.Descriptor.Autoscaling != nil {
+		if err := clusterManager.CreateOrUpdateAutoscaler(); err != nil {
+			myLogger.Printf("Error restoring autoscaler: %v", err)
+		}
+	}
+	if err := d.restoreProxy(deployment, myLogger); err != nil {
+		myLogger.Printf("Error restoring Ingress: %v", err)
+	}
+	deployment.PausedReplicas = 0
+	d.handleStatusError(myLogger, deployment, types.DEPLOYMENTSTATUS_DEPLOYED, events.EVENT_PAUSE_FAILED, msg, args...)
+}
+
+// resume scales the replication controller of a paused deployment back to its previous replicas, and restores its Ingress
+// when the pods are healthy
+func (d *DeploymentHandlers) resume(namespace string, id string, myLogger logger.Logger) {
+	deployment, err := d.registry.GetDeploymentById(namespace, id)
+	if err != nil {
+		myLogger.Printf("Error getting deployment %v: %v", id, err)
+		return
+	}
+
+	unlock := d.lockApp(namespace, deployment.Descriptor.AppName, myLogger)
+	defer unlock()
+
+	// the deployment might have been replaced while waiting for the mutex
+	if deployment, err = d.registry.GetDeploymentById(namespace, id); err != nil {
+		myLogger.Printf("Error getting deployment %v: %v", id, err)
+		return
+	}
+	if deployment.Status != types.DEPLOYMENTSTATUS_PAUSED {
+		myLogger.Printf("Resume cancelled, deployment %v is not paused anymore", id)
+		return
+	}
+	descriptor := deployment.Descriptor
+
+	replicas := deployment.PausedReplicas
+	if replicas == 0 {
+		replicas = deployment.GetReplicas()
+	}
+	deployment.Status = types.DEPLOYMENTSTATUS_RESUMING
+	d.registry.UpdateDeployment(deployment)
+
+	clusterManager := cluster.NewClusterManager(d.config, deployment, d.registry, myLogger)
+
+	myLogger.Printf("Scaling %v from 0 to %v replicas", deployment.GetVersionedName(), replicas)
+	if err := clusterManager.Scale(replicas); err != nil {
+		d.resetResume(deployment, clusterManager, myLogger, "Resume failed! Error scaling replication controller: %v", err)
+		return
+	}
+	if err := clusterManager.ScaleDisruptionBudget(replicas); err != nil {
+		d.resetResume(deployment, clusterManager, myLogger, "Resume failed! Error updating disruption budget: %v", err)
+		return
+	}
+	if descriptor.Autoscaling != nil {
+		if err := clusterManager.CreateOrUpdateAutoscaler(); err != nil {
+			d.resetResume(deployment, clusterManager, myLogger, "Resume failed! Error creating autoscaler: %v", err)
+			return
+		}
+	}
+
+	myLogger.Printf("Waiting up to %v seconds for pods to start and to become healthy", d.config.HealthTimeout)
+	if err := clusterManager.WaitForPods(replicas); err != nil {
+		d.resetResume(deployment, clusterManager, myLogger, "Resume failed! %v", err)
+		return
+	}
+
+	if descriptor.Frontend != "" {
+		myLogger.Println("Restoring Ingress")
+		if err := d.restoreProxy(deployment, myLogger); err != nil {
+			d.resetResume(deployment, clusterManager, myLogger, "Resume failed! Error restoring Ingress: %v", err)
+			return
+		}
+	}
+
+	deployment.Status = types.DEPLOYMENTSTATUS_DEPLOYED
+	deployment.PausedReplicas = 0
+	if err := d.registry.UpdateDeployment(deployment); err != nil {
+		myLogger.Println("WARNING: couldn't update deployment status to DEPLOYED!")
+	}
+	myLogger.Println("Resume successful")
+	d.config.Events.Publish(events.NewDeploymentEvent(events.EVENT_DEPLOYMENT_RESUMED, deployment,
+		"Resumed %v version %v with %v replicas", descriptor.AppName, deployment.Version, replicas))
+}
+
+// resetResume scales a deployment which couldn't be resumed back to zero, and keeps it paused
+func (d *DeploymentHandlers) resetResume(deployment *types.Deployment, clusterManager *cluster.ClusterManager, myLogger logger.Logger, msg string, args ...interface{}) {
+	if deployment.Descriptor.Autoscaling != nil {
+		clusterManager.DeleteAutoscaler()
+	}
+	if err := clusterManager.Scale(0); err != nil {
+		myLogger.Printf("Error scaling replication controller back to 0 replicas: %v", err)
+	}
+	if err := clusterManager.ScaleDisruptionBudget(0); err != nil {
+		myLogger.Printf("Error updating disruption budget: %v", err)
+	}
+	d.handleStatusError(myLogger, deployment, types.DEPLOYMENTSTATUS_PAUSED, events.EVENT_RESUME_FAILED, msg, args...)
+}
+
+// restoreProxy points the Ingress of the app at the versioned service of the deployment again
+func (d *DeploymentHandlers) restoreProxy(deployment *types.Deployment, myLogger logger.Logger) error {
+	if deployment.Descriptor.Frontend == "" {
+		return nil
+	}
+	service, err := d.config.K8sClient.GetService(deployment.Descriptor.Namespace, deployment.GetVersionedName())
+	if err != nil {
+		return err
+	}
+	return d.config.IngressConfigurator.CreateOrUpdateProxy(deployment, service, myLogger)
+}
+
+// handleStatusError logs the error, sets the status of the deployment and publishes the given event
+func (d *DeploymentHandlers) handleStatusError(myLogger logger.Logger, deployment *types.Deployment, status string, eventType string, msg string, args ...interface{}) {
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+	myLogger.Println(msg)
+	deployment.Status = status
+	d.registry.UpdateDeployment(deployment)
+	d.config.Events.Publish(events.NewDeploymentEvent(eventType, deployment, msg))
+}
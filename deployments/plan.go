@@ -0,0 +1,163 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package deployments
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/cluster"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/defaults"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/descriptors"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/manifests"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/plan"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/variables"
+)
+
+// PlanDeploymentHandler returns the changes a deployment would make to the cluster, without deploying anything.
+// The descriptor is either given by id, or in the body for planning unsaved descriptor changes.
+func (d *DeploymentHandlers) PlanDeploymentHandler(writer http.ResponseWriter, req *http.Request) {
+	myLogger := logger.NewConsoleLogger()
+	myLogger.Println("Planning deployment")
+
+	descriptor, ok := d.readDescriptor(writer, req, myLogger)
+	if !ok {
+		return
+	}
+
+	planner := plan.NewPlanner(d.config)
+	if !helper.IsAdmin(req) {
+		planner.SetSensitiveFields(d.registry.SensitiveFields())
+	}
+	result, err := planner.Plan(descriptor, myLogger)
+	if err != nil {
+		helper.HandleError(writer, myLogger, 500, "Error planning deployment: %v", err)
+		return
+	}
+
+	helper.HandleSuccess(writer, myLogger, result, "Planned deployment of %v version %v", descriptor.AppName, result.Version)
+}
+
+// RenderManifestsHandler returns the Kubernetes objects of a deployment as multi-document YAML.
+// The descriptor is given like for planning, the version defaults to the version the next deployment would get.
+func (d *DeploymentHandlers) RenderManifestsHandler(writer http.ResponseWriter, req *http.Request) {
+	myLogger := logger.NewConsoleLogger()
+	myLogger.Println("Rendering manifests")
+
+	descriptor, ok := d.readDescriptor(writer, req, myLogger)
+	if !ok {
+		return
+	}
+
+	if version := req.URL.Query().Get("version"); version != "" {
+		descriptor.NewVersion = version
+	}
+	deployment := &types.Deployment{Descriptor: descriptor}
+	deployment.SetVersion()
+	version, err := cluster.NewClusterManager(d.config, deployment, d.registry, myLogger).ResolveVersion(false)
+	if err != nil {
+		helper.HandleError(writer, myLogger, 500, "Error resolving version: %v", err)
+		return
+	}
+
+	environment, err := d.registry.GetEnvironmentVars(descriptor.Namespace, descriptor.AppName)
+	if err != nil {
+		myLogger.Printf("Error getting environment vars: %v", err)
+	}
+	descriptor.SetEnvironment(environment)
+	if !helper.IsAdmin(req) {
+		d.registry.SensitiveFields().Redact(descriptor)
+	}
+
+	rendered, err := manifests.Render(descriptor, version, d.config.IngressConfigurator, d.config.SecretResolver, myLogger)
+	if err != nil {
+		helper.HandleError(writer, myLogger, 500, "Error rendering manifests: %v", err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/x-yaml")
+	helper.HandleSuccess(writer, myLogger, string(rendered), "Rendered manifests of %v version %v", descriptor.AppName, version)
+}
+
+// readDescriptor returns the valid descriptor given by id or in the body, or handles the error
+func (d *DeploymentHandlers) readDescriptor(writer http.ResponseWriter, req *http.Request, myLogger logger.Logger) (*types.Descriptor, bool) {
+
+	//TODO check namespaces of user
+	namespace := req.URL.Query().Get("namespace")
+	if namespace == "" {
+		helper.HandleError(writer, myLogger, 400, "Namespace parameter missing")
+		return nil, false
+	}
+
+	defer req.Body.Close()
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		helper.HandleError(writer, myLogger, 500, "Error reading body: %v", err)
+		return nil, false
+	}
+
+	var descriptor *types.Descriptor
+	if len(strings.TrimSpace(string(body))) > 0 {
+		descriptor, err = descriptors.CreateDescriptor(body)
+		if err != nil {
+			helper.HandleError(writer, myLogger, 400, "Error parsing body: %v", err)
+			return nil, false
+		}
+	} else {
+		descriptorId := req.URL.Query().Get("descriptorId")
+		if descriptorId == "" {
+			helper.HandleError(writer, myLogger, 400, "DescriptorId parameter or descriptor body missing")
+			return nil, false
+		}
+
+		descriptor, err = descriptors.GetDescriptorById(d.registry, namespace, descriptorId, myLogger)
+		if err == etcdregistry.ErrDescriptorNotFound {
+			helper.HandleNotFound(writer, myLogger, "Descriptor %v not found", descriptorId)
+			return nil, false
+		} else if err != nil {
+			helper.HandleError(writer, myLogger, 500, "Error getting descriptor %v: %v", descriptorId, err)
+			return nil, false
+		}
+	}
+
+	if namespace != descriptor.Namespace {
+		helper.HandleError(writer, myLogger, 400, "Namespaces of request parameter and descriptor do not match!")
+		return nil, false
+	}
+
+	descriptor, err = defaults.Effective(d.registry, descriptor)
+	if err != nil {
+		helper.HandleError(writer, myLogger, 500, "Error applying defaults: %v", err)
+		return nil, false
+	}
+
+	if err := variables.ResolveWithRegistry(d.registry, descriptor, variables.Parameters(req.URL.Query())); err != nil {
+		helper.HandleError(writer, myLogger, startErrorStatus(err), "Error resolving variables: %v", err)
+		return nil, false
+	}
+
+	if err := descriptor.SetDefaults().Validate(); err != nil {
+		helper.HandleError(writer, myLogger, 400, "Deployment descriptor incorrect: \n %v", err.Error())
+		return nil, false
+	}
+
+	return descriptor, true
+}
@@ -0,0 +1,113 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package deployments
+
+import (
+	"net/http"
+	"strconv"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/cluster"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/events"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"github.com/gorilla/mux"
+)
+
+func (d *DeploymentHandlers) RestartDeploymentHandler(writer http.ResponseWriter, req *http.Request) {
+	var myLogger logger.Logger
+	myLogger = logger.NewConsoleLogger()
+	myLogger.Println("Restarting deployment")
+
+	//TODO check namespaces of user
+	namespace := req.URL.Query().Get("namespace")
+	if namespace == "" {
+		helper.HandleError(writer, myLogger, 400, "Namespace parameter missing")
+		return
+	}
+
+	vars := mux.Vars(req)
+	id := vars["id"]
+	if id == "" {
+		helper.HandleError(writer, myLogger, 400, "Missing id")
+		return
+	}
+
+	batchSize := 1
+	if value := req.URL.Query().Get("batchSize"); value != "" {
+		var err error
+		if batchSize, err = strconv.Atoi(value); err != nil || batchSize < 1 {
+			helper.HandleError(writer, myLogger, 400, "BatchSize parameter must be a positive number")
+			return
+		}
+	}
+
+	deployment, err := d.registry.GetDeploymentById(namespace, id)
+	if err != nil && err != etcdregistry.ErrDeploymentNotFound {
+		helper.HandleError(writer, myLogger, 500, "Error getting deployment for namespace %v with id %v: %v", namespace, id, err)
+		return
+	} else if err == etcdregistry.ErrDeploymentNotFound {
+		helper.HandleNotFound(writer, myLogger, "Deployment %v not found.", id)
+		return
+	}
+	if deployment.Status != types.DEPLOYMENTSTATUS_DEPLOYED {
+		helper.HandleError(writer, myLogger, 409, "Error restarting deployment %v: %v", id, ErrNotDeployed)
+		return
+	}
+
+	myLogger = logger.NewDeploymentLogger(deployment, d.registry, myLogger)
+
+	// start restart async
+	go d.restart(namespace, id, batchSize, myLogger)
+
+	helper.HandleStarted(writer, myLogger, "/deployments/"+deployment.Id, "Restart started, namespace %v, appname %v", namespace, deployment.Descriptor.AppName)
+}
+
+// restart replaces the pods of a deployed deployment, see ClusterManager.RestartPods
+func (d *DeploymentHandlers) restart(namespace string, id string, batchSize int, myLogger logger.Logger) {
+	deployment, err := d.registry.GetDeploymentById(namespace, id)
+	if err != nil {
+		myLogger.Printf("Error getting deployment %v: %v", id, err)
+		return
+	}
+
+	unlock := d.lockApp(namespace, deployment.Descriptor.AppName, myLogger)
+	defer unlock()
+
+	// the deployment might have been replaced while waiting for the mutex
+	if deployment, err = d.registry.GetDeploymentById(namespace, id); err != nil {
+		myLogger.Printf("Error getting deployment %v: %v", id, err)
+		return
+	}
+	if deployment.Status != types.DEPLOYMENTSTATUS_DEPLOYED {
+		myLogger.Printf("Restart cancelled, deployment %v is not deployed anymore", id)
+		return
+	}
+
+	myLogger.Printf("Restarting pods of %v, %v at a time", deployment.GetVersionedName(), batchSize)
+	clusterManager := cluster.NewClusterManager(d.config, deployment, d.registry, myLogger)
+	if err := clusterManager.RestartPods(batchSize); err != nil {
+		myLogger.Printf("Restart failed! %v", err)
+		d.config.Events.Publish(events.NewDeploymentEvent(events.EVENT_RESTART_FAILED, deployment,
+			"Restart of %v version %v failed: %v", deployment.Descriptor.AppName, deployment.Version, err))
+		return
+	}
+
+	myLogger.Println("Restart successful")
+	d.config.Events.Publish(events.NewDeploymentEvent(events.EVENT_DEPLOYMENT_RESTARTED, deployment,
+		"Restarted %v version %v", deployment.Descriptor.AppName, deployment.Version))
+}
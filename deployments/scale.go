@@ -0,0 +1,121 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package deployments
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/cluster"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/events"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"github.com/gorilla/mux"
+)
+
+var ErrNotDeployed = errors.New("Deployment is not deployed")
+var ErrAutoscaled = errors.New("Replicas are managed by the autoscaler of the app")
+
+func (d *DeploymentHandlers) ScaleDeploymentHandler(writer http.ResponseWriter, req *http.Request) {
+	myLogger := logger.NewConsoleLogger()
+	myLogger.Println("Scaling deployment")
+
+	//TODO check namespaces of user
+	namespace := req.URL.Query().Get("namespace")
+	if namespace == "" {
+		helper.HandleError(writer, myLogger, 400, "Namespace parameter missing")
+		return
+	}
+
+	vars := mux.Vars(req)
+	id := vars["id"]
+	if id == "" {
+		helper.HandleError(writer, myLogger, 400, "Missing id")
+		return
+	}
+
+	replicas, err := strconv.Atoi(req.URL.Query().Get("replicas"))
+	if err != nil || replicas < 1 {
+		helper.HandleError(writer, myLogger, 400, "Replicas parameter must be a positive number")
+		return
+	}
+
+	deployment, err := d.Scale(namespace, id, replicas, myLogger)
+	if err == etcdregistry.ErrDeploymentNotFound {
+		helper.HandleNotFound(writer, myLogger, "Deployment %v not found.", id)
+		return
+	} else if err == ErrNotDeployed || err == ErrAutoscaled {
+		helper.HandleError(writer, myLogger, 409, "Error scaling deployment %v: %v", id, err)
+		return
+	} else if err != nil {
+		helper.HandleError(writer, myLogger, 500, "Error scaling deployment %v: %v", id, err)
+		return
+	}
+
+	helper.HandleSuccess(writer, myLogger, helper.RedactDeployment(req, d.registry.SensitiveFields(), deployment), "Deployment %v scaled to %v replicas", id, replicas)
+}
+
+// Scale sets the replicas of the replication controller of a deployed deployment, and records them on the deployment,
+// so they are kept by the next deployment of the app.
+func (d *DeploymentHandlers) Scale(namespace string, id string, replicas int, myLogger logger.Logger) (*types.Deployment, error) {
+	deployment, err := d.registry.GetDeploymentById(namespace, id)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := d.lockApp(namespace, deployment.Descriptor.AppName, myLogger)
+	defer unlock()
+
+	// the deployment might have been replaced while waiting for the mutex
+	if deployment, err = d.registry.GetDeploymentById(namespace, id); err != nil {
+		return nil, err
+	}
+	if deployment.Status != types.DEPLOYMENTSTATUS_DEPLOYED {
+		return nil, ErrNotDeployed
+	}
+	if deployment.Descriptor.Autoscaling != nil {
+		return nil, ErrAutoscaled
+	}
+
+	deploymentLogger := logger.NewDeploymentLogger(deployment, d.registry, myLogger)
+	previous := deployment.GetReplicas()
+	deploymentLogger.Printf("Scaling %v from %v to %v replicas", deployment.GetVersionedName(), previous, replicas)
+
+	clusterManager := cluster.NewClusterManager(d.config, deployment, d.registry, deploymentLogger)
+	if err := clusterManager.Scale(replicas); err != nil {
+		deploymentLogger.Printf("Error scaling replication controller: %v", err)
+		return nil, err
+	}
+
+	deployment.Replicas = replicas
+	if err := d.registry.UpdateDeployment(deployment); err != nil {
+		deploymentLogger.Printf("WARNING: couldn't store the replicas of the deployment: %v", err)
+		return nil, err
+	}
+
+	if err := clusterManager.ScaleDisruptionBudget(replicas); err != nil {
+		deploymentLogger.Printf("Error updating disruption budget: %v", err)
+		return nil, err
+	}
+
+	d.config.Events.Publish(events.NewDeploymentEvent(events.EVENT_DEPLOYMENT_SCALED, deployment,
+		"Scaled %v version %v from %v to %v replicas", deployment.Descriptor.AppName, deployment.Version, previous, replicas))
+
+	return deployment, nil
+}
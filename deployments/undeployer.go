@@ -19,9 +19,12 @@ import (
 	"fmt"
 
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/events"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/pkg/api/v1"
 )
 
@@ -38,7 +41,7 @@ func NewUndeployer(config helper.DeployerConfig, logger logger.Logger) *Undeploy
 
 func (undeployer *Undeployer) Undeploy(deployment *types.Deployment, logger logger.Logger, deleteDeployment bool) {
 
-	if deployment.Status != types.DEPLOYMENTSTATUS_DEPLOYED {
+	if deployment.Status != types.DEPLOYMENTSTATUS_DEPLOYED && deployment.Status != types.DEPLOYMENTSTATUS_PAUSED {
 		if deleteDeployment {
 			undeployer.deleteDeployment(deployment, logger)
 		}
@@ -77,13 +80,22 @@ func (undeployer *Undeployer) Undeploy(deployment *types.Deployment, logger logg
 		success = false
 	}
 
+	if err = undeployer.deleteConfigMaps(deployment, logger); err != nil {
+		undeployer.handleError(logger, deployment, "Error deleting config maps: %v", err.Error())
+		success = false
+	}
+
 	undeployer.deleteProxy(deployment, logger)
+	undeployer.deleteAutoscaler(deployment, logger)
+	undeployer.deleteDisruptionBudget(deployment, logger)
+	undeployer.deleteSecrets(deployment, logger)
 
 	if success {
 		deployment.Status = types.DEPLOYMENTSTATUS_UNDEPLOYED
 		undeployer.registry.UpdateDeployment(deployment)
 
 		logger.Printf("Deployment %v undeployed.", deployment.Id)
+		undeployer.config.Events.Publish(events.NewDeploymentEvent(events.EVENT_DEPLOYMENT_UNDEPLOYED, deployment, "Deployment %v undeployed", deployment.Id))
 		if deleteDeployment {
 			undeployer.deleteDeployment(deployment, logger)
 		}
@@ -117,6 +129,45 @@ func (undeployer *Undeployer) deleteProxy(deployment *types.Deployment, logger l
 	}
 }
 
+func (undeployer *Undeployer) deleteAutoscaler(deployment *types.Deployment, logger logger.Logger) {
+	descriptor := deployment.Descriptor
+	err := undeployer.config.K8sClient.DeleteHorizontalPodAutoscaler(descriptor.Namespace, descriptor.AppName)
+	if statusError, isStatus := err.(*errors.StatusError); isStatus && statusError.Status().Reason == meta.StatusReasonNotFound {
+		return
+	} else if err != nil {
+		logger.Printf("  Error deleting autoscaler %v: %v", descriptor.AppName, err.Error())
+		return
+	}
+	logger.Printf("Deleted autoscaler %v", descriptor.AppName)
+}
+
+func (undeployer *Undeployer) deleteDisruptionBudget(deployment *types.Deployment, logger logger.Logger) {
+	descriptor := deployment.Descriptor
+	err := undeployer.config.K8sClient.DeletePodDisruptionBudget(descriptor.Namespace, descriptor.AppName)
+	if statusError, isStatus := err.(*errors.StatusError); isStatus && statusError.Status().Reason == meta.StatusReasonNotFound {
+		return
+	} else if err != nil {
+		logger.Printf("  Error deleting disruption budget %v: %v", descriptor.AppName, err.Error())
+		return
+	}
+	logger.Printf("Deleted disruption budget %v", descriptor.AppName)
+}
+
+// deleteSecrets deletes the secrets with the secret environment vars and the provider secrets of the app
+func (undeployer *Undeployer) deleteSecrets(deployment *types.Deployment, logger logger.Logger) {
+	descriptor := deployment.Descriptor
+	for _, name := range []string{descriptor.EnvironmentSecretName(), descriptor.ProviderSecretName()} {
+		err := undeployer.config.K8sClient.DeleteSecret(descriptor.Namespace, name)
+		if statusError, isStatus := err.(*errors.StatusError); isStatus && statusError.Status().Reason == meta.StatusReasonNotFound {
+			continue
+		} else if err != nil {
+			logger.Printf("  Error deleting secret %v: %v", name, err.Error())
+			continue
+		}
+		logger.Printf("Deleted secret %v", name)
+	}
+}
+
 func (undeployer *Undeployer) deleteServices(deployment *types.Deployment, logger logger.Logger) error {
 
 	selector := map[string]string{"app": deployment.Descriptor.AppName}
@@ -136,9 +187,29 @@ func (undeployer *Undeployer) deleteServices(deployment *types.Deployment, logge
 	return nil
 }
 
+func (undeployer *Undeployer) deleteConfigMaps(deployment *types.Deployment, logger logger.Logger) error {
+
+	selector := map[string]string{"app": deployment.Descriptor.AppName}
+	configMaps, err := undeployer.config.K8sClient.ListConfigMapsWithSelector(deployment.Descriptor.Namespace, selector)
+	if err != nil {
+		return err
+	}
+
+	for _, configMap := range configMaps.Items {
+		logger.Printf("Deleting config map %v\n", configMap.Name)
+		err := undeployer.config.K8sClient.DeleteConfigMap(deployment.Descriptor.Namespace, configMap.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (undeployer *Undeployer) handleError(logger logger.Logger, deployment *types.Deployment, msg string, args ...interface{}) {
 	message := fmt.Sprintf(msg, args...)
 	logger.Println(message)
 	deployment.Status = types.DEPLOYMENTSTATUS_FAILURE
 	undeployer.registry.UpdateDeployment(deployment)
+	undeployer.config.Events.Publish(events.NewDeploymentEvent(events.EVENT_UNDEPLOYMENT_FAILED, deployment, message))
 }
@@ -0,0 +1,119 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package deployments
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+)
+
+// default and max seconds to wait for a deployment to finish
+const (
+	DEFAULT_WAIT_TIMEOUT = 600
+	MAX_WAIT_TIMEOUT     = 3600
+)
+
+var waitPollInterval = time.Second
+
+// DeploymentResult is returned when waiting for a deployment
+type DeploymentResult struct {
+	Deployment *types.Deployment  `json:"deployment"`
+	Logs       string             `json:"logs"`
+	HealthData []types.HealthData `json:"healthData"`
+}
+
+// parseWaitTimeout returns the seconds to wait for a deployment, or 0 if the request doesn't wait.
+// It is called before a deployment is started, so an invalid timeout doesn't leave a deployment running unnoticed.
+func parseWaitTimeout(req *http.Request) (int, error) {
+	if req.URL.Query().Get("wait") != "true" {
+		return 0, nil
+	}
+	timeoutParam := req.URL.Query().Get("timeout")
+	if timeoutParam == "" {
+		return DEFAULT_WAIT_TIMEOUT, nil
+	}
+	timeout, err := strconv.Atoi(timeoutParam)
+	if err != nil || timeout < 1 || timeout > MAX_WAIT_TIMEOUT {
+		return 0, fmt.Errorf("Timeout parameter must be a number between 1 and %v", MAX_WAIT_TIMEOUT)
+	}
+	return timeout, nil
+}
+
+// handleDeploymentStarted returns the location of a started deployment, or waits for it to finish with a timeout other than 0
+func (d *DeploymentHandlers) handleDeploymentStarted(writer http.ResponseWriter, req *http.Request, deployment *types.Deployment, timeout int, myLogger logger.Logger) {
+	if timeout > 0 {
+		writer.Header().Set("Location", "/deployments/"+deployment.Id+"/?namespace="+deployment.Descriptor.Namespace)
+		d.waitForDeployment(writer, req, deployment, timeout, myLogger)
+		return
+	}
+
+	helper.HandleStarted(writer, myLogger, "/deployments/"+deployment.Id+"/?namespace="+deployment.Descriptor.Namespace, "Deployment started: %v", deployment.Id)
+}
+
+// waitForDeployment blocks until the deployment isn't busy anymore, and returns it with its logs and health data.
+// Failed deployments result in a 422, timeouts in a 504.
+func (d *DeploymentHandlers) waitForDeployment(writer http.ResponseWriter, req *http.Request, deployment *types.Deployment, timeout int, myLogger logger.Logger) {
+	namespace := deployment.Descriptor.Namespace
+	id := deployment.Id
+	myLogger.Printf("Waiting max %v seconds for deployment %v", timeout, id)
+
+	deadline := time.After(time.Duration(timeout) * time.Second)
+	ticker := time.NewTicker(waitPollInterval)
+	defer ticker.Stop()
+
+	for deployment.IsBusy() {
+		select {
+		case <-req.Context().Done():
+			myLogger.Printf("Client stopped waiting for deployment %v", id)
+			return
+		case <-deadline:
+			helper.HandleResult(writer, myLogger, 504, d.deploymentResult(req, deployment), "Timeout waiting for deployment %v", id)
+			return
+		case <-ticker.C:
+			current, err := d.registry.GetDeploymentById(namespace, id)
+			if err != nil {
+				myLogger.Printf("Error getting deployment %v, ignoring...: %v", id, err)
+				continue
+			}
+			deployment = current
+		}
+	}
+
+	result := d.deploymentResult(req, deployment)
+	if deployment.Status == types.DEPLOYMENTSTATUS_FAILURE {
+		helper.HandleResult(writer, myLogger, 422, result, "Deployment %v failed", id)
+		return
+	}
+	helper.HandleResult(writer, myLogger, 200, result, "Deployment %v finished with status %v", id, deployment.Status)
+}
+
+func (d *DeploymentHandlers) deploymentResult(req *http.Request, deployment *types.Deployment) *DeploymentResult {
+	result := &DeploymentResult{Deployment: helper.RedactDeployment(req, d.registry.SensitiveFields(), deployment), HealthData: []types.HealthData{}}
+	// logs and health data are optional
+	if logs, _, err := d.registry.GetLogs(deployment.Descriptor.Namespace, deployment.Id); err == nil {
+		result.Logs = logs
+	}
+	if health, err := d.registry.GetHealth(deployment.Descriptor.Namespace, deployment.Id); err == nil {
+		result.HealthData = health
+	}
+	return result
+}
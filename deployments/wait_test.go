@@ -0,0 +1,103 @@
+package deployments
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry/etcdtest"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+)
+
+func TestWaitForDeployment(t *testing.T) {
+	defer func(interval time.Duration) { waitPollInterval = interval }(waitPollInterval)
+	waitPollInterval = 10 * time.Millisecond
+
+	tests := []struct {
+		// status the deployment has in the registry when it is polled
+		status  string
+		timeout int
+		code    int
+	}{
+		{types.DEPLOYMENTSTATUS_DEPLOYED, DEFAULT_WAIT_TIMEOUT, 200},
+		{types.DEPLOYMENTSTATUS_UNDEPLOYED, DEFAULT_WAIT_TIMEOUT, 200},
+		{types.DEPLOYMENTSTATUS_PAUSED, DEFAULT_WAIT_TIMEOUT, 200},
+		{types.DEPLOYMENTSTATUS_FAILURE, DEFAULT_WAIT_TIMEOUT, 422},
+		{types.DEPLOYMENTSTATUS_DEPLOYING, 1, 504},
+	}
+
+	for _, test := range tests {
+		registry := etcdregistry.NewEtcdRegistry(etcdtest.NewKeysAPI())
+		handlers := NewDeploymentHandlers(helper.DeployerConfig{EtcdRegistry: registry})
+
+		stored := &types.Deployment{Id: "d1", Status: test.status, Descriptor: &types.Descriptor{Namespace: "test", AppName: "myapp"}}
+		if err := registry.CreateDeployment(stored); err != nil {
+			t.Fatal(err)
+		}
+
+		req, _ := http.NewRequest("POST", "/deployments/?namespace=test&wait=true", nil)
+		recorder := httptest.NewRecorder()
+		started := &types.Deployment{Id: "d1", Status: types.DEPLOYMENTSTATUS_DEPLOYING, Descriptor: stored.Descriptor}
+		handlers.waitForDeployment(recorder, req, started, test.timeout, logger.NewConsoleLogger())
+
+		if recorder.Code != test.code {
+			t.Errorf("Status %v with timeout %v: expected %v, got %v", test.status, test.timeout, test.code, recorder.Code)
+			continue
+		}
+		result := &DeploymentResult{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Deployment == nil || result.Deployment.Id != "d1" || result.Deployment.Status != test.status {
+			t.Errorf("Status %v: unexpected result %+v", test.status, result.Deployment)
+		}
+	}
+}
+
+func TestParseWaitTimeout(t *testing.T) {
+	tests := []struct {
+		query   string
+		timeout int
+		valid   bool
+	}{
+		{"", 0, true},
+		{"wait=false&timeout=abc", 0, true},
+		{"wait=true", DEFAULT_WAIT_TIMEOUT, true},
+		{"wait=true&timeout=30", 30, true},
+		{"wait=true&timeout=0", 0, false},
+		{"wait=true&timeout=3601", 0, false},
+		{"wait=true&timeout=abc", 0, false},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/deployments/?"+test.query, nil)
+		timeout, err := parseWaitTimeout(req)
+		if (err == nil) != test.valid {
+			t.Errorf("Query %q: expected valid %v, got error %v", test.query, test.valid, err)
+		}
+		if timeout != test.timeout {
+			t.Errorf("Query %q: expected timeout %v, got %v", test.query, test.timeout, timeout)
+		}
+	}
+}
+
+func TestInvalidTimeoutDoesNotDeploy(t *testing.T) {
+	registry := etcdregistry.NewEtcdRegistry(etcdtest.NewKeysAPI())
+	handlers := NewDeploymentHandlers(helper.DeployerConfig{EtcdRegistry: registry})
+
+	req, _ := http.NewRequest("POST", "/deployments/?namespace=test&wait=true&timeout=abc", nil)
+	recorder := httptest.NewRecorder()
+	handlers.deploy(recorder, req, &types.Descriptor{Namespace: "test", AppName: "myapp"}, logger.NewConsoleLogger())
+
+	if recorder.Code != 400 {
+		t.Errorf("Expected 400, got %v", recorder.Code)
+	}
+	if deployments, err := registry.GetDeployments("test"); err != etcdregistry.ErrDeploymentNotFound {
+		t.Errorf("Expected no deployment to be started, got %v deployments and error %v", len(deployments), err)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package deployments
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"github.com/gorilla/mux"
+)
+
+// webhookPayload covers the supported payload formats:
+// a generic {"image": "...", "tag": "..."}, Docker Hub push payloads and Docker registry notifications
+type webhookPayload struct {
+	Image    string `json:"image,omitempty"`
+	Tag      string `json:"tag,omitempty"`
+	PushData *struct {
+		Tag string `json:"tag,omitempty"`
+	} `json:"push_data,omitempty"`
+	Repository *struct {
+		RepoName string `json:"repo_name,omitempty"`
+	} `json:"repository,omitempty"`
+	Events []struct {
+		Action string `json:"action,omitempty"`
+		Target struct {
+			Repository string `json:"repository,omitempty"`
+			Tag        string `json:"tag,omitempty"`
+		} `json:"target,omitempty"`
+		Request struct {
+			Host string `json:"host,omitempty"`
+		} `json:"request,omitempty"`
+	} `json:"events,omitempty"`
+}
+
+// imageUpdate is the pushed image, an empty repository means the only container of the app
+type imageUpdate struct {
+	Repository string
+	Tag        string
+}
+
+// parseWebhookPayload returns the pushed image, or nil if the payload contains none
+func parseWebhookPayload(body []byte) (*imageUpdate, error) {
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return nil, nil
+	}
+
+	payload := webhookPayload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	// Docker Hub
+	if payload.PushData != nil && payload.Repository != nil {
+		if payload.PushData.Tag == "" {
+			return nil, nil
+		}
+		return &imageUpdate{payload.Repository.RepoName, payload.PushData.Tag}, nil
+	}
+
+	// Docker registry notifications, use the last pushed tag
+	if len(payload.Events) > 0 {
+		var update *imageUpdate
+		for _, event := range payload.Events {
+			if event.Action != "push" || event.Target.Tag == "" {
+				continue
+			}
+			repository := event.Target.Repository
+			if event.Request.Host != "" {
+				repository = event.Request.Host + "/" + repository
+			}
+			update = &imageUpdate{repository, event.Target.Tag}
+		}
+		return update, nil
+	}
+
+	// generic
+	if payload.Image != "" {
+		repository, tag := SplitImage(payload.Image)
+		if payload.Tag != "" {
+			tag = payload.Tag
+		}
+		return &imageUpdate{repository, tag}, nil
+	}
+	if payload.Tag != "" {
+		return &imageUpdate{"", payload.Tag}, nil
+	}
+	return nil, nil
+}
+
+func (d *DeploymentHandlers) WebhookHandler(writer http.ResponseWriter, req *http.Request) {
+	myLogger := logger.NewConsoleLogger()
+	myLogger.Println("Handling webhook")
+
+	key := mux.Vars(req)["key"]
+	if key == "" {
+		helper.HandleError(writer, myLogger, 400, "Key parameter missing")
+		return
+	}
+
+	defer req.Body.Close()
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		helper.HandleError(writer, myLogger, 400, "Error reading body: %v", err)
+		return
+	}
+
+	update, err := parseWebhookPayload(body)
+	if err != nil {
+		helper.HandleError(writer, myLogger, 400, "Error parsing body: %v", err)
+		return
+	}
+
+	// the tag parameter overrides the tag of the payload
+	if tag := req.URL.Query().Get("tag"); tag != "" {
+		if update == nil {
+			update = &imageUpdate{}
+		}
+		update.Tag = tag
+	}
+
+	descriptor, err := d.registry.GetDescriptorByWebhookKey(key)
+	if err == etcdregistry.ErrDescriptorNotFound {
+		helper.HandleNotFound(writer, myLogger, "No descriptor found for webhook key")
+		return
+	} else if err != nil {
+		helper.HandleError(writer, myLogger, 500, "Error getting descriptor for webhook key: %v", err)
+		return
+	}
+
+	if update != nil && update.Tag != "" {
+		if update.Repository == "" {
+			if len(descriptor.PodSpec.Containers) != 1 {
+				helper.HandleError(writer, myLogger, 400, "App %v has multiple containers, image missing for tag %v", descriptor.AppName, update.Tag)
+				return
+			}
+			update.Repository, _ = SplitImage(descriptor.PodSpec.Containers[0].Image)
+		}
+		if SetImageTag(descriptor, update.Repository, update.Tag) == 0 {
+			// e.g. registry notifications of other images, nothing to do for this app
+			helper.HandleSuccess(writer, myLogger, "", "No container of app %v uses image %v, ignoring webhook", descriptor.AppName, update.Repository)
+			return
+		}
+		myLogger.Printf("Using tag %v for image %v", update.Tag, update.Repository)
+		// the retagged descriptor isn't stored, so the deployment doesn't match any revision
+		descriptor.Revision = 0
+	}
+
+	d.deploy(writer, req, descriptor, myLogger)
+}
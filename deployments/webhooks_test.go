@@ -0,0 +1,78 @@
+package deployments
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry/etcdtest"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"github.com/gorilla/mux"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestParseWebhookPayload(t *testing.T) {
+	tests := map[string]*imageUpdate{
+		``:                                  nil,
+		`{"tag": "1.1"}`:                    {"", "1.1"},
+		`{"image": "user/app:1.2"}`:         {"user/app", "1.2"},
+		`{"image": "user/app", "tag": "3"}`: {"user/app", "3"},
+		`{"push_data": {"tag": "1.3"}, "repository": {"repo_name": "user/app"}}`: {"user/app", "1.3"},
+		`{"events": [
+			{"action": "pull", "target": {"repository": "app", "tag": "0.9"}},
+			{"action": "push", "target": {"repository": "app"}},
+			{"action": "push", "target": {"repository": "app", "tag": "1.4"}, "request": {"host": "registry:5000"}}
+		]}`: {"registry:5000/app", "1.4"},
+		`{"events": [{"action": "pull", "target": {"repository": "app", "tag": "0.9"}}]}`: nil,
+	}
+	for body, expected := range tests {
+		update, err := parseWebhookPayload([]byte(body))
+		if err != nil {
+			t.Errorf("Error parsing %v: %v", body, err)
+			continue
+		}
+		if (update == nil) != (expected == nil) || (update != nil && *update != *expected) {
+			t.Errorf("Unexpected update for %v: %+v", body, update)
+		}
+	}
+
+	if _, err := parseWebhookPayload([]byte("no json")); err == nil {
+		t.Error("Expected error for invalid payload")
+	}
+}
+
+func TestWebhookTagWithoutImage(t *testing.T) {
+	registry := etcdregistry.NewEtcdRegistry(etcdtest.NewKeysAPI())
+	handlers := NewDeploymentHandlers(helper.DeployerConfig{EtcdRegistry: registry})
+	router := mux.NewRouter()
+	router.HandleFunc("/webhooks/{key}", handlers.WebhookHandler).Methods("POST")
+
+	descriptor := &types.Descriptor{Id: "d1", Namespace: "test", AppName: "myapp", WebHooks: []types.WebHook{{Key: "secret"}},
+		PodSpec: v1.PodSpec{Containers: []v1.Container{
+			{Name: "app", Image: "user/app:1.0"},
+			{Name: "sidecar", Image: "user/sidecar:1.0"},
+		}}}
+	if err := registry.CreateDescriptor(descriptor); err != nil {
+		t.Fatal(err)
+	}
+
+	// neither payload retags a container, so no deployment is started
+	tests := map[string]int{
+		`{"tag": "2.0"}`:              400,
+		`{"image": "user/other:2.0"}`: 204,
+	}
+	for body, code := range tests {
+		req, _ := http.NewRequest("POST", "/webhooks/secret", strings.NewReader(body))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != code {
+			t.Errorf("Payload %v: expected %v, got %v", body, code, recorder.Code)
+		}
+	}
+	if _, err := registry.GetDeployments("test"); err != etcdregistry.ErrDeploymentNotFound {
+		t.Errorf("Expected no deployment to be started, got error %v", err)
+	}
+}
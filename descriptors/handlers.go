@@ -30,6 +30,7 @@ import (
 	"github.com/satori/go.uuid"
 	"sort"
 	"strconv"
+	"strings"
 )
 
 type DescriptorHandlers struct {
@@ -79,6 +80,7 @@ func (d *DescriptorHandlers) CreateDescriptorHandler(writer http.ResponseWriter,
 	//TODO check namespaces of user
 
 	descriptor.Id = uuid.NewV4().String()
+	descriptor.ModifiedBy = helper.GetUser(req)
 
 	err = descriptor.SetDefaults().Validate()
 	if err != nil {
@@ -151,7 +153,7 @@ func (d *DescriptorHandlers) ListDescriptorsHandler(writer http.ResponseWriter,
 		return
 	}
 
-	helper.HandleSuccess(writer, logger, descriptors, "Successfully listed descriptors")
+	helper.HandleSuccess(writer, logger, helper.RedactDescriptors(req, d.registry.SensitiveFields(), descriptors), "Successfully listed descriptors")
 }
 
 func (d *DescriptorHandlers) DeleteDescriptorsHandler(writer http.ResponseWriter, req *http.Request) {
@@ -224,7 +226,9 @@ func (d *DescriptorHandlers) GetDescriptorHandler(writer http.ResponseWriter, re
 		return
 	}
 
-	descriptor, err := GetDescriptorById(d.registry, namespace, id, logger)
+	logger.Printf("Getting descriptor %v\n", id)
+
+	descriptor, index, err := d.registry.GetDescriptorWithIndex(namespace, id)
 	if err == etcdregistry.ErrDescriptorNotFound {
 		helper.HandleNotFound(writer, logger, "Descriptor %v not found", id)
 		return
@@ -233,7 +237,8 @@ func (d *DescriptorHandlers) GetDescriptorHandler(writer http.ResponseWriter, re
 		return
 	}
 
-	helper.HandleSuccess(writer, logger, descriptor, "Descriptor %v found", id)
+	setETag(writer, index)
+	helper.HandleSuccess(writer, logger, helper.RedactDescriptor(req, d.registry.SensitiveFields(), descriptor), "Descriptor %v found", id)
 }
 
 func (d *DescriptorHandlers) UpdateDescriptorHandler(writer http.ResponseWriter, req *http.Request) {
@@ -255,6 +260,15 @@ func (d *DescriptorHandlers) UpdateDescriptorHandler(writer http.ResponseWriter,
 		return
 	}
 
+	index, present, err := parseIfMatch(req)
+	if !present {
+		helper.HandleError(writer, logger, 428, "If-Match header with the ETag of the descriptor required")
+		return
+	} else if err != nil {
+		helper.HandleError(writer, logger, 400, "Malformed If-Match header: %v", err)
+		return
+	}
+
 	defer req.Body.Close()
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
@@ -292,12 +306,21 @@ func (d *DescriptorHandlers) UpdateDescriptorHandler(writer http.ResponseWriter,
 		return
 	}
 
-	err = d.registry.UpdateDescriptor(descriptor)
-	if err != nil {
+	// keep the stored values of sensitive fields which were sent redacted
+	types.RestoreRedacted(descriptor, oldDescriptor)
+
+	descriptor.ModifiedBy = helper.GetUser(req)
+	newIndex, err := d.registry.UpdateDescriptorIfUnmodified(descriptor, index)
+	if err == etcdregistry.ErrConcurrentUpdate {
+		helper.HandleError(writer, logger, 412, "Descriptor %v was modified in the meantime, get the latest version and try again", id)
+		return
+	} else if err != nil {
 		helper.HandleError(writer, logger, 500, "Error updating descriptor: %v", err)
 		return
 	}
 
+	setETag(writer, newIndex)
+
 	helper.HandleSuccess(writer, logger, "", "Descriptor updated: %v", descriptor.Id)
 }
 
@@ -338,6 +361,30 @@ func (d *DescriptorHandlers) DeleteDescriptorHandler(writer http.ResponseWriter,
 	helper.HandleSuccess(writer, logger, "", "Descriptor %v deleted.", id)
 }
 
+// setETag sets the modification index of the descriptor as ETag
+func setETag(writer http.ResponseWriter, index uint64) {
+	writer.Header().Set("ETag", fmt.Sprintf("\"%v\"", index))
+}
+
+// parseIfMatch returns the modification index of the If-Match header, and whether the header is present.
+// An index of 0 means that any version matches ("*").
+func parseIfMatch(req *http.Request) (uint64, bool, error) {
+	ifMatch := strings.TrimSpace(req.Header.Get("If-Match"))
+	if ifMatch == "" {
+		return 0, false, nil
+	}
+	if ifMatch == "*" {
+		return 0, true, nil
+	}
+
+	ifMatch = strings.Trim(strings.TrimPrefix(ifMatch, "W/"), "\"")
+	index, err := strconv.ParseUint(ifMatch, 10, 64)
+	if err != nil || index == 0 {
+		return 0, true, fmt.Errorf("unknown ETag %v", ifMatch)
+	}
+	return index, true, nil
+}
+
 func CreateDescriptor(jsonString []byte) (*types.Descriptor, error) {
 	descriptor := &types.Descriptor{}
 
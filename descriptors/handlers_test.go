@@ -0,0 +1,86 @@
+package descriptors
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseIfMatch(t *testing.T) {
+	tests := []struct {
+		header  string
+		index   uint64
+		present bool
+		valid   bool
+	}{
+		{"", 0, false, true},
+		{"*", 0, true, true},
+		{"\"42\"", 42, true, true},
+		{"W/\"42\"", 42, true, true},
+		{"42", 42, true, true},
+		{"\"abc\"", 0, true, false},
+		{"\"0\"", 0, true, false},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("PUT", "/descriptors/123/", nil)
+		if test.header != "" {
+			req.Header.Set("If-Match", test.header)
+		}
+		index, present, err := parseIfMatch(req)
+		if index != test.index || present != test.present || (err == nil) != test.valid {
+			t.Errorf("Header %q: expected %v, %v, valid %v, got %v, %v, %v", test.header, test.index, test.present, test.valid, index, present, err)
+		}
+	}
+}
+
+func TestUpdateDescriptorHandler(t *testing.T) {
+	router, registry := newRevisionsRouter(t)
+	handlers := NewDescriptorHandlers(registry)
+	router.HandleFunc("/descriptors/{id}/", handlers.UpdateDescriptorHandler).Methods("PUT")
+
+	put := func(ifMatch string) *httptest.ResponseRecorder {
+		body := `{"id": "d1", "namespace": "test", "appName": "myapp", "replicas": 3}`
+		req, _ := http.NewRequest("PUT", "/descriptors/d1/?namespace=test", strings.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	_, index, err := registry.GetDescriptorWithIndex("test", "d1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if recorder := put(""); recorder.Code != 428 {
+		t.Errorf("Expected 428 without If-Match, got %v", recorder.Code)
+	}
+	if recorder := put(fmt.Sprintf("\"%v\"", index-1)); recorder.Code != 412 {
+		t.Errorf("Expected 412 for stale ETag, got %v", recorder.Code)
+	}
+
+	recorder := put(fmt.Sprintf("\"%v\"", index))
+	if recorder.Code != 204 {
+		t.Fatalf("Expected 204, got %v: %v", recorder.Code, recorder.Body.String())
+	}
+	descriptor, newIndex, err := registry.GetDescriptorWithIndex("test", "d1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if descriptor.Replicas != 3 || newIndex == index {
+		t.Errorf("Expected updated descriptor with new index, got %v replicas at index %v", descriptor.Replicas, newIndex)
+	}
+	if etag := recorder.Header().Get("ETag"); etag != fmt.Sprintf("\"%v\"", newIndex) {
+		t.Errorf("Expected ETag of index %v, got %v", newIndex, etag)
+	}
+
+	// the previous ETag is stale now
+	if recorder := put(fmt.Sprintf("\"%v\"", index)); recorder.Code != 412 {
+		t.Errorf("Expected 412 for previous ETag, got %v", recorder.Code)
+	}
+}
@@ -0,0 +1,235 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package descriptors
+
+import (
+	"net/http"
+	"strconv"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/diff"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"github.com/gorilla/mux"
+)
+
+// fields which change with every revision, and so are not part of a diff
+var revisionMetadata = []string{"revision", "modifiedBy", "created", "lastModified"}
+
+type RevisionSummary struct {
+	Revision   int    `json:"revision"`
+	Timestamp  string `json:"timestamp"`
+	ModifiedBy string `json:"modifiedBy,omitempty"`
+}
+
+type RevisionDiff struct {
+	From    int           `json:"from"`
+	To      int           `json:"to"`
+	Changes []diff.Change `json:"changes"`
+}
+
+func (d *DescriptorHandlers) ListRevisionsHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	namespace, id, ok := d.getDescriptorParams(writer, req, logger)
+	if !ok {
+		return
+	}
+
+	revisions, err := d.registry.GetDescriptorRevisions(namespace, id)
+	if err != nil {
+		helper.HandleError(writer, logger, 500, "Error getting revisions of descriptor %v: %v", id, err)
+		return
+	}
+
+	summaries := []RevisionSummary{}
+	for _, revision := range revisions {
+		summaries = append(summaries, RevisionSummary{revision.Revision, revision.LastModified, revision.ModifiedBy})
+	}
+
+	helper.HandleSuccess(writer, logger, summaries, "Listed %v revisions of descriptor %v", len(summaries), id)
+}
+
+func (d *DescriptorHandlers) GetRevisionHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	namespace, id, ok := d.getDescriptorParams(writer, req, logger)
+	if !ok {
+		return
+	}
+
+	revision, ok := d.getRevision(writer, namespace, id, mux.Vars(req)["revision"], logger)
+	if !ok {
+		return
+	}
+
+	helper.HandleSuccess(writer, logger, helper.RedactDescriptor(req, d.registry.SensitiveFields(), revision), "Revision %v of descriptor %v found", revision.Revision, id)
+}
+
+// DiffRevisionsHandler compares two revisions, the "to" revision defaults to the current one
+func (d *DescriptorHandlers) DiffRevisionsHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	namespace, id, ok := d.getDescriptorParams(writer, req, logger)
+	if !ok {
+		return
+	}
+
+	fromParam := req.URL.Query().Get("from")
+	if fromParam == "" {
+		helper.HandleError(writer, logger, 400, "From parameter missing")
+		return
+	}
+	from, ok := d.getRevision(writer, namespace, id, fromParam, logger)
+	if !ok {
+		return
+	}
+
+	var to *types.Descriptor
+	if toParam := req.URL.Query().Get("to"); toParam != "" {
+		if to, ok = d.getRevision(writer, namespace, id, toParam, logger); !ok {
+			return
+		}
+	} else {
+		var err error
+		if to, err = d.registry.GetDescriptorById(namespace, id); err != nil {
+			helper.HandleError(writer, logger, 500, "Error getting descriptor %v: %v", id, err)
+			return
+		}
+	}
+
+	changes, err := diff.Compare(from, to, revisionMetadata...)
+	if err != nil {
+		helper.HandleError(writer, logger, 500, "Error comparing revisions: %v", err)
+		return
+	}
+	changes = redactChanges(changes, helper.RedactDescriptor(req, d.registry.SensitiveFields(), from), helper.RedactDescriptor(req, d.registry.SensitiveFields(), to))
+
+	helper.HandleSuccess(writer, logger, RevisionDiff{from.Revision, to.Revision, changes}, "Compared revisions %v and %v of descriptor %v", from.Revision, to.Revision, id)
+}
+
+// RestoreRevisionHandler stores the content of an old revision as new revision of the descriptor
+func (d *DescriptorHandlers) RestoreRevisionHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	namespace, id, ok := d.getDescriptorParams(writer, req, logger)
+	if !ok {
+		return
+	}
+
+	revision, ok := d.getRevision(writer, namespace, id, mux.Vars(req)["revision"], logger)
+	if !ok {
+		return
+	}
+
+	// If-Match is optional here, the restored content is known
+	index, _, err := parseIfMatch(req)
+	if err != nil {
+		helper.HandleError(writer, logger, 400, "Malformed If-Match header: %v", err)
+		return
+	}
+
+	current, err := d.registry.GetDescriptorById(namespace, id)
+	if err != nil {
+		helper.HandleError(writer, logger, 500, "Error getting descriptor %v: %v", id, err)
+		return
+	}
+
+	restored := *revision
+	restored.Created = current.Created
+	restored.ModifiedBy = helper.GetUser(req)
+
+	newIndex, err := d.registry.UpdateDescriptorIfUnmodified(&restored, index)
+	if err == etcdregistry.ErrConcurrentUpdate {
+		helper.HandleError(writer, logger, 412, "Descriptor %v was modified in the meantime", id)
+		return
+	} else if err != nil {
+		helper.HandleError(writer, logger, 500, "Error restoring descriptor: %v", err)
+		return
+	}
+
+	setETag(writer, newIndex)
+
+	helper.HandleSuccess(writer, logger, helper.RedactDescriptor(req, d.registry.SensitiveFields(), &restored), "Restored revision %v of descriptor %v as revision %v", revision.Revision, id, restored.Revision)
+}
+
+func (d *DescriptorHandlers) getDescriptorParams(writer http.ResponseWriter, req *http.Request, logger logger.Logger) (string, string, bool) {
+	//TODO check namespaces of user
+	namespace := req.URL.Query().Get("namespace")
+	if namespace == "" {
+		helper.HandleError(writer, logger, 400, "Namespace parameter missing")
+		return "", "", false
+	}
+
+	id := mux.Vars(req)["id"]
+	if id == "" {
+		helper.HandleError(writer, logger, 400, "Id parameter missing")
+		return "", "", false
+	}
+
+	_, err := d.registry.GetDescriptorById(namespace, id)
+	if err == etcdregistry.ErrDescriptorNotFound {
+		helper.HandleNotFound(writer, logger, "Descriptor %v not found", id)
+		return "", "", false
+	} else if err != nil {
+		helper.HandleError(writer, logger, 500, "Error getting descriptor %v: %v", id, err)
+		return "", "", false
+	}
+
+	return namespace, id, true
+}
+
+func (d *DescriptorHandlers) getRevision(writer http.ResponseWriter, namespace string, id string, revisionParam string, logger logger.Logger) (*types.Descriptor, bool) {
+	number, err := strconv.Atoi(revisionParam)
+	if err != nil {
+		helper.HandleError(writer, logger, 400, "Revision %v is not a number", revisionParam)
+		return nil, false
+	}
+
+	revision, err := d.registry.GetDescriptorRevision(namespace, id, number)
+	if err == etcdregistry.ErrRevisionNotFound {
+		helper.HandleNotFound(writer, logger, "Revision %v of descriptor %v not found", number, id)
+		return nil, false
+	} else if err != nil {
+		helper.HandleError(writer, logger, 500, "Error getting revision %v of descriptor %v: %v", number, id, err)
+		return nil, false
+	}
+
+	return revision, true
+}
+
+// redactChanges replaces the values of the changes with the ones of the redacted revisions,
+// changed sensitive fields are still listed but without their values
+func redactChanges(changes []diff.Change, from *types.Descriptor, to *types.Descriptor) []diff.Change {
+	fromFields, err := diff.Flatten(from)
+	if err != nil {
+		return changes
+	}
+	toFields, err := diff.Flatten(to)
+	if err != nil {
+		return changes
+	}
+	for i := range changes {
+		if changes[i].Current != nil {
+			changes[i].Current = fromFields[changes[i].Path]
+		}
+		if changes[i].Desired != nil {
+			changes[i].Desired = toFields[changes[i].Path]
+		}
+	}
+	return changes
+}
@@ -0,0 +1,177 @@
+package descriptors
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/diff"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry/etcdtest"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"github.com/gorilla/mux"
+)
+
+// newRevisionsRouter returns a router with the revision routes of the deployer, for a descriptor with two revisions
+func newRevisionsRouter(t *testing.T) (*mux.Router, *etcdregistry.EtcdRegistry) {
+	registry := etcdregistry.NewEtcdRegistry(etcdtest.NewKeysAPI())
+	registry.SetSensitiveFields(types.DefaultSensitiveFields)
+
+	descriptor := &types.Descriptor{Id: "d1", Namespace: "test", AppName: "myapp", Replicas: 1, Password: "secret1"}
+	if err := registry.CreateDescriptor(descriptor); err != nil {
+		t.Fatal(err)
+	}
+	descriptor.Replicas = 2
+	descriptor.Password = "secret2"
+	if err := registry.UpdateDescriptor(descriptor); err != nil {
+		t.Fatal(err)
+	}
+
+	handlers := NewDescriptorHandlers(registry)
+	r := mux.NewRouter()
+	r.HandleFunc("/descriptors/{id}/revisions", handlers.ListRevisionsHandler).Methods("GET")
+	r.HandleFunc("/descriptors/{id}/revisions/diff", handlers.DiffRevisionsHandler).Methods("GET")
+	r.HandleFunc("/descriptors/{id}/revisions/{revision:[0-9]+}", handlers.GetRevisionHandler).Methods("GET")
+	r.HandleFunc("/descriptors/{id}/revisions/{revision:[0-9]+}/restore", handlers.RestoreRevisionHandler).Methods("POST")
+	return r, registry
+}
+
+func serve(router *mux.Router, method string, url string, header map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, nil)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestListRevisionsHandler(t *testing.T) {
+	router, _ := newRevisionsRouter(t)
+
+	recorder := serve(router, "GET", "/descriptors/d1/revisions?namespace=test", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("Expected 200, got %v: %v", recorder.Code, recorder.Body.String())
+	}
+	summaries := []RevisionSummary{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &summaries); err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 || summaries[0].Revision != 1 || summaries[1].Revision != 2 {
+		t.Errorf("Unexpected revisions %+v", summaries)
+	}
+
+	if recorder := serve(router, "GET", "/descriptors/unknown/revisions?namespace=test", nil); recorder.Code != 404 {
+		t.Errorf("Expected 404 for unknown descriptor, got %v", recorder.Code)
+	}
+	if recorder := serve(router, "GET", "/descriptors/d1/revisions", nil); recorder.Code != 400 {
+		t.Errorf("Expected 400 without namespace, got %v", recorder.Code)
+	}
+}
+
+func TestGetRevisionHandler(t *testing.T) {
+	router, _ := newRevisionsRouter(t)
+
+	recorder := serve(router, "GET", "/descriptors/d1/revisions/1?namespace=test", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("Expected 200, got %v: %v", recorder.Code, recorder.Body.String())
+	}
+	revision := &types.Descriptor{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), revision); err != nil {
+		t.Fatal(err)
+	}
+	if revision.Revision != 1 || revision.Replicas != 1 || revision.Password != types.REDACTED {
+		t.Errorf("Unexpected revision %+v", revision)
+	}
+
+	if recorder := serve(router, "GET", "/descriptors/d1/revisions/3?namespace=test", nil); recorder.Code != 404 {
+		t.Errorf("Expected 404 for unknown revision, got %v", recorder.Code)
+	}
+}
+
+func TestDiffRevisionsHandler(t *testing.T) {
+	router, _ := newRevisionsRouter(t)
+
+	recorder := serve(router, "GET", "/descriptors/d1/revisions/diff?namespace=test&from=1", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("Expected 200, got %v: %v", recorder.Code, recorder.Body.String())
+	}
+	if strings.Contains(recorder.Body.String(), "secret") {
+		t.Errorf("Expected redacted diff, got %v", recorder.Body.String())
+	}
+	result := RevisionDiff{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	changes := map[string]diff.Change{}
+	for _, change := range result.Changes {
+		changes[change.Path] = change
+	}
+	if result.From != 1 || result.To != 2 || len(changes) != 2 {
+		t.Errorf("Unexpected diff %+v", result)
+	}
+	if change := changes["replicas"]; change.Current != 1.0 || change.Desired != 2.0 {
+		t.Errorf("Unexpected replicas change %+v", change)
+	}
+	if change := changes["password"]; change.Current != types.REDACTED || change.Desired != types.REDACTED {
+		t.Errorf("Expected redacted password change, got %+v", change)
+	}
+
+	if recorder := serve(router, "GET", "/descriptors/d1/revisions/diff?namespace=test&from=1&to=1", nil); recorder.Code != 200 ||
+		!strings.Contains(recorder.Body.String(), `"changes": []`) {
+		t.Errorf("Expected no changes between the same revisions, got %v: %v", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serve(router, "GET", "/descriptors/d1/revisions/diff?namespace=test", nil); recorder.Code != 400 {
+		t.Errorf("Expected 400 without from, got %v", recorder.Code)
+	}
+}
+
+func TestRestoreRevisionHandler(t *testing.T) {
+	router, registry := newRevisionsRouter(t)
+
+	if recorder := serve(router, "POST", "/descriptors/d1/revisions/1/restore?namespace=test", map[string]string{"If-Match": "\"1\""}); recorder.Code != 412 {
+		t.Errorf("Expected 412 for outdated If-Match, got %v", recorder.Code)
+	}
+
+	recorder := serve(router, "POST", "/descriptors/d1/revisions/1/restore?namespace=test", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("Expected 200, got %v: %v", recorder.Code, recorder.Body.String())
+	}
+	if recorder.Header().Get("ETag") == "" {
+		t.Error("Expected ETag of the restored descriptor")
+	}
+
+	descriptor, err := registry.GetDescriptorById("test", "d1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if descriptor.Revision != 3 || descriptor.Replicas != 1 || descriptor.Password != "secret1" {
+		t.Errorf("Expected revision 1 restored as revision 3, got %+v", descriptor)
+	}
+	if revision, err := registry.GetDescriptorRevision("test", "d1", 3); err != nil || revision.Replicas != 1 {
+		t.Errorf("Unexpected revision 3: %+v, %v", revision, err)
+	}
+}
+
+func TestRedactChanges(t *testing.T) {
+	changes := []diff.Change{
+		{Path: "password", Type: "changed", Current: "secret1", Desired: "secret2"},
+		{Path: "email", Type: "added", Desired: "me@example.com"},
+		{Path: "replicas", Type: "changed", Current: 1.0, Desired: 2.0},
+	}
+	from := &types.Descriptor{Password: types.REDACTED, Replicas: 1}
+	to := &types.Descriptor{Password: types.REDACTED, Email: types.REDACTED, Replicas: 2}
+
+	redacted := redactChanges(changes, from, to)
+	if redacted[0].Current != types.REDACTED || redacted[0].Desired != types.REDACTED {
+		t.Errorf("Expected redacted password change, got %+v", redacted[0])
+	}
+	if redacted[1].Current != nil || redacted[1].Desired != types.REDACTED {
+		t.Errorf("Expected redacted added email, got %+v", redacted[1])
+	}
+	if redacted[2].Current != 1.0 || redacted[2].Desired != 2.0 {
+		t.Errorf("Expected unchanged replicas change, got %+v", redacted[2])
+	}
+}
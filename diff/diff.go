@@ -0,0 +1,140 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	CHANGE_ADDED   = "added"
+	CHANGE_REMOVED = "removed"
+	CHANGE_CHANGED = "changed"
+)
+
+// Change is a difference of a single field, identified by its JSON path, e.g. "spec.template.spec.containers.0.image"
+type Change struct {
+	Path    string      `json:"path"`
+	Type    string      `json:"type"`
+	Current interface{} `json:"current,omitempty"`
+	Desired interface{} `json:"desired,omitempty"`
+}
+
+// Compare returns the differences between the JSON representations of current and desired, sorted by path.
+// Ignored paths are prefixes like "metadata.uid", or field names at any depth like "*.imagePullPolicy".
+// Empty objects and arrays are treated as missing.
+func Compare(current interface{}, desired interface{}, ignored ...string) ([]Change, error) {
+	currentFields, err := Flatten(current)
+	if err != nil {
+		return nil, err
+	}
+	desiredFields, err := Flatten(desired)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []Change{}
+	for path, desiredValue := range desiredFields {
+		if isIgnored(path, ignored) {
+			continue
+		}
+		if currentValue, ok := currentFields[path]; !ok {
+			changes = append(changes, Change{Path: path, Type: CHANGE_ADDED, Desired: desiredValue})
+		} else if !reflect.DeepEqual(currentValue, desiredValue) {
+			changes = append(changes, Change{Path: path, Type: CHANGE_CHANGED, Current: currentValue, Desired: desiredValue})
+		}
+	}
+	for path, currentValue := range currentFields {
+		if isIgnored(path, ignored) {
+			continue
+		}
+		if _, ok := desiredFields[path]; !ok {
+			changes = append(changes, Change{Path: path, Type: CHANGE_REMOVED, Current: currentValue})
+		}
+	}
+
+	sort.Sort(byPath(changes))
+	return changes, nil
+}
+
+// Flatten returns all leaf values of the JSON representation of the object by their path
+func Flatten(object interface{}) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	if object == nil || (reflect.ValueOf(object).Kind() == reflect.Ptr && reflect.ValueOf(object).IsNil()) {
+		return fields, nil
+	}
+
+	bytes, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(bytes, &generic); err != nil {
+		return nil, err
+	}
+
+	flatten("", generic, fields)
+	return fields, nil
+}
+
+func flatten(prefix string, value interface{}, fields map[string]interface{}) {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, child := range typed {
+			flatten(join(prefix, key), child, fields)
+		}
+	case []interface{}:
+		for i, child := range typed {
+			flatten(join(prefix, fmt.Sprint(i)), child, fields)
+		}
+	case nil:
+		// treat like missing
+	default:
+		fields[prefix] = typed
+	}
+}
+
+func join(prefix string, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+func isIgnored(path string, ignored []string) bool {
+	for _, ignore := range ignored {
+		if strings.HasPrefix(ignore, "*.") {
+			for _, segment := range strings.Split(path, ".") {
+				if segment == ignore[2:] {
+					return true
+				}
+			}
+		} else if path == ignore || strings.HasPrefix(path, ignore+".") {
+			return true
+		}
+	}
+	return false
+}
+
+type byPath []Change
+
+func (a byPath) Len() int           { return len(a) }
+func (a byPath) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byPath) Less(i, j int) bool { return a[i].Path < a[j].Path }
@@ -0,0 +1,62 @@
+package diff
+
+import (
+	"testing"
+)
+
+type object struct {
+	Name   string            `json:"name,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Ports  []int             `json:"ports,omitempty"`
+	Policy string            `json:"policy,omitempty"`
+}
+
+func TestCompare(t *testing.T) {
+	current := &object{Name: "app", Labels: map[string]string{"version": "1", "old": "x"}, Ports: []int{80, 443}, Policy: "Always"}
+	desired := &object{Name: "app", Labels: map[string]string{"version": "2", "new": "y"}, Ports: []int{80}}
+
+	changes, err := Compare(current, desired, "*.policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Change{
+		{Path: "labels.new", Type: CHANGE_ADDED, Desired: "y"},
+		{Path: "labels.old", Type: CHANGE_REMOVED, Current: "x"},
+		{Path: "labels.version", Type: CHANGE_CHANGED, Current: "1", Desired: "2"},
+		{Path: "ports.1", Type: CHANGE_REMOVED, Current: float64(443)},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %v changes, got %+v", len(expected), changes)
+	}
+	for i, change := range changes {
+		if change != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], change)
+		}
+	}
+}
+
+func TestCompareWithNil(t *testing.T) {
+	var current *object
+	changes, err := Compare(current, &object{Name: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Type != CHANGE_ADDED || changes[0].Path != "name" {
+		t.Errorf("Unexpected changes %+v", changes)
+	}
+}
+
+func TestIgnored(t *testing.T) {
+	ignored := []string{"metadata.uid", "*.imagePullPolicy"}
+	for _, path := range []string{"metadata.uid", "spec.containers.0.imagePullPolicy", "imagePullPolicy"} {
+		if !isIgnored(path, ignored) {
+			t.Errorf("Expected %v to be ignored", path)
+		}
+	}
+	for _, path := range []string{"metadata.uidx", "metadata.name", "spec.containers.0.image"} {
+		if isIgnored(path, ignored) {
+			t.Errorf("Expected %v not to be ignored", path)
+		}
+	}
+}
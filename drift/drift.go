@@ -0,0 +1,579 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package drift
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/cluster"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/events"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	autoscaling "k8s.io/client-go/pkg/apis/autoscaling/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+)
+
+const (
+	KIND_REPLICATIONCONTROLLER = "ReplicationController"
+	KIND_SERVICE               = "Service"
+	KIND_INGRESS               = "Ingress"
+	KIND_AUTOSCALER            = "HorizontalPodAutoscaler"
+
+	FIELD_MISSING    = "missing"
+	FIELD_DELETING   = "deleting"
+	FIELD_REPLICAS   = "replicas"
+	FIELD_IMAGE      = "image"
+	FIELD_SELECTOR   = "selector"
+	FIELD_HOST       = "host"
+	FIELD_BACKEND    = "backend"
+	FIELD_TARGET     = "target"
+	FIELD_UNEXPECTED = "unexpected"
+)
+
+// Drift is a single difference between the registry and the cluster
+type Drift struct {
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+	Field    string `json:"field"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	// whether the drift can be healed without a new deployment
+	Healable bool `json:"healable"`
+}
+
+// Report lists all differences between a deployed deployment and the cluster
+type Report struct {
+	Namespace    string  `json:"namespace"`
+	AppName      string  `json:"appName"`
+	DeploymentId string  `json:"deploymentId"`
+	Version      string  `json:"version"`
+	Checked      string  `json:"checked"`
+	InSync       bool    `json:"inSync"`
+	Drifts       []Drift `json:"drifts"`
+}
+
+// Reconciler periodically compares all deployed apps with the cluster, and optionally heals them back to their descriptor
+type Reconciler struct {
+	config   helper.DeployerConfig
+	registry *etcdregistry.EtcdRegistry
+	interval time.Duration
+	heal     bool
+	logger   logger.Logger
+
+	mutex sync.Mutex
+	// drifts per app of the last run, for only publishing changes
+	reported map[string]string
+}
+
+func NewReconciler(config helper.DeployerConfig, interval int, heal bool) *Reconciler {
+	return &Reconciler{
+		config:   config,
+		registry: config.EtcdRegistry,
+		interval: time.Duration(interval) * time.Second,
+		heal:     heal,
+		logger:   logger.NewConsoleLogger(),
+		reported: map[string]string{},
+	}
+}
+
+func (r *Reconciler) Run() {
+	r.logger.Printf("Starting drift reconciler with an interval of %v, healing: %v", r.interval, r.heal)
+	for {
+		r.reconcileAll()
+		time.Sleep(r.interval)
+	}
+}
+
+func (r *Reconciler) reconcileAll() {
+	deployments, err := r.registry.GetAllDeployments()
+	if err != nil {
+		r.logger.Printf("Drift reconciler: error getting deployments: %v", err.Error())
+		return
+	}
+
+	// don't interfere with running deployments
+	busy := map[string]bool{}
+	for _, deployment := range deployments {
+		if deployment.IsBusy() {
+			busy[appKey(deployment.Descriptor.Namespace, deployment.Descriptor.AppName)] = true
+		}
+	}
+
+	checked := map[string]bool{}
+	for _, deployment := range deployments {
+		key := appKey(deployment.Descriptor.Namespace, deployment.Descriptor.AppName)
+		if deployment.Status != types.DEPLOYMENTSTATUS_DEPLOYED || busy[key] {
+			continue
+		}
+		checked[key] = true
+
+		report, err := r.Check(deployment)
+		if err != nil {
+			r.logger.Printf("Drift reconciler: error checking %v: %v", key, err.Error())
+			continue
+		}
+		r.publishChanges(deployment, report)
+
+		if r.heal && !report.InSync && hasHealable(report) {
+			if _, err := r.Heal(deployment.Descriptor.Namespace, deployment.Id, r.logger); err != nil {
+				r.logger.Printf("Drift reconciler: error healing %v: %v", key, err.Error())
+			}
+		}
+	}
+
+	// forget apps which are not deployed anymore
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for key := range r.reported {
+		if !checked[key] {
+			delete(r.reported, key)
+		}
+	}
+}
+
+// publishChanges publishes an event when drift appears, changes or disappears
+func (r *Reconciler) publishChanges(deployment *types.Deployment, report *Report) {
+	key := appKey(report.Namespace, report.AppName)
+	summary := summarize(report)
+
+	r.mutex.Lock()
+	previous, known := r.reported[key]
+	r.reported[key] = summary
+	r.mutex.Unlock()
+
+	if previous == summary || (!known && report.InSync) {
+		return
+	}
+
+	if report.InSync {
+		r.config.Events.Publish(newEvent(events.EVENT_DRIFT_RESOLVED, deployment, report, "No drift left for %v", report.AppName))
+	} else {
+		r.config.Events.Publish(newEvent(events.EVENT_DRIFT_DETECTED, deployment, report, "Drift detected for %v: %v", report.AppName, summary))
+	}
+}
+
+// Check compares the deployment with the replication controllers, services and Ingress of its app in the cluster
+func (r *Reconciler) Check(deployment *types.Deployment) (*Report, error) {
+	descriptor := deployment.Descriptor
+	namespace := descriptor.Namespace
+	k8sClient := r.config.K8sClient
+
+	report := &Report{
+		Namespace:    namespace,
+		AppName:      descriptor.AppName,
+		DeploymentId: deployment.Id,
+		Version:      deployment.Version,
+		Checked:      time.Now().Format(time.RFC3339),
+		Drifts:       []Drift{},
+	}
+
+	clusterManager := cluster.NewClusterManager(r.config, copyDeployment(deployment), nil, r.logger)
+	versionedName := deployment.GetVersionedName()
+
+	controllers, err := k8sClient.ListReplicationControllersWithSelector(namespace, map[string]string{"app": descriptor.AppName})
+	if err != nil {
+		return nil, err
+	}
+	report.Drifts = append(report.Drifts, CompareReplicationControllers(descriptor, deployment.GetReplicas(), versionedName, controllers.Items)...)
+
+	service, err := getService(r.config, namespace, versionedName)
+	if err != nil {
+		return nil, err
+	}
+	report.Drifts = append(report.Drifts, CompareService(clusterManager.BuildService(), service)...)
+
+	persistentService, err := getService(r.config, namespace, descriptor.AppName)
+	if err != nil {
+		return nil, err
+	}
+	report.Drifts = append(report.Drifts, CompareService(clusterManager.BuildPersistentService(nil), persistentService)...)
+
+	if descriptor.Frontend != "" {
+		ingress, err := k8sClient.GetIngress(namespace, descriptor.AppName)
+		if isNotFound(err) {
+			ingress, err = nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		report.Drifts = append(report.Drifts, CompareIngress(descriptor, versionedName, ingress)...)
+	}
+
+	autoscaler, err := k8sClient.GetHorizontalPodAutoscaler(namespace, descriptor.AppName)
+	if isNotFound(err) {
+		autoscaler, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	report.Drifts = append(report.Drifts, CompareAutoscaler(descriptor, versionedName, autoscaler)...)
+
+	report.InSync = len(report.Drifts) == 0
+	return report, nil
+}
+
+// CompareReplicationControllers checks that the replication controller of the deployed version exists with the expected
+// replicas and images, and that no other version of the app is running
+func CompareReplicationControllers(descriptor *types.Descriptor, expectedReplicas int, versionedName string, controllers []v1.ReplicationController) []Drift {
+	drifts := []Drift{}
+
+	var current *v1.ReplicationController
+	for i, rc := range controllers {
+		if rc.Name == versionedName {
+			current = &controllers[i]
+			continue
+		}
+		replicas := int32(0)
+		if rc.Spec.Replicas != nil {
+			replicas = *rc.Spec.Replicas
+		}
+		if replicas > 0 || rc.Status.Replicas > 0 {
+			drifts = append(drifts, Drift{Kind: KIND_REPLICATIONCONTROLLER, Name: rc.Name, Field: FIELD_UNEXPECTED,
+				Expected: "0 replicas", Actual: fmt.Sprintf("%v replicas", replicas), Healable: true})
+		}
+	}
+
+	if current == nil {
+		return append(drifts, Drift{Kind: KIND_REPLICATIONCONTROLLER, Name: versionedName, Field: FIELD_MISSING, Healable: true})
+	}
+
+	if current.DeletionTimestamp != nil {
+		drifts = append(drifts, Drift{Kind: KIND_REPLICATIONCONTROLLER, Name: versionedName, Field: FIELD_DELETING})
+	}
+
+	replicas := int32(0)
+	if current.Spec.Replicas != nil {
+		replicas = *current.Spec.Replicas
+	}
+	// with autoscaling the autoscaler manages the replicas
+	if descriptor.Autoscaling == nil && int(replicas) != expectedReplicas {
+		drifts = append(drifts, Drift{Kind: KIND_REPLICATIONCONTROLLER, Name: versionedName, Field: FIELD_REPLICAS,
+			Expected: fmt.Sprint(expectedReplicas), Actual: fmt.Sprint(replicas), Healable: true})
+	}
+
+	actualImages := map[string]string{}
+	if current.Spec.Template != nil {
+		for _, container := range current.Spec.Template.Spec.Containers {
+			actualImages[container.Name] = container.Image
+		}
+	}
+	for _, container := range descriptor.PodSpec.Containers {
+		if actualImages[container.Name] != container.Image {
+			// running pods don't pick up a changed template, so this needs a new deployment
+			drifts = append(drifts, Drift{Kind: KIND_REPLICATIONCONTROLLER, Name: versionedName, Field: FIELD_IMAGE + "/" + container.Name,
+				Expected: container.Image, Actual: actualImages[container.Name]})
+		}
+	}
+
+	return drifts
+}
+
+// CompareAutoscaler checks that the autoscaler exists if the descriptor has autoscaling, and scales the deployed version
+func CompareAutoscaler(descriptor *types.Descriptor, versionedName string, autoscaler *autoscaling.HorizontalPodAutoscaler) []Drift {
+	settings := descriptor.Autoscaling
+	if settings == nil {
+		if autoscaler != nil {
+			return []Drift{{Kind: KIND_AUTOSCALER, Name: autoscaler.Name, Field: FIELD_UNEXPECTED, Healable: true}}
+		}
+		return []Drift{}
+	}
+	if autoscaler == nil {
+		return []Drift{{Kind: KIND_AUTOSCALER, Name: descriptor.AppName, Field: FIELD_MISSING, Healable: true}}
+	}
+
+	drifts := []Drift{}
+	if autoscaler.Spec.ScaleTargetRef.Name != versionedName {
+		drifts = append(drifts, Drift{Kind: KIND_AUTOSCALER, Name: autoscaler.Name, Field: FIELD_TARGET,
+			Expected: versionedName, Actual: autoscaler.Spec.ScaleTargetRef.Name, Healable: true})
+	}
+	minReplicas := int32(1)
+	if autoscaler.Spec.MinReplicas != nil {
+		minReplicas = *autoscaler.Spec.MinReplicas
+	}
+	expected := fmt.Sprintf("%v-%v", settings.MinReplicas, settings.MaxReplicas)
+	actual := fmt.Sprintf("%v-%v", minReplicas, autoscaler.Spec.MaxReplicas)
+	if expected != actual {
+		drifts = append(drifts, Drift{Kind: KIND_AUTOSCALER, Name: autoscaler.Name, Field: FIELD_REPLICAS,
+			Expected: expected, Actual: actual, Healable: true})
+	}
+	return drifts
+}
+
+// CompareService checks that the service exists and selects the expected pods
+func CompareService(expected *v1.Service, actual *v1.Service) []Drift {
+	if actual == nil {
+		return []Drift{{Kind: KIND_SERVICE, Name: expected.Name, Field: FIELD_MISSING, Healable: true}}
+	}
+
+	expectedSelector := formatSelector(expected.Spec.Selector)
+	actualSelector := formatSelector(actual.Spec.Selector)
+	if expectedSelector != actualSelector {
+		return []Drift{{Kind: KIND_SERVICE, Name: expected.Name, Field: FIELD_SELECTOR,
+			Expected: expectedSelector, Actual: actualSelector, Healable: true}}
+	}
+	return []Drift{}
+}
+
+// CompareIngress checks that the Ingress routes the frontend of the descriptor to the versioned service
+func CompareIngress(descriptor *types.Descriptor, versionedName string, ingress *v1beta1.Ingress) []Drift {
+	if ingress == nil {
+		return []Drift{{Kind: KIND_INGRESS, Name: descriptor.AppName, Field: FIELD_MISSING, Healable: true}}
+	}
+
+	hosts := []string{}
+	backends := []string{}
+	for _, rule := range ingress.Spec.Rules {
+		hosts = append(hosts, rule.Host)
+		if rule.Host != descriptor.Frontend || rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			backends = append(backends, path.Backend.ServiceName)
+		}
+	}
+
+	if !contains(hosts, descriptor.Frontend) {
+		return []Drift{{Kind: KIND_INGRESS, Name: ingress.Name, Field: FIELD_HOST,
+			Expected: descriptor.Frontend, Actual: strings.Join(hosts, ","), Healable: true}}
+	}
+	if len(backends) != 1 || backends[0] != versionedName {
+		return []Drift{{Kind: KIND_INGRESS, Name: ingress.Name, Field: FIELD_BACKEND,
+			Expected: versionedName, Actual: strings.Join(backends, ","), Healable: true}}
+	}
+	return []Drift{}
+}
+
+// Heal brings the cluster back to the descriptor of the deployment, for all healable drifts.
+// It returns a new report, which still contains the drifts which can't be healed.
+func (r *Reconciler) Heal(namespace string, deploymentId string, logger logger.Logger) (*Report, error) {
+	deployment, err := r.registry.GetDeploymentById(namespace, deploymentId)
+	if err != nil {
+		return nil, err
+	}
+
+	mutexKey := namespace + "-" + deployment.Descriptor.AppName
+	logger.Printf("Trying to acquire mutex for %v", mutexKey)
+	mutex := helper.GetMutex(r.config.Mutexes, mutexKey)
+	mutex.Lock()
+	defer mutex.Unlock()
+	logger.Printf("Acquired mutex for %v", mutexKey)
+
+	// the deployment might have been replaced while waiting for the mutex
+	if deployment, err = r.registry.GetDeploymentById(namespace, deploymentId); err != nil {
+		return nil, err
+	}
+	if deployment.Status != types.DEPLOYMENTSTATUS_DEPLOYED {
+		return nil, fmt.Errorf("Deployment %v is not deployed anymore", deploymentId)
+	}
+
+	report, err := r.Check(deployment)
+	if err != nil {
+		return nil, err
+	}
+	if !hasHealable(report) {
+		return report, nil
+	}
+
+	healed := []string{}
+	for _, drift := range report.Drifts {
+		if !drift.Healable {
+			continue
+		}
+		logger.Printf("Healing %v %v: %v", drift.Kind, drift.Name, drift.Field)
+		if err := r.healDrift(deployment, drift, logger); err != nil {
+			return nil, fmt.Errorf("Error healing %v %v: %v", drift.Kind, drift.Name, err.Error())
+		}
+		healed = append(healed, fmt.Sprintf("%v %v %v", drift.Kind, drift.Name, drift.Field))
+	}
+
+	if report, err = r.Check(deployment); err != nil {
+		return nil, err
+	}
+
+	r.config.Events.Publish(newEvent(events.EVENT_DRIFT_HEALED, deployment, report, "Healed drift of %v: %v", report.AppName, strings.Join(healed, ", ")))
+	r.publishChanges(deployment, report)
+
+	return report, nil
+}
+
+func (r *Reconciler) healDrift(deployment *types.Deployment, drift Drift, logger logger.Logger) error {
+	descriptor := deployment.Descriptor
+	namespace := descriptor.Namespace
+	k8sClient := r.config.K8sClient
+	clusterManager := cluster.NewClusterManager(r.config, copyDeployment(deployment), r.registry, logger)
+
+	switch drift.Kind {
+	case KIND_REPLICATIONCONTROLLER:
+		if drift.Field == FIELD_UNEXPECTED {
+			rc, err := k8sClient.GetReplicationController(namespace, drift.Name)
+			if err != nil {
+				return err
+			}
+			return k8sClient.ShutdownReplicationController(rc, logger)
+		}
+		if drift.Field == FIELD_MISSING {
+			environment, err := r.registry.GetEnvironmentVars(namespace, descriptor.AppName)
+			if err != nil {
+				return err
+			}
+			clusterManager.Deployment.Descriptor.SetEnvironment(environment)
+			_, err = clusterManager.CreateReplicationController()
+			return err
+		}
+		rc, err := k8sClient.GetReplicationController(namespace, drift.Name)
+		if err != nil {
+			return err
+		}
+		replicas := int32(deployment.GetReplicas())
+		rc.Spec.Replicas = &replicas
+		_, err = k8sClient.UpdateReplicationController(namespace, rc)
+		return err
+
+	case KIND_SERVICE:
+		persistent := drift.Name == descriptor.AppName
+		if drift.Field == FIELD_MISSING && persistent {
+			_, err := clusterManager.CreateOrUpdatePersistentService()
+			return err
+		}
+		if drift.Field == FIELD_MISSING {
+			_, err := clusterManager.CreateService()
+			return err
+		}
+		service, err := k8sClient.GetService(namespace, drift.Name)
+		if err != nil {
+			return err
+		}
+		if persistent {
+			service.Spec.Selector = clusterManager.BuildPersistentService(nil).Spec.Selector
+		} else {
+			service.Spec.Selector = clusterManager.BuildService().Spec.Selector
+		}
+		_, err = k8sClient.UpdateService(namespace, service)
+		return err
+
+	case KIND_INGRESS:
+		service, err := k8sClient.GetService(namespace, deployment.GetVersionedName())
+		if err != nil {
+			return err
+		}
+		if drift.Field == FIELD_MISSING {
+			ingress, err := r.config.IngressConfigurator.BuildIngress(nil, descriptor, service, logger)
+			if err != nil {
+				return err
+			}
+			_, err = k8sClient.CreateIngress(namespace, ingress)
+			return err
+		}
+		ingress, err := k8sClient.GetIngress(namespace, drift.Name)
+		if err != nil {
+			return err
+		}
+		if ingress, err = r.config.IngressConfigurator.BuildIngress(ingress, descriptor, service, logger); err != nil {
+			return err
+		}
+		_, err = k8sClient.UpdateIngress(namespace, ingress)
+		return err
+
+	case KIND_AUTOSCALER:
+		if drift.Field == FIELD_UNEXPECTED {
+			return k8sClient.DeleteHorizontalPodAutoscaler(namespace, drift.Name)
+		}
+		return clusterManager.CreateOrUpdateAutoscaler()
+	}
+
+	return fmt.Errorf("Unknown kind %v", drift.Kind)
+}
+
+func newEvent(eventType string, deployment *types.Deployment, report *Report, msg string, args ...interface{}) events.Event {
+	return events.Event{
+		Type:         eventType,
+		Namespace:    report.Namespace,
+		AppName:      report.AppName,
+		DeploymentId: deployment.Id,
+		Message:      fmt.Sprintf(msg, args...),
+		Data:         report,
+	}
+}
+
+func getService(config helper.DeployerConfig, namespace string, name string) (*v1.Service, error) {
+	service, err := config.K8sClient.GetService(namespace, name)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	return service, err
+}
+
+// the cluster manager modifies the descriptor, so don't hand out the registry's copy
+func copyDeployment(deployment *types.Deployment) *types.Deployment {
+	descriptor := *deployment.Descriptor
+	descriptor.PodSpec.Containers = append([]v1.Container{}, descriptor.PodSpec.Containers...)
+	copied := *deployment
+	copied.Descriptor = &descriptor
+	return &copied
+}
+
+func hasHealable(report *Report) bool {
+	for _, drift := range report.Drifts {
+		if drift.Healable {
+			return true
+		}
+	}
+	return false
+}
+
+func summarize(report *Report) string {
+	parts := []string{}
+	for _, drift := range report.Drifts {
+		parts = append(parts, fmt.Sprintf("%v %v %v", drift.Kind, drift.Name, drift.Field))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatSelector(selector map[string]string) string {
+	parts := []string{}
+	for key, value := range selector {
+		parts = append(parts, key+"="+value)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func isNotFound(err error) bool {
+	statusError, isStatus := err.(*errors.StatusError)
+	return isStatus && statusError.Status().Reason == meta.StatusReasonNotFound
+}
+
+func appKey(namespace string, appName string) string {
+	return namespace + "/" + appName
+}
@@ -0,0 +1,230 @@
+package drift
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/importer"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/k8s"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	autoscaling "k8s.io/client-go/pkg/apis/autoscaling/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+)
+
+func newRc(name string, replicas int32, image string) v1.ReplicationController {
+	return v1.ReplicationController{
+		ObjectMeta: meta.ObjectMeta{Name: name},
+		Spec: v1.ReplicationControllerSpec{
+			Replicas: &replicas,
+			Template: &v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{{Name: "myapp", Image: image}}}},
+		},
+	}
+}
+
+func newDescriptor() *types.Descriptor {
+	return &types.Descriptor{
+		Namespace: "test",
+		AppName:   "myapp",
+		Replicas:  2,
+		Frontend:  "myapp.example.com",
+		PodSpec:   v1.PodSpec{Containers: []v1.Container{{Name: "myapp", Image: "user/myapp:2.0"}}},
+	}
+}
+
+func TestCompareReplicationControllersInSync(t *testing.T) {
+	drifts := CompareReplicationControllers(newDescriptor(), 2, "myapp-2", []v1.ReplicationController{
+		newRc("myapp-1", 0, "user/myapp:1.0"),
+		newRc("myapp-2", 2, "user/myapp:2.0"),
+	})
+	if len(drifts) != 0 {
+		t.Errorf("Expected no drift, got %+v", drifts)
+	}
+}
+
+func TestCompareReplicationControllers(t *testing.T) {
+	drifts := CompareReplicationControllers(newDescriptor(), 2, "myapp-2", []v1.ReplicationController{
+		newRc("myapp-1", 1, "user/myapp:1.0"),
+		newRc("myapp-2", 5, "user/myapp:latest"),
+	})
+
+	expected := []Drift{
+		{Kind: KIND_REPLICATIONCONTROLLER, Name: "myapp-1", Field: FIELD_UNEXPECTED, Expected: "0 replicas", Actual: "1 replicas", Healable: true},
+		{Kind: KIND_REPLICATIONCONTROLLER, Name: "myapp-2", Field: FIELD_REPLICAS, Expected: "2", Actual: "5", Healable: true},
+		{Kind: KIND_REPLICATIONCONTROLLER, Name: "myapp-2", Field: "image/myapp", Expected: "user/myapp:2.0", Actual: "user/myapp:latest"},
+	}
+	if len(drifts) != len(expected) {
+		t.Fatalf("Expected %v drifts, got %+v", len(expected), drifts)
+	}
+	for i := range expected {
+		if drifts[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], drifts[i])
+		}
+	}
+}
+
+func TestCompareReplicationControllersMissing(t *testing.T) {
+	drifts := CompareReplicationControllers(newDescriptor(), 2, "myapp-2", []v1.ReplicationController{})
+	if len(drifts) != 1 || drifts[0].Field != FIELD_MISSING || !drifts[0].Healable {
+		t.Errorf("Expected missing replication controller, got %+v", drifts)
+	}
+}
+
+func TestCompareReplicationControllersAutoscaled(t *testing.T) {
+	descriptor := newDescriptor()
+	descriptor.Autoscaling = &types.Autoscaling{MinReplicas: 1, MaxReplicas: 10, TargetCPUUtilization: 80}
+
+	drifts := CompareReplicationControllers(descriptor, 2, "myapp-2", []v1.ReplicationController{
+		newRc("myapp-2", 7, "user/myapp:2.0"),
+	})
+	if len(drifts) != 0 {
+		t.Errorf("Expected replicas managed by the autoscaler to be ignored, got %+v", drifts)
+	}
+}
+
+func TestCompareReplicationControllersScaled(t *testing.T) {
+	drifts := CompareReplicationControllers(newDescriptor(), 5, "myapp-2", []v1.ReplicationController{
+		newRc("myapp-2", 5, "user/myapp:2.0"),
+	})
+	if len(drifts) != 0 {
+		t.Errorf("Expected the scaled replicas to be in sync, got %+v", drifts)
+	}
+}
+
+func TestCompareAutoscaler(t *testing.T) {
+	descriptor := newDescriptor()
+	if drifts := CompareAutoscaler(descriptor, "myapp-2", nil); len(drifts) != 0 {
+		t.Errorf("Expected no drift without autoscaling, got %+v", drifts)
+	}
+
+	descriptor.Autoscaling = &types.Autoscaling{MinReplicas: 2, MaxReplicas: 10, TargetCPUUtilization: 80}
+	if drifts := CompareAutoscaler(descriptor, "myapp-2", nil); len(drifts) != 1 || drifts[0].Field != FIELD_MISSING {
+		t.Errorf("Expected missing autoscaler, got %+v", drifts)
+	}
+
+	minReplicas := int32(2)
+	autoscaler := &autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: meta.ObjectMeta{Name: "myapp"},
+		Spec: autoscaling.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscaling.CrossVersionObjectReference{Kind: "ReplicationController", Name: "myapp-1"},
+			MinReplicas:    &minReplicas,
+			MaxReplicas:    10,
+		},
+	}
+	drifts := CompareAutoscaler(descriptor, "myapp-2", autoscaler)
+	if len(drifts) != 1 || drifts[0].Field != FIELD_TARGET || drifts[0].Expected != "myapp-2" || !drifts[0].Healable {
+		t.Errorf("Expected autoscaler of old version, got %+v", drifts)
+	}
+}
+
+func TestCompareService(t *testing.T) {
+	expected := &v1.Service{ObjectMeta: meta.ObjectMeta{Name: "myapp"}, Spec: v1.ServiceSpec{Selector: map[string]string{"app": "myapp", "version": "2"}}}
+
+	if drifts := CompareService(expected, nil); len(drifts) != 1 || drifts[0].Field != FIELD_MISSING {
+		t.Errorf("Expected missing service, got %+v", drifts)
+	}
+
+	actual := &v1.Service{ObjectMeta: meta.ObjectMeta{Name: "myapp"}, Spec: v1.ServiceSpec{Selector: map[string]string{"version": "2", "app": "myapp"}}}
+	if drifts := CompareService(expected, actual); len(drifts) != 0 {
+		t.Errorf("Expected no drift, got %+v", drifts)
+	}
+
+	actual.Spec.Selector["version"] = "1"
+	drifts := CompareService(expected, actual)
+	if len(drifts) != 1 || drifts[0].Expected != "app=myapp,version=2" || drifts[0].Actual != "app=myapp,version=1" {
+		t.Errorf("Expected selector drift, got %+v", drifts)
+	}
+}
+
+func TestCompareIngress(t *testing.T) {
+	descriptor := newDescriptor()
+	ingress := &v1beta1.Ingress{
+		ObjectMeta: meta.ObjectMeta{Name: "myapp"},
+		Spec: v1beta1.IngressSpec{Rules: []v1beta1.IngressRule{{
+			Host: "myapp.example.com",
+			IngressRuleValue: v1beta1.IngressRuleValue{HTTP: &v1beta1.HTTPIngressRuleValue{
+				Paths: []v1beta1.HTTPIngressPath{{Backend: v1beta1.IngressBackend{ServiceName: "myapp-2"}}},
+			}},
+		}}},
+	}
+
+	if drifts := CompareIngress(descriptor, "myapp-2", ingress); len(drifts) != 0 {
+		t.Errorf("Expected no drift, got %+v", drifts)
+	}
+
+	if drifts := CompareIngress(descriptor, "myapp-3", ingress); len(drifts) != 1 || drifts[0].Field != FIELD_BACKEND {
+		t.Errorf("Expected backend drift, got %+v", drifts)
+	}
+
+	ingress.Spec.Rules[0].Host = "other.example.com"
+	if drifts := CompareIngress(descriptor, "myapp-2", ingress); len(drifts) != 1 || drifts[0].Field != FIELD_HOST {
+		t.Errorf("Expected host drift, got %+v", drifts)
+	}
+
+	if drifts := CompareIngress(descriptor, "myapp-2", nil); len(drifts) != 1 || drifts[0].Field != FIELD_MISSING {
+		t.Errorf("Expected missing Ingress, got %+v", drifts)
+	}
+}
+
+// fakeApiServer serves the given objects by path, and a NotFound status for everything else
+func fakeApiServer(objects map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		object, found := objects[req.URL.Path]
+		if !found {
+			writer.WriteHeader(404)
+			object = meta.Status{TypeMeta: meta.TypeMeta{Kind: "Status", APIVersion: "v1"}, Status: meta.StatusFailure,
+				Reason: meta.StatusReasonNotFound, Code: 404}
+		}
+		json.NewEncoder(writer).Encode(object)
+	}))
+}
+
+func TestCheckAdoptedDeployment(t *testing.T) {
+	replicas := int32(2)
+	labels := map[string]string{"app": "myapp", "version": "3"}
+	rc := v1.ReplicationController{
+		TypeMeta:   meta.TypeMeta{Kind: "ReplicationController", APIVersion: "v1"},
+		ObjectMeta: meta.ObjectMeta{Name: "myapp-3", Namespace: "test", Labels: labels},
+		Spec: v1.ReplicationControllerSpec{
+			Replicas: &replicas,
+			Selector: map[string]string{"name": "myapp-3"},
+			Template: &v1.PodTemplateSpec{
+				ObjectMeta: meta.ObjectMeta{Labels: map[string]string{"name": "myapp-3"}},
+				Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "myapp", Image: "user/myapp:3.0"}}},
+			},
+		},
+	}
+	if !importer.IsAdoptable("myapp", "3", rc.Name) {
+		t.Fatal("Expected replication controller to be adoptable")
+	}
+
+	server := fakeApiServer(map[string]interface{}{
+		"/api/v1/namespaces/test/replicationcontrollers": v1.ReplicationControllerList{
+			TypeMeta: meta.TypeMeta{Kind: "ReplicationControllerList", APIVersion: "v1"},
+			Items:    []v1.ReplicationController{rc},
+		},
+	})
+	defer server.Close()
+	k8sClient, err := k8s.New(k8s.K8sConfig{ApiServerUrl: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	descriptor := importer.DescriptorFromWorkload("test", rc.Name, rc.ObjectMeta, rc.Spec.Replicas, rc.Spec.Template)
+	deployment := importer.AdoptedDeployment(descriptor, "3")
+
+	report, err := NewReconciler(helper.DeployerConfig{K8sClient: k8sClient}, 60, true).Check(deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, drift := range report.Drifts {
+		if drift.Kind == KIND_REPLICATIONCONTROLLER {
+			t.Errorf("Expected the adopted replication controller to be found, got %+v", drift)
+		}
+	}
+}
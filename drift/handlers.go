@@ -0,0 +1,125 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package drift
+
+import (
+	"net/http"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+)
+
+type DriftHandlers struct {
+	registry   *etcdregistry.EtcdRegistry
+	reconciler *Reconciler
+}
+
+func NewDriftHandlers(registry *etcdregistry.EtcdRegistry, reconciler *Reconciler) *DriftHandlers {
+	return &DriftHandlers{registry, reconciler}
+}
+
+// DriftReportHandler checks all deployed apps of a namespace, or a single app, for drift
+func (d *DriftHandlers) DriftReportHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	//TODO check namespaces of user
+	namespace := req.URL.Query().Get("namespace")
+	if namespace == "" {
+		helper.HandleError(writer, logger, 400, "Namespace parameter missing")
+		return
+	}
+	appName := req.URL.Query().Get("appname")
+
+	deployed, err := d.getDeployed(namespace, appName)
+	if err != nil {
+		helper.HandleError(writer, logger, 500, "Error getting deployments: %v", err)
+		return
+	}
+	if appName != "" && len(deployed) == 0 {
+		helper.HandleNotFound(writer, logger, "No deployed version of app %v found", appName)
+		return
+	}
+
+	reports := []*Report{}
+	for _, deployment := range deployed {
+		report, err := d.reconciler.Check(deployment)
+		if err != nil {
+			helper.HandleError(writer, logger, 500, "Error checking drift of app %v: %v", deployment.Descriptor.AppName, err)
+			return
+		}
+		reports = append(reports, report)
+	}
+
+	helper.HandleSuccess(writer, logger, reports, "Checked drift of %v apps in namespace %v", len(reports), namespace)
+}
+
+// HealDriftHandler heals the drift of an app back to its descriptor, and returns the remaining drift
+func (d *DriftHandlers) HealDriftHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	//TODO check namespaces of user
+	namespace := req.URL.Query().Get("namespace")
+	if namespace == "" {
+		helper.HandleError(writer, logger, 400, "Namespace parameter missing")
+		return
+	}
+	appName := req.URL.Query().Get("appname")
+	if appName == "" {
+		helper.HandleError(writer, logger, 400, "Appname parameter missing")
+		return
+	}
+
+	deployed, err := d.getDeployed(namespace, appName)
+	if err != nil {
+		helper.HandleError(writer, logger, 500, "Error getting deployments: %v", err)
+		return
+	}
+	if len(deployed) == 0 {
+		helper.HandleNotFound(writer, logger, "No deployed version of app %v found", appName)
+		return
+	}
+
+	report, err := d.reconciler.Heal(namespace, deployed[0].Id, logger)
+	if err != nil {
+		helper.HandleError(writer, logger, 500, "Error healing drift of app %v: %v", appName, err)
+		return
+	}
+
+	helper.HandleSuccess(writer, logger, report, "Healed drift of app %v", appName)
+}
+
+func (d *DriftHandlers) getDeployed(namespace string, appName string) ([]*types.Deployment, error) {
+	var deployments []*types.Deployment
+	var err error
+	if appName != "" {
+		deployments, err = d.registry.GetDeploymentsByAppName(namespace, appName)
+	} else {
+		deployments, err = d.registry.GetDeployments(namespace)
+	}
+	if err != nil && err != etcdregistry.ErrDeploymentNotFound {
+		return nil, err
+	}
+
+	deployed := []*types.Deployment{}
+	for _, deployment := range deployments {
+		if deployment.Status == types.DEPLOYMENTSTATUS_DEPLOYED {
+			deployed = append(deployed, deployment)
+		}
+	}
+	return deployed, nil
+}
@@ -0,0 +1,80 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	cipher, err := NewCipher(bytes.Repeat([]byte{1}, KEY_LENGTH))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := cipher.Encrypt("secret value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encrypted == "secret value" {
+		t.Error("Value was not encrypted")
+	}
+	again, _ := cipher.Encrypt("secret value")
+	if again == encrypted {
+		t.Error("Expected a new nonce for every encryption")
+	}
+
+	decrypted, err := cipher.Decrypt(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != "secret value" {
+		t.Errorf("Unexpected decrypted value %v", decrypted)
+	}
+}
+
+func TestDecryptWithOtherKey(t *testing.T) {
+	cipher, _ := NewCipher(bytes.Repeat([]byte{1}, KEY_LENGTH))
+	other, _ := NewCipher(bytes.Repeat([]byte{2}, KEY_LENGTH))
+
+	encrypted, _ := cipher.Encrypt("secret value")
+	if _, err := other.Decrypt(encrypted); err != ErrInvalidCiphertext {
+		t.Errorf("Expected ErrInvalidCiphertext, got %v", err)
+	}
+	if _, err := cipher.Decrypt("not base64!"); err != ErrInvalidCiphertext {
+		t.Errorf("Expected ErrInvalidCiphertext, got %v", err)
+	}
+}
+
+func TestNewCipherInvalidKey(t *testing.T) {
+	if _, err := NewCipher([]byte("short")); err == nil {
+		t.Error("Expected error for short key")
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	key := bytes.Repeat([]byte{3}, KEY_LENGTH)
+	file, err := ioutil.TempFile("", "deployerkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(base64.StdEncoding.EncodeToString(key) + "\n")
+	file.Close()
+
+	keyring, err := LoadKeyring(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyring.PrimaryKeyId() != DEFAULT_KEY_ID {
+		t.Errorf("Unexpected primary key %v", keyring.PrimaryKeyId())
+	}
+
+	os.Setenv(ENV_KEY, "")
+	keyring, err = LoadKeyring("")
+	if err != nil || keyring != nil {
+		t.Errorf("Expected no keyring, got %v %v", keyring, err)
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package encryption
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// id of a key given without id
+const DEFAULT_KEY_ID = "default"
+
+var ErrUnknownKey = errors.New("unknown encryption key!")
+
+var keyIdRegexp = regexp.MustCompile("^[A-Za-z0-9_-]+$")
+
+// Keyring does envelope encryption: every value is encrypted with a new data key, which is encrypted with the primary key
+// of the keyring. The id of that key is stored with the value, so values encrypted with older keys can still be decrypted
+// after a new primary key was added.
+type Keyring struct {
+	primary string
+	keys    map[string]*Cipher
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{keys: map[string]*Cipher{}}
+}
+
+// Add adds a key, the first added key is the primary key unless another key is added as primary
+func (k *Keyring) Add(id string, key []byte, primary bool) error {
+	if !keyIdRegexp.MatchString(id) {
+		return fmt.Errorf("Invalid key id %v, use letters, digits, dashes and underscores", id)
+	}
+	if _, exists := k.keys[id]; exists {
+		return fmt.Errorf("Duplicate key id %v", id)
+	}
+	cipher, err := NewCipher(key)
+	if err != nil {
+		return err
+	}
+	k.keys[id] = cipher
+	if primary || k.primary == "" {
+		k.primary = id
+	}
+	return nil
+}
+
+// PrimaryKeyId returns the id of the key used for encrypting
+func (k *Keyring) PrimaryKeyId() string {
+	return k.primary
+}
+
+// LoadKeyring reads the keys from the given file, or from the DEPLOYER_ENCRYPTION_KEY environment variable when no file is given.
+// Every line contains a base64 encoded key, optionally prefixed with its id and a colon ("2017-06:<base64>"). The first key
+// is the primary key. It returns nil without error when no key is configured.
+func LoadKeyring(path string) (*Keyring, error) {
+	content := os.Getenv(ENV_KEY)
+	if path != "" {
+		bytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		content = string(bytes)
+	}
+	return ParseKeyring(content)
+}
+
+// ParseKeyring parses keys in the format of LoadKeyring
+func ParseKeyring(content string) (*Keyring, error) {
+	keyring := NewKeyring()
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id := DEFAULT_KEY_ID
+		if colon := strings.Index(line, ":"); colon >= 0 {
+			id = strings.TrimSpace(line[:colon])
+			line = strings.TrimSpace(line[colon+1:])
+		}
+		key, err := decodeKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid key %v: %v", id, err)
+		}
+		if err := keyring.Add(id, key, false); err != nil {
+			return nil, err
+		}
+	}
+	if keyring.primary == "" {
+		return nil, nil
+	}
+	return keyring, nil
+}
+
+// Encrypt returns the envelope of the plaintext: the key id, the encrypted data key and the encrypted value, separated by dots
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	dataKey := make([]byte, KEY_LENGTH)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	dataCipher, err := NewCipher(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := dataCipher.Encrypt(plaintext)
+	if err != nil {
+		return "", err
+	}
+	wrappedKey, err := k.keys[k.primary].Encrypt(string(dataKey))
+	if err != nil {
+		return "", err
+	}
+	return k.primary + "." + wrappedKey + "." + ciphertext, nil
+}
+
+// Decrypt returns the plaintext of an envelope created by Encrypt
+func (k *Keyring) Decrypt(envelope string) (string, error) {
+	parts := strings.Split(envelope, ".")
+	if len(parts) != 3 {
+		return "", ErrInvalidCiphertext
+	}
+	keyCipher, found := k.keys[parts[0]]
+	if !found {
+		return "", ErrUnknownKey
+	}
+	dataKey, err := keyCipher.Decrypt(parts[1])
+	if err != nil {
+		return "", err
+	}
+	dataCipher, err := NewCipher([]byte(dataKey))
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+	return dataCipher.Decrypt(parts[2])
+}
+
+// NeedsRotation returns whether the envelope was not encrypted with the primary key
+func (k *Keyring) NeedsRotation(envelope string) bool {
+	return KeyId(envelope) != k.primary
+}
+
+// KeyId returns the id of the key of the envelope
+func KeyId(envelope string) string {
+	dot := strings.Index(envelope, ".")
+	if dot < 0 {
+		return ""
+	}
+	return envelope[:dot]
+}
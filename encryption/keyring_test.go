@@ -0,0 +1,74 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func encodedKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, KEY_LENGTH))
+}
+
+func TestKeyringEncryptDecrypt(t *testing.T) {
+	keyring, err := ParseKeyring("k1:" + encodedKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope, err := keyring.Encrypt("secret value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(envelope, "k1.") || strings.Contains(envelope, "secret value") {
+		t.Errorf("Unexpected envelope %v", envelope)
+	}
+
+	decrypted, err := keyring.Decrypt(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != "secret value" {
+		t.Errorf("Unexpected decrypted value %v", decrypted)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	old, _ := ParseKeyring("k1:" + encodedKey(1))
+	envelope, _ := old.Encrypt("secret value")
+
+	// the new key is added as first line, the old one is kept for decrypting
+	rotated, err := ParseKeyring("# keys\nk2:" + encodedKey(2) + "\nk1:" + encodedKey(1) + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.PrimaryKeyId() != "k2" {
+		t.Errorf("Unexpected primary key %v", rotated.PrimaryKeyId())
+	}
+	if !rotated.NeedsRotation(envelope) {
+		t.Error("Expected envelope of old key to need rotation")
+	}
+	decrypted, err := rotated.Decrypt(envelope)
+	if err != nil || decrypted != "secret value" {
+		t.Errorf("Unexpected decrypted value %v %v", decrypted, err)
+	}
+
+	reencrypted, _ := rotated.Encrypt(decrypted)
+	if rotated.NeedsRotation(reencrypted) || KeyId(reencrypted) != "k2" {
+		t.Errorf("Unexpected envelope after rotation %v", reencrypted)
+	}
+
+	withoutOld, _ := ParseKeyring("k2:" + encodedKey(2))
+	if _, err := withoutOld.Decrypt(envelope); err != ErrUnknownKey {
+		t.Errorf("Expected ErrUnknownKey, got %v", err)
+	}
+}
+
+func TestParseKeyringInvalid(t *testing.T) {
+	for _, content := range []string{"k1:notbase64!", "k1:" + base64.StdEncoding.EncodeToString([]byte("short")), "k 1:" + encodedKey(1), "k1:" + encodedKey(1) + "\nk1:" + encodedKey(2)} {
+		if _, err := ParseKeyring(content); err == nil {
+			t.Errorf("Expected error for %q", content)
+		}
+	}
+}
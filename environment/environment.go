@@ -0,0 +1,153 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package environment
+
+import (
+	"regexp"
+	"sort"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+)
+
+var nameRegexp = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_-]*$")
+
+// AffectedApp is a deployed app which gets another value for an environment var on its next deployment
+// when the var is changed in a scope
+type AffectedApp struct {
+	Namespace    string `json:"namespace"`
+	AppName      string `json:"appName"`
+	DeploymentId string `json:"deploymentId"`
+	Version      string `json:"version"`
+	Value        string `json:"value,omitempty"`
+	Secret       bool   `json:"secret,omitempty"`
+}
+
+// ScopedVars returns the environment vars of a single scope, see EtcdRegistry.GetScopedEnvironmentVars
+type ScopedVars func(namespace string, appName string) (map[string]types.EnvironmentVar, error)
+
+func IsValidName(name string) bool {
+	return nameRegexp.MatchString(name)
+}
+
+// Redact replaces the values of secret vars, and of the vars which are sensitive fields
+func Redact(vars map[string]types.EnvironmentVar, fields types.SensitiveFields) {
+	for name, envVar := range vars {
+		if envVar.Secret || fields.IncludesEnv(name) {
+			envVar.Value = types.REDACTED
+			vars[name] = envVar
+		}
+	}
+}
+
+// Affected returns the deployed apps in the scope of the given namespace and appName (global without namespace)
+// which would be affected by changing the environment var with the given name in that scope
+func Affected(registry *etcdregistry.EtcdRegistry, namespace string, appName string, name string) ([]AffectedApp, error) {
+	var deployments []*types.Deployment
+	var err error
+	if namespace == "" {
+		deployments, err = registry.GetAllDeployments()
+	} else {
+		deployments, err = registry.GetDeployments(namespace)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return AffectedDeployments(deployments, namespace, appName, name, registry.GetScopedEnvironmentVars)
+}
+
+// AffectedDeployments returns the apps of the deployed deployments in the scope, which don't override the environment var
+// in a more specific scope. Value is the current effective value of the var.
+func AffectedDeployments(deployments []*types.Deployment, namespace string, appName string, name string, scopedVars ScopedVars) ([]AffectedApp, error) {
+	name = etcdregistry.EnvVarName(name)
+
+	// cache lookups, many apps share the global and namespace scopes
+	cache := map[string]map[string]types.EnvironmentVar{}
+	lookup := func(namespace string, appName string) (map[string]types.EnvironmentVar, error) {
+		key := namespace + "/" + appName
+		if vars, found := cache[key]; found {
+			return vars, nil
+		}
+		vars, err := scopedVars(namespace, appName)
+		if err != nil {
+			return nil, err
+		}
+		cache[key] = vars
+		return vars, nil
+	}
+
+	affected := []AffectedApp{}
+	for _, deployment := range deployments {
+		descriptor := deployment.Descriptor
+		if deployment.Status != types.DEPLOYMENTSTATUS_DEPLOYED {
+			continue
+		}
+		if (namespace != "" && descriptor.Namespace != namespace) || (appName != "" && descriptor.AppName != appName) {
+			continue
+		}
+
+		// the scopes of the app, from global to app, and the index of the changed scope
+		scopes := [][]string{{"", ""}, {descriptor.Namespace, ""}, {descriptor.Namespace, descriptor.AppName}}
+		changed := 0
+		if appName != "" {
+			changed = 2
+		} else if namespace != "" {
+			changed = 1
+		}
+
+		current := types.EnvironmentVar{}
+		overridden := false
+		for i, scope := range scopes {
+			vars, err := lookup(scope[0], scope[1])
+			if err != nil {
+				return nil, err
+			}
+			if envVar, found := vars[name]; found {
+				current = envVar
+				overridden = i > changed
+			}
+		}
+		if current.Secret {
+			current.Value = types.REDACTED
+		}
+		if overridden {
+			continue
+		}
+
+		affected = append(affected, AffectedApp{
+			Namespace:    descriptor.Namespace,
+			AppName:      descriptor.AppName,
+			DeploymentId: deployment.Id,
+			Version:      deployment.Version,
+			Value:        current.Value,
+			Secret:       current.Secret,
+		})
+	}
+
+	sort.Sort(byApp(affected))
+	return affected, nil
+}
+
+type byApp []AffectedApp
+
+func (a byApp) Len() int      { return len(a) }
+func (a byApp) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byApp) Less(i, j int) bool {
+	if a[i].Namespace != a[j].Namespace {
+		return a[i].Namespace < a[j].Namespace
+	}
+	return a[i].AppName < a[j].AppName
+}
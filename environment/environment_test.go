@@ -0,0 +1,121 @@
+package environment
+
+import (
+	"testing"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+)
+
+func newDeployment(namespace string, appName string, status string) *types.Deployment {
+	return &types.Deployment{
+		Id:         namespace + "-" + appName,
+		Version:    "1",
+		Status:     status,
+		Descriptor: &types.Descriptor{Namespace: namespace, AppName: appName},
+	}
+}
+
+func TestAffectedDeployments(t *testing.T) {
+	deployments := []*types.Deployment{
+		newDeployment("test", "b", types.DEPLOYMENTSTATUS_DEPLOYED),
+		newDeployment("test", "a", types.DEPLOYMENTSTATUS_DEPLOYED),
+		newDeployment("test", "c", types.DEPLOYMENTSTATUS_UNDEPLOYED),
+		newDeployment("other", "a", types.DEPLOYMENTSTATUS_DEPLOYED),
+		newDeployment("prod", "a", types.DEPLOYMENTSTATUS_DEPLOYED),
+	}
+	scopes := map[string]map[string]types.EnvironmentVar{
+		"/":        {"DB_HOST": {Value: "global"}},
+		"prod/":    {"DB_HOST": {Value: "prod"}},
+		"test/b":   {"DB_HOST": {Value: "b"}, "DB_PASSWORD": {Value: "secret", Secret: true}},
+		"other/":   {},
+		"other/a":  {},
+		"prod/a":   {},
+		"test/":    {},
+		"test/a":   {},
+		"unused/x": {},
+	}
+	scopedVars := func(namespace string, appName string) (map[string]types.EnvironmentVar, error) {
+		return scopes[namespace+"/"+appName], nil
+	}
+
+	// global change: overridden by namespace prod and by app test/b
+	affected, err := AffectedDeployments(deployments, "", "", "db-host", scopedVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(affected) != 2 {
+		t.Fatalf("Expected 2 affected apps, got %+v", affected)
+	}
+	if affected[0].Namespace != "other" || affected[1].Namespace != "test" || affected[1].AppName != "a" || affected[1].Value != "global" {
+		t.Errorf("Unexpected affected apps %+v", affected)
+	}
+
+	// namespace change: b overrides it
+	affected, err = AffectedDeployments(deployments, "test", "", "DB_HOST", scopedVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(affected) != 1 || affected[0].AppName != "a" {
+		t.Errorf("Unexpected affected apps %+v", affected)
+	}
+
+	// app change
+	affected, err = AffectedDeployments(deployments, "test", "b", "DB_HOST", scopedVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(affected) != 1 || affected[0].AppName != "b" || affected[0].Value != "b" {
+		t.Errorf("Unexpected affected apps %+v", affected)
+	}
+}
+
+func TestAffectedDeploymentsRedactsSecrets(t *testing.T) {
+	deployments := []*types.Deployment{newDeployment("test", "b", types.DEPLOYMENTSTATUS_DEPLOYED)}
+	scopedVars := func(namespace string, appName string) (map[string]types.EnvironmentVar, error) {
+		if appName == "b" {
+			return map[string]types.EnvironmentVar{"DB_PASSWORD": {Value: "secret", Secret: true}}, nil
+		}
+		return map[string]types.EnvironmentVar{}, nil
+	}
+
+	affected, err := AffectedDeployments(deployments, "test", "b", "DB_PASSWORD", scopedVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(affected) != 1 || affected[0].Value != types.REDACTED || !affected[0].Secret {
+		t.Errorf("Unexpected affected apps %+v", affected)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	vars := map[string]types.EnvironmentVar{"A": {Value: "a"}, "B": {Value: "b", Secret: true}}
+
+	Redact(vars, nil)
+
+	if vars["A"].Value != "a" || vars["B"].Value != types.REDACTED || !vars["B"].Secret {
+		t.Errorf("Unexpected redacted vars %v", vars)
+	}
+}
+
+func TestRedactSensitiveVars(t *testing.T) {
+	vars := map[string]types.EnvironmentVar{"A": {Value: "a"}, "TOKEN": {Value: "t"}}
+
+	Redact(vars, types.SensitiveFields{"env.TOKEN"})
+
+	if vars["A"].Value != "a" || vars["TOKEN"].Value != types.REDACTED {
+		t.Errorf("Unexpected redacted vars %v", vars)
+	}
+}
+
+func TestIsValidName(t *testing.T) {
+	for _, name := range []string{"DB_HOST", "db-host", "_X1"} {
+		if !IsValidName(name) {
+			t.Errorf("Expected %v to be valid", name)
+		}
+	}
+	for _, name := range []string{"", "1X", "A B", "a/b"} {
+		if IsValidName(name) {
+			t.Errorf("Expected %v to be invalid", name)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package environment
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"github.com/gorilla/mux"
+)
+
+type EnvironmentHandlers struct {
+	registry *etcdregistry.EtcdRegistry
+}
+
+func NewEnvironmentHandlers(registry *etcdregistry.EtcdRegistry) *EnvironmentHandlers {
+	return &EnvironmentHandlers{registry}
+}
+
+// ListEnvironmentVarsHandler lists the vars of the scope, or with effective=true the merged vars which are injected into the app
+func (e *EnvironmentHandlers) ListEnvironmentVarsHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	//TODO check namespaces of user
+	namespace, appName, ok := getScope(writer, req, logger)
+	if !ok {
+		return
+	}
+
+	var vars map[string]types.EnvironmentVar
+	var err error
+	if req.URL.Query().Get("effective") == "true" {
+		if appName == "" {
+			helper.HandleError(writer, logger, 400, "Namespace and appname parameters are required for effective environment vars")
+			return
+		}
+		vars, err = e.registry.GetEnvironmentVars(namespace, appName)
+	} else {
+		vars, err = e.registry.GetScopedEnvironmentVars(namespace, appName)
+	}
+	if err != nil {
+		helper.HandleError(writer, logger, 500, "Error getting environment vars of %v: %v", scopeName(namespace, appName), err)
+		return
+	}
+	// secret vars are never returned, sensitive ones only for admin requests
+	if helper.IsAdmin(req) {
+		Redact(vars, nil)
+	} else {
+		Redact(vars, e.registry.SensitiveFields())
+	}
+
+	helper.HandleSuccess(writer, logger, vars, "Listed environment vars of %v", scopeName(namespace, appName))
+}
+
+func (e *EnvironmentHandlers) SetEnvironmentVarHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	//TODO check namespaces of user
+	namespace, appName, ok := getScope(writer, req, logger)
+	if !ok {
+		return
+	}
+
+	name := mux.Vars(req)["name"]
+	if !IsValidName(name) {
+		helper.HandleError(writer, logger, 400, "Invalid environment var name %v, use letters, digits, dashes and underscores", name)
+		return
+	}
+
+	defer req.Body.Close()
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		helper.HandleError(writer, logger, 500, "Error reading body: %v", err)
+		return
+	}
+	environmentVar := types.EnvironmentVar{}
+	if err := json.Unmarshal(body, &environmentVar); err != nil {
+		helper.HandleError(writer, logger, 400, "Error parsing body: %v", err)
+		return
+	}
+
+	if err := e.registry.SetEnvironmentVar(namespace, appName, name, environmentVar); err == etcdregistry.ErrNoEncryptionKey {
+		helper.HandleError(writer, logger, 400, "Secret environment vars need an encryption key, see the -encryptionkeyfile flag")
+		return
+	} else if err != nil {
+		helper.HandleError(writer, logger, 500, "Error storing environment var %v: %v", name, err)
+		return
+	}
+
+	helper.HandleSuccess(writer, logger, "", "Environment var %v of %v set", name, scopeName(namespace, appName))
+}
+
+func (e *EnvironmentHandlers) DeleteEnvironmentVarHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	//TODO check namespaces of user
+	namespace, appName, ok := getScope(writer, req, logger)
+	if !ok {
+		return
+	}
+
+	name := mux.Vars(req)["name"]
+	err := e.registry.DeleteEnvironmentVar(namespace, appName, name)
+	if err == etcdregistry.ErrEnvironmentVarNotFound {
+		helper.HandleNotFound(writer, logger, "Environment var %v not found", name)
+		return
+	} else if err != nil {
+		helper.HandleError(writer, logger, 500, "Error deleting environment var %v: %v", name, err)
+		return
+	}
+
+	helper.HandleSuccess(writer, logger, "", "Environment var %v of %v deleted", name, scopeName(namespace, appName))
+}
+
+// AffectedAppsHandler lists the deployed apps which would be affected by changing the var in the scope
+func (e *EnvironmentHandlers) AffectedAppsHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	//TODO check namespaces of user
+	namespace, appName, ok := getScope(writer, req, logger)
+	if !ok {
+		return
+	}
+
+	name := mux.Vars(req)["name"]
+	affected, err := Affected(e.registry, namespace, appName, name)
+	if err != nil {
+		helper.HandleError(writer, logger, 500, "Error getting affected apps: %v", err)
+		return
+	}
+	if !helper.IsAdmin(req) && e.registry.SensitiveFields().IncludesEnv(etcdregistry.EnvVarName(name)) {
+		for i := range affected {
+			if affected[i].Value != "" {
+				affected[i].Value = types.REDACTED
+			}
+		}
+	}
+
+	helper.HandleSuccess(writer, logger, affected, "Listed apps affected by environment var %v of %v", name, scopeName(namespace, appName))
+}
+
+// getScope returns the namespace and appname parameters, both are empty for the global scope
+func getScope(writer http.ResponseWriter, req *http.Request, logger logger.Logger) (string, string, bool) {
+	namespace := req.URL.Query().Get("namespace")
+	appName := req.URL.Query().Get("appname")
+	if appName != "" && namespace == "" {
+		helper.HandleError(writer, logger, 400, "Namespace parameter missing")
+		return "", "", false
+	}
+	return namespace, appName, true
+}
+
+func scopeName(namespace string, appName string) string {
+	if namespace == "" {
+		return "global scope"
+	}
+	if appName == "" {
+		return "namespace " + namespace
+	}
+	return "app " + appName + " in namespace " + namespace
+}
@@ -19,9 +19,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
+	"sort"
 	"strings"
 	"time"
 
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/encryption"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/events"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
 	"github.com/coreos/etcd/client"
 	etcd "github.com/coreos/etcd/client"
@@ -30,24 +34,68 @@ import (
 )
 
 const (
-	PATH_DESCRIPTORS = "/deployer/descriptors/"
-	PATH_DEPLOYMENTS = "/deployer/deployments/"
-	PATH_ENVIRONMENT = "/deployer/environment/"
-	PATH_HEALTHDATA  = "/deployer/healthcheckdata/"
-	PATH_LOGS        = "/deployer/logs/"
+	PATH_DESCRIPTORS         = "/deployer/descriptors/"
+	PATH_DESCRIPTORREVISIONS = "/deployer/descriptorrevisions/"
+	PATH_DEPLOYMENTS         = "/deployer/deployments/"
+	PATH_DEFAULTS            = "/deployer/defaults/"
+	PATH_VARIABLES           = "/deployer/variables/"
+	PATH_ENVIRONMENT         = "/deployer/environment/"
+	PATH_HEALTHDATA          = "/deployer/healthcheckdata/"
+	PATH_LOGS                = "/deployer/logs/"
+
+	PATH_NOTIFICATIONS          = "/deployer/notifications/"
+	PATH_NOTIFICATIONDELIVERIES = "/deployer/notificationdeliveries/"
 )
 
+// prefix of encrypted values
+const ENCRYPTED_PREFIX = "encrypted:"
+
+// prefix of unencrypted values which start with one of the prefixes, so they aren't taken for encrypted values
+const ESCAPED_PREFIX = "unencrypted:"
+
+// how long notification deliveries are kept
+const NOTIFICATIONDELIVERY_TTL = 7 * 24 * time.Hour
+
 var (
-	ErrDescriptorNotFound = errors.New("descriptor not found!")
-	ErrDeploymentNotFound = errors.New("deployment not found!")
+	ErrDescriptorNotFound     = errors.New("descriptor not found!")
+	ErrRevisionNotFound       = errors.New("descriptor revision not found!")
+	ErrConcurrentUpdate       = errors.New("modified concurrently!")
+	ErrDefaultsNotFound       = errors.New("defaults not found!")
+	ErrVariableNotFound       = errors.New("variable not found!")
+	ErrEnvironmentVarNotFound = errors.New("environment var not found!")
+	ErrNoEncryptionKey        = errors.New("no encryption key configured!")
+	ErrDeploymentNotFound     = errors.New("deployment not found!")
+	ErrNotificationNotFound   = errors.New("notification not found!")
 )
 
 type EtcdRegistry struct {
-	etcdApi etcd.KeysAPI
+	etcdApi         etcd.KeysAPI
+	events          *events.Bus
+	keyring         *encryption.Keyring
+	sensitiveFields types.SensitiveFields
 }
 
 func NewEtcdRegistry(etcdApi etcd.KeysAPI) *EtcdRegistry {
-	return &EtcdRegistry{etcdApi}
+	return &EtcdRegistry{etcdApi: etcdApi}
+}
+
+// SetEventBus enables publishing of deployment status transitions and descriptor changes
+func (registry *EtcdRegistry) SetEventBus(bus *events.Bus) {
+	registry.events = bus
+}
+
+// SetKeyring sets the keys for encrypting secret and sensitive values, without keyring they can't be encrypted or decrypted
+func (registry *EtcdRegistry) SetKeyring(keyring *encryption.Keyring) {
+	registry.keyring = keyring
+}
+
+// SetSensitiveFields sets the descriptor fields which are stored encrypted
+func (registry *EtcdRegistry) SetSensitiveFields(fields types.SensitiveFields) {
+	registry.sensitiveFields = fields
+}
+
+func (registry *EtcdRegistry) SensitiveFields() types.SensitiveFields {
+	return registry.sensitiveFields
 }
 
 func (registry *EtcdRegistry) CreateDeployment(deployment *types.Deployment) error {
@@ -70,7 +118,28 @@ func (registry *EtcdRegistry) storeDeployment(deployment *types.Deployment, isNe
 		}
 		deployment.LastModified = ts
 	}
-	return registry.storeJson(PATH_DEPLOYMENTS, deployment.Descriptor.Namespace, deployment.Descriptor.AppName, deployment.Id, deployment, isNew)
+
+	prevValue, _, err := registry.storeJson(PATH_DEPLOYMENTS, deployment.Descriptor.Namespace, deployment.Descriptor.AppName, deployment.Id, deployment, isNew, 0)
+	if err != nil {
+		return err
+	}
+
+	previousStatus := ""
+	if prevValue != "" {
+		previous := &types.Deployment{}
+		if err := json.Unmarshal([]byte(prevValue), previous); err == nil {
+			previousStatus = previous.Status
+		}
+	}
+	if previousStatus != deployment.Status {
+		event := events.NewDeploymentEvent(events.EVENT_DEPLOYMENT_STATUSCHANGED, deployment, "Status changed from '%v' to '%v'", previousStatus, deployment.Status)
+		summary := event.Data.(events.DeploymentSummary)
+		summary.PreviousStatus = previousStatus
+		event.Data = summary
+		registry.events.Publish(event)
+	}
+
+	return nil
 }
 
 func (registry *EtcdRegistry) GetDeployments(namespace string) ([]*types.Deployment, error) {
@@ -84,8 +153,29 @@ func (registry *EtcdRegistry) GetDeployments(namespace string) ([]*types.Deploym
 		return nil, err
 	}
 
-	return parseDeployments(resp.Node.Nodes)
+	return registry.parseDeployments(resp.Node.Nodes)
+
+}
+
+func (registry *EtcdRegistry) GetAllDeployments() ([]*types.Deployment, error) {
+	resp, err := registry.etcdApi.Get(context.Background(), PATH_DEPLOYMENTS, &client.GetOptions{Recursive: true})
+	if err != nil {
+		if strings.Contains(err.Error(), "Key not found") {
+			return []*types.Deployment{}, nil
+		}
+		return nil, err
+	}
+
+	deployments := []*types.Deployment{}
+	for _, namespaceNode := range resp.Node.Nodes {
+		namespaceDeployments, err := registry.parseDeployments(namespaceNode.Nodes)
+		if err != nil {
+			return nil, err
+		}
+		deployments = append(deployments, namespaceDeployments...)
+	}
 
+	return deployments, nil
 }
 
 func (registry *EtcdRegistry) GetDeploymentById(namespace string, id string) (*types.Deployment, error) {
@@ -139,18 +229,27 @@ func (registry *EtcdRegistry) DeleteDeployment(namespace string, id string) erro
 }
 
 func (registry *EtcdRegistry) CreateDescriptor(descriptor *types.Descriptor) error {
-	return registry.storeDescriptor(descriptor, true, false)
+	_, err := registry.storeDescriptor(descriptor, true, false, 0)
+	return err
 }
 
 func (registry *EtcdRegistry) CreateDescriptorWithoutTimestamps(descriptor *types.Descriptor) error {
-	return registry.storeDescriptor(descriptor, true, true)
+	_, err := registry.storeDescriptor(descriptor, true, true, 0)
+	return err
 }
 
 func (registry *EtcdRegistry) UpdateDescriptor(descriptor *types.Descriptor) error {
-	return registry.storeDescriptor(descriptor, false, false)
+	_, err := registry.storeDescriptor(descriptor, false, false, 0)
+	return err
 }
 
-func (registry *EtcdRegistry) storeDescriptor(descriptor *types.Descriptor, isNew bool, skipSettingTimestamps bool) error {
+// UpdateDescriptorIfUnmodified only updates the descriptor if it was not modified since it had the given index,
+// it returns ErrConcurrentUpdate otherwise, and the new index on success.
+func (registry *EtcdRegistry) UpdateDescriptorIfUnmodified(descriptor *types.Descriptor, index uint64) (uint64, error) {
+	return registry.storeDescriptor(descriptor, false, false, index)
+}
+
+func (registry *EtcdRegistry) storeDescriptor(descriptor *types.Descriptor, isNew bool, skipSettingTimestamps bool, prevIndex uint64) (uint64, error) {
 	if !skipSettingTimestamps {
 		ts := time.Now().Format(time.RFC3339)
 		if isNew {
@@ -158,9 +257,124 @@ func (registry *EtcdRegistry) storeDescriptor(descriptor *types.Descriptor, isNe
 		}
 		descriptor.LastModified = ts
 	}
-	return registry.storeJson(PATH_DESCRIPTORS, descriptor.Namespace, descriptor.AppName, descriptor.Id, descriptor, isNew)
+
+	if isNew {
+		descriptor.Revision = 1
+	} else {
+		previous, currentIndex, err := registry.GetDescriptorWithIndex(descriptor.Namespace, descriptor.Id)
+		if err != nil {
+			return 0, err
+		}
+		if prevIndex != 0 && prevIndex != currentIndex {
+			return 0, ErrConcurrentUpdate
+		}
+		// the revision is based on the previous descriptor, so it must not change in the meantime
+		prevIndex = currentIndex
+
+		if previous.Revision == 0 {
+			// stored before revisions existed, keep it as first revision, which is already stored if a previous update failed
+			previous.Revision = 1
+			if _, err := registry.storeRevision(previous); err != nil && err != ErrConcurrentUpdate {
+				return 0, err
+			}
+		}
+		descriptor.Revision = previous.Revision + 1
+	}
+
+	// the revision is stored first, so there is no descriptor without its revision
+	revisionIndex, err := registry.storeRevision(descriptor)
+	if err != nil {
+		return 0, err
+	}
+
+	_, index, err := registry.storeJson(PATH_DESCRIPTORS, descriptor.Namespace, descriptor.AppName, descriptor.Id, descriptor, isNew, prevIndex)
+	if err != nil {
+		registry.deleteRevision(descriptor, revisionIndex)
+		return 0, err
+	}
+
+	if isNew {
+		registry.events.Publish(events.NewDescriptorEvent(events.EVENT_DESCRIPTOR_CREATED, descriptor))
+	} else {
+		registry.events.Publish(events.NewDescriptorEvent(events.EVENT_DESCRIPTOR_UPDATED, descriptor))
+	}
+	return index, nil
+}
+
+// storeRevision stores an immutable copy of the descriptor, under its id and revision, and returns its modification index.
+// An existing revision means that the descriptor is updated concurrently.
+func (registry *EtcdRegistry) storeRevision(descriptor *types.Descriptor) (uint64, error) {
+	keyName := fmt.Sprintf("%v%v/%v/%v", PATH_DESCRIPTORREVISIONS, descriptor.Namespace, descriptor.Id, descriptor.Revision)
+
+	bytes, err := registry.marshal(descriptor)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := registry.etcdApi.Set(context.Background(), keyName, string(bytes), &etcd.SetOptions{PrevExist: etcd.PrevNoExist})
+	if err != nil {
+		if etcdErr, ok := err.(client.Error); ok && etcdErr.Code == client.ErrorCodeNodeExist {
+			return 0, ErrConcurrentUpdate
+		}
+		return 0, err
+	}
+	return resp.Node.ModifiedIndex, nil
+}
+
+// deleteRevision deletes the revision stored for a descriptor update which failed, unless it was replaced in the meantime
+func (registry *EtcdRegistry) deleteRevision(descriptor *types.Descriptor, index uint64) {
+	keyName := fmt.Sprintf("%v%v/%v/%v", PATH_DESCRIPTORREVISIONS, descriptor.Namespace, descriptor.Id, descriptor.Revision)
+	registry.etcdApi.Delete(context.Background(), keyName, &etcd.DeleteOptions{PrevIndex: index})
+}
+
+// GetDescriptorRevisions returns all stored revisions of a descriptor, oldest first
+func (registry *EtcdRegistry) GetDescriptorRevisions(namespace string, id string) ([]*types.Descriptor, error) {
+	keyName := fmt.Sprintf("%v%v/%v", PATH_DESCRIPTORREVISIONS, namespace, id)
+
+	resp, err := registry.etcdApi.Get(context.Background(), keyName, nil)
+	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return []*types.Descriptor{}, nil
+		}
+		return nil, err
+	}
+
+	revisions := []*types.Descriptor{}
+	for _, revisionNode := range resp.Node.Nodes {
+		descriptor, err := registry.unmarshalDescriptor(revisionNode.Value)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, descriptor)
+	}
+	sort.Sort(byRevision(revisions))
+	return revisions, nil
+}
+
+func (registry *EtcdRegistry) GetDescriptorRevision(namespace string, id string, revision int) (*types.Descriptor, error) {
+	keyName := fmt.Sprintf("%v%v/%v/%v", PATH_DESCRIPTORREVISIONS, namespace, id, revision)
+
+	resp, err := registry.etcdApi.Get(context.Background(), keyName, nil)
+	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return &types.Descriptor{}, ErrRevisionNotFound
+		}
+		return &types.Descriptor{}, err
+	}
+
+	descriptor, err := registry.unmarshalDescriptor(resp.Node.Value)
+	if err != nil {
+		return &types.Descriptor{}, err
+	}
+	return descriptor, nil
 }
 
+type byRevision []*types.Descriptor
+
+func (a byRevision) Len() int           { return len(a) }
+func (a byRevision) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byRevision) Less(i, j int) bool { return a[i].Revision < a[j].Revision }
+
 func (registry *EtcdRegistry) GetDescriptors(namespace string) ([]*types.Descriptor, error) {
 	keyName := PATH_DESCRIPTORS + namespace
 
@@ -172,7 +386,7 @@ func (registry *EtcdRegistry) GetDescriptors(namespace string) ([]*types.Descrip
 		return nil, err
 	}
 
-	return parseDescriptors(resp.Node.Nodes)
+	return registry.parseDescriptors(resp.Node.Nodes)
 }
 
 func (registry *EtcdRegistry) GetDescriptorById(namespace string, id string) (*types.Descriptor, error) {
@@ -189,6 +403,31 @@ func (registry *EtcdRegistry) GetDescriptorById(namespace string, id string) (*t
 	return &types.Descriptor{}, ErrDescriptorNotFound
 }
 
+// GetDescriptorWithIndex returns the descriptor together with its etcd modification index, which changes on every update
+func (registry *EtcdRegistry) GetDescriptorWithIndex(namespace string, id string) (*types.Descriptor, uint64, error) {
+	descriptor, err := registry.GetDescriptorById(namespace, id)
+	if err != nil {
+		return descriptor, 0, err
+	}
+
+	keyName := fmt.Sprintf("%v%v/%v/%v", PATH_DESCRIPTORS, namespace, descriptor.AppName, id)
+	resp, err := registry.etcdApi.Get(context.Background(), keyName, nil)
+	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return &types.Descriptor{}, 0, ErrDescriptorNotFound
+		}
+		return &types.Descriptor{}, 0, err
+	}
+
+	// parse again, the descriptor might have changed after listing
+	descriptor, err = registry.unmarshalDescriptor(resp.Node.Value)
+	if err != nil {
+		return &types.Descriptor{}, 0, err
+	}
+
+	return descriptor, resp.Node.ModifiedIndex, nil
+}
+
 func (registry *EtcdRegistry) GetDescriptorsByAppName(namespace string, appName string) ([]*types.Descriptor, error) {
 	allDescriptors, err := registry.GetDescriptors(namespace)
 	if err != nil {
@@ -209,6 +448,32 @@ func (registry *EtcdRegistry) GetDescriptorsByAppName(namespace string, appName
 	return descriptors, nil
 }
 
+func (registry *EtcdRegistry) GetDescriptorByWebhookKey(key string) (*types.Descriptor, error) {
+	namespaces, err := registry.GetNamespaces()
+	if err != nil {
+		if strings.Contains(err.Error(), "Key not found") {
+			return &types.Descriptor{}, ErrDescriptorNotFound
+		}
+		return &types.Descriptor{}, err
+	}
+	for _, namespace := range namespaces {
+		descriptors, err := registry.GetDescriptors(namespace)
+		if err == ErrDescriptorNotFound {
+			continue
+		} else if err != nil {
+			return &types.Descriptor{}, err
+		}
+		for _, descriptor := range descriptors {
+			for _, webhook := range descriptor.WebHooks {
+				if webhook.Key == key {
+					return descriptor, nil
+				}
+			}
+		}
+	}
+	return &types.Descriptor{}, ErrDescriptorNotFound
+}
+
 func (registry *EtcdRegistry) DeleteDescriptor(namespace string, id string) error {
 	descriptor, err := registry.GetDescriptorById(namespace, id)
 	if err != nil {
@@ -216,7 +481,17 @@ func (registry *EtcdRegistry) DeleteDescriptor(namespace string, id string) erro
 	}
 	keyName := fmt.Sprintf("%v%v/%v/%v", PATH_DESCRIPTORS, namespace, descriptor.AppName, id)
 	_, err = registry.etcdApi.Delete(context.Background(), keyName, &client.DeleteOptions{Recursive: true})
-	return err
+	if err != nil {
+		return err
+	}
+	registry.events.Publish(events.NewDescriptorEvent(events.EVENT_DESCRIPTOR_DELETED, descriptor))
+
+	keyName = fmt.Sprintf("%v%v/%v", PATH_DESCRIPTORREVISIONS, namespace, id)
+	_, err = registry.etcdApi.Delete(context.Background(), keyName, &client.DeleteOptions{Recursive: true})
+	if err != nil && !client.IsKeyNotFound(err) {
+		return err
+	}
+	return nil
 }
 
 func (registry *EtcdRegistry) GetNamespaces() ([]string, error) {
@@ -234,12 +509,14 @@ func (registry *EtcdRegistry) GetNamespaces() ([]string, error) {
 	return namespaces, nil
 }
 
-func (registry *EtcdRegistry) storeJson(basePath string, namespace string, appname string, id string, object interface{}, isNew bool) error {
+// storeJson stores the object and returns the previous value and the new modification index.
+// With a prevIndex other than 0 the object is only stored if its current modification index matches.
+func (registry *EtcdRegistry) storeJson(basePath string, namespace string, appname string, id string, object interface{}, isNew bool, prevIndex uint64) (string, uint64, error) {
 	keyName := fmt.Sprintf("%v%v/%v/%v", basePath, namespace, appname, id)
 
-	bytes, err := json.MarshalIndent(object, "", "  ")
+	bytes, err := registry.marshal(object)
 	if err != nil {
-		return err
+		return "", 0, err
 	}
 
 	// check if correctly creating or updating
@@ -249,29 +526,34 @@ func (registry *EtcdRegistry) storeJson(basePath string, namespace string, appna
 	} else {
 		prevExists = etcd.PrevExist
 	}
-	options := etcd.SetOptions{PrevExist: prevExists}
+	options := etcd.SetOptions{PrevExist: prevExists, PrevIndex: prevIndex}
 
-	if _, err := registry.etcdApi.Set(context.Background(), keyName, string(bytes), &options); err != nil {
-		return err
+	resp, err := registry.etcdApi.Set(context.Background(), keyName, string(bytes), &options)
+	if err != nil {
+		if etcdErr, ok := err.(client.Error); ok && etcdErr.Code == client.ErrorCodeTestFailed {
+			return "", 0, ErrConcurrentUpdate
+		}
+		return "", 0, err
 	}
 
-	return nil
+	if resp.PrevNode != nil {
+		return resp.PrevNode.Value, resp.Node.ModifiedIndex, nil
+	}
+	return "", resp.Node.ModifiedIndex, nil
 }
 
-func parseDeployments(nodes client.Nodes) ([]*types.Deployment, error) {
+func (registry *EtcdRegistry) parseDeployments(nodes client.Nodes) ([]*types.Deployment, error) {
 	var deployments []*types.Deployment
 
 	for _, appNode := range nodes {
 		for _, deploymentNode := range appNode.Nodes {
-			deployment := &types.Deployment{}
-
-			bytes := []byte(deploymentNode.Value)
-			if err := json.Unmarshal(bytes, deployment); err != nil {
-				return []*types.Deployment{}, err
+			deployment, err := registry.unmarshalDeployment(deploymentNode.Value)
+			if err != nil {
+				// e.g. encrypted with a key which isn't configured, this shouldn't hide the other deployments
+				log.Printf("Skipping deployment %v: %v", deploymentNode.Key, err)
+				continue
 			}
 
-			cleanDescriptor(deployment.Descriptor)
-
 			deployments = append(deployments, deployment)
 		}
 	}
@@ -279,21 +561,19 @@ func parseDeployments(nodes client.Nodes) ([]*types.Deployment, error) {
 	return deployments, nil
 }
 
-func parseDescriptors(nodes client.Nodes) ([]*types.Descriptor, error) {
+func (registry *EtcdRegistry) parseDescriptors(nodes client.Nodes) ([]*types.Descriptor, error) {
 
 	var descriptors []*types.Descriptor
 
 	for _, appNode := range nodes {
 		for _, descriptorNode := range appNode.Nodes {
-			descriptor := &types.Descriptor{}
-
-			bytes := []byte(descriptorNode.Value)
-			if err := json.Unmarshal(bytes, descriptor); err != nil {
-				return nil, err
+			descriptor, err := registry.unmarshalDescriptor(descriptorNode.Value)
+			if err != nil {
+				// e.g. encrypted with a key which isn't configured, this shouldn't hide the other descriptors
+				log.Printf("Skipping descriptor %v: %v", descriptorNode.Key, err)
+				continue
 			}
 
-			cleanDescriptor(descriptor)
-
 			descriptors = append(descriptors, descriptor)
 		}
 	}
@@ -301,6 +581,228 @@ func parseDescriptors(nodes client.Nodes) ([]*types.Descriptor, error) {
 	return descriptors, nil
 }
 
+func (registry *EtcdRegistry) unmarshalDeployment(value string) (*types.Deployment, error) {
+	deployment := &types.Deployment{}
+	if err := json.Unmarshal([]byte(value), deployment); err != nil {
+		return nil, err
+	}
+	// decrypt all encrypted values, also of fields which aren't sensitive anymore
+	if err := types.AllSensitiveFields.Transform(deployment.Descriptor, registry.decryptValue); err != nil {
+		return nil, err
+	}
+	cleanDescriptor(deployment.Descriptor)
+	return deployment, nil
+}
+
+func (registry *EtcdRegistry) unmarshalDescriptor(value string) (*types.Descriptor, error) {
+	descriptor := &types.Descriptor{}
+	if err := json.Unmarshal([]byte(value), descriptor); err != nil {
+		return nil, err
+	}
+	if err := types.AllSensitiveFields.Transform(descriptor, registry.decryptValue); err != nil {
+		return nil, err
+	}
+	cleanDescriptor(descriptor)
+	return descriptor, nil
+}
+
+// marshal returns the JSON of the object, with the sensitive fields of descriptors and deployments encrypted,
+// and the other values which are decrypted when reading escaped
+func (registry *EtcdRegistry) marshal(object interface{}) ([]byte, error) {
+	bytes, err := json.MarshalIndent(object, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	// transform a copy, the caller keeps using the object
+	var encrypted interface{}
+	var descriptor *types.Descriptor
+	switch object.(type) {
+	case *types.Descriptor:
+		descriptor = &types.Descriptor{}
+		if err := json.Unmarshal(bytes, descriptor); err != nil {
+			return nil, err
+		}
+		encrypted = descriptor
+	case *types.Deployment:
+		deployment := &types.Deployment{}
+		if err := json.Unmarshal(bytes, deployment); err != nil {
+			return nil, err
+		}
+		encrypted, descriptor = deployment, deployment.Descriptor
+	default:
+		return bytes, nil
+	}
+
+	types.AllSensitiveFields.Transform(descriptor, func(value string) (string, error) {
+		return escapeValue(value), nil
+	})
+	if registry.keyring != nil && len(registry.sensitiveFields) > 0 {
+		encrypt := func(value string) (string, error) {
+			return registry.encryptValue(unescapeValue(value))
+		}
+		if err := registry.sensitiveFields.Transform(descriptor, encrypt); err != nil {
+			return nil, err
+		}
+	}
+	return json.MarshalIndent(encrypted, "", "  ")
+}
+
+func (registry *EtcdRegistry) encryptValue(value string) (string, error) {
+	if registry.keyring == nil {
+		return "", ErrNoEncryptionKey
+	}
+	envelope, err := registry.keyring.Encrypt(value)
+	if err != nil {
+		return "", err
+	}
+	return ENCRYPTED_PREFIX + envelope, nil
+}
+
+// decryptValue returns the value of an encrypted or escaped stored value
+func (registry *EtcdRegistry) decryptValue(value string) (string, error) {
+	if !strings.HasPrefix(value, ENCRYPTED_PREFIX) {
+		return unescapeValue(value), nil
+	}
+	if registry.keyring == nil {
+		return "", ErrNoEncryptionKey
+	}
+	return registry.keyring.Decrypt(strings.TrimPrefix(value, ENCRYPTED_PREFIX))
+}
+
+// escapeValue prefixes unencrypted values which look like encrypted or escaped values, see unescapeValue
+func escapeValue(value string) string {
+	if strings.HasPrefix(value, ENCRYPTED_PREFIX) || strings.HasPrefix(value, ESCAPED_PREFIX) {
+		return ESCAPED_PREFIX + value
+	}
+	return value
+}
+
+func unescapeValue(value string) string {
+	return strings.TrimPrefix(value, ESCAPED_PREFIX)
+}
+
+// RotationReport lists the result of re-encrypting the registry with the primary key
+type RotationReport struct {
+	PrimaryKeyId string `json:"primaryKeyId"`
+	Rotated      int    `json:"rotated"`
+	Conflicts    int    `json:"conflicts"`
+}
+
+// RotateKeys re-encrypts all values which are encrypted with another key than the primary key,
+// and encrypts sensitive fields which are stored unencrypted. Entries which are updated concurrently
+// are counted as conflicts, running the rotation again picks them up.
+func (registry *EtcdRegistry) RotateKeys() (RotationReport, error) {
+	if registry.keyring == nil {
+		return RotationReport{}, ErrNoEncryptionKey
+	}
+	report := RotationReport{PrimaryKeyId: registry.keyring.PrimaryKeyId()}
+
+	for _, path := range []string{PATH_DESCRIPTORS, PATH_DESCRIPTORREVISIONS, PATH_DEPLOYMENTS, PATH_ENVIRONMENT} {
+		resp, err := registry.etcdApi.Get(context.Background(), path, &client.GetOptions{Recursive: true})
+		if err != nil {
+			if client.IsKeyNotFound(err) {
+				continue
+			}
+			return report, err
+		}
+		if err := registry.rotateNodes(path, resp.Node.Nodes, &report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+func (registry *EtcdRegistry) rotateNodes(path string, nodes client.Nodes, report *RotationReport) error {
+	for _, node := range nodes {
+		if node.Dir {
+			if err := registry.rotateNodes(path, node.Nodes, report); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, changed, err := registry.rotateValue(path, node.Value)
+		if err != nil {
+			return fmt.Errorf("Error rotating %v: %v", node.Key, err)
+		}
+		if !changed {
+			continue
+		}
+
+		options := client.SetOptions{PrevIndex: node.ModifiedIndex}
+		if _, err := registry.etcdApi.Set(context.Background(), node.Key, value, &options); err != nil {
+			if etcdErr, ok := err.(client.Error); ok && etcdErr.Code == client.ErrorCodeTestFailed {
+				report.Conflicts++
+				continue
+			}
+			return err
+		}
+		report.Rotated++
+	}
+	return nil
+}
+
+// rotateValue returns the value encrypted with the primary key, and if it needed to change
+func (registry *EtcdRegistry) rotateValue(path string, value string) (string, bool, error) {
+	if path == PATH_ENVIRONMENT {
+		if !strings.HasPrefix(value, ENCRYPTED_PREFIX) || !registry.keyring.NeedsRotation(strings.TrimPrefix(value, ENCRYPTED_PREFIX)) {
+			return value, false, nil
+		}
+		plain, err := registry.decryptValue(value)
+		if err != nil {
+			return "", false, err
+		}
+		encrypted, err := registry.encryptValue(plain)
+		return encrypted, err == nil, err
+	}
+
+	var object interface{}
+	var descriptor *types.Descriptor
+	if path == PATH_DEPLOYMENTS {
+		deployment := &types.Deployment{}
+		if err := json.Unmarshal([]byte(value), deployment); err != nil {
+			return "", false, err
+		}
+		object, descriptor = deployment, deployment.Descriptor
+	} else {
+		descriptor = &types.Descriptor{}
+		if err := json.Unmarshal([]byte(value), descriptor); err != nil {
+			return "", false, err
+		}
+		object = descriptor
+	}
+
+	if !registry.needsRotation(descriptor) {
+		return value, false, nil
+	}
+	if err := types.AllSensitiveFields.Transform(descriptor, registry.decryptValue); err != nil {
+		return "", false, err
+	}
+	bytes, err := registry.marshal(object)
+	if err != nil {
+		return "", false, err
+	}
+	return string(bytes), true, nil
+}
+
+func (registry *EtcdRegistry) needsRotation(descriptor *types.Descriptor) bool {
+	needsRotation := false
+	types.AllSensitiveFields.Transform(descriptor, func(value string) (string, error) {
+		if strings.HasPrefix(value, ENCRYPTED_PREFIX) && registry.keyring.NeedsRotation(strings.TrimPrefix(value, ENCRYPTED_PREFIX)) {
+			needsRotation = true
+		}
+		return value, nil
+	})
+	registry.sensitiveFields.Transform(descriptor, func(value string) (string, error) {
+		if !strings.HasPrefix(value, ENCRYPTED_PREFIX) {
+			needsRotation = true
+		}
+		return value, nil
+	})
+	return needsRotation
+}
+
 func cleanDescriptor(descriptor *types.Descriptor) {
 	// remove env vars which were added by deployer
 	keysToRemove := make([]string, 0)
@@ -314,6 +816,10 @@ func cleanDescriptor(descriptor *types.Descriptor) {
 	for i, container := range descriptor.PodSpec.Containers {
 		newEnv := make([]v1.EnvVar, 0)
 		for _, env := range container.Env {
+			// secret environment vars are injected from the environment secret of the app
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil && env.ValueFrom.SecretKeyRef.Name == descriptor.EnvironmentSecretName() {
+				continue
+			}
 			keep := true
 			for _, key := range keysToRemove {
 				if env.Name == key {
@@ -331,28 +837,236 @@ func cleanDescriptor(descriptor *types.Descriptor) {
 	descriptor.Environment = nil
 }
 
-func (registry *EtcdRegistry) GetEnvironmentVars() (map[string]string, error) {
-	result, err := registry.etcdApi.Get(context.Background(), PATH_ENVIRONMENT, &client.GetOptions{Recursive: true})
+// defaults are stored as partial descriptors, the global ones without namespace
+func defaultsKey(namespace string) string {
+	if namespace == "" {
+		return PATH_DEFAULTS + "global"
+	}
+	return PATH_DEFAULTS + "namespaces/" + namespace
+}
+
+func (registry *EtcdRegistry) GetDefaults(namespace string) (map[string]interface{}, error) {
+	resp, err := registry.etcdApi.Get(context.Background(), defaultsKey(namespace), nil)
+	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return nil, ErrDefaultsNotFound
+		}
+		return nil, err
+	}
+
+	defaults := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(resp.Node.Value), &defaults); err != nil {
+		return nil, err
+	}
+	return defaults, nil
+}
+
+func (registry *EtcdRegistry) StoreDefaults(namespace string, defaults map[string]interface{}) error {
+	bytes, err := json.MarshalIndent(defaults, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = registry.etcdApi.Set(context.Background(), defaultsKey(namespace), string(bytes), nil)
+	return err
+}
+
+func (registry *EtcdRegistry) DeleteDefaults(namespace string) error {
+	_, err := registry.etcdApi.Delete(context.Background(), defaultsKey(namespace), nil)
+	if client.IsKeyNotFound(err) {
+		return ErrDefaultsNotFound
+	}
+	return err
+}
+
+// GetVariables returns the variables of the namespace for resolving descriptor placeholders, by name
+func (registry *EtcdRegistry) GetVariables(namespace string) (map[string]string, error) {
+	variables := map[string]string{}
+
+	resp, err := registry.etcdApi.Get(context.Background(), PATH_VARIABLES+namespace, nil)
+	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return variables, nil
+		}
+		return nil, err
+	}
+
+	for _, node := range resp.Node.Nodes {
+		variables[node.Key[strings.LastIndex(node.Key, "/")+1:]] = node.Value
+	}
+	return variables, nil
+}
+
+func (registry *EtcdRegistry) SetVariable(namespace string, name string, value string) error {
+	_, err := registry.etcdApi.Set(context.Background(), PATH_VARIABLES+namespace+"/"+name, value, nil)
+	return err
+}
+
+func (registry *EtcdRegistry) DeleteVariable(namespace string, name string) error {
+	_, err := registry.etcdApi.Delete(context.Background(), PATH_VARIABLES+namespace+"/"+name, nil)
+	if client.IsKeyNotFound(err) {
+		return ErrVariableNotFound
+	}
+	return err
+}
+
+// environment vars are stored in layered scopes: global vars directly in PATH_ENVIRONMENT, namespace vars in
+// PATH_ENVIRONMENT/namespaces/{namespace}/ and app vars in PATH_ENVIRONMENT/apps/{namespace}/{appname}/
+func environmentPath(namespace string, appName string) string {
+	if namespace == "" {
+		return PATH_ENVIRONMENT
+	}
+	if appName == "" {
+		return PATH_ENVIRONMENT + "namespaces/" + namespace + "/"
+	}
+	return PATH_ENVIRONMENT + "apps/" + namespace + "/" + appName + "/"
+}
+
+// GetEnvironmentVars returns the environment vars which are injected into the containers of the app:
+// the global vars, overridden by the vars of the namespace, overridden by the vars of the app
+func (registry *EtcdRegistry) GetEnvironmentVars(namespace string, appName string) (map[string]types.EnvironmentVar, error) {
+	vars := map[string]types.EnvironmentVar{}
+	scopes := [][]string{{"", ""}, {namespace, ""}, {namespace, appName}}
+	for _, scope := range scopes {
+		scopeVars, err := registry.GetScopedEnvironmentVars(scope[0], scope[1])
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range scopeVars {
+			vars[key] = value
+		}
+	}
+	return vars, nil
+}
+
+// GetScopedEnvironmentVars returns the environment vars of a single scope: global without namespace,
+// of the namespace without appName, or of the app. Secret vars are decrypted.
+func (registry *EtcdRegistry) GetScopedEnvironmentVars(namespace string, appName string) (map[string]types.EnvironmentVar, error) {
+	vars := map[string]types.EnvironmentVar{}
+
+	result, err := registry.etcdApi.Get(context.Background(), environmentPath(namespace, appName), nil)
 	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return vars, nil
+		}
 		return nil, err
 	}
 
-	vars := map[string]string{}
 	for _, entry := range result.Node.Nodes {
+		// skip the directories of the more specific scopes
+		if entry.Dir {
+			continue
+		}
 		idx := strings.LastIndex(entry.Key, "/") + 1
-		key := fixEnvVarName(entry.Key[idx:len(entry.Key)])
+		key := EnvVarName(entry.Key[idx:len(entry.Key)])
 
-		vars[key] = entry.Value
+		envVar := types.EnvironmentVar{Secret: strings.HasPrefix(entry.Value, ENCRYPTED_PREFIX)}
+		envVar.Value, err = registry.decryptValue(entry.Value)
+		if err != nil {
+			return nil, fmt.Errorf("Error decrypting environment var %v: %v", key, err)
+		}
+		vars[key] = envVar
 	}
 
 	return vars, nil
 }
 
-func fixEnvVarName(name string) string {
+// SetEnvironmentVar stores an environment var in the scope, secret vars are stored encrypted
+func (registry *EtcdRegistry) SetEnvironmentVar(namespace string, appName string, name string, envVar types.EnvironmentVar) error {
+	value := escapeValue(envVar.Value)
+	if envVar.Secret {
+		encrypted, err := registry.encryptValue(envVar.Value)
+		if err != nil {
+			return err
+		}
+		value = encrypted
+	}
+
+	// remove keys which are injected with the same name, e.g. a lowercase one
+	if err := registry.DeleteEnvironmentVar(namespace, appName, name); err != nil && err != ErrEnvironmentVarNotFound {
+		return err
+	}
+	_, err := registry.etcdApi.Set(context.Background(), environmentPath(namespace, appName)+EnvVarName(name), value, nil)
+	return err
+}
+
+// DeleteEnvironmentVar deletes all keys of the scope which are injected with the given name
+func (registry *EtcdRegistry) DeleteEnvironmentVar(namespace string, appName string, name string) error {
+	result, err := registry.etcdApi.Get(context.Background(), environmentPath(namespace, appName), nil)
+	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return ErrEnvironmentVarNotFound
+		}
+		return err
+	}
+
+	found := false
+	for _, entry := range result.Node.Nodes {
+		if entry.Dir || EnvVarName(entry.Key[strings.LastIndex(entry.Key, "/")+1:]) != EnvVarName(name) {
+			continue
+		}
+		if _, err := registry.etcdApi.Delete(context.Background(), entry.Key, nil); err != nil && !client.IsKeyNotFound(err) {
+			return err
+		}
+		found = true
+	}
+	if !found {
+		return ErrEnvironmentVarNotFound
+	}
+	return nil
+}
+
+// EnvVarName returns the name under which a key is injected as environment var, uppercase with underscores
+func EnvVarName(name string) string {
 	keyName := strings.ToUpper(name)
 	return strings.Replace(keyName, "-", "_", -1)
 }
 
+// DeleteDeploymentData deletes the logs and healthcheck data of a deployment, but keeps the deployment itself
+func (registry *EtcdRegistry) DeleteDeploymentData(namespace string, id string) error {
+	keyName := fmt.Sprintf("%v%v/%v", PATH_HEALTHDATA, namespace, id)
+	_, err := registry.etcdApi.Delete(context.Background(), keyName, &client.DeleteOptions{Recursive: true})
+	if err != nil && !client.IsKeyNotFound(err) {
+		return err
+	}
+
+	keyName = fmt.Sprintf("%v%v/%v", PATH_LOGS, namespace, id)
+	_, err = registry.etcdApi.Delete(context.Background(), keyName, &client.DeleteOptions{Recursive: true})
+	if err != nil && !client.IsKeyNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// GetDeploymentDataIds returns the ids of all deployments with logs or healthcheck data, per namespace
+func (registry *EtcdRegistry) GetDeploymentDataIds() (map[string][]string, error) {
+	result := map[string][]string{}
+	seen := map[string]bool{}
+	for _, basePath := range []string{PATH_LOGS, PATH_HEALTHDATA} {
+		resp, err := registry.etcdApi.Get(context.Background(), basePath, nil)
+		if err != nil {
+			if client.IsKeyNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, namespaceNode := range resp.Node.Nodes {
+			namespace := namespaceNode.Key[strings.LastIndex(namespaceNode.Key, "/")+1:]
+			ids, err := registry.etcdApi.Get(context.Background(), namespaceNode.Key, nil)
+			if err != nil {
+				return nil, err
+			}
+			for _, idNode := range ids.Node.Nodes {
+				id := idNode.Key[strings.LastIndex(idNode.Key, "/")+1:]
+				if !seen[namespace+"/"+id] {
+					seen[namespace+"/"+id] = true
+					result[namespace] = append(result[namespace], id)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
 func (registry *EtcdRegistry) StoreHealth(namespace string, deploymentId string, podName string, health string) error {
 	keyName := fmt.Sprintf("%v%v/%v/%v", PATH_HEALTHDATA, namespace, deploymentId, podName)
 	_, err := registry.etcdApi.Set(context.Background(), keyName, health, nil)
@@ -412,3 +1126,119 @@ func (registry *EtcdRegistry) NextLogs(namespace string, deploymentId string, in
 	}
 	return response.Node.Value, response.Node.ModifiedIndex, nil
 }
+
+func (registry *EtcdRegistry) CreateNotification(notification *types.Notification) error {
+	return registry.storeNotification(notification, true)
+}
+
+func (registry *EtcdRegistry) UpdateNotification(notification *types.Notification) error {
+	return registry.storeNotification(notification, false)
+}
+
+func (registry *EtcdRegistry) storeNotification(notification *types.Notification, isNew bool) error {
+	ts := time.Now().Format(time.RFC3339)
+	if isNew {
+		notification.Created = ts
+	}
+	notification.LastModified = ts
+
+	bytes, err := json.MarshalIndent(notification, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	var prevExists client.PrevExistType
+	if isNew {
+		prevExists = etcd.PrevNoExist
+	} else {
+		prevExists = etcd.PrevExist
+	}
+
+	_, err = registry.etcdApi.Set(context.Background(), PATH_NOTIFICATIONS+notification.Id, string(bytes), &etcd.SetOptions{PrevExist: prevExists})
+	return err
+}
+
+func (registry *EtcdRegistry) GetNotifications() ([]*types.Notification, error) {
+	resp, err := registry.etcdApi.Get(context.Background(), PATH_NOTIFICATIONS, &client.GetOptions{Recursive: true})
+	if err != nil {
+		if strings.Contains(err.Error(), "Key not found") {
+			return []*types.Notification{}, nil
+		}
+		return nil, err
+	}
+
+	notifications := []*types.Notification{}
+	for _, node := range resp.Node.Nodes {
+		notification := &types.Notification{}
+		if err := json.Unmarshal([]byte(node.Value), notification); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, notification)
+	}
+
+	return notifications, nil
+}
+
+func (registry *EtcdRegistry) GetNotificationById(id string) (*types.Notification, error) {
+	resp, err := registry.etcdApi.Get(context.Background(), PATH_NOTIFICATIONS+id, nil)
+	if err != nil {
+		if strings.Contains(err.Error(), "Key not found") {
+			return &types.Notification{}, ErrNotificationNotFound
+		}
+		return &types.Notification{}, err
+	}
+
+	notification := &types.Notification{}
+	if err := json.Unmarshal([]byte(resp.Node.Value), notification); err != nil {
+		return &types.Notification{}, err
+	}
+	return notification, nil
+}
+
+func (registry *EtcdRegistry) DeleteNotification(id string) error {
+	_, err := registry.etcdApi.Delete(context.Background(), PATH_NOTIFICATIONS+id, nil)
+	if err != nil && strings.Contains(err.Error(), "Key not found") {
+		return ErrNotificationNotFound
+	} else if err != nil {
+		return err
+	}
+
+	_, err = registry.etcdApi.Delete(context.Background(), PATH_NOTIFICATIONDELIVERIES+id, &client.DeleteOptions{Recursive: true})
+	if err != nil && strings.Contains(err.Error(), "Key not found") {
+		return nil
+	}
+	return err
+}
+
+func (registry *EtcdRegistry) StoreNotificationDelivery(delivery *types.NotificationDelivery) error {
+	bytes, err := json.MarshalIndent(delivery, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	keyName := fmt.Sprintf("%v%v/%v", PATH_NOTIFICATIONDELIVERIES, delivery.NotificationId, delivery.Id)
+	_, err = registry.etcdApi.Set(context.Background(), keyName, string(bytes), &etcd.SetOptions{TTL: NOTIFICATIONDELIVERY_TTL})
+	return err
+}
+
+func (registry *EtcdRegistry) GetNotificationDeliveries(notificationId string) ([]*types.NotificationDelivery, error) {
+	keyName := PATH_NOTIFICATIONDELIVERIES + notificationId
+	resp, err := registry.etcdApi.Get(context.Background(), keyName, &client.GetOptions{Recursive: true, Sort: true})
+	if err != nil {
+		if strings.Contains(err.Error(), "Key not found") {
+			return []*types.NotificationDelivery{}, nil
+		}
+		return nil, err
+	}
+
+	deliveries := []*types.NotificationDelivery{}
+	for _, node := range resp.Node.Nodes {
+		delivery := &types.NotificationDelivery{}
+		if err := json.Unmarshal([]byte(node.Value), delivery); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
@@ -1,10 +1,254 @@
 package etcdregistry
 
-import "testing"
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/encryption"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry/etcdtest"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"golang.org/x/net/context"
+	"k8s.io/client-go/pkg/api/v1"
+)
 
 func TestEnvVarRename(t *testing.T) {
-	result := fixEnvVarName("my-example-key")
+	result := EnvVarName("my-example-key")
 	if result != "MY_EXAMPLE_KEY" {
 		t.Error("Invalid rename of environement variable")
 	}
 }
+
+func TestMarshalEncryptsSensitiveFields(t *testing.T) {
+	keyring, err := encryption.ParseKeyring("k1:" + base64.StdEncoding.EncodeToString(make([]byte, encryption.KEY_LENGTH)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := NewEtcdRegistry(nil)
+	registry.SetKeyring(keyring)
+	registry.SetSensitiveFields(types.DefaultSensitiveFields)
+
+	descriptor := &types.Descriptor{AppName: "app", Email: "me@example.com", Password: "secret"}
+	bytes, err := registry.marshal(descriptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(bytes), "me@example.com") || strings.Contains(string(bytes), "secret\"") {
+		t.Errorf("Sensitive fields stored unencrypted: %v", string(bytes))
+	}
+	if descriptor.Password != "secret" {
+		t.Errorf("Marshalling changed the descriptor: %v", descriptor.Password)
+	}
+
+	stored, err := registry.unmarshalDescriptor(string(bytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Email != "me@example.com" || stored.Password != "secret" || stored.AppName != "app" {
+		t.Errorf("Unexpected decrypted descriptor %+v", stored)
+	}
+
+	if _, err := NewEtcdRegistry(nil).unmarshalDescriptor(string(bytes)); err != ErrNoEncryptionKey {
+		t.Errorf("Expected missing key error, got %v", err)
+	}
+}
+
+func TestDescriptorRevisions(t *testing.T) {
+	registry := NewEtcdRegistry(etcdtest.NewKeysAPI())
+
+	descriptor := &types.Descriptor{Id: "d1", Namespace: "test", AppName: "myapp", Replicas: 1}
+	if err := registry.CreateDescriptor(descriptor); err != nil {
+		t.Fatal(err)
+	}
+	for _, replicas := range []int{2, 3} {
+		descriptor.Replicas = replicas
+		if err := registry.UpdateDescriptor(descriptor); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revisions, err := registry.GetDescriptorRevisions("test", "d1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 3 {
+		t.Fatalf("Expected 3 revisions, got %v", len(revisions))
+	}
+	for i, revision := range revisions {
+		if revision.Revision != i+1 || revision.Replicas != i+1 {
+			t.Errorf("Unexpected revision %v with %v replicas at position %v", revision.Revision, revision.Replicas, i)
+		}
+	}
+
+	revision, err := registry.GetDescriptorRevision("test", "d1", 2)
+	if err != nil || revision.Replicas != 2 {
+		t.Errorf("Unexpected revision 2: %+v, %v", revision, err)
+	}
+	if _, err := registry.GetDescriptorRevision("test", "d1", 4); err != ErrRevisionNotFound {
+		t.Errorf("Expected ErrRevisionNotFound, got %v", err)
+	}
+	if revisions, err := registry.GetDescriptorRevisions("test", "unknown"); err != nil || len(revisions) != 0 {
+		t.Errorf("Expected no revisions of unknown descriptor, got %v, %v", revisions, err)
+	}
+}
+
+func TestFailedDescriptorUpdateLeavesNoRevision(t *testing.T) {
+	registry := NewEtcdRegistry(etcdtest.NewKeysAPI())
+
+	descriptor := &types.Descriptor{Id: "d1", Namespace: "test", AppName: "myapp", Replicas: 1}
+	if err := registry.CreateDescriptor(descriptor); err != nil {
+		t.Fatal(err)
+	}
+	_, index, err := registry.GetDescriptorWithIndex("test", "d1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	descriptor.Replicas = 2
+	if _, err := registry.UpdateDescriptorIfUnmodified(descriptor, index+1); err != ErrConcurrentUpdate {
+		t.Fatalf("Expected ErrConcurrentUpdate, got %v", err)
+	}
+	if _, err := registry.GetDescriptorRevision("test", "d1", 2); err != ErrRevisionNotFound {
+		t.Errorf("Expected revision of failed update to be removed, got %v", err)
+	}
+
+	if _, err := registry.UpdateDescriptorIfUnmodified(descriptor, index); err != nil {
+		t.Fatal(err)
+	}
+	if revision, err := registry.GetDescriptorRevision("test", "d1", 2); err != nil || revision.Replicas != 2 {
+		t.Errorf("Unexpected revision 2: %+v, %v", revision, err)
+	}
+}
+
+func TestDescriptorUpdateFailsWithoutRevision(t *testing.T) {
+	keysAPI := etcdtest.NewKeysAPI()
+	registry := NewEtcdRegistry(keysAPI)
+
+	descriptor := &types.Descriptor{Id: "d1", Namespace: "test", AppName: "myapp", Replicas: 1}
+	if err := registry.CreateDescriptor(descriptor); err != nil {
+		t.Fatal(err)
+	}
+	// revision 2 stored by a concurrent update
+	if _, err := keysAPI.Set(context.Background(), PATH_DESCRIPTORREVISIONS+"test/d1/2", "{}", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	descriptor.Replicas = 2
+	if err := registry.UpdateDescriptor(descriptor); err != ErrConcurrentUpdate {
+		t.Fatalf("Expected ErrConcurrentUpdate, got %v", err)
+	}
+	if stored, err := registry.GetDescriptorById("test", "d1"); err != nil || stored.Replicas != 1 || stored.Revision != 1 {
+		t.Errorf("Expected descriptor to be unchanged, got %+v, %v", stored, err)
+	}
+}
+
+func TestUpdateDescriptorWithoutRevisions(t *testing.T) {
+	keysAPI := etcdtest.NewKeysAPI()
+	registry := NewEtcdRegistry(keysAPI)
+
+	// stored before revisions existed
+	legacy := `{"id": "d1", "namespace": "test", "appName": "myapp", "replicas": 1}`
+	if _, err := keysAPI.Set(context.Background(), PATH_DESCRIPTORS+"test/myapp/d1", legacy, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	descriptor, err := registry.GetDescriptorById("test", "d1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	descriptor.Replicas = 2
+	if err := registry.UpdateDescriptor(descriptor); err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, err := registry.GetDescriptorRevisions("test", "d1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Replicas != 1 || revisions[1].Revision != 2 || revisions[1].Replicas != 2 {
+		t.Errorf("Expected the legacy descriptor as first revision, got %+v", revisions)
+	}
+}
+
+func newEncryptingRegistry(t *testing.T, keysAPI *etcdtest.KeysAPI) *EtcdRegistry {
+	keyring, err := encryption.ParseKeyring("k1:" + base64.StdEncoding.EncodeToString(make([]byte, encryption.KEY_LENGTH)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := NewEtcdRegistry(keysAPI)
+	registry.SetKeyring(keyring)
+	registry.SetSensitiveFields(types.DefaultSensitiveFields)
+	return registry
+}
+
+func TestPlainValuesWithPrefix(t *testing.T) {
+	keysAPI := etcdtest.NewKeysAPI()
+	encrypting := newEncryptingRegistry(t, keysAPI)
+	plain := NewEtcdRegistry(keysAPI)
+
+	for _, registry := range []*EtcdRegistry{plain, encrypting} {
+		descriptor := &types.Descriptor{Id: "d1", Namespace: "test", AppName: "myapp", Email: "encrypted:me", Password: "unencrypted:secret",
+			PodSpec: v1.PodSpec{Containers: []v1.Container{{Name: "myapp", Env: []v1.EnvVar{{Name: "DB", Value: "encrypted:db"}}}}}}
+		if err := registry.CreateDescriptor(descriptor); err != nil {
+			t.Fatal(err)
+		}
+
+		stored, err := registry.GetDescriptorById("test", "d1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Email != "encrypted:me" || stored.Password != "unencrypted:secret" || stored.PodSpec.Containers[0].Env[0].Value != "encrypted:db" {
+			t.Errorf("Unexpected stored values %+v", stored)
+		}
+		if err := registry.DeleteDescriptor("test", "d1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := plain.SetEnvironmentVar("", "", "DB", types.EnvironmentVar{Value: "encrypted:db"}); err != nil {
+		t.Fatal(err)
+	}
+	vars, err := plain.GetScopedEnvironmentVars("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars["DB"].Value != "encrypted:db" || vars["DB"].Secret {
+		t.Errorf("Unexpected environment var %+v", vars["DB"])
+	}
+}
+
+func TestUndecryptableRecordsAreSkipped(t *testing.T) {
+	keysAPI := etcdtest.NewKeysAPI()
+	encrypting := newEncryptingRegistry(t, keysAPI)
+	plain := NewEtcdRegistry(keysAPI)
+
+	encrypted := &types.Descriptor{Id: "d1", Namespace: "test", AppName: "app1", Password: "secret"}
+	if err := encrypting.CreateDescriptor(encrypted); err != nil {
+		t.Fatal(err)
+	}
+	if err := encrypting.CreateDeployment(&types.Deployment{Id: "x1", Descriptor: encrypted}); err != nil {
+		t.Fatal(err)
+	}
+	unencrypted := &types.Descriptor{Id: "d2", Namespace: "test", AppName: "app2"}
+	if err := plain.CreateDescriptor(unencrypted); err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.CreateDeployment(&types.Deployment{Id: "x2", Descriptor: unencrypted}); err != nil {
+		t.Fatal(err)
+	}
+
+	descriptors, err := plain.GetDescriptors("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(descriptors) != 1 || descriptors[0].Id != "d2" {
+		t.Errorf("Expected only the unencrypted descriptor, got %v descriptors", len(descriptors))
+	}
+	deployments, err := plain.GetAllDeployments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deployments) != 1 || deployments[0].Id != "x2" {
+		t.Errorf("Expected only the unencrypted deployment, got %v deployments", len(deployments))
+	}
+}
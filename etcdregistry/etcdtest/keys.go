@@ -0,0 +1,192 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package etcdtest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	etcd "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+)
+
+// KeysAPI is an in-memory etcd.KeysAPI for tests. It supports the options used by the registry:
+// recursive gets, and PrevExist and PrevIndex conditions on sets and deletes. TTLs are ignored and watchers never fire.
+type KeysAPI struct {
+	mutex  sync.Mutex
+	values map[string]string
+	index  map[string]uint64
+	last   uint64
+}
+
+func NewKeysAPI() *KeysAPI {
+	return &KeysAPI{values: map[string]string{}, index: map[string]uint64{}}
+}
+
+func (k *KeysAPI) Get(ctx context.Context, key string, opts *etcd.GetOptions) (*etcd.Response, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	key = normalize(key)
+	if _, found := k.values[key]; found {
+		return &etcd.Response{Action: "get", Node: k.node(key)}, nil
+	}
+	if !k.isDir(key) {
+		return nil, k.keyNotFound(key)
+	}
+	return &etcd.Response{Action: "get", Node: k.dir(key, opts != nil && opts.Recursive)}, nil
+}
+
+func (k *KeysAPI) Set(ctx context.Context, key, value string, opts *etcd.SetOptions) (*etcd.Response, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	key = normalize(key)
+	_, exists := k.values[key]
+	if opts != nil {
+		if opts.PrevExist == etcd.PrevNoExist && exists {
+			return nil, etcd.Error{Code: etcd.ErrorCodeNodeExist, Message: "Key already exists", Cause: key, Index: k.last}
+		}
+		if (opts.PrevExist == etcd.PrevExist || opts.PrevIndex != 0) && !exists {
+			return nil, k.keyNotFound(key)
+		}
+		if opts.PrevIndex != 0 && opts.PrevIndex != k.index[key] {
+			return nil, k.testFailed(key, opts.PrevIndex)
+		}
+	}
+
+	var prevNode *etcd.Node
+	if exists {
+		prevNode = k.node(key)
+	}
+	k.last++
+	k.values[key] = value
+	k.index[key] = k.last
+	return &etcd.Response{Action: "set", Node: k.node(key), PrevNode: prevNode, Index: k.last}, nil
+}
+
+func (k *KeysAPI) Delete(ctx context.Context, key string, opts *etcd.DeleteOptions) (*etcd.Response, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	key = normalize(key)
+	if _, exists := k.values[key]; exists {
+		if opts != nil && opts.PrevIndex != 0 && opts.PrevIndex != k.index[key] {
+			return nil, k.testFailed(key, opts.PrevIndex)
+		}
+		prevNode := k.node(key)
+		delete(k.values, key)
+		delete(k.index, key)
+		k.last++
+		return &etcd.Response{Action: "delete", Node: &etcd.Node{Key: key}, PrevNode: prevNode, Index: k.last}, nil
+	}
+	if !k.isDir(key) {
+		return nil, k.keyNotFound(key)
+	}
+	if opts == nil || !opts.Recursive {
+		return nil, etcd.Error{Code: etcd.ErrorCodeNotFile, Message: "Not a file", Cause: key, Index: k.last}
+	}
+	for stored := range k.values {
+		if strings.HasPrefix(stored, key+"/") {
+			delete(k.values, stored)
+			delete(k.index, stored)
+		}
+	}
+	k.last++
+	return &etcd.Response{Action: "delete", Node: &etcd.Node{Key: key, Dir: true}, Index: k.last}, nil
+}
+
+func (k *KeysAPI) Create(ctx context.Context, key, value string) (*etcd.Response, error) {
+	return k.Set(ctx, key, value, &etcd.SetOptions{PrevExist: etcd.PrevNoExist})
+}
+
+func (k *KeysAPI) CreateInOrder(ctx context.Context, dir, value string, opts *etcd.CreateInOrderOptions) (*etcd.Response, error) {
+	k.mutex.Lock()
+	key := fmt.Sprintf("%v/%020d", normalize(dir), k.last+1)
+	k.mutex.Unlock()
+	return k.Create(ctx, key, value)
+}
+
+func (k *KeysAPI) Update(ctx context.Context, key, value string) (*etcd.Response, error) {
+	return k.Set(ctx, key, value, &etcd.SetOptions{PrevExist: etcd.PrevExist})
+}
+
+func (k *KeysAPI) Watcher(key string, opts *etcd.WatcherOptions) etcd.Watcher {
+	return watcher{}
+}
+
+type watcher struct{}
+
+func (w watcher) Next(ctx context.Context) (*etcd.Response, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (k *KeysAPI) node(key string) *etcd.Node {
+	index := k.index[key]
+	return &etcd.Node{Key: key, Value: k.values[key], CreatedIndex: index, ModifiedIndex: index}
+}
+
+func (k *KeysAPI) isDir(key string) bool {
+	for stored := range k.values {
+		if key == "" || strings.HasPrefix(stored, key+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// dir returns the directory node with its direct children, or all descendants if recursive, sorted by key like etcd
+func (k *KeysAPI) dir(key string, recursive bool) *etcd.Node {
+	children := map[string]bool{}
+	for stored := range k.values {
+		if strings.HasPrefix(stored, key+"/") {
+			child := strings.SplitN(strings.TrimPrefix(stored, key+"/"), "/", 2)[0]
+			children[key+"/"+child] = true
+		}
+	}
+	keys := []string{}
+	for child := range children {
+		keys = append(keys, child)
+	}
+	sort.Strings(keys)
+
+	node := &etcd.Node{Key: key, Dir: true}
+	for _, child := range keys {
+		if _, isValue := k.values[child]; isValue {
+			node.Nodes = append(node.Nodes, k.node(child))
+		} else if recursive {
+			node.Nodes = append(node.Nodes, k.dir(child, true))
+		} else {
+			node.Nodes = append(node.Nodes, &etcd.Node{Key: child, Dir: true})
+		}
+	}
+	return node
+}
+
+func (k *KeysAPI) keyNotFound(key string) error {
+	return etcd.Error{Code: etcd.ErrorCodeKeyNotFound, Message: "Key not found", Cause: key, Index: k.last}
+}
+
+func (k *KeysAPI) testFailed(key string, prevIndex uint64) error {
+	return etcd.Error{Code: etcd.ErrorCodeTestFailed, Message: "Compare failed", Cause: fmt.Sprintf("[%v != %v]", prevIndex, k.index[key]), Index: k.last}
+}
+
+func normalize(key string) string {
+	return strings.TrimSuffix(key, "/")
+}
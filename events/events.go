@@ -0,0 +1,220 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	EVENT_HEALTH_CHANGED = "health.changed"
+
+	EVENT_DEPLOYMENT_STARTED    = "deployment.started"
+	EVENT_DEPLOYMENT_SUCCEEDED  = "deployment.succeeded"
+	EVENT_DEPLOYMENT_FAILED     = "deployment.failed"
+	EVENT_DEPLOYMENT_ROLLEDBACK = "deployment.rolledback"
+	EVENT_DEPLOYMENT_UNDEPLOYED = "deployment.undeployed"
+	EVENT_UNDEPLOYMENT_FAILED   = "undeployment.failed"
+	EVENT_DEPLOYMENT_SCALED     = "deployment.scaled"
+	EVENT_DEPLOYMENT_RESTARTED  = "deployment.restarted"
+	EVENT_RESTART_FAILED        = "restart.failed"
+	EVENT_DEPLOYMENT_PAUSED     = "deployment.paused"
+	EVENT_PAUSE_FAILED          = "pause.failed"
+	EVENT_DEPLOYMENT_RESUMED    = "deployment.resumed"
+	EVENT_RESUME_FAILED         = "resume.failed"
+
+	EVENT_DEPLOYMENT_STATUSCHANGED = "deployment.statuschanged"
+
+	EVENT_DESCRIPTOR_CREATED = "descriptor.created"
+	EVENT_DESCRIPTOR_UPDATED = "descriptor.updated"
+	EVENT_DESCRIPTOR_DELETED = "descriptor.deleted"
+
+	EVENT_DRIFT_DETECTED = "drift.detected"
+	EVENT_DRIFT_RESOLVED = "drift.resolved"
+	EVENT_DRIFT_HEALED   = "drift.healed"
+
+	EVENT_SECRETS_CHANGED = "secrets.changed"
+)
+
+var DeploymentLifecycleEvents = []string{
+	EVENT_DEPLOYMENT_STARTED,
+	EVENT_DEPLOYMENT_SUCCEEDED,
+	EVENT_DEPLOYMENT_FAILED,
+	EVENT_DEPLOYMENT_ROLLEDBACK,
+	EVENT_DEPLOYMENT_UNDEPLOYED,
+	EVENT_UNDEPLOYMENT_FAILED,
+	EVENT_DEPLOYMENT_SCALED,
+	EVENT_DEPLOYMENT_RESTARTED,
+	EVENT_RESTART_FAILED,
+	EVENT_DEPLOYMENT_PAUSED,
+	EVENT_PAUSE_FAILED,
+	EVENT_DEPLOYMENT_RESUMED,
+	EVENT_RESUME_FAILED,
+}
+
+// size of the channel buffer of each subscriber, events are dropped for subscribers which are too slow
+const subscriberBufferSize = 100
+
+type Event struct {
+	Id           string      `json:"id"`
+	Type         string      `json:"type"`
+	Time         string      `json:"time"`
+	Namespace    string      `json:"namespace,omitempty"`
+	AppName      string      `json:"appName,omitempty"`
+	DeploymentId string      `json:"deploymentId,omitempty"`
+	Message      string      `json:"message,omitempty"`
+	Data         interface{} `json:"data,omitempty"`
+}
+
+// DeploymentSummary is a copy of the public parts of a deployment, taken when the event occurred
+type DeploymentSummary struct {
+	Id             string   `json:"id"`
+	Created        string   `json:"created,omitempty"`
+	LastModified   string   `json:"lastModified,omitempty"`
+	Version        string   `json:"version,omitempty"`
+	Status         string   `json:"status,omitempty"`
+	PreviousStatus string   `json:"previousStatus,omitempty"`
+	Namespace      string   `json:"namespace"`
+	AppName        string   `json:"appName"`
+	DescriptorId   string   `json:"descriptorId,omitempty"`
+	Frontend       string   `json:"frontend,omitempty"`
+	Images         []string `json:"images,omitempty"`
+}
+
+func NewDeploymentEvent(eventType string, deployment *types.Deployment, msg string, args ...interface{}) Event {
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+
+	descriptor := deployment.Descriptor
+	summary := DeploymentSummary{
+		Id:           deployment.Id,
+		Created:      deployment.Created,
+		LastModified: deployment.LastModified,
+		Version:      deployment.Version,
+		Status:       deployment.Status,
+		Namespace:    descriptor.Namespace,
+		AppName:      descriptor.AppName,
+		DescriptorId: descriptor.Id,
+		Frontend:     descriptor.Frontend,
+	}
+	for _, container := range descriptor.PodSpec.Containers {
+		summary.Images = append(summary.Images, container.Image)
+	}
+
+	return Event{
+		Type:         eventType,
+		Namespace:    descriptor.Namespace,
+		AppName:      descriptor.AppName,
+		DeploymentId: deployment.Id,
+		Message:      msg,
+		Data:         summary,
+	}
+}
+
+type DescriptorSummary struct {
+	Id           string `json:"id"`
+	Namespace    string `json:"namespace"`
+	AppName      string `json:"appName"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+func NewDescriptorEvent(eventType string, descriptor *types.Descriptor) Event {
+	return Event{
+		Type:      eventType,
+		Namespace: descriptor.Namespace,
+		AppName:   descriptor.AppName,
+		Message:   fmt.Sprintf("Descriptor %v of %v %v", descriptor.Id, descriptor.AppName, eventType[strings.Index(eventType, ".")+1:]),
+		Data: DescriptorSummary{
+			Id:           descriptor.Id,
+			Namespace:    descriptor.Namespace,
+			AppName:      descriptor.AppName,
+			LastModified: descriptor.LastModified,
+		},
+	}
+}
+
+func (event *Event) String() string {
+	b, err := json.Marshal(event)
+
+	if err != nil {
+		return "Error writing event to JSON"
+	}
+
+	return string(b)
+}
+
+// Bus distributes events to all of its subscribers
+type Bus struct {
+	mutex       sync.Mutex
+	subscribers map[chan Event]bool
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: map[chan Event]bool{}}
+}
+
+// Publish sends the event to all subscribers, it does nothing on a nil bus
+func (bus *Bus) Publish(event Event) {
+	if bus == nil {
+		return
+	}
+	if event.Id == "" {
+		event.Id = uuid.NewV4().String()
+	}
+	if event.Time == "" {
+		event.Time = time.Now().Format(time.RFC3339)
+	}
+
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	for subscriber := range bus.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			log.Printf("Event subscriber too slow, dropping event %v", event.Type)
+		}
+	}
+}
+
+func (bus *Bus) Subscribe() chan Event {
+	subscriber := make(chan Event, subscriberBufferSize)
+
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.subscribers[subscriber] = true
+
+	return subscriber
+}
+
+func (bus *Bus) Unsubscribe(subscriber chan Event) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	if _, ok := bus.subscribers[subscriber]; ok {
+		delete(bus.subscribers, subscriber)
+		close(subscriber)
+	}
+}
@@ -0,0 +1,42 @@
+package events
+
+import "testing"
+
+func TestPublishToSubscribers(t *testing.T) {
+	bus := NewBus()
+	first := bus.Subscribe()
+	second := bus.Subscribe()
+
+	bus.Publish(Event{Type: EVENT_HEALTH_CHANGED, AppName: "myapp"})
+
+	for _, subscriber := range []chan Event{first, second} {
+		event := <-subscriber
+		if event.Type != EVENT_HEALTH_CHANGED || event.AppName != "myapp" {
+			t.Errorf("Unexpected event %v", event.String())
+		}
+		if event.Time == "" {
+			t.Error("Event time not set")
+		}
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	bus := NewBus()
+	subscriber := bus.Subscribe()
+	bus.Unsubscribe(subscriber)
+
+	bus.Publish(Event{Type: EVENT_HEALTH_CHANGED})
+
+	if _, open := <-subscriber; open {
+		t.Error("Expected closed channel after unsubscribe")
+	}
+}
+
+func TestSlowSubscriberDoesNotBlock(t *testing.T) {
+	bus := NewBus()
+	bus.Subscribe()
+
+	for i := 0; i < subscriberBufferSize+10; i++ {
+		bus.Publish(Event{Type: EVENT_HEALTH_CHANGED})
+	}
+}
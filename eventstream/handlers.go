@@ -0,0 +1,161 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package eventstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/events"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+)
+
+const CLOUDEVENTS_TYPE_PREFIX = "org.amdatu.deployer."
+
+// send a comment line every now and then, so proxies don't close idle connections
+var keepAliveInterval = 30 * time.Second
+
+// CloudEvent is the JSON representation of an event following the CloudEvents 1.0 spec
+type CloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	Id              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Subject         string      `json:"subject,omitempty"`
+	Time            string      `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Namespace       string      `json:"namespace,omitempty"`
+	AppName         string      `json:"appname,omitempty"`
+	Message         string      `json:"message,omitempty"`
+	Data            interface{} `json:"data,omitempty"`
+}
+
+func ToCloudEvent(event events.Event) CloudEvent {
+	source := "/deployer"
+	if event.Namespace != "" {
+		source += "/namespaces/" + event.Namespace
+		if event.AppName != "" {
+			source += "/apps/" + event.AppName
+		}
+	}
+
+	return CloudEvent{
+		SpecVersion:     "1.0",
+		Id:              event.Id,
+		Source:          source,
+		Type:            CLOUDEVENTS_TYPE_PREFIX + event.Type,
+		Subject:         event.DeploymentId,
+		Time:            event.Time,
+		DataContentType: "application/json",
+		Namespace:       event.Namespace,
+		AppName:         event.AppName,
+		Message:         event.Message,
+		Data:            event.Data,
+	}
+}
+
+type EventStreamHandlers struct {
+	bus *events.Bus
+}
+
+func NewEventStreamHandlers(bus *events.Bus) *EventStreamHandlers {
+	return &EventStreamHandlers{bus}
+}
+
+// StreamEventsHandler streams all events of a namespace as Server-Sent Events, with CloudEvents formatted data
+func (e *EventStreamHandlers) StreamEventsHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	//TODO check namespaces of user
+	namespace := req.URL.Query().Get("namespace")
+	if namespace == "" {
+		helper.HandleError(writer, logger, 400, "Namespace parameter missing")
+		return
+	}
+	appname := req.URL.Query().Get("appname")
+
+	var eventTypes []string
+	if typesParam := req.URL.Query().Get("types"); typesParam != "" {
+		eventTypes = strings.Split(typesParam, ",")
+	}
+
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		helper.HandleError(writer, logger, 500, "Streaming not supported")
+		return
+	}
+
+	logger.Printf("Streaming events for namespace %v", namespace)
+
+	subscriber := e.bus.Subscribe()
+	defer e.bus.Unsubscribe(subscriber)
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("Connection", "keep-alive")
+	writer.WriteHeader(200)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			logger.Printf("Stopped streaming events for namespace %v", namespace)
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(writer, ": keepalive\n\n")
+			flusher.Flush()
+		case event, open := <-subscriber:
+			if !open {
+				return
+			}
+			if !matches(event, namespace, appname, eventTypes) {
+				continue
+			}
+			data, err := json.Marshal(ToCloudEvent(event))
+			if err != nil {
+				logger.Printf("Error marshalling event %v: %v", event.Id, err.Error())
+				continue
+			}
+			fmt.Fprintf(writer, "id: %v\nevent: %v\ndata: %v\n\n", event.Id, event.Type, string(data))
+			flusher.Flush()
+		}
+	}
+}
+
+func matches(event events.Event, namespace string, appname string, eventTypes []string) bool {
+	if event.Namespace != namespace {
+		return false
+	}
+	if appname != "" && event.AppName != appname {
+		return false
+	}
+	if len(eventTypes) == 0 {
+		return true
+	}
+	for _, eventType := range eventTypes {
+		if strings.TrimSpace(eventType) == event.Type {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package eventstream
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/events"
+)
+
+func TestToCloudEvent(t *testing.T) {
+	event := events.Event{
+		Id:           "123",
+		Type:         events.EVENT_DEPLOYMENT_STATUSCHANGED,
+		Time:         "2017-01-15T02:02:14Z",
+		Namespace:    "test",
+		AppName:      "myapp",
+		DeploymentId: "456",
+	}
+
+	cloudEvent := ToCloudEvent(event)
+
+	if cloudEvent.SpecVersion != "1.0" || cloudEvent.Id != "123" || cloudEvent.Time != event.Time {
+		t.Errorf("Unexpected cloud event %+v", cloudEvent)
+	}
+	if cloudEvent.Type != "org.amdatu.deployer.deployment.statuschanged" {
+		t.Errorf("Unexpected type %v", cloudEvent.Type)
+	}
+	if cloudEvent.Source != "/deployer/namespaces/test/apps/myapp" {
+		t.Errorf("Unexpected source %v", cloudEvent.Source)
+	}
+	if cloudEvent.Subject != "456" {
+		t.Errorf("Unexpected subject %v", cloudEvent.Subject)
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	bus := events.NewBus()
+	handlers := NewEventStreamHandlers(bus)
+	server := httptest.NewServer(http.HandlerFunc(handlers.StreamEventsHandler))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events?namespace=test&appname=myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Unexpected content type %v", resp.Header.Get("Content-Type"))
+	}
+
+	// wait for the handler to subscribe
+	time.Sleep(100 * time.Millisecond)
+	bus.Publish(events.Event{Type: events.EVENT_HEALTH_CHANGED, Namespace: "other", AppName: "myapp"})
+	bus.Publish(events.Event{Type: events.EVENT_HEALTH_CHANGED, Namespace: "test", AppName: "otherapp"})
+	bus.Publish(events.Event{Type: events.EVENT_HEALTH_CHANGED, Namespace: "test", AppName: "myapp", Message: "expected"})
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		cloudEvent := CloudEvent{}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &cloudEvent); err != nil {
+			t.Fatal(err)
+		}
+		if cloudEvent.Message != "expected" {
+			t.Errorf("Received unexpected event %+v", cloudEvent)
+		}
+		return
+	}
+}
+
+func TestStreamEventsRequiresNamespace(t *testing.T) {
+	handlers := NewEventStreamHandlers(events.NewBus())
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/events", nil)
+
+	handlers.StreamEventsHandler(recorder, req)
+
+	if recorder.Code != 400 {
+		t.Errorf("Expected 400, got %v", recorder.Code)
+	}
+}
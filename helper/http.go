@@ -26,11 +26,19 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// until the REST API is authenticated, clients identify their user with this header
+const HEADER_USER = "X-Deployer-User"
+
 var Upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
 }
 
+// GetUser returns the user sending the request, empty if unknown
+func GetUser(req *http.Request) string {
+	return strings.TrimSpace(req.Header.Get(HEADER_USER))
+}
+
 func HandleSuccess(writer http.ResponseWriter, logger logger.Logger, body interface{}, msg string, args ...interface{}) {
 
 	var bodyBytes []byte
@@ -64,6 +72,19 @@ func HandleSuccess(writer http.ResponseWriter, logger logger.Logger, body interf
 	logMsg(logger, msg, args...)
 }
 
+// HandleResult writes the body as JSON with the given status, e.g. for errors with details
+func HandleResult(writer http.ResponseWriter, logger logger.Logger, status int, body interface{}, msg string, args ...interface{}) {
+	bodyBytes, err := json.MarshalIndent(body, "", "  ")
+	if err != nil {
+		HandleError(writer, logger, 500, "Error marshalling result to json: %v", err)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	writer.Write(bodyBytes)
+	logMsg(logger, msg, args...)
+}
+
 func HandleCreated(writer http.ResponseWriter, logger logger.Logger, location string, msg string, args ...interface{}) {
 	handleNew(writer, logger, location, 201, msg, args...)
 }
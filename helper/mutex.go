@@ -17,7 +17,13 @@ package helper
 
 import "sync"
 
+// guards the mutex maps, since the deployments, the drift reconciler, the sweeper and the secret refresher get mutexes concurrently
+var mutexesLock sync.Mutex
+
 func GetMutex(mutexes map[string]*sync.Mutex, mutexKey string) *sync.Mutex {
+	mutexesLock.Lock()
+	defer mutexesLock.Unlock()
+
 	var mutex *sync.Mutex
 	var ok bool
 	if mutex, ok = mutexes[mutexKey]; !ok {
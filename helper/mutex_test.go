@@ -0,0 +1,32 @@
+package helper
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestGetMutexConcurrently(t *testing.T) {
+	mutexes := map[string]*sync.Mutex{}
+	results := make(chan *sync.Mutex, 50)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- GetMutex(mutexes, "test-myapp")
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	first := <-results
+	for mutex := range results {
+		if mutex != first {
+			t.Fatal("Expected the same mutex for the same key")
+		}
+	}
+	if len(mutexes) != 1 {
+		t.Errorf("Expected 1 mutex, got %v", len(mutexes))
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package helper
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+)
+
+// header with the admin token, for reading sensitive values and administrative endpoints
+const HEADER_ADMIN_TOKEN = "X-Deployer-Admin-Token"
+
+// hash of the configured admin token, nil if there is none
+var adminTokenHash []byte
+
+// SetAdminToken configures the token of admin requests. Without token no request is an admin request.
+func SetAdminToken(token string) {
+	if token == "" {
+		adminTokenHash = nil
+		return
+	}
+	hash := sha256.Sum256([]byte(token))
+	adminTokenHash = hash[:]
+}
+
+// AdminEnabled returns if an admin token is configured
+func AdminEnabled() bool {
+	return adminTokenHash != nil
+}
+
+// IsAdmin returns if the request was sent with the configured admin token.
+// The hashes of the tokens are compared in constant time, so the comparison doesn't leak the token or its length.
+func IsAdmin(req *http.Request) bool {
+	token := req.Header.Get(HEADER_ADMIN_TOKEN)
+	if adminTokenHash == nil || token == "" {
+		return false
+	}
+	hash := sha256.Sum256([]byte(token))
+	return subtle.ConstantTimeCompare(hash[:], adminTokenHash) == 1
+}
+
+// RedactDescriptor returns a copy of the descriptor with redacted sensitive fields, unless the request is an admin request
+func RedactDescriptor(req *http.Request, fields types.SensitiveFields, descriptor *types.Descriptor) *types.Descriptor {
+	if descriptor == nil || len(fields) == 0 || IsAdmin(req) {
+		return descriptor
+	}
+	// marshalling a descriptor doesn't fail, on error an empty descriptor is returned rather than sensitive values
+	redacted := &types.Descriptor{}
+	if bytes, err := json.Marshal(descriptor); err == nil {
+		json.Unmarshal(bytes, redacted)
+	}
+	fields.Redact(redacted)
+	return redacted
+}
+
+// RedactDescriptors redacts a list of descriptors, see RedactDescriptor
+func RedactDescriptors(req *http.Request, fields types.SensitiveFields, descriptors []*types.Descriptor) []*types.Descriptor {
+	redacted := make([]*types.Descriptor, len(descriptors))
+	for i, descriptor := range descriptors {
+		redacted[i] = RedactDescriptor(req, fields, descriptor)
+	}
+	return redacted
+}
+
+// RedactDeployment returns a copy of the deployment with a redacted descriptor, unless the request is an admin request
+func RedactDeployment(req *http.Request, fields types.SensitiveFields, deployment *types.Deployment) *types.Deployment {
+	if deployment == nil || deployment.Descriptor == nil || len(fields) == 0 || IsAdmin(req) {
+		return deployment
+	}
+	redacted := *deployment
+	redacted.Descriptor = RedactDescriptor(req, fields, deployment.Descriptor)
+	return &redacted
+}
+
+// RedactDeployments redacts a list of deployments, see RedactDeployment
+func RedactDeployments(req *http.Request, fields types.SensitiveFields, deployments []*types.Deployment) []*types.Deployment {
+	redacted := make([]*types.Deployment, len(deployments))
+	for i, deployment := range deployments {
+		redacted[i] = RedactDeployment(req, fields, deployment)
+	}
+	return redacted
+}
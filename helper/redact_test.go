@@ -0,0 +1,51 @@
+package helper
+
+import (
+	"net/http"
+	"testing"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+)
+
+func newAdminRequest(token string) *http.Request {
+	req, _ := http.NewRequest("GET", "/descriptors/", nil)
+	if token != "" {
+		req.Header.Set(HEADER_ADMIN_TOKEN, token)
+	}
+	return req
+}
+
+func TestIsAdmin(t *testing.T) {
+	defer SetAdminToken("")
+
+	SetAdminToken("")
+	if IsAdmin(newAdminRequest("")) || IsAdmin(newAdminRequest("admin")) {
+		t.Error("Expected no admin requests without admin token")
+	}
+
+	SetAdminToken("s3cret")
+	if !IsAdmin(newAdminRequest("s3cret")) {
+		t.Error("Expected admin request with the admin token")
+	}
+	if IsAdmin(newAdminRequest("wrong")) || IsAdmin(newAdminRequest("")) {
+		t.Error("Expected no admin request without the admin token")
+	}
+}
+
+func TestRedactDescriptorWithoutAdminToken(t *testing.T) {
+	defer SetAdminToken("")
+	SetAdminToken("s3cret")
+
+	descriptor := &types.Descriptor{Password: "password"}
+	fields := types.SensitiveFields{types.SENSITIVE_PASSWORD}
+
+	if redacted := RedactDescriptor(newAdminRequest("wrong"), fields, descriptor); redacted.Password != types.REDACTED {
+		t.Errorf("Expected redacted password, got %v", redacted.Password)
+	}
+	if descriptor.Password != "password" {
+		t.Error("Expected the original descriptor to be unchanged")
+	}
+	if redacted := RedactDescriptor(newAdminRequest("s3cret"), fields, descriptor); redacted.Password != "password" {
+		t.Errorf("Expected the password for admin requests, got %v", redacted.Password)
+	}
+}
@@ -19,8 +19,10 @@ import (
 	"sync"
 
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/events"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/k8s"
 	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/proxies"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/secrets"
 )
 
 type DeployerConfig struct {
@@ -29,4 +31,6 @@ type DeployerConfig struct {
 	EtcdRegistry        *etcdregistry.EtcdRegistry
 	IngressConfigurator *proxies.IngressConfigurator
 	Mutexes             map[string]*sync.Mutex
+	Events              *events.Bus
+	SecretResolver      *secrets.Resolver
 }
@@ -0,0 +1,120 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package importer
+
+import (
+	"net/http"
+	"strconv"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"github.com/satori/go.uuid"
+)
+
+type ImportHandlers struct {
+	importer *Importer
+	config   helper.DeployerConfig
+}
+
+func NewImportHandlers(config helper.DeployerConfig) *ImportHandlers {
+	return &ImportHandlers{NewImporter(config), config}
+}
+
+func (i *ImportHandlers) ImportHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	//TODO check namespaces of user
+	namespace := req.URL.Query().Get("namespace")
+	if namespace == "" {
+		helper.HandleError(writer, logger, 400, "Namespace parameter missing")
+		return
+	}
+
+	name := req.URL.Query().Get("name")
+	if name == "" {
+		helper.HandleError(writer, logger, 400, "Name parameter missing")
+		return
+	}
+
+	kind := req.URL.Query().Get("kind")
+	if kind == "" {
+		kind = KIND_REPLICATIONCONTROLLER
+	}
+
+	store := parseBool(req, "store")
+	adopt := parseBool(req, "adopt")
+	if store == nil || adopt == nil {
+		helper.HandleError(writer, logger, 400, "Store and adopt parameters must be true or false")
+		return
+	}
+	if *adopt && kind != KIND_REPLICATIONCONTROLLER {
+		helper.HandleError(writer, logger, 400, "Only replication controllers can be adopted")
+		return
+	}
+
+	logger.Printf("Importing %v %v in namespace %v", kind, name, namespace)
+
+	result, err := i.importer.Import(namespace, kind, name)
+	if err == ErrUnsupportedKind {
+		helper.HandleError(writer, logger, 400, "Unsupported kind %v", kind)
+		return
+	} else if err == ErrWorkloadNotFound {
+		helper.HandleNotFound(writer, logger, "%v %v not found", kind, name)
+		return
+	} else if err != nil {
+		helper.HandleError(writer, logger, 500, "Error importing %v %v: %v", kind, name, err)
+		return
+	}
+
+	result.Descriptor.ModifiedBy = helper.GetUser(req)
+	if *adopt {
+		if err := i.importer.Adopt(result, logger); err == ErrNotAdoptable {
+			helper.HandleError(writer, logger, 400, "Error adopting %v %v: %v", kind, name, err)
+			return
+		} else if err != nil {
+			helper.HandleError(writer, logger, 500, "Error adopting %v %v: %v", kind, name, err)
+			return
+		}
+	} else if *store {
+		result.Descriptor.Id = uuid.NewV4().String()
+		if err := i.config.EtcdRegistry.CreateDescriptor(result.Descriptor); err != nil {
+			helper.HandleError(writer, logger, 500, "Error storing descriptor: %v", err)
+			return
+		}
+	}
+
+	redacted := *result
+	redacted.Descriptor = helper.RedactDescriptor(req, i.config.EtcdRegistry.SensitiveFields(), result.Descriptor)
+	if !*adopt && !*store {
+		helper.HandleSuccess(writer, logger, &redacted, "Imported %v %v", kind, name)
+		return
+	}
+
+	writer.Header().Set("Location", "/descriptors/"+result.Descriptor.Id+"/?namespace="+namespace)
+	helper.HandleResult(writer, logger, 201, &redacted, "Imported %v %v as descriptor %v", kind, name, result.Descriptor.Id)
+}
+
+// parseBool returns nil for invalid values
+func parseBool(req *http.Request, name string) *bool {
+	value := false
+	if param := req.URL.Query().Get(name); param != "" {
+		var err error
+		if value, err = strconv.ParseBool(param); err != nil {
+			return nil
+		}
+	}
+	return &value
+}
@@ -0,0 +1,384 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"github.com/satori/go.uuid"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+)
+
+const (
+	KIND_REPLICATIONCONTROLLER = "replicationcontroller"
+	KIND_DEPLOYMENT            = "deployment"
+)
+
+const (
+	snippetAnnotation  = "ingress.kubernetes.io/configuration-snippet"
+	affinityAnnotation = "ingress.kubernetes.io/affinity"
+)
+
+var (
+	ErrWorkloadNotFound = errors.New("workload not found!")
+	ErrUnsupportedKind  = errors.New("unsupported kind, use replicationcontroller or deployment")
+	// the deployer finds the replication controller of a deployment by app name and version, see Deployment.GetVersionedName
+	ErrNotAdoptable = errors.New("only replication controllers named <app>-<version> with a numeric version label can be adopted")
+)
+
+// env vars added by the deployer to all containers, see ClusterManager.BuildReplicationController
+var deployerEnvVars = map[string]bool{"APP_NAME": true, "POD_NAMESPACE": true, "APP_VERSION": true, "POD_NAME": true}
+
+var headerRegexp = regexp.MustCompile(`'([^':]+):\s*([^']*)'`)
+
+// Result is a descriptor generated from an existing workload, with the objects it was generated from
+type Result struct {
+	Descriptor *types.Descriptor `json:"descriptor"`
+	Kind       string            `json:"kind"`
+	Workload   string            `json:"workload"`
+	Version    string            `json:"version,omitempty"`
+	Services   []string          `json:"services,omitempty"`
+	Ingresses  []string          `json:"ingresses,omitempty"`
+	Deployment *types.Deployment `json:"deployment,omitempty"`
+	Warnings   []string          `json:"warnings,omitempty"`
+
+	controller *v1.ReplicationController
+	services   []v1.Service
+}
+
+type Importer struct {
+	config helper.DeployerConfig
+}
+
+func NewImporter(config helper.DeployerConfig) *Importer {
+	return &Importer{config}
+}
+
+// Import generates a descriptor from the replication controller or deployment with the given name, its services and its Ingress
+func (i *Importer) Import(namespace string, kind string, name string) (*Result, error) {
+	k8sClient := i.config.K8sClient
+
+	result := &Result{Kind: kind, Workload: name}
+
+	var objectMeta meta.ObjectMeta
+	var replicas *int32
+	var template *v1.PodTemplateSpec
+	var err error
+
+	switch kind {
+	case KIND_REPLICATIONCONTROLLER:
+		result.controller, err = k8sClient.GetReplicationController(namespace, name)
+		if err == nil {
+			objectMeta, replicas, template = result.controller.ObjectMeta, result.controller.Spec.Replicas, result.controller.Spec.Template
+		}
+	case KIND_DEPLOYMENT:
+		var deployment *v1beta1.Deployment
+		deployment, err = k8sClient.GetDeployment(namespace, name)
+		if err == nil {
+			objectMeta, replicas, template = deployment.ObjectMeta, deployment.Spec.Replicas, &deployment.Spec.Template
+		}
+	default:
+		return nil, ErrUnsupportedKind
+	}
+	if isNotFound(err) {
+		return nil, ErrWorkloadNotFound
+	} else if err != nil {
+		return nil, err
+	} else if template == nil {
+		return nil, fmt.Errorf("%v %v has no pod template", kind, name)
+	}
+
+	descriptor := DescriptorFromWorkload(namespace, name, objectMeta, replicas, template)
+	result.Descriptor = descriptor
+	result.Version = objectMeta.Labels["version"]
+
+	// services selecting the pods of the workload
+	services, err := k8sClient.ListServices(namespace)
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range services.Items {
+		if selects(service.Spec.Selector, template.Labels) {
+			result.services = append(result.services, service)
+			result.Services = append(result.Services, service.Name)
+		}
+	}
+
+	// Ingresses pointing to these services
+	ingresses, err := k8sClient.ListIngresses(namespace)
+	if err != nil {
+		return nil, err
+	}
+	var ingress, wwwIngress *v1beta1.Ingress
+	for j, candidate := range ingresses.Items {
+		if !hasBackend(&candidate, result.Services) {
+			continue
+		}
+		result.Ingresses = append(result.Ingresses, candidate.Name)
+		if isWwwRedirect(&candidate) {
+			wwwIngress = &ingresses.Items[j]
+		} else if ingress == nil {
+			ingress = &ingresses.Items[j]
+		} else {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("Multiple Ingresses found, only %v is used", ingress.Name))
+		}
+	}
+	if ingress != nil {
+		ApplyIngress(descriptor, ingress, wwwIngress)
+	}
+
+	if err := descriptor.SetDefaults().Validate(); err != nil {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("Generated descriptor is invalid, fix it before deploying: %v", err.Error()))
+	}
+
+	return result, nil
+}
+
+// Adopt stores the descriptor and the running version as DEPLOYED deployment, and labels the replication controller and its services,
+// so that they are replaced and cleaned up by the next deployment. Only replication controllers can be adopted.
+func (i *Importer) Adopt(result *Result, logger logger.Logger) error {
+	if result.controller == nil {
+		return errors.New("only replication controllers can be adopted")
+	}
+
+	descriptor := result.Descriptor
+	k8sClient := i.config.K8sClient
+	ctrl := result.controller
+	version := result.Version
+
+	if !IsAdoptable(descriptor.AppName, version, ctrl.Name) {
+		return ErrNotAdoptable
+	}
+
+	if ctrl.Labels == nil {
+		ctrl.Labels = make(map[string]string)
+	}
+	ctrl.Labels["app"] = descriptor.AppName
+	ctrl.Labels["version"] = version
+	logger.Printf("Labeling replication controller %v with version %v", ctrl.Name, version)
+	if _, err := k8sClient.UpdateReplicationController(descriptor.Namespace, ctrl); err != nil {
+		return err
+	}
+
+	for _, service := range result.services {
+		if service.Labels == nil {
+			service.Labels = make(map[string]string)
+		}
+		service.Labels["app"] = descriptor.AppName
+		if service.Name == descriptor.AppName {
+			service.Labels["persistent"] = "true"
+			for key := range service.Spec.Selector {
+				if key != "app" && key != "version" {
+					result.Warnings = append(result.Warnings, fmt.Sprintf("Service %v selects pods by %v, which the next deployment doesn't set. Change its selector to app and version", service.Name, key))
+				}
+			}
+		} else {
+			service.Labels["version"] = version
+			result.Warnings = append(result.Warnings, fmt.Sprintf("Service %v will be deleted by the next deployment, use service %v instead", service.Name, descriptor.AppName))
+		}
+		logger.Printf("Labeling service %v", service.Name)
+		if _, err := k8sClient.UpdateService(descriptor.Namespace, &service); err != nil {
+			return err
+		}
+	}
+
+	for _, ingress := range result.Ingresses {
+		if ingress != descriptor.AppName && ingress != descriptor.AppName+"-www-redirect" {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("Ingress %v is not managed by the deployer, delete it after the next deployment", ingress))
+		}
+	}
+
+	descriptor.Id = uuid.NewV4().String()
+	if err := i.config.EtcdRegistry.CreateDescriptor(descriptor); err != nil {
+		return err
+	}
+
+	deployment := AdoptedDeployment(descriptor, version)
+	if err := i.config.EtcdRegistry.CreateDeployment(deployment); err != nil {
+		return err
+	}
+	result.Deployment = deployment
+
+	logger.Printf("Adopted %v version %v as deployment %v", descriptor.AppName, version, deployment.Id)
+	return nil
+}
+
+// IsAdoptable checks that the deployer finds the replication controller by the app name and version: the next version is
+// based on the version label, so it must be a number, and the name must be <app>-<version>
+func IsAdoptable(appName string, version string, controllerName string) bool {
+	if _, err := strconv.Atoi(version); err != nil {
+		return false
+	}
+	return controllerName == appName+"-"+version
+}
+
+// AdoptedDeployment is the DEPLOYED deployment representing the running version of an adopted app
+func AdoptedDeployment(descriptor *types.Descriptor, version string) *types.Deployment {
+	descriptorCopy := *descriptor
+	return &types.Deployment{
+		Id:                 uuid.NewV4().String(),
+		Version:            version,
+		Status:             types.DEPLOYMENTSTATUS_DEPLOYED,
+		Descriptor:         &descriptorCopy,
+		DescriptorRevision: descriptor.Revision,
+	}
+}
+
+// DescriptorFromWorkload generates a descriptor from the metadata and pod template of a workload
+func DescriptorFromWorkload(namespace string, name string, objectMeta meta.ObjectMeta, replicas *int32, template *v1.PodTemplateSpec) *types.Descriptor {
+	descriptor := &types.Descriptor{
+		Namespace:      namespace,
+		AppName:        objectMeta.Labels["app"],
+		NewVersion:     "#",
+		DeploymentType: "blue-green",
+		Replicas:       1,
+		PodSpec:        template.Spec,
+	}
+
+	if descriptor.AppName == "" {
+		descriptor.AppName = name
+		if version := objectMeta.Labels["version"]; version != "" {
+			descriptor.AppName = strings.TrimSuffix(name, "-"+version)
+		}
+	}
+
+	if replicas != nil && *replicas > 0 {
+		descriptor.Replicas = int(*replicas)
+	}
+
+	// remove env vars which are added by the deployer
+	containers := []v1.Container{}
+	for _, container := range template.Spec.Containers {
+		env := []v1.EnvVar{}
+		for _, envVar := range container.Env {
+			if !deployerEnvVars[envVar.Name] {
+				env = append(env, envVar)
+			}
+		}
+		container.Env = env
+		containers = append(containers, container)
+	}
+	descriptor.PodSpec.Containers = containers
+
+	// health settings, from the annotations of deployer managed workloads, or else from the readiness probe
+	annotations := objectMeta.Annotations
+	if useHealthCheck, err := strconv.ParseBool(annotations["useHealthCheck"]); err == nil {
+		descriptor.UseHealthCheck = useHealthCheck
+		descriptor.HealthCheckPath = annotations["healthCheckPath"]
+		descriptor.HealthCheckPort, _ = strconv.Atoi(annotations["healthCheckPort"])
+		descriptor.HealthCheckType = annotations["healthCheckType"]
+	} else if len(containers) > 0 && containers[0].ReadinessProbe != nil && containers[0].ReadinessProbe.HTTPGet != nil {
+		httpGet := containers[0].ReadinessProbe.HTTPGet
+		descriptor.UseHealthCheck = true
+		descriptor.HealthCheckType = "simple"
+		descriptor.HealthCheckPath = httpGet.Path
+		descriptor.HealthCheckPort = resolvePort(httpGet.Port, containers[0])
+	}
+
+	return descriptor
+}
+
+// ApplyIngress sets the proxy settings of the descriptor based on the Ingress and the optional www redirect Ingress
+func ApplyIngress(descriptor *types.Descriptor, ingress *v1beta1.Ingress, wwwIngress *v1beta1.Ingress) {
+	if len(ingress.Spec.Rules) > 0 {
+		descriptor.Frontend = ingress.Spec.Rules[0].Host
+	}
+	if len(ingress.Spec.TLS) > 0 {
+		descriptor.TlsSecretName = ingress.Spec.TLS[0].SecretName
+	}
+
+	descriptor.UseStickySessions = ingress.Annotations[affinityAnnotation] == "cookie"
+
+	snippet := ingress.Annotations[snippetAnnotation]
+	descriptor.UseCompression = strings.Contains(snippet, "gzip on;")
+	if index := strings.Index(snippet, "more_set_headers"); index >= 0 {
+		for _, match := range headerRegexp.FindAllStringSubmatch(snippet[index:], -1) {
+			descriptor.AdditionHttpHeaders = append(descriptor.AdditionHttpHeaders, types.HttpHeader{Header: match[1], Value: match[2]})
+		}
+	}
+
+	descriptor.RedirectWww = wwwIngress != nil
+}
+
+func resolvePort(port intstr.IntOrString, container v1.Container) int {
+	if port.Type == intstr.Int {
+		return port.IntValue()
+	}
+	for _, containerPort := range container.Ports {
+		if containerPort.Name == port.StrVal {
+			return int(containerPort.ContainerPort)
+		}
+	}
+	return 0
+}
+
+func selects(selector map[string]string, labels map[string]string) bool {
+	if len(selector) == 0 {
+		return false
+	}
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func hasBackend(ingress *v1beta1.Ingress, services []string) bool {
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			for _, service := range services {
+				if path.Backend.ServiceName == service {
+					return true
+				}
+			}
+		}
+	}
+	return ingress.Spec.Backend != nil && contains(services, ingress.Spec.Backend.ServiceName)
+}
+
+func isWwwRedirect(ingress *v1beta1.Ingress) bool {
+	return len(ingress.Spec.Rules) > 0 && strings.HasPrefix(ingress.Spec.Rules[0].Host, "www.") &&
+		strings.Contains(ingress.Annotations[snippetAnnotation], "rewrite ")
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+func isNotFound(err error) bool {
+	statusError, isStatus := err.(*k8sErrors.StatusError)
+	return isStatus && statusError.Status().Reason == meta.StatusReasonNotFound
+}
@@ -0,0 +1,123 @@
+package importer
+
+import (
+	"testing"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+)
+
+func TestDescriptorFromWorkload(t *testing.T) {
+	replicas := int32(3)
+	objectMeta := meta.ObjectMeta{Labels: map[string]string{"version": "2"}}
+	template := &v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{
+		{
+			Name:  "myapp",
+			Image: "user/myapp:1.0",
+			Ports: []v1.ContainerPort{{Name: "health", ContainerPort: 9999}},
+			Env:   []v1.EnvVar{{Name: "APP_NAME", Value: "myapp"}, {Name: "DB", Value: "db"}},
+			ReadinessProbe: &v1.Probe{Handler: v1.Handler{HTTPGet: &v1.HTTPGetAction{
+				Path: "/health",
+				Port: intstr.FromString("health"),
+			}}},
+		},
+	}}}
+
+	descriptor := DescriptorFromWorkload("test", "myapp-2", objectMeta, &replicas, template)
+
+	if descriptor.AppName != "myapp" || descriptor.Namespace != "test" || descriptor.NewVersion != "#" || descriptor.Replicas != 3 {
+		t.Errorf("Unexpected descriptor %+v", descriptor)
+	}
+	if env := descriptor.PodSpec.Containers[0].Env; len(env) != 1 || env[0].Name != "DB" {
+		t.Errorf("Expected deployer env vars to be removed, got %+v", env)
+	}
+	if !descriptor.UseHealthCheck || descriptor.HealthCheckType != "simple" || descriptor.HealthCheckPath != "/health" || descriptor.HealthCheckPort != 9999 {
+		t.Errorf("Unexpected health settings %+v", descriptor)
+	}
+	if len(template.Spec.Containers[0].Env) != 2 {
+		t.Error("Expected the workload to be unchanged")
+	}
+}
+
+func TestDescriptorFromDeployerAnnotations(t *testing.T) {
+	objectMeta := meta.ObjectMeta{
+		Labels:      map[string]string{"app": "myapp", "version": "2"},
+		Annotations: map[string]string{"useHealthCheck": "true", "healthCheckPath": "/probe", "healthCheckPort": "8080", "healthCheckType": "probe"},
+	}
+	template := &v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{{Name: "myapp", Image: "user/myapp:1.0"}}}}
+
+	descriptor := DescriptorFromWorkload("test", "legacy", objectMeta, nil, template)
+
+	if descriptor.AppName != "myapp" || descriptor.Replicas != 1 {
+		t.Errorf("Unexpected descriptor %+v", descriptor)
+	}
+	if !descriptor.UseHealthCheck || descriptor.HealthCheckType != "probe" || descriptor.HealthCheckPath != "/probe" || descriptor.HealthCheckPort != 8080 {
+		t.Errorf("Unexpected health settings %+v", descriptor)
+	}
+}
+
+func TestApplyIngress(t *testing.T) {
+	ingress := &v1beta1.Ingress{
+		ObjectMeta: meta.ObjectMeta{Annotations: map[string]string{
+			affinityAnnotation: "cookie",
+			snippetAnnotation:  "gzip on;\ngzip_comp_level 5;\nmore_set_headers 'X-Frame-Options: DENY' 'Cache-Control: no-cache, no-store' ;",
+		}},
+		Spec: v1beta1.IngressSpec{
+			TLS:   []v1beta1.IngressTLS{{Hosts: []string{"example.com"}, SecretName: "example-tls"}},
+			Rules: []v1beta1.IngressRule{{Host: "example.com"}},
+		},
+	}
+
+	descriptor := DescriptorFromWorkload("test", "myapp", meta.ObjectMeta{}, nil, &v1.PodTemplateSpec{})
+	ApplyIngress(descriptor, ingress, &v1beta1.Ingress{})
+
+	if descriptor.Frontend != "example.com" || descriptor.TlsSecretName != "example-tls" {
+		t.Errorf("Unexpected frontend settings %+v", descriptor)
+	}
+	if !descriptor.UseCompression || !descriptor.UseStickySessions || !descriptor.RedirectWww {
+		t.Errorf("Unexpected proxy settings %+v", descriptor)
+	}
+	headers := descriptor.AdditionHttpHeaders
+	if len(headers) != 2 || headers[0].Header != "X-Frame-Options" || headers[0].Value != "DENY" || headers[1].Value != "no-cache, no-store" {
+		t.Errorf("Unexpected headers %+v", headers)
+	}
+}
+
+func TestAdoptRefusesUnknownNames(t *testing.T) {
+	tests := []struct {
+		appName   string
+		version   string
+		name      string
+		adoptable bool
+	}{
+		{"myapp", "2", "myapp-2", true},
+		{"myapp", "v2", "myapp-v2", false},
+		{"myapp", "", "myapp", false},
+		{"myapp", "2", "myapp-controller", false},
+	}
+
+	for _, test := range tests {
+		if adoptable := IsAdoptable(test.appName, test.version, test.name); adoptable != test.adoptable {
+			t.Errorf("%v with version %q: expected adoptable %v, got %v", test.name, test.version, test.adoptable, adoptable)
+		}
+	}
+
+	// refused before anything is changed in the cluster or the registry
+	result := &Result{
+		Descriptor: &types.Descriptor{Namespace: "test", AppName: "myapp"},
+		Version:    "2",
+		controller: &v1.ReplicationController{ObjectMeta: meta.ObjectMeta{Name: "myapp-controller"}},
+	}
+	if err := NewImporter(helper.DeployerConfig{}).Adopt(result, logger.NewConsoleLogger()); err != ErrNotAdoptable {
+		t.Errorf("Expected ErrNotAdoptable, got %v", err)
+	}
+	if result.controller.Labels != nil {
+		t.Error("Expected the replication controller to be unchanged")
+	}
+}
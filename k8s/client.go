@@ -24,7 +24,9 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/pkg/api/v1"
+	autoscaling "k8s.io/client-go/pkg/apis/autoscaling/v1"
 	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	policy "k8s.io/client-go/pkg/apis/policy/v1beta1"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
@@ -154,6 +156,10 @@ func (k8s *K8sClient) GetNamespace(name string) (*v1.Namespace, error) {
 	return k8s.client.Namespaces().Get(name, meta.GetOptions{})
 }
 
+func (k8s *K8sClient) ListIngresses(namespace string) (*v1beta1.IngressList, error) {
+	return k8s.client.Ingresses(namespace).List(meta.ListOptions{})
+}
+
 func (k8s *K8sClient) CreateIngress(namespace string, ingress *v1beta1.Ingress) (*v1beta1.Ingress, error) {
 	return k8s.client.Ingresses(namespace).Create(ingress)
 }
@@ -172,10 +178,78 @@ func (k8s *K8sClient) DeleteIngress(namespace, name string) error {
 		Delete(name, &meta.DeleteOptions{})
 }
 
+func (k8s *K8sClient) GetDeployment(namespace, name string) (*v1beta1.Deployment, error) {
+	return k8s.client.ExtensionsV1beta1().Deployments(namespace).Get(name, meta.GetOptions{})
+}
+
 func (k8s *K8sClient) GetSecret(namespace, name string) (*v1.Secret, error) {
 	return k8s.client.Secrets(namespace).Get(name, meta.GetOptions{})
 }
 
+func (k8s *K8sClient) CreateSecret(namespace string, secret *v1.Secret) (*v1.Secret, error) {
+	return k8s.client.Secrets(namespace).Create(secret)
+}
+
+func (k8s *K8sClient) UpdateSecret(namespace string, secret *v1.Secret) (*v1.Secret, error) {
+	return k8s.client.Secrets(namespace).Update(secret)
+}
+
+func (k8s *K8sClient) DeleteSecret(namespace, name string) error {
+	return k8s.client.Secrets(namespace).Delete(name, &meta.DeleteOptions{})
+}
+
+func (k8s *K8sClient) ListConfigMapsWithSelector(namespace string, selector map[string]string) (*v1.ConfigMapList, error) {
+	return k8s.client.
+		ConfigMaps(namespace).
+		List(meta.ListOptions{
+			LabelSelector: labels.SelectorFromSet(selector).String(),
+		})
+}
+
+func (k8s *K8sClient) GetConfigMap(namespace, name string) (*v1.ConfigMap, error) {
+	return k8s.client.ConfigMaps(namespace).Get(name, meta.GetOptions{})
+}
+
+func (k8s *K8sClient) CreateConfigMap(namespace string, configMap *v1.ConfigMap) (*v1.ConfigMap, error) {
+	return k8s.client.ConfigMaps(namespace).Create(configMap)
+}
+
+func (k8s *K8sClient) UpdateConfigMap(namespace string, configMap *v1.ConfigMap) (*v1.ConfigMap, error) {
+	return k8s.client.ConfigMaps(namespace).Update(configMap)
+}
+
+func (k8s *K8sClient) DeleteConfigMap(namespace, name string) error {
+	return k8s.client.ConfigMaps(namespace).Delete(name, &meta.DeleteOptions{})
+}
+
+func (k8s *K8sClient) GetHorizontalPodAutoscaler(namespace, name string) (*autoscaling.HorizontalPodAutoscaler, error) {
+	return k8s.client.AutoscalingV1().HorizontalPodAutoscalers(namespace).Get(name, meta.GetOptions{})
+}
+
+func (k8s *K8sClient) CreateHorizontalPodAutoscaler(namespace string, hpa *autoscaling.HorizontalPodAutoscaler) (*autoscaling.HorizontalPodAutoscaler, error) {
+	return k8s.client.AutoscalingV1().HorizontalPodAutoscalers(namespace).Create(hpa)
+}
+
+func (k8s *K8sClient) UpdateHorizontalPodAutoscaler(namespace string, hpa *autoscaling.HorizontalPodAutoscaler) (*autoscaling.HorizontalPodAutoscaler, error) {
+	return k8s.client.AutoscalingV1().HorizontalPodAutoscalers(namespace).Update(hpa)
+}
+
+func (k8s *K8sClient) DeleteHorizontalPodAutoscaler(namespace, name string) error {
+	return k8s.client.AutoscalingV1().HorizontalPodAutoscalers(namespace).Delete(name, &meta.DeleteOptions{})
+}
+
+func (k8s *K8sClient) GetPodDisruptionBudget(namespace, name string) (*policy.PodDisruptionBudget, error) {
+	return k8s.client.PolicyV1beta1().PodDisruptionBudgets(namespace).Get(name, meta.GetOptions{})
+}
+
+func (k8s *K8sClient) CreatePodDisruptionBudget(namespace string, pdb *policy.PodDisruptionBudget) (*policy.PodDisruptionBudget, error) {
+	return k8s.client.PolicyV1beta1().PodDisruptionBudgets(namespace).Create(pdb)
+}
+
+func (k8s *K8sClient) DeletePodDisruptionBudget(namespace, name string) error {
+	return k8s.client.PolicyV1beta1().PodDisruptionBudgets(namespace).Delete(name, &meta.DeleteOptions{})
+}
+
 func (k8s *K8sClient) ShutdownReplicationController(rc *v1.ReplicationController, logger logger.Logger) error {
 	logger.Printf("Scaling down replication controller: %v\n", rc.Name)
 
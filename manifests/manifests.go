@@ -0,0 +1,140 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package manifests
+
+import (
+	"bytes"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/cluster"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/proxies"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/secrets"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"github.com/ghodss/yaml"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// Objects returns all Kubernetes objects a deployment of the descriptor with the given version creates, built by the same code as
+// the deployment itself. The ingress configurator is only needed for descriptors with a frontend, it looks up the TLS secret.
+// With a resolver, env vars referencing a secret provider are injected from the provider secret like in a deployment.
+// Secrets are rendered with redacted values.
+func Objects(descriptor *types.Descriptor, version string, ingressConfigurator *proxies.IngressConfigurator, resolver *secrets.Resolver, logger logger.Logger) ([]interface{}, error) {
+	deployment := &types.Deployment{
+		Version:    version,
+		Descriptor: descriptor,
+	}
+	clusterManager := cluster.NewClusterManager(helper.DeployerConfig{SecretResolver: resolver}, deployment, nil, logger)
+
+	objects := []interface{}{}
+	if len(descriptor.SecretEnvironment) > 0 {
+		objects = append(objects, redactSecret(clusterManager.BuildEnvironmentSecret(), descriptor))
+	}
+	// the values of the references are only resolved when deploying
+	if references := resolver.References(descriptor); len(references) > 0 {
+		secret := clusterManager.BuildProviderSecret()
+		for key := range references {
+			secret.Data[key] = nil
+		}
+		objects = append(objects, redactSecret(secret, descriptor))
+	}
+
+	ctrl := clusterManager.BuildReplicationController()
+	ctrl.APIVersion = "v1"
+	ctrl.Kind = "ReplicationController"
+	ctrl.Namespace = descriptor.Namespace
+
+	service := clusterManager.BuildService()
+	service.APIVersion = "v1"
+	service.Kind = "Service"
+	service.Namespace = descriptor.Namespace
+
+	persistentService := clusterManager.BuildPersistentService(nil)
+	persistentService.APIVersion = "v1"
+	persistentService.Kind = "Service"
+	persistentService.Namespace = descriptor.Namespace
+
+	if configMap := clusterManager.BuildConfigMap(); configMap != nil {
+		configMap.APIVersion = "v1"
+		configMap.Kind = "ConfigMap"
+		configMap.Namespace = descriptor.Namespace
+		objects = append(objects, configMap)
+	}
+	objects = append(objects, ctrl, service, persistentService)
+	if autoscaler := clusterManager.BuildAutoscaler(nil); autoscaler != nil {
+		autoscaler.APIVersion = "autoscaling/v1"
+		autoscaler.Kind = "HorizontalPodAutoscaler"
+		autoscaler.Namespace = descriptor.Namespace
+		objects = append(objects, autoscaler)
+	}
+	if budget := clusterManager.BuildDisruptionBudget(); budget != nil {
+		budget.APIVersion = "policy/v1beta1"
+		budget.Kind = "PodDisruptionBudget"
+		budget.Namespace = descriptor.Namespace
+		objects = append(objects, budget)
+	}
+
+	if descriptor.Frontend == "" || len(service.Spec.Ports) == 0 {
+		return objects, nil
+	}
+
+	ingress, err := ingressConfigurator.BuildIngress(nil, descriptor, service, logger)
+	if err != nil {
+		return nil, err
+	}
+	ingress.APIVersion = "extensions/v1beta1"
+	ingress.Kind = "Ingress"
+	objects = append(objects, ingress)
+
+	if descriptor.RedirectWww {
+		wwwIngress := ingressConfigurator.BuildWwwRedirectIngress(nil, descriptor, persistentService, logger)
+		wwwIngress.APIVersion = "extensions/v1beta1"
+		wwwIngress.Kind = "Ingress"
+		objects = append(objects, wwwIngress)
+	}
+
+	return objects, nil
+}
+
+// redactSecret replaces the values of the secret, they don't belong in rendered manifests
+func redactSecret(secret *v1.Secret, descriptor *types.Descriptor) *v1.Secret {
+	for key := range secret.Data {
+		secret.Data[key] = []byte(types.REDACTED)
+	}
+	secret.APIVersion = "v1"
+	secret.Kind = "Secret"
+	secret.Namespace = descriptor.Namespace
+	return secret
+}
+
+// Render returns the Kubernetes objects of a deployment of the descriptor as multi-document YAML
+func Render(descriptor *types.Descriptor, version string, ingressConfigurator *proxies.IngressConfigurator, resolver *secrets.Resolver, logger logger.Logger) ([]byte, error) {
+	objects, err := Objects(descriptor, version, ingressConfigurator, resolver, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	var result bytes.Buffer
+	for _, object := range objects {
+		document, err := yaml.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		result.WriteString("---\n")
+		result.Write(document)
+	}
+	return result.Bytes(), nil
+}
@@ -0,0 +1,94 @@
+package manifests
+
+import (
+	"strings"
+	"testing"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/secrets"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestRender(t *testing.T) {
+	descriptor := &types.Descriptor{
+		Namespace: "test",
+		AppName:   "myapp",
+		Replicas:  2,
+		PodSpec: v1.PodSpec{Containers: []v1.Container{
+			{Name: "myapp", Image: "user/myapp:1.0", Ports: []v1.ContainerPort{{Name: "http", ContainerPort: 8080}}},
+		}},
+	}
+
+	rendered, err := Render(descriptor, "3", nil, nil, logger.NewConsoleLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	documents := strings.Split(strings.TrimPrefix(string(rendered), "---\n"), "---\n")
+	if len(documents) != 3 {
+		t.Fatalf("Expected 3 documents, got %v:\n%v", len(documents), string(rendered))
+	}
+
+	expected := []string{"kind: ReplicationController\n", "name: myapp-3\n", "replicas: 2\n", "image: user/myapp:1.0\n", "namespace: test\n"}
+	for _, part := range expected {
+		if !strings.Contains(documents[0], part) {
+			t.Errorf("Expected %q in replication controller:\n%v", part, documents[0])
+		}
+	}
+	if !strings.Contains(documents[1], "kind: Service\n") || !strings.Contains(documents[1], "name: myapp-3\n") {
+		t.Errorf("Unexpected versioned service:\n%v", documents[1])
+	}
+	if !strings.Contains(documents[2], "persistent: \"true\"\n") || !strings.Contains(documents[2], "name: myapp\n") {
+		t.Errorf("Unexpected persistent service:\n%v", documents[2])
+	}
+}
+
+func TestRenderSecrets(t *testing.T) {
+	descriptor := &types.Descriptor{
+		Namespace:         "test",
+		AppName:           "myapp",
+		Replicas:          1,
+		SecretEnvironment: map[string]string{"API_KEY": "s3cret"},
+		PodSpec: v1.PodSpec{Containers: []v1.Container{
+			{Name: "myapp", Image: "user/myapp:1.0", Env: []v1.EnvVar{{Name: "DB_PASS", Value: "vault:secret/data/app#dbpass"}}},
+		}},
+	}
+	resolver := secrets.NewResolver()
+	resolver.Register("vault", secrets.NewVaultProvider("http://vault", "token"))
+
+	objects, err := Objects(descriptor, "3", nil, resolver, logger.NewConsoleLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	environmentSecret, isSecret := objects[0].(*v1.Secret)
+	if !isSecret || environmentSecret.Name != "myapp-environment" || string(environmentSecret.Data["API_KEY"]) != types.REDACTED {
+		t.Errorf("Expected redacted environment secret, got %+v", objects[0])
+	}
+	providerSecret, isSecret := objects[1].(*v1.Secret)
+	if !isSecret || providerSecret.Name != "myapp-secrets" || string(providerSecret.Data["myapp-DB_PASS"]) != types.REDACTED {
+		t.Errorf("Expected redacted provider secret, got %+v", objects[1])
+	}
+
+	ctrl := objects[2].(*v1.ReplicationController)
+	refs := map[string]string{}
+	for _, env := range ctrl.Spec.Template.Spec.Containers[0].Env {
+		if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+			refs[env.Name] = env.ValueFrom.SecretKeyRef.Name + "/" + env.ValueFrom.SecretKeyRef.Key
+		} else if strings.HasPrefix(env.Value, "vault:") {
+			t.Errorf("Expected reference of %v to be injected from the provider secret", env.Name)
+		}
+	}
+	if refs["DB_PASS"] != "myapp-secrets/myapp-DB_PASS" || refs["API_KEY"] != "myapp-environment/API_KEY" {
+		t.Errorf("Unexpected secret key refs %v", refs)
+	}
+
+	rendered, err := Render(descriptor, "3", nil, resolver, logger.NewConsoleLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(rendered), "vault:") || strings.Contains(string(rendered), "s3cret") {
+		t.Errorf("Expected no secret values or references in rendered manifests:\n%v", string(rendered))
+	}
+}
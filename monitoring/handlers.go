@@ -0,0 +1,81 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package monitoring
+
+import (
+	"net/http"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"github.com/gorilla/mux"
+)
+
+type MonitorHandlers struct {
+	registry *etcdregistry.EtcdRegistry
+	monitor  *Monitor
+}
+
+func NewMonitorHandlers(registry *etcdregistry.EtcdRegistry, monitor *Monitor) *MonitorHandlers {
+	return &MonitorHandlers{registry, monitor}
+}
+
+func (m *MonitorHandlers) AppStatusHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	//TODO check namespaces of user
+	namespace := req.URL.Query().Get("namespace")
+	if namespace == "" {
+		helper.HandleError(writer, logger, 400, "Namespace parameter missing")
+		return
+	}
+
+	vars := mux.Vars(req)
+	appName := vars["name"]
+	if appName == "" {
+		helper.HandleError(writer, logger, 400, "App name missing")
+		return
+	}
+
+	logger.Printf("Getting status of app %v in namespace %v", appName, namespace)
+
+	deployments, err := m.registry.GetDeploymentsByAppName(namespace, appName)
+	if err != nil && err != etcdregistry.ErrDeploymentNotFound {
+		helper.HandleError(writer, logger, 500, "Error getting deployments of app %v: %v", appName, err)
+		return
+	}
+
+	var deployed *types.Deployment
+	for _, deployment := range deployments {
+		if deployment.Status == types.DEPLOYMENTSTATUS_DEPLOYED {
+			deployed = deployment
+			break
+		}
+	}
+	if deployed == nil {
+		helper.HandleNotFound(writer, logger, "No deployed version of app %v found", appName)
+		return
+	}
+
+	status, err := m.monitor.GetStatus(deployed)
+	if err != nil {
+		helper.HandleError(writer, logger, 500, "Error getting status of app %v: %v", appName, err)
+		return
+	}
+
+	helper.HandleSuccess(writer, logger, status, "Got status of app %v", appName)
+}
@@ -0,0 +1,380 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package monitoring
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/cluster"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/events"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	HEALTH_HEALTHY   = "HEALTHY"
+	HEALTH_DEGRADED  = "DEGRADED"
+	HEALTH_UNHEALTHY = "UNHEALTHY"
+	HEALTH_UNKNOWN   = "UNKNOWN"
+)
+
+type HealthSample struct {
+	Time    string `json:"time"`
+	Healthy bool   `json:"healthy"`
+	Value   string `json:"value"`
+}
+
+type PodStatus struct {
+	Name    string         `json:"name"`
+	Phase   string         `json:"phase"`
+	Healthy bool           `json:"healthy"`
+	History []HealthSample `json:"history"`
+}
+
+type ResourceStatus struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Message string `json:"message,omitempty"`
+}
+
+type AppStatus struct {
+	Namespace        string           `json:"namespace"`
+	AppName          string           `json:"appName"`
+	DeploymentId     string           `json:"deploymentId"`
+	Version          string           `json:"version"`
+	DeploymentStatus string           `json:"deploymentStatus"`
+	Health           string           `json:"health"`
+	ExpectedPods     int              `json:"expectedPods"`
+	HealthyPods      int              `json:"healthyPods"`
+	Pods             []PodStatus      `json:"pods"`
+	Resources        []ResourceStatus `json:"resources"`
+}
+
+// Monitor periodically runs the configured healthchecks against the pods of all deployed apps
+type Monitor struct {
+	config      helper.DeployerConfig
+	registry    *etcdregistry.EtcdRegistry
+	interval    time.Duration
+	historySize int
+	logger      logger.Logger
+
+	mutex sync.Mutex
+	// health samples per app and pod
+	history map[string]map[string][]HealthSample
+	// last aggregated health per app
+	health map[string]string
+}
+
+func NewMonitor(config helper.DeployerConfig, interval int, historySize int) *Monitor {
+	return &Monitor{
+		config:      config,
+		registry:    config.EtcdRegistry,
+		interval:    time.Duration(interval) * time.Second,
+		historySize: historySize,
+		logger:      logger.NewConsoleLogger(),
+		history:     map[string]map[string][]HealthSample{},
+		health:      map[string]string{},
+	}
+}
+
+func (monitor *Monitor) Run() {
+	monitor.logger.Printf("Starting health monitor with an interval of %v", monitor.interval)
+	for {
+		monitor.checkAll()
+		time.Sleep(monitor.interval)
+	}
+}
+
+func (monitor *Monitor) checkAll() {
+	deployments, err := monitor.registry.GetAllDeployments()
+	if err != nil {
+		monitor.logger.Printf("Health monitor: error getting deployments: %v", err.Error())
+		return
+	}
+
+	monitored := map[string]bool{}
+	for _, deployment := range deployments {
+		if deployment.Status != types.DEPLOYMENTSTATUS_DEPLOYED {
+			continue
+		}
+		key := appKey(deployment.Descriptor.Namespace, deployment.Descriptor.AppName)
+		monitored[key] = true
+
+		pods, err := monitor.listPods(deployment)
+		if err != nil {
+			monitor.logger.Printf("Health monitor: error listing pods of %v: %v", key, err.Error())
+			continue
+		}
+
+		monitor.probePods(deployment, pods)
+		status := monitor.buildStatus(deployment, pods)
+		monitor.updateHealth(deployment, status)
+	}
+
+	// forget apps which are not deployed anymore
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+	for key := range monitor.history {
+		if !monitored[key] {
+			delete(monitor.history, key)
+		}
+	}
+	for key := range monitor.health {
+		if !monitored[key] {
+			delete(monitor.health, key)
+		}
+	}
+}
+
+func (monitor *Monitor) probePods(deployment *types.Deployment, pods []v1.Pod) {
+	descriptor := deployment.Descriptor
+	key := appKey(descriptor.Namespace, descriptor.AppName)
+
+	samples := map[string]HealthSample{}
+	// pods checked with the health check of the descriptor, their health data is stored when it changes
+	checked := map[string]bool{}
+	for i := range pods {
+		pod := &pods[i]
+		sample := HealthSample{Time: time.Now().Format(time.RFC3339)}
+
+		if pod.Status.Phase != v1.PodRunning || pod.Status.PodIP == "" {
+			sample.Healthy = false
+			sample.Value = fmt.Sprintf("{\"phase\": \"%v\"}", pod.Status.Phase)
+		} else if descriptor.UseHealthCheck {
+			sample.Healthy, sample.Value, _ = cluster.CheckPodHealth(descriptor, pod)
+			checked[pod.Name] = true
+		} else {
+			sample.Healthy = isReady(pod)
+			sample.Value = fmt.Sprintf("{\"phase\": \"%v\", \"ready\": %v}", pod.Status.Phase, sample.Healthy)
+		}
+
+		samples[pod.Name] = sample
+	}
+
+	monitor.mutex.Lock()
+	oldHistory := monitor.history[key]
+	newHistory := map[string][]HealthSample{}
+	changed := []string{}
+	for podName, sample := range samples {
+		podHistory := oldHistory[podName]
+		if checked[podName] && (len(podHistory) == 0 || podHistory[len(podHistory)-1].Healthy != sample.Healthy) {
+			changed = append(changed, podName)
+		}
+		podHistory = append(podHistory, sample)
+		if len(podHistory) > monitor.historySize {
+			podHistory = podHistory[len(podHistory)-monitor.historySize:]
+		}
+		newHistory[podName] = podHistory
+	}
+	monitor.history[key] = newHistory
+	monitor.mutex.Unlock()
+
+	// storing the health data of every pod on every interval would keep etcd busy, so only store changes
+	for _, podName := range changed {
+		if err := monitor.registry.StoreHealth(descriptor.Namespace, deployment.Id, podName, samples[podName].Value); err != nil {
+			monitor.logger.Printf("Health monitor: error storing health of pod %v: %v", podName, err.Error())
+		}
+	}
+}
+
+func (monitor *Monitor) updateHealth(deployment *types.Deployment, status *AppStatus) {
+	key := appKey(status.Namespace, status.AppName)
+
+	monitor.mutex.Lock()
+	previous, known := monitor.health[key]
+	monitor.health[key] = status.Health
+	monitor.mutex.Unlock()
+
+	if previous == status.Health || (!known && status.Health == HEALTH_HEALTHY) {
+		return
+	}
+	if !known {
+		previous = HEALTH_UNKNOWN
+	}
+
+	monitor.logger.Printf("Health of %v changed from %v to %v", key, previous, status.Health)
+	monitor.config.Events.Publish(events.Event{
+		Type:         events.EVENT_HEALTH_CHANGED,
+		Namespace:    status.Namespace,
+		AppName:      status.AppName,
+		DeploymentId: deployment.Id,
+		Message:      fmt.Sprintf("Health changed from %v to %v", previous, status.Health),
+		Data: map[string]interface{}{
+			"previous":     previous,
+			"current":      status.Health,
+			"healthyPods":  status.HealthyPods,
+			"expectedPods": status.ExpectedPods,
+		},
+	})
+}
+
+// GetStatus returns the aggregated status of the deployed version of the given app
+func (monitor *Monitor) GetStatus(deployment *types.Deployment) (*AppStatus, error) {
+	pods, err := monitor.listPods(deployment)
+	if err != nil {
+		return nil, err
+	}
+	return monitor.buildStatus(deployment, pods), nil
+}
+
+func (monitor *Monitor) listPods(deployment *types.Deployment) ([]v1.Pod, error) {
+	selector := map[string]string{"app": deployment.Descriptor.AppName, "version": deployment.Version}
+	pods, err := monitor.config.K8sClient.ListPodsWithSelector(deployment.Descriptor.Namespace, selector)
+	if err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
+func (monitor *Monitor) buildStatus(deployment *types.Deployment, pods []v1.Pod) *AppStatus {
+	descriptor := deployment.Descriptor
+
+	status := &AppStatus{
+		Namespace:        descriptor.Namespace,
+		AppName:          descriptor.AppName,
+		DeploymentId:     deployment.Id,
+		Version:          deployment.Version,
+		DeploymentStatus: deployment.Status,
+		ExpectedPods:     monitor.expectedPods(deployment),
+		Pods:             []PodStatus{},
+		Resources:        []ResourceStatus{},
+	}
+
+	monitor.mutex.Lock()
+	history := monitor.history[appKey(descriptor.Namespace, descriptor.AppName)]
+	for _, pod := range pods {
+		podHistory := append([]HealthSample{}, history[pod.Name]...)
+		podStatus := PodStatus{Name: pod.Name, Phase: string(pod.Status.Phase), History: podHistory}
+		if len(podHistory) > 0 {
+			podStatus.Healthy = podHistory[len(podHistory)-1].Healthy
+		} else {
+			podStatus.Healthy = pod.Status.Phase == v1.PodRunning && isReady(&pod)
+		}
+		if podStatus.Healthy {
+			status.HealthyPods++
+		}
+		status.Pods = append(status.Pods, podStatus)
+	}
+	monitor.mutex.Unlock()
+
+	status.Resources = append(status.Resources, monitor.checkPersistentService(deployment))
+	versionedService := monitor.checkVersionedService(deployment)
+	status.Resources = append(status.Resources, versionedService)
+	if descriptor.Frontend != "" {
+		status.Resources = append(status.Resources, monitor.checkIngress(deployment))
+	}
+
+	resourcesHealthy := true
+	for _, resource := range status.Resources {
+		resourcesHealthy = resourcesHealthy && resource.Healthy
+	}
+
+	if status.HealthyPods == 0 && status.ExpectedPods > 0 {
+		status.Health = HEALTH_UNHEALTHY
+	} else if status.HealthyPods < status.ExpectedPods || !resourcesHealthy {
+		status.Health = HEALTH_DEGRADED
+	} else {
+		status.Health = HEALTH_HEALTHY
+	}
+
+	return status
+}
+
+// expectedPods returns the replicas of the deployment, with autoscaling the replicas the autoscaler scaled the replication controller to
+func (monitor *Monitor) expectedPods(deployment *types.Deployment) int {
+	settings := deployment.Descriptor.Autoscaling
+	if settings == nil {
+		return deployment.GetReplicas()
+	}
+	rc, err := monitor.config.K8sClient.GetReplicationController(deployment.Descriptor.Namespace, deployment.GetVersionedName())
+	if err != nil || rc.Spec.Replicas == nil {
+		return settings.MinReplicas
+	}
+	return int(*rc.Spec.Replicas)
+}
+
+func (monitor *Monitor) checkPersistentService(deployment *types.Deployment) ResourceStatus {
+	descriptor := deployment.Descriptor
+	result := ResourceStatus{Kind: "Service", Name: descriptor.AppName}
+
+	svc, err := monitor.config.K8sClient.GetService(descriptor.Namespace, descriptor.AppName)
+	if err != nil {
+		result.Message = "Error getting service: " + err.Error()
+	} else if svc.Spec.Selector["version"] != deployment.Version {
+		result.Message = fmt.Sprintf("Service selects version %v instead of %v", svc.Spec.Selector["version"], deployment.Version)
+	} else {
+		result.Healthy = true
+	}
+	return result
+}
+
+func (monitor *Monitor) checkVersionedService(deployment *types.Deployment) ResourceStatus {
+	descriptor := deployment.Descriptor
+	result := ResourceStatus{Kind: "Service", Name: deployment.GetVersionedName()}
+
+	_, err := monitor.config.K8sClient.GetService(descriptor.Namespace, deployment.GetVersionedName())
+	if err != nil {
+		result.Message = "Error getting service: " + err.Error()
+	} else {
+		result.Healthy = true
+	}
+	return result
+}
+
+func (monitor *Monitor) checkIngress(deployment *types.Deployment) ResourceStatus {
+	descriptor := deployment.Descriptor
+	result := ResourceStatus{Kind: "Ingress", Name: descriptor.AppName}
+
+	ingress, err := monitor.config.K8sClient.GetIngress(descriptor.Namespace, descriptor.AppName)
+	if err != nil {
+		result.Message = "Error getting ingress: " + err.Error()
+		return result
+	}
+
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host != descriptor.Frontend || rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.ServiceName == deployment.GetVersionedName() {
+				result.Healthy = true
+				return result
+			}
+		}
+	}
+
+	result.Message = fmt.Sprintf("Ingress has no rule for %v pointing to service %v", descriptor.Frontend, deployment.GetVersionedName())
+	return result
+}
+
+func isReady(pod *v1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func appKey(namespace, appName string) string {
+	return namespace + "/" + appName
+}
@@ -0,0 +1,243 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry/etcdtest"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/events"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/k8s"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func newDeployment() *types.Deployment {
+	return &types.Deployment{
+		Id:         "d1",
+		Version:    "2",
+		Status:     types.DEPLOYMENTSTATUS_DEPLOYED,
+		Descriptor: &types.Descriptor{Namespace: "test", AppName: "myapp", Replicas: 2},
+	}
+}
+
+func newPod(name string, ready bool) v1.Pod {
+	status := v1.ConditionFalse
+	if ready {
+		status = v1.ConditionTrue
+	}
+	return v1.Pod{
+		ObjectMeta: meta.ObjectMeta{Name: name},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "myapp"}}},
+		Status: v1.PodStatus{
+			Phase:      v1.PodRunning,
+			PodIP:      "127.0.0.1",
+			Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: status}},
+		},
+	}
+}
+
+// fakeApiServer returns the given objects by path, and a not found status for all other paths
+func fakeApiServer(objects map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		object, found := objects[req.URL.Path]
+		if !found {
+			writer.WriteHeader(404)
+			object = meta.Status{TypeMeta: meta.TypeMeta{Kind: "Status", APIVersion: "v1"}, Status: meta.StatusFailure,
+				Reason: meta.StatusReasonNotFound, Code: 404}
+		}
+		json.NewEncoder(writer).Encode(object)
+	}))
+}
+
+func newService(name string, version string) v1.Service {
+	return v1.Service{
+		TypeMeta:   meta.TypeMeta{Kind: "Service", APIVersion: "v1"},
+		ObjectMeta: meta.ObjectMeta{Name: name, Namespace: "test"},
+		Spec:       v1.ServiceSpec{Selector: map[string]string{"app": "myapp", "version": version}},
+	}
+}
+
+func TestHistoryIsBounded(t *testing.T) {
+	monitor := NewMonitor(helper.DeployerConfig{EtcdRegistry: etcdregistry.NewEtcdRegistry(etcdtest.NewKeysAPI())}, 1, 3)
+	deployment := newDeployment()
+
+	for i := 0; i < 5; i++ {
+		monitor.probePods(deployment, []v1.Pod{newPod("pod-1", true), newPod("pod-2", i%2 == 0)})
+	}
+
+	history := monitor.history[appKey("test", "myapp")]
+	if len(history["pod-1"]) != 3 || len(history["pod-2"]) != 3 {
+		t.Fatalf("Expected 3 samples per pod, got %v and %v", len(history["pod-1"]), len(history["pod-2"]))
+	}
+	// the last samples are kept: ready, not ready, ready
+	for i, expected := range []bool{true, false, true} {
+		if history["pod-2"][i].Healthy != expected {
+			t.Errorf("Sample %v: expected healthy %v", i, expected)
+		}
+	}
+
+	// pods which are gone are forgotten
+	monitor.probePods(deployment, []v1.Pod{newPod("pod-1", true)})
+	history = monitor.history[appKey("test", "myapp")]
+	if _, found := history["pod-2"]; found || len(history["pod-1"]) != 3 {
+		t.Errorf("Unexpected history %+v", history)
+	}
+}
+
+func TestBuildStatus(t *testing.T) {
+	deployment := newDeployment()
+	server := fakeApiServer(map[string]interface{}{
+		"/api/v1/namespaces/test/services/myapp":   newService("myapp", "2"),
+		"/api/v1/namespaces/test/services/myapp-2": newService("myapp-2", "2"),
+	})
+	defer server.Close()
+	oldServer := fakeApiServer(map[string]interface{}{
+		"/api/v1/namespaces/test/services/myapp":   newService("myapp", "1"),
+		"/api/v1/namespaces/test/services/myapp-2": newService("myapp-2", "2"),
+	})
+	defer oldServer.Close()
+
+	tests := []struct {
+		server      *httptest.Server
+		pods        []v1.Pod
+		health      string
+		healthyPods int
+	}{
+		{server, []v1.Pod{newPod("pod-1", true), newPod("pod-2", true)}, HEALTH_HEALTHY, 2},
+		{server, []v1.Pod{newPod("pod-1", true), newPod("pod-2", false)}, HEALTH_DEGRADED, 1},
+		{server, []v1.Pod{newPod("pod-1", true)}, HEALTH_DEGRADED, 1},
+		{server, []v1.Pod{newPod("pod-1", false), newPod("pod-2", false)}, HEALTH_UNHEALTHY, 0},
+		{server, []v1.Pod{}, HEALTH_UNHEALTHY, 0},
+		// the persistent service still selects the previous version
+		{oldServer, []v1.Pod{newPod("pod-1", true), newPod("pod-2", true)}, HEALTH_DEGRADED, 2},
+	}
+
+	for i, test := range tests {
+		k8sClient, err := k8s.New(k8s.K8sConfig{ApiServerUrl: test.server.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		monitor := NewMonitor(helper.DeployerConfig{K8sClient: k8sClient}, 1, 3)
+
+		status := monitor.buildStatus(deployment, test.pods)
+		if status.Health != test.health || status.HealthyPods != test.healthyPods || status.ExpectedPods != 2 {
+			t.Errorf("Test %v: expected %v with %v healthy pods, got %v with %v of %v", i, test.health, test.healthyPods,
+				status.Health, status.HealthyPods, status.ExpectedPods)
+		}
+	}
+}
+
+func TestBuildStatusUsesHistory(t *testing.T) {
+	deployment := newDeployment()
+	deployment.Descriptor.Replicas = 1
+	server := fakeApiServer(map[string]interface{}{
+		"/api/v1/namespaces/test/services/myapp":   newService("myapp", "2"),
+		"/api/v1/namespaces/test/services/myapp-2": newService("myapp-2", "2"),
+	})
+	defer server.Close()
+	k8sClient, err := k8s.New(k8s.K8sConfig{ApiServerUrl: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	monitor := NewMonitor(helper.DeployerConfig{K8sClient: k8sClient}, 1, 3)
+
+	// the last sample wins over the readiness of the pod
+	monitor.history[appKey("test", "myapp")] = map[string][]HealthSample{"pod-1": {{Healthy: true}, {Healthy: false}}}
+	status := monitor.buildStatus(deployment, []v1.Pod{newPod("pod-1", true)})
+	if status.Health != HEALTH_UNHEALTHY || len(status.Pods) != 1 || len(status.Pods[0].History) != 2 {
+		t.Errorf("Unexpected status %+v", status)
+	}
+}
+
+func TestUpdateHealthPublishesChanges(t *testing.T) {
+	bus := events.NewBus()
+	subscriber := bus.Subscribe()
+	defer bus.Unsubscribe(subscriber)
+	monitor := NewMonitor(helper.DeployerConfig{Events: bus}, 1, 3)
+	deployment := newDeployment()
+
+	for _, health := range []string{HEALTH_HEALTHY, HEALTH_HEALTHY, HEALTH_DEGRADED, HEALTH_DEGRADED, HEALTH_UNHEALTHY} {
+		monitor.updateHealth(deployment, &AppStatus{Namespace: "test", AppName: "myapp", Health: health})
+	}
+	// an app which is unhealthy when it is first checked
+	monitor.updateHealth(deployment, &AppStatus{Namespace: "test", AppName: "other", Health: HEALTH_UNHEALTHY})
+
+	expected := [][2]string{
+		{HEALTH_HEALTHY, HEALTH_DEGRADED},
+		{HEALTH_DEGRADED, HEALTH_UNHEALTHY},
+		{HEALTH_UNKNOWN, HEALTH_UNHEALTHY},
+	}
+	for _, change := range expected {
+		select {
+		case event := <-subscriber:
+			data, _ := event.Data.(map[string]interface{})
+			if event.Type != events.EVENT_HEALTH_CHANGED || data["previous"] != change[0] || data["current"] != change[1] {
+				t.Errorf("Expected change from %v to %v, got %+v", change[0], change[1], event)
+			}
+		default:
+			t.Fatalf("Expected change from %v to %v, got no event", change[0], change[1])
+		}
+	}
+	select {
+	case event := <-subscriber:
+		t.Errorf("Unexpected event %+v", event)
+	default:
+	}
+}
+
+func TestHealthIsStoredOnChange(t *testing.T) {
+	healthy := true
+	healthServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		if !healthy {
+			writer.WriteHeader(500)
+		}
+	}))
+	defer healthServer.Close()
+	_, port, err := net.SplitHostPort(healthServer.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	portNumber, _ := strconv.Atoi(port)
+
+	registry := etcdregistry.NewEtcdRegistry(etcdtest.NewKeysAPI())
+	monitor := NewMonitor(helper.DeployerConfig{EtcdRegistry: registry}, 1, 3)
+	deployment := newDeployment()
+	deployment.Descriptor.UseHealthCheck = true
+	deployment.Descriptor.HealthCheckType = "simple"
+	pod := newPod("pod-1", true)
+	pod.Spec.Containers[0].Ports = []v1.ContainerPort{{ContainerPort: int32(portNumber)}}
+
+	storedHealth := func() string {
+		health, err := registry.GetHealth("test", "d1")
+		if err != nil || len(health) != 1 {
+			t.Fatalf("Expected health of one pod, got %+v, %v", health, err)
+		}
+		return health[0].Value
+	}
+
+	monitor.probePods(deployment, []v1.Pod{pod})
+	if value := storedHealth(); value != "{\"simplehealthcheck\": \"http get success\"}" {
+		t.Errorf("Unexpected health %v", value)
+	}
+
+	// unchanged health isn't stored again
+	registry.StoreHealth("test", "d1", "pod-1", "marker")
+	monitor.probePods(deployment, []v1.Pod{pod})
+	if value := storedHealth(); value != "marker" {
+		t.Errorf("Expected unchanged health not to be stored, got %v", value)
+	}
+
+	healthy = false
+	monitor.probePods(deployment, []v1.Pod{pod})
+	if value := storedHealth(); value != "{\"simplehealthcheck\": \"http get statuscode != 200\"}" {
+		t.Errorf("Expected changed health to be stored, got %v", value)
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package notifications
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/events"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	HEADER_EVENT     = "X-Deployer-Event"
+	HEADER_DELIVERY  = "X-Deployer-Delivery"
+	HEADER_SIGNATURE = "X-Deployer-Signature"
+)
+
+// Dispatcher sends deployment lifecycle events to all matching notification webhooks
+type Dispatcher struct {
+	registry    *etcdregistry.EtcdRegistry
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	logger      logger.Logger
+}
+
+func NewDispatcher(registry *etcdregistry.EtcdRegistry, maxAttempts int, backoff time.Duration) *Dispatcher {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &Dispatcher{
+		registry:    registry,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		logger:      logger.NewConsoleLogger(),
+	}
+}
+
+func (dispatcher *Dispatcher) Run(bus *events.Bus) {
+	for event := range bus.Subscribe() {
+		if !isLifecycleEvent(event.Type) {
+			continue
+		}
+
+		notifications, err := dispatcher.registry.GetNotifications()
+		if err != nil {
+			dispatcher.logger.Printf("Error getting notifications for event %v: %v", event.Type, err.Error())
+			continue
+		}
+
+		for _, notification := range notifications {
+			if Matches(notification, event) {
+				go dispatcher.deliver(notification, event)
+			}
+		}
+	}
+}
+
+func (dispatcher *Dispatcher) deliver(notification *types.Notification, event events.Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		dispatcher.logger.Printf("Error marshalling event %v: %v", event.Type, err.Error())
+		return
+	}
+
+	delivery := dispatcher.send(notification, event.Type, payload)
+	delivery.DeploymentId = event.DeploymentId
+
+	if !delivery.Success {
+		dispatcher.logger.Printf("Delivery of event %v to %v failed after %v attempts", event.Type, notification.Url, len(delivery.Attempts))
+	}
+
+	if err := dispatcher.registry.StoreNotificationDelivery(delivery); err != nil {
+		dispatcher.logger.Printf("Error storing notification delivery: %v", err.Error())
+	}
+}
+
+// send posts the payload to the notification url, and retries with exponential backoff on failures
+func (dispatcher *Dispatcher) send(notification *types.Notification, eventType string, payload []byte) *types.NotificationDelivery {
+	delivery := &types.NotificationDelivery{
+		Id:             time.Now().UTC().Format("20060102150405") + "-" + uuid.NewV4().String(),
+		NotificationId: notification.Id,
+		Event:          eventType,
+		Url:            notification.Url,
+		Attempts:       []types.NotificationAttempt{},
+	}
+
+	backoff := dispatcher.backoff
+	for i := 0; i < dispatcher.maxAttempts; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		attempt := types.NotificationAttempt{Time: time.Now().Format(time.RFC3339)}
+		statusCode, err := dispatcher.post(notification, eventType, delivery.Id, payload)
+		attempt.StatusCode = statusCode
+		if err != nil {
+			attempt.Error = err.Error()
+		}
+		delivery.Attempts = append(delivery.Attempts, attempt)
+
+		if err == nil {
+			delivery.Success = true
+			break
+		}
+	}
+
+	return delivery
+}
+
+func (dispatcher *Dispatcher) post(notification *types.Notification, eventType string, deliveryId string, payload []byte) (int, error) {
+	req, err := http.NewRequest("POST", notification.Url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HEADER_EVENT, eventType)
+	req.Header.Set(HEADER_DELIVERY, deliveryId)
+	if notification.Secret != "" {
+		req.Header.Set(HEADER_SIGNATURE, Sign(notification.Secret, payload))
+	}
+
+	resp, err := dispatcher.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code %v", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the HMAC-SHA256 signature of the payload in the format "sha256=<hex>"
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Matches checks if the notification is interested in the given event
+func Matches(notification *types.Notification, event events.Event) bool {
+	if notification.Namespace != "" && notification.Namespace != event.Namespace {
+		return false
+	}
+	if notification.AppName != "" && notification.AppName != event.AppName {
+		return false
+	}
+	if len(notification.Events) == 0 {
+		return true
+	}
+	for _, eventType := range notification.Events {
+		if eventType == event.Type {
+			return true
+		}
+	}
+	return false
+}
+
+func isLifecycleEvent(eventType string) bool {
+	for _, lifecycleEvent := range events.DeploymentLifecycleEvents {
+		if eventType == lifecycleEvent {
+			return true
+		}
+	}
+	return false
+}
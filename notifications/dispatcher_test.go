@@ -0,0 +1,107 @@
+package notifications
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/events"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+)
+
+func TestSendSignsPayload(t *testing.T) {
+	payload := []byte(`{"type":"deployment.started"}`)
+
+	var signature, eventType string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		signature = req.Header.Get(HEADER_SIGNATURE)
+		eventType = req.Header.Get(HEADER_EVENT)
+		body, _ = ioutil.ReadAll(req.Body)
+	}))
+	defer server.Close()
+
+	dispatcher := NewDispatcher(nil, 1, time.Millisecond)
+	notification := &types.Notification{Id: "n1", Url: server.URL, Secret: "topsecret"}
+	delivery := dispatcher.send(notification, events.EVENT_DEPLOYMENT_STARTED, payload)
+
+	if !delivery.Success || len(delivery.Attempts) != 1 {
+		t.Fatalf("Expected successful delivery with 1 attempt, got %+v", delivery)
+	}
+	if string(body) != string(payload) {
+		t.Errorf("Unexpected body %v", string(body))
+	}
+	if eventType != events.EVENT_DEPLOYMENT_STARTED {
+		t.Errorf("Unexpected event header %v", eventType)
+	}
+	if signature != Sign("topsecret", payload) {
+		t.Errorf("Unexpected signature %v", signature)
+	}
+}
+
+func TestSendRetriesOnFailure(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls < 3 {
+			writer.WriteHeader(503)
+		}
+	}))
+	defer server.Close()
+
+	dispatcher := NewDispatcher(nil, 5, time.Millisecond)
+	delivery := dispatcher.send(&types.Notification{Url: server.URL}, events.EVENT_DEPLOYMENT_FAILED, []byte("{}"))
+
+	if !delivery.Success {
+		t.Error("Expected successful delivery")
+	}
+	if len(delivery.Attempts) != 3 {
+		t.Errorf("Expected 3 attempts, got %v", len(delivery.Attempts))
+	}
+	if delivery.Attempts[0].StatusCode != 503 || delivery.Attempts[0].Error == "" {
+		t.Errorf("Expected failed first attempt, got %+v", delivery.Attempts[0])
+	}
+}
+
+func TestSendGivesUp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		writer.WriteHeader(500)
+	}))
+	defer server.Close()
+
+	dispatcher := NewDispatcher(nil, 2, time.Millisecond)
+	delivery := dispatcher.send(&types.Notification{Url: server.URL}, events.EVENT_DEPLOYMENT_FAILED, []byte("{}"))
+
+	if delivery.Success || len(delivery.Attempts) != 2 {
+		t.Errorf("Expected failed delivery with 2 attempts, got %+v", delivery)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	event := events.Event{Type: events.EVENT_DEPLOYMENT_SUCCEEDED, Namespace: "test", AppName: "myapp"}
+
+	matching := []*types.Notification{
+		{},
+		{Namespace: "test"},
+		{Namespace: "test", AppName: "myapp"},
+		{Events: []string{events.EVENT_DEPLOYMENT_FAILED, events.EVENT_DEPLOYMENT_SUCCEEDED}},
+	}
+	for _, notification := range matching {
+		if !Matches(notification, event) {
+			t.Errorf("Expected match for %+v", notification)
+		}
+	}
+
+	notMatching := []*types.Notification{
+		{Namespace: "prod"},
+		{Namespace: "test", AppName: "otherapp"},
+		{Events: []string{events.EVENT_DEPLOYMENT_FAILED}},
+	}
+	for _, notification := range notMatching {
+		if Matches(notification, event) {
+			t.Errorf("Expected no match for %+v", notification)
+		}
+	}
+}
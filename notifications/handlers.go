@@ -0,0 +1,217 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package notifications
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"github.com/gorilla/mux"
+	"github.com/satori/go.uuid"
+)
+
+// secrets are never returned, updates with this value keep the stored secret
+const REDACTED_SECRET = "******"
+
+type NotificationHandlers struct {
+	registry *etcdregistry.EtcdRegistry
+}
+
+func NewNotificationHandlers(registry *etcdregistry.EtcdRegistry) *NotificationHandlers {
+	return &NotificationHandlers{registry}
+}
+
+func (n *NotificationHandlers) CreateNotificationHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+	logger.Println("Creating notification")
+
+	notification, err := readNotification(req)
+	if err != nil {
+		helper.HandleError(writer, logger, 400, "Error parsing body: %v", err)
+		return
+	}
+
+	if notification.Id != "" {
+		helper.HandleError(writer, logger, 400, "Id must not be set")
+		return
+	}
+
+	if err := notification.Validate(); err != nil {
+		helper.HandleError(writer, logger, 400, "Invalid notification: %v", err)
+		return
+	}
+
+	notification.Id = uuid.NewV4().String()
+	if err := n.registry.CreateNotification(notification); err != nil {
+		helper.HandleError(writer, logger, 500, "Error storing notification: %v", err)
+		return
+	}
+
+	helper.HandleCreated(writer, logger, "/notifications/"+notification.Id+"/", "Notification created: %v", notification.Id)
+}
+
+func (n *NotificationHandlers) ListNotificationsHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	namespace := req.URL.Query().Get("namespace")
+	appname := req.URL.Query().Get("appname")
+
+	logger.Printf("Listing notifications for namespace '%v' and app '%v'", namespace, appname)
+
+	notifications, err := n.registry.GetNotifications()
+	if err != nil {
+		helper.HandleError(writer, logger, 500, "Error getting notifications: %v", err)
+		return
+	}
+
+	result := []*types.Notification{}
+	for _, notification := range notifications {
+		if namespace != "" && notification.Namespace != namespace {
+			continue
+		}
+		if appname != "" && notification.AppName != appname {
+			continue
+		}
+		redact(notification)
+		result = append(result, notification)
+	}
+
+	helper.HandleSuccess(writer, logger, result, "Successfully listed notifications")
+}
+
+func (n *NotificationHandlers) GetNotificationHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	id := mux.Vars(req)["id"]
+	notification, err := n.registry.GetNotificationById(id)
+	if err == etcdregistry.ErrNotificationNotFound {
+		helper.HandleNotFound(writer, logger, "Notification %v not found", id)
+		return
+	} else if err != nil {
+		helper.HandleError(writer, logger, 500, "Error getting notification %v: %v", id, err)
+		return
+	}
+
+	redact(notification)
+	helper.HandleSuccess(writer, logger, notification, "Notification %v found", id)
+}
+
+func (n *NotificationHandlers) UpdateNotificationHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+	logger.Println("Updating notification")
+
+	id := mux.Vars(req)["id"]
+
+	notification, err := readNotification(req)
+	if err != nil {
+		helper.HandleError(writer, logger, 400, "Error parsing body: %v", err)
+		return
+	}
+
+	if notification.Id != id {
+		helper.HandleError(writer, logger, 400, "Notification id does not match id parameter")
+		return
+	}
+
+	if err := notification.Validate(); err != nil {
+		helper.HandleError(writer, logger, 400, "Invalid notification: %v", err)
+		return
+	}
+
+	oldNotification, err := n.registry.GetNotificationById(id)
+	if err == etcdregistry.ErrNotificationNotFound {
+		helper.HandleNotFound(writer, logger, "Notification %v not found", id)
+		return
+	} else if err != nil {
+		helper.HandleError(writer, logger, 500, "Error getting notification %v: %v", id, err)
+		return
+	}
+
+	if notification.Secret == REDACTED_SECRET {
+		notification.Secret = oldNotification.Secret
+	}
+	notification.Created = oldNotification.Created
+
+	if err := n.registry.UpdateNotification(notification); err != nil {
+		helper.HandleError(writer, logger, 500, "Error updating notification: %v", err)
+		return
+	}
+
+	helper.HandleSuccess(writer, logger, "", "Notification updated: %v", id)
+}
+
+func (n *NotificationHandlers) DeleteNotificationHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+	logger.Println("Deleting notification")
+
+	id := mux.Vars(req)["id"]
+	err := n.registry.DeleteNotification(id)
+	if err == etcdregistry.ErrNotificationNotFound {
+		helper.HandleNotFound(writer, logger, "Notification %v not found", id)
+		return
+	} else if err != nil {
+		helper.HandleError(writer, logger, 500, "Error deleting notification %v: %v", id, err)
+		return
+	}
+
+	helper.HandleSuccess(writer, logger, "", "Notification %v deleted", id)
+}
+
+func (n *NotificationHandlers) ListDeliveriesHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	id := mux.Vars(req)["id"]
+	if _, err := n.registry.GetNotificationById(id); err == etcdregistry.ErrNotificationNotFound {
+		helper.HandleNotFound(writer, logger, "Notification %v not found", id)
+		return
+	} else if err != nil {
+		helper.HandleError(writer, logger, 500, "Error getting notification %v: %v", id, err)
+		return
+	}
+
+	deliveries, err := n.registry.GetNotificationDeliveries(id)
+	if err != nil {
+		helper.HandleError(writer, logger, 500, "Error getting deliveries of notification %v: %v", id, err)
+		return
+	}
+
+	helper.HandleSuccess(writer, logger, deliveries, "Successfully listed deliveries of notification %v", id)
+}
+
+func readNotification(req *http.Request) (*types.Notification, error) {
+	defer req.Body.Close()
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	notification := &types.Notification{}
+	if err := json.Unmarshal(body, notification); err != nil {
+		return nil, err
+	}
+	return notification, nil
+}
+
+func redact(notification *types.Notification) {
+	if notification.Secret != "" {
+		notification.Secret = REDACTED_SECRET
+	}
+}
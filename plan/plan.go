@@ -0,0 +1,454 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/cluster"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/diff"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/proxies"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	autoscaling "k8s.io/client-go/pkg/apis/autoscaling/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+)
+
+const (
+	ACTION_CREATE    = "create"
+	ACTION_UPDATE    = "update"
+	ACTION_DELETE    = "delete"
+	ACTION_UNCHANGED = "unchanged"
+)
+
+const (
+	KIND_REPLICATIONCONTROLLER = "ReplicationController"
+	KIND_SERVICE               = "Service"
+	KIND_INGRESS               = "Ingress"
+	KIND_CONFIGMAP             = "ConfigMap"
+	KIND_AUTOSCALER            = "HorizontalPodAutoscaler"
+	KIND_DISRUPTIONBUDGET      = "PodDisruptionBudget"
+)
+
+// fields which are set by Kubernetes, or which change on every deployment
+var IgnoredFields = []string{
+	"metadata.uid",
+	"metadata.resourceVersion",
+	"metadata.selfLink",
+	"metadata.creationTimestamp",
+	"metadata.generation",
+	"metadata.namespace",
+	"metadata.annotations.deploymentTs",
+	"metadata.annotations.deploymentId",
+	"status",
+	"spec.clusterIP",
+	"*.terminationMessagePath",
+	"*.terminationMessagePolicy",
+	"*.imagePullPolicy",
+	"*.dnsPolicy",
+	"*.restartPolicy",
+	"*.securityContext",
+	"*.schedulerName",
+	"*.terminationGracePeriodSeconds",
+}
+
+// ObjectChange is the planned change of a single Kubernetes object.
+// For new versioned objects the diff is against the object of the current version.
+type ObjectChange struct {
+	Kind   string        `json:"kind"`
+	Name   string        `json:"name"`
+	Action string        `json:"action"`
+	Diff   []diff.Change `json:"diff,omitempty"`
+	Object interface{}   `json:"object,omitempty"`
+}
+
+type Plan struct {
+	Namespace      string          `json:"namespace"`
+	AppName        string          `json:"appName"`
+	DescriptorId   string          `json:"descriptorId,omitempty"`
+	Version        string          `json:"version"`
+	CurrentVersion string          `json:"currentVersion,omitempty"`
+	Changes        []*ObjectChange `json:"changes"`
+	Warnings       []string        `json:"warnings,omitempty"`
+
+	// env vars whose values are redacted in the objects and diffs
+	sensitiveFields types.SensitiveFields
+}
+
+type Planner struct {
+	config          helper.DeployerConfig
+	sensitiveFields types.SensitiveFields
+}
+
+func NewPlanner(config helper.DeployerConfig) *Planner {
+	return &Planner{config: config}
+}
+
+// SetSensitiveFields lets the planner redact the values of the sensitive env vars in the objects and diffs of plans
+func (p *Planner) SetSensitiveFields(fields types.SensitiveFields) {
+	p.sensitiveFields = fields
+}
+
+// Plan returns the changes a deployment of the descriptor would make to the cluster, without changing anything.
+// It uses the same code for building the Kubernetes objects as the deployment itself.
+func (p *Planner) Plan(descriptor *types.Descriptor, logger logger.Logger) (*Plan, error) {
+	k8sClient := p.config.K8sClient
+
+	deployment := &types.Deployment{
+		Id:         "plan",
+		Created:    time.Now().Format(time.RFC3339),
+		Descriptor: descriptor,
+	}
+	deployment.SetVersion()
+
+	clusterManager := cluster.NewClusterManager(p.config, deployment, p.config.EtcdRegistry, logger)
+	version, err := clusterManager.ResolveVersion(false)
+	if err != nil {
+		return nil, err
+	}
+	deployment.Version = version
+
+	environment, err := p.config.EtcdRegistry.GetEnvironmentVars(descriptor.Namespace, descriptor.AppName)
+	if err != nil {
+		logger.Printf("Error getting environment vars: %v", err)
+	}
+	descriptor.SetEnvironment(environment)
+
+	plan := &Plan{
+		Namespace:    descriptor.Namespace,
+		AppName:      descriptor.AppName,
+		DescriptorId: descriptor.Id,
+		Version:      version,
+		Changes:      []*ObjectChange{},
+
+		sensitiveFields: p.sensitiveFields,
+	}
+
+	oldControllers, err := clusterManager.FindOldReplicationControllers()
+	if err != nil {
+		return nil, err
+	}
+	var currentController *v1.ReplicationController
+	for i, ctrl := range oldControllers {
+		if ctrl.DeletionTimestamp == nil {
+			currentController = &oldControllers[i]
+			plan.CurrentVersion = ctrl.Labels["version"]
+		}
+	}
+
+	oldServices, err := clusterManager.FindOldServices()
+	if err != nil {
+		return nil, err
+	}
+	var currentService *v1.Service
+	for i, service := range oldServices {
+		if plan.CurrentVersion != "" && service.Labels["version"] == plan.CurrentVersion {
+			currentService = &oldServices[i]
+		}
+	}
+
+	if _, err := k8sClient.GetService(descriptor.Namespace, deployment.GetVersionedName()); err == nil {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("Version %v is already deployed, the deployment would fail", version))
+	} else if !isNotFound(err) {
+		return nil, err
+	}
+
+	oldConfigMaps, err := clusterManager.FindOldConfigMaps()
+	if err != nil {
+		return nil, err
+	}
+	var currentConfigMap *v1.ConfigMap
+	for i, configMap := range oldConfigMaps {
+		if plan.CurrentVersion != "" && configMap.Labels["version"] == plan.CurrentVersion {
+			currentConfigMap = &oldConfigMaps[i]
+		}
+	}
+
+	// new versioned objects
+	if err := clusterManager.ResolveStartReplicas(); err != nil {
+		return nil, err
+	}
+	if configMap := clusterManager.BuildConfigMap(); configMap != nil {
+		if err := plan.add(KIND_CONFIGMAP, configMap.Name, ACTION_CREATE, currentConfigMap, configMap); err != nil {
+			return nil, err
+		}
+	}
+
+	ctrl := clusterManager.BuildReplicationController()
+	if err := plan.add(KIND_REPLICATIONCONTROLLER, ctrl.Name, ACTION_CREATE, currentController, ctrl); err != nil {
+		return nil, err
+	}
+
+	service := clusterManager.BuildService()
+	if err := plan.add(KIND_SERVICE, service.Name, ACTION_CREATE, currentService, service); err != nil {
+		return nil, err
+	}
+
+	// persistent service
+	existingPersistentService, err := k8sClient.GetService(descriptor.Namespace, descriptor.AppName)
+	var persistentService *v1.Service
+	if err == nil {
+		persistentService = &v1.Service{}
+		if err := copyObject(existingPersistentService, persistentService); err != nil {
+			return nil, err
+		}
+		persistentService = clusterManager.BuildPersistentService(persistentService)
+		err = plan.add(KIND_SERVICE, persistentService.Name, ACTION_UPDATE, existingPersistentService, persistentService)
+	} else if isNotFound(err) {
+		persistentService = clusterManager.BuildPersistentService(nil)
+		err = plan.add(KIND_SERVICE, persistentService.Name, ACTION_CREATE, nil, persistentService)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// autoscaler, moved to the new version
+	existingAutoscaler, err := k8sClient.GetHorizontalPodAutoscaler(descriptor.Namespace, descriptor.AppName)
+	if isNotFound(err) {
+		existingAutoscaler, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if descriptor.Autoscaling != nil {
+		if existingAutoscaler != nil {
+			autoscaler := &autoscaling.HorizontalPodAutoscaler{}
+			if err := copyObject(existingAutoscaler, autoscaler); err != nil {
+				return nil, err
+			}
+			err = plan.add(KIND_AUTOSCALER, autoscaler.Name, ACTION_UPDATE, existingAutoscaler, clusterManager.BuildAutoscaler(autoscaler))
+		} else {
+			autoscaler := clusterManager.BuildAutoscaler(nil)
+			err = plan.add(KIND_AUTOSCALER, autoscaler.Name, ACTION_CREATE, nil, autoscaler)
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else if existingAutoscaler != nil {
+		plan.Changes = append(plan.Changes, &ObjectChange{Kind: KIND_AUTOSCALER, Name: existingAutoscaler.Name, Action: ACTION_DELETE})
+	}
+
+	// disruption budget, recreated when changed
+	existingBudget, err := k8sClient.GetPodDisruptionBudget(descriptor.Namespace, descriptor.AppName)
+	if isNotFound(err) {
+		existingBudget, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if budget := clusterManager.BuildDisruptionBudget(); budget != nil {
+		action := ACTION_CREATE
+		if existingBudget != nil {
+			action = ACTION_UPDATE
+		}
+		if err := plan.add(KIND_DISRUPTIONBUDGET, budget.Name, action, existingBudget, budget); err != nil {
+			return nil, err
+		}
+	} else if existingBudget != nil {
+		plan.Changes = append(plan.Changes, &ObjectChange{Kind: KIND_DISRUPTIONBUDGET, Name: existingBudget.Name, Action: ACTION_DELETE})
+	}
+
+	// proxy config
+	if err := p.planIngresses(plan, descriptor, service, persistentService, logger); err != nil {
+		return nil, err
+	}
+
+	// old versions are removed after a successful deployment
+	for _, ctrl := range oldControllers {
+		plan.Changes = append(plan.Changes, &ObjectChange{Kind: KIND_REPLICATIONCONTROLLER, Name: ctrl.Name, Action: ACTION_DELETE})
+	}
+	for _, service := range oldServices {
+		plan.Changes = append(plan.Changes, &ObjectChange{Kind: KIND_SERVICE, Name: service.Name, Action: ACTION_DELETE})
+	}
+	for _, configMap := range oldConfigMaps {
+		plan.Changes = append(plan.Changes, &ObjectChange{Kind: KIND_CONFIGMAP, Name: configMap.Name, Action: ACTION_DELETE})
+	}
+
+	return plan, nil
+}
+
+func (p *Planner) planIngresses(plan *Plan, descriptor *types.Descriptor, service *v1.Service, persistentService *v1.Service, logger logger.Logger) error {
+	ingressConfigurator := p.config.IngressConfigurator
+	namespace := descriptor.Namespace
+
+	existingIngress, err := p.getIngress(namespace, descriptor.AppName)
+	if err != nil {
+		return err
+	}
+	wwwIngressName := proxies.GetWwwRedirectName(descriptor)
+	existingWwwIngress, err := p.getIngress(namespace, wwwIngressName)
+	if err != nil {
+		return err
+	}
+
+	if descriptor.Frontend == "" || len(service.Spec.Ports) == 0 {
+		if existingIngress != nil {
+			plan.Changes = append(plan.Changes, &ObjectChange{Kind: KIND_INGRESS, Name: existingIngress.Name, Action: ACTION_DELETE})
+		}
+		if existingWwwIngress != nil {
+			plan.Changes = append(plan.Changes, &ObjectChange{Kind: KIND_INGRESS, Name: existingWwwIngress.Name, Action: ACTION_DELETE})
+		}
+		return nil
+	}
+
+	var ingress *v1beta1.Ingress
+	action := ACTION_CREATE
+	if existingIngress != nil {
+		action = ACTION_UPDATE
+		ingress = &v1beta1.Ingress{}
+		if err := copyObject(existingIngress, ingress); err != nil {
+			return err
+		}
+	}
+	ingress, err = ingressConfigurator.BuildIngress(ingress, descriptor, service, logger)
+	if err != nil {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("Ingress can't be configured, the deployment would fail: %v", err.Error()))
+		return nil
+	}
+	if err := plan.add(KIND_INGRESS, ingress.Name, action, existingIngress, ingress); err != nil {
+		return err
+	}
+
+	if descriptor.RedirectWww {
+		var wwwIngress *v1beta1.Ingress
+		action := ACTION_CREATE
+		if existingWwwIngress != nil {
+			action = ACTION_UPDATE
+			wwwIngress = &v1beta1.Ingress{}
+			if err := copyObject(existingWwwIngress, wwwIngress); err != nil {
+				return err
+			}
+		}
+		wwwIngress = ingressConfigurator.BuildWwwRedirectIngress(wwwIngress, descriptor, persistentService, logger)
+		return plan.add(KIND_INGRESS, wwwIngress.Name, action, existingWwwIngress, wwwIngress)
+	} else if existingWwwIngress != nil {
+		plan.Changes = append(plan.Changes, &ObjectChange{Kind: KIND_INGRESS, Name: wwwIngressName, Action: ACTION_DELETE})
+	}
+
+	return nil
+}
+
+// getIngress returns nil if the Ingress doesn't exist
+func (p *Planner) getIngress(namespace string, name string) (*v1beta1.Ingress, error) {
+	ingress, err := p.config.K8sClient.GetIngress(namespace, name)
+	if err == nil {
+		return ingress, nil
+	} else if isNotFound(err) {
+		return nil, nil
+	}
+	return nil, err
+}
+
+func (plan *Plan) add(kind string, name string, action string, current interface{}, desired interface{}) error {
+	changes, err := diff.Compare(current, desired, IgnoredFields...)
+	if err != nil {
+		return err
+	}
+	// changed sensitive values are still listed, but with the values of the redacted objects
+	if len(plan.sensitiveFields) > 0 {
+		if current, err = RedactObject(current, plan.sensitiveFields); err != nil {
+			return err
+		}
+		if desired, err = RedactObject(desired, plan.sensitiveFields); err != nil {
+			return err
+		}
+		if changes, err = redactChanges(changes, current, desired); err != nil {
+			return err
+		}
+	}
+	if action == ACTION_UPDATE && len(changes) == 0 {
+		action = ACTION_UNCHANGED
+	}
+	plan.Changes = append(plan.Changes, &ObjectChange{Kind: kind, Name: name, Action: action, Diff: changes, Object: desired})
+	return nil
+}
+
+// RedactObject returns a generic JSON copy of the object, in which the values of the sensitive env vars
+// of all containers are redacted
+func RedactObject(object interface{}, fields types.SensitiveFields) (interface{}, error) {
+	var redacted interface{}
+	if err := copyObject(object, &redacted); err != nil {
+		return nil, err
+	}
+	redactEnv(redacted, fields)
+	return redacted, nil
+}
+
+// redactChanges replaces the values of the changes with the ones of the redacted objects
+func redactChanges(changes []diff.Change, current interface{}, desired interface{}) ([]diff.Change, error) {
+	currentFields, err := diff.Flatten(current)
+	if err != nil {
+		return nil, err
+	}
+	desiredFields, err := diff.Flatten(desired)
+	if err != nil {
+		return nil, err
+	}
+	for i := range changes {
+		if changes[i].Current != nil {
+			changes[i].Current = currentFields[changes[i].Path]
+		}
+		if changes[i].Desired != nil {
+			changes[i].Desired = desiredFields[changes[i].Path]
+		}
+	}
+	return changes, nil
+}
+
+func redactEnv(value interface{}, fields types.SensitiveFields) {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, child := range typed {
+			if env, isList := child.([]interface{}); isList && key == "env" {
+				for _, item := range env {
+					envVar, isMap := item.(map[string]interface{})
+					if !isMap {
+						continue
+					}
+					name, _ := envVar["name"].(string)
+					if envValue, hasValue := envVar["value"].(string); hasValue && envValue != "" && fields.IncludesEnv(name) {
+						envVar["value"] = types.REDACTED
+					}
+				}
+				continue
+			}
+			redactEnv(child, fields)
+		}
+	case []interface{}:
+		for _, child := range typed {
+			redactEnv(child, fields)
+		}
+	}
+}
+
+func isNotFound(err error) bool {
+	statusError, isStatus := err.(*errors.StatusError)
+	return isStatus && statusError.Status().Reason == meta.StatusReasonNotFound
+}
+
+func copyObject(in interface{}, out interface{}) error {
+	bytes, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bytes, out)
+}
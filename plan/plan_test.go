@@ -0,0 +1,53 @@
+package plan
+
+import (
+	"testing"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func newController(password string) *v1.ReplicationController {
+	rc := &v1.ReplicationController{}
+	rc.Name = "myapp-2"
+	rc.Spec.Template = &v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{{
+		Name: "myapp",
+		Env: []v1.EnvVar{
+			{Name: "DB_HOST", Value: "db.example.com"},
+			{Name: "DB_PASSWORD", Value: password},
+		},
+	}}}}
+	return rc
+}
+
+func TestAddRedactsSensitiveEnv(t *testing.T) {
+	plan := &Plan{sensitiveFields: types.SensitiveFields{"env.DB_PASSWORD"}}
+	if err := plan.add(KIND_REPLICATIONCONTROLLER, "myapp-2", ACTION_CREATE, newController("old"), newController("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	change := plan.Changes[0]
+	if len(change.Diff) != 1 || change.Diff[0].Current != types.REDACTED || change.Diff[0].Desired != types.REDACTED {
+		t.Errorf("Expected a redacted diff of the password, got %+v", change.Diff)
+	}
+
+	containers := change.Object.(map[string]interface{})["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})["containers"].([]interface{})
+	env := containers[0].(map[string]interface{})["env"].([]interface{})
+	if value := env[0].(map[string]interface{})["value"]; value != "db.example.com" {
+		t.Errorf("Expected the host to be kept, got %v", value)
+	}
+	if value := env[1].(map[string]interface{})["value"]; value != types.REDACTED {
+		t.Errorf("Expected a redacted password, got %v", value)
+	}
+}
+
+func TestAddWithoutSensitiveFields(t *testing.T) {
+	plan := &Plan{}
+	rc := newController("new")
+	if err := plan.add(KIND_REPLICATIONCONTROLLER, "myapp-2", ACTION_CREATE, nil, rc); err != nil {
+		t.Fatal(err)
+	}
+	if plan.Changes[0].Object != rc {
+		t.Error("Expected the object itself without sensitive fields")
+	}
+}
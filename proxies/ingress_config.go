@@ -32,8 +32,9 @@ import (
 )
 
 type IngressConfigurator struct {
-	k8sClient *k8s.K8sClient
-	nginx     *NginxStatus
+	k8sClient          *k8s.K8sClient
+	nginx              *NginxStatus
+	maintenanceBackend *v1beta1.IngressBackend
 }
 
 func NewIngressConfigurator(k8sClient *k8s.K8sClient, proxyReload int, healthTimeout int) *IngressConfigurator {
@@ -56,7 +57,7 @@ func (ic *IngressConfigurator) CreateOrUpdateProxy(deployment *types.Deployment,
 		logger.Println("  found existing Ingress, updating")
 		oldIngress = *ingress // copy values, not pointer!
 
-		if err := ic.configure(ingress, descriptor, service, logger); err != nil {
+		if ingress, err = ic.BuildIngress(ingress, descriptor, service, logger); err != nil {
 			return err
 		}
 
@@ -68,16 +69,7 @@ func (ic *IngressConfigurator) CreateOrUpdateProxy(deployment *types.Deployment,
 
 		logger.Println("  no Ingress found, creating new one")
 
-		ingress = &v1beta1.Ingress{}
-
-		ingress.Namespace = descriptor.Namespace
-		ingress.Name = descriptor.AppName
-
-		annotations := make(map[string]string)
-		annotations["kubernetes.io/ingress.class"] = "nginx"
-		ingress.Annotations = annotations
-
-		if err := ic.configure(ingress, descriptor, service, logger); err != nil {
+		if ingress, err = ic.BuildIngress(nil, descriptor, service, logger); err != nil {
 			return err
 		}
 
@@ -111,18 +103,14 @@ func (ic *IngressConfigurator) CreateOrUpdateProxy(deployment *types.Deployment,
 		}
 
 		logger.Printf("Getting Ingress for wwww redirect of %v", descriptor.AppName)
-		wwwIngressName := getWwwRedirectName(deployment.Descriptor)
-		rewriteSnippetKey := "ingress.kubernetes.io/configuration-snippet"
-		rewriteSnippetValue := "rewrite ^/(.*)$ https://" + descriptor.Frontend + "/$1 permanent;"
+		wwwIngressName := GetWwwRedirectName(deployment.Descriptor)
 
 		ingress, err := ic.k8sClient.GetIngress(descriptor.Namespace, wwwIngressName)
 		if err == nil {
 
 			logger.Println("  found existing www redirect Ingress, updating")
 
-			ingress.Annotations[rewriteSnippetKey] = rewriteSnippetValue
-			ic.setRules(ingress, descriptor, wwwService, true)
-			ic.setTlsConfig(ingress, descriptor, true, logger)
+			ingress = ic.BuildWwwRedirectIngress(ingress, descriptor, wwwService, logger)
 
 			if _, err := ic.k8sClient.UpdateIngress(descriptor.Namespace, ingress); err != nil {
 				return err
@@ -132,18 +120,7 @@ func (ic *IngressConfigurator) CreateOrUpdateProxy(deployment *types.Deployment,
 
 			logger.Println("  no www redirect Ingress found, creating new one")
 
-			ingress = &v1beta1.Ingress{}
-
-			ingress.Namespace = descriptor.Namespace
-			ingress.Name = wwwIngressName
-
-			annotations := make(map[string]string)
-			annotations["kubernetes.io/ingress.class"] = "nginx"
-			annotations[rewriteSnippetKey] = rewriteSnippetValue
-			ingress.Annotations = annotations
-
-			ic.setRules(ingress, descriptor, wwwService, true)
-			ic.setTlsConfig(ingress, descriptor, true, logger)
+			ingress = ic.BuildWwwRedirectIngress(nil, descriptor, wwwService, logger)
 
 			if _, err := ic.k8sClient.CreateIngress(descriptor.Namespace, ingress); err != nil {
 				return err
@@ -154,7 +131,7 @@ func (ic *IngressConfigurator) CreateOrUpdateProxy(deployment *types.Deployment,
 		}
 	} else {
 		// check if old www redirect exists
-		wwwIngressName := getWwwRedirectName(deployment.Descriptor)
+		wwwIngressName := GetWwwRedirectName(deployment.Descriptor)
 		_, err = ic.k8sClient.GetIngress(deployment.Descriptor.Namespace, wwwIngressName)
 		if err == nil {
 			err = ic.k8sClient.DeleteIngress(deployment.Descriptor.Namespace, wwwIngressName)
@@ -171,6 +148,52 @@ func (ic *IngressConfigurator) CreateOrUpdateProxy(deployment *types.Deployment,
 	return nil
 }
 
+// BuildIngress returns the Ingress for the given service, without creating or updating it.
+// The existing Ingress is updated in place, a new one is returned if there is none.
+func (ic *IngressConfigurator) BuildIngress(existing *v1beta1.Ingress, descriptor *types.Descriptor, service *v1.Service, logger logger.Logger) (*v1beta1.Ingress, error) {
+	ingress := existing
+	if ingress == nil {
+		ingress = newIngress(descriptor.Namespace, descriptor.AppName)
+	} else if ingress.Annotations == nil {
+		ingress.Annotations = make(map[string]string)
+	}
+
+	if err := ic.configure(ingress, descriptor, service, logger); err != nil {
+		return nil, err
+	}
+	return ingress, nil
+}
+
+// BuildWwwRedirectIngress returns the Ingress redirecting the www domain, without creating or updating it.
+// The existing Ingress is updated in place, a new one is returned if there is none.
+func (ic *IngressConfigurator) BuildWwwRedirectIngress(existing *v1beta1.Ingress, descriptor *types.Descriptor, wwwService *v1.Service, logger logger.Logger) *v1beta1.Ingress {
+	ingress := existing
+	if ingress == nil {
+		ingress = newIngress(descriptor.Namespace, GetWwwRedirectName(descriptor))
+	} else if ingress.Annotations == nil {
+		ingress.Annotations = make(map[string]string)
+	}
+
+	ingress.Annotations["ingress.kubernetes.io/configuration-snippet"] = "rewrite ^/(.*)$ https://" + descriptor.Frontend + "/$1 permanent;"
+	ic.setRules(ingress, descriptor, wwwService, true)
+	ic.setTlsConfig(ingress, descriptor, true, logger)
+
+	return ingress
+}
+
+func newIngress(namespace string, name string) *v1beta1.Ingress {
+	ingress := &v1beta1.Ingress{}
+
+	ingress.Namespace = namespace
+	ingress.Name = name
+
+	annotations := make(map[string]string)
+	annotations["kubernetes.io/ingress.class"] = "nginx"
+	ingress.Annotations = annotations
+
+	return ingress
+}
+
 func (ic *IngressConfigurator) configure(ingress *v1beta1.Ingress, descriptor *types.Descriptor, service *v1.Service, logger logger.Logger) error {
 	if err := ic.setTlsConfig(ingress, descriptor, false, logger); err != nil {
 		return err
@@ -315,7 +338,7 @@ func (ic *IngressConfigurator) DeleteProxy(deployment *types.Deployment, logger
 	}
 
 	var err2 error
-	wwwIngressName := getWwwRedirectName(deployment.Descriptor)
+	wwwIngressName := GetWwwRedirectName(deployment.Descriptor)
 	_, err2 = ic.k8sClient.GetIngress(deployment.Descriptor.Namespace, wwwIngressName)
 	if err2 == nil {
 		err2 = ic.k8sClient.DeleteIngress(deployment.Descriptor.Namespace, wwwIngressName)
@@ -341,7 +364,7 @@ func (ic *IngressConfigurator) getPersistentService(deployment *types.Deployment
 
 }
 
-func getWwwRedirectName(descriptor *types.Descriptor) string {
+func GetWwwRedirectName(descriptor *types.Descriptor) string {
 	return descriptor.AppName + "-www-redirect"
 }
 
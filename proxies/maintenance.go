@@ -0,0 +1,84 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package proxies
+
+import (
+	"fmt"
+	"strings"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+)
+
+// the configuration snippet of paused apps without maintenance backend
+const MAINTENANCE_SNIPPET = "return 503;"
+
+// ParseMaintenanceBackend parses a maintenance backend in the format "service:port", the port can be a number or a name
+func ParseMaintenanceBackend(value string) (*v1beta1.IngressBackend, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("Maintenance backend %v must be in the format service:port", value)
+	}
+	return &v1beta1.IngressBackend{ServiceName: parts[0], ServicePort: intstr.Parse(parts[1])}, nil
+}
+
+// SetMaintenanceBackend sets the backend the Ingresses of paused apps point at. The service has to exist in the namespace of the app.
+func (ic *IngressConfigurator) SetMaintenanceBackend(backend *v1beta1.IngressBackend) {
+	ic.maintenanceBackend = backend
+}
+
+// SetMaintenance points the Ingress of the app at the maintenance backend, or lets it answer with 503 without maintenance backend.
+// CreateOrUpdateProxy restores the Ingress.
+func (ic *IngressConfigurator) SetMaintenance(deployment *types.Deployment, logger logger.Logger) error {
+	descriptor := deployment.Descriptor
+
+	ingress, err := ic.k8sClient.GetIngress(descriptor.Namespace, descriptor.AppName)
+	if statusError, isStatus := err.(*k8sErrors.StatusError); isStatus && statusError.Status().Reason == meta.StatusReasonNotFound {
+		logger.Printf("  no Ingress found for %v", descriptor.AppName)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	BuildMaintenanceIngress(ingress, ic.maintenanceBackend)
+	_, err = ic.k8sClient.UpdateIngress(descriptor.Namespace, ingress)
+	return err
+}
+
+// BuildMaintenanceIngress points all rules of the Ingress at the given backend, or adds a configuration snippet
+// answering all requests with 503 if the backend is nil. The Ingress is updated in place.
+func BuildMaintenanceIngress(ingress *v1beta1.Ingress, backend *v1beta1.IngressBackend) {
+	if backend == nil {
+		if ingress.Annotations == nil {
+			ingress.Annotations = make(map[string]string)
+		}
+		ingress.Annotations["ingress.kubernetes.io/configuration-snippet"] = MAINTENANCE_SNIPPET
+		return
+	}
+
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for i := range rule.HTTP.Paths {
+			rule.HTTP.Paths[i].Backend = *backend
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package proxies
+
+import (
+	"testing"
+
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+)
+
+func newRuleIngress() *v1beta1.Ingress {
+	ingress := newIngress("default", "myapp")
+	ingress.Spec.Rules = []v1beta1.IngressRule{{
+		Host: "myapp.example.com",
+		IngressRuleValue: v1beta1.IngressRuleValue{HTTP: &v1beta1.HTTPIngressRuleValue{
+			Paths: []v1beta1.HTTPIngressPath{{Backend: v1beta1.IngressBackend{ServiceName: "myapp-2"}}},
+		}},
+	}}
+	return ingress
+}
+
+func TestParseMaintenanceBackend(t *testing.T) {
+	backend, err := ParseMaintenanceBackend("maintenance:http")
+	if err != nil || backend.ServiceName != "maintenance" || backend.ServicePort.StrVal != "http" {
+		t.Errorf("Expected maintenance backend with named port, got %+v, %v", backend, err)
+	}
+	if backend, err = ParseMaintenanceBackend("maintenance:80"); err != nil || backend.ServicePort.IntVal != 80 {
+		t.Errorf("Expected maintenance backend with port 80, got %+v, %v", backend, err)
+	}
+	if _, err = ParseMaintenanceBackend("maintenance"); err == nil {
+		t.Error("Expected error for missing port")
+	}
+}
+
+func TestBuildMaintenanceIngress(t *testing.T) {
+	ingress := newRuleIngress()
+	backend, _ := ParseMaintenanceBackend("maintenance:80")
+	BuildMaintenanceIngress(ingress, backend)
+	if name := ingress.Spec.Rules[0].HTTP.Paths[0].Backend.ServiceName; name != "maintenance" {
+		t.Errorf("Expected the maintenance backend, got %v", name)
+	}
+
+	ingress = newRuleIngress()
+	BuildMaintenanceIngress(ingress, nil)
+	if snippet := ingress.Annotations["ingress.kubernetes.io/configuration-snippet"]; snippet != MAINTENANCE_SNIPPET {
+		t.Errorf("Expected the maintenance snippet, got %v", snippet)
+	}
+	if name := ingress.Spec.Rules[0].HTTP.Paths[0].Backend.ServiceName; name != "myapp-2" {
+		t.Errorf("Expected the backend to be unchanged, got %v", name)
+	}
+}
@@ -62,7 +62,7 @@ func (nginx NginxStatus) WaitForProxy(deployment *types.Deployment, port int32,
 	timeoutChan := make(chan bool, 2) // don't block if we timeout, but monitorBackend still waits for connection
 
 	upstreamName := deployment.Descriptor.Namespace + "-" + deployment.GetVersionedName() + "-" + strconv.Itoa(int(port))
-	go nginx.monitorProxy(upstreamName, deployment.Descriptor.Replicas, successChan, timeoutChan, logger)
+	go nginx.monitorProxy(upstreamName, deployment.GetReplicas(), successChan, timeoutChan, logger)
 
 	select {
 	case success := <-successChan:
@@ -0,0 +1,124 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package secrets
+
+import (
+	"sync"
+	"time"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/events"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+)
+
+// RedeployFunc starts a new deployment of the descriptor of the given deployment
+type RedeployFunc func(deployment *types.Deployment, logger logger.Logger) (*types.Deployment, error)
+
+// Refresher periodically reads the secrets referenced by deployed apps, and publishes an event or redeploys the app when they changed
+type Refresher struct {
+	registry *etcdregistry.EtcdRegistry
+	resolver *Resolver
+	bus      *events.Bus
+	interval time.Duration
+	redeploy RedeployFunc
+	logger   logger.Logger
+
+	mutex sync.Mutex
+	// changed hash per deployment, for only publishing changes once
+	reported map[string]string
+}
+
+// NewRefresher creates a refresher, without redeploy function changes are only published as events
+func NewRefresher(registry *etcdregistry.EtcdRegistry, resolver *Resolver, bus *events.Bus, interval int, redeploy RedeployFunc) *Refresher {
+	return &Refresher{
+		registry: registry,
+		resolver: resolver,
+		bus:      bus,
+		interval: time.Duration(interval) * time.Second,
+		redeploy: redeploy,
+		logger:   logger.NewConsoleLogger(),
+		reported: map[string]string{},
+	}
+}
+
+func (r *Refresher) Run() {
+	r.logger.Printf("Starting secret refresher with an interval of %v, redeploying: %v", r.interval, r.redeploy != nil)
+	for {
+		r.refreshAll()
+		time.Sleep(r.interval)
+	}
+}
+
+func (r *Refresher) refreshAll() {
+	deployments, err := r.registry.GetAllDeployments()
+	if err != nil {
+		r.logger.Printf("Secret refresher: error getting deployments: %v", err.Error())
+		return
+	}
+
+	// don't interfere with running deployments
+	busy := map[string]bool{}
+	for _, deployment := range deployments {
+		if deployment.IsBusy() {
+			busy[deployment.Descriptor.Namespace+"-"+deployment.Descriptor.AppName] = true
+		}
+	}
+
+	for _, deployment := range deployments {
+		if deployment.Status != types.DEPLOYMENTSTATUS_DEPLOYED || busy[deployment.Descriptor.Namespace+"-"+deployment.Descriptor.AppName] {
+			continue
+		}
+		if _, err := r.Refresh(deployment); err != nil {
+			r.logger.Printf("Secret refresher: error refreshing deployment %v: %v", deployment.Id, err.Error())
+		}
+	}
+}
+
+// Refresh checks whether the referenced secrets of a deployed deployment changed. It returns whether they did.
+func (r *Refresher) Refresh(deployment *types.Deployment) (bool, error) {
+	if !r.resolver.HasReferences(deployment.Descriptor) {
+		return false, nil
+	}
+
+	values, err := r.resolver.Resolve(deployment.Descriptor)
+	if err != nil {
+		return false, err
+	}
+	hash := Hash(values)
+	if hash == deployment.SecretsHash {
+		return false, nil
+	}
+
+	r.mutex.Lock()
+	alreadyReported := r.reported[deployment.Id] == hash
+	r.reported[deployment.Id] = hash
+	r.mutex.Unlock()
+
+	// handle every change once, so a failing redeployment isn't repeated on every run
+	if alreadyReported {
+		return true, nil
+	}
+	r.bus.Publish(events.NewDeploymentEvent(events.EVENT_SECRETS_CHANGED, deployment, "Secrets of %v changed", deployment.Descriptor.AppName))
+
+	if r.redeploy != nil {
+		r.logger.Printf("Secrets of %v changed, redeploying", deployment.Descriptor.AppName)
+		if _, err := r.redeploy(deployment, r.logger); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package secrets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+)
+
+// Provider reads secrets from an external secret store
+type Provider interface {
+	// Read returns the key value pairs of the secret at the given path
+	Read(path string) (map[string]string, error)
+}
+
+// Reference points to a key of a secret of a provider, written as "<provider>:<path>#<key>", e.g. "vault:secret/data/app#dbpass"
+type Reference struct {
+	Provider string
+	Path     string
+	Key      string
+}
+
+func (r Reference) String() string {
+	return r.Provider + ":" + r.Path + "#" + r.Key
+}
+
+// Resolver resolves secret references in container env values with the registered providers
+type Resolver struct {
+	providers map[string]Provider
+}
+
+func NewResolver() *Resolver {
+	return &Resolver{map[string]Provider{}}
+}
+
+func (r *Resolver) Register(name string, provider Provider) {
+	r.providers[name] = provider
+}
+
+// Parse returns the reference of the value, if it references a registered provider
+func (r *Resolver) Parse(value string) (Reference, bool) {
+	if r == nil {
+		return Reference{}, false
+	}
+	colon := strings.Index(value, ":")
+	hash := strings.LastIndex(value, "#")
+	if colon <= 0 || hash < colon+2 || hash == len(value)-1 {
+		return Reference{}, false
+	}
+	reference := Reference{Provider: value[:colon], Path: value[colon+1 : hash], Key: value[hash+1:]}
+	if _, registered := r.providers[reference.Provider]; !registered {
+		return Reference{}, false
+	}
+	return reference, true
+}
+
+// HasReferences returns whether any container env value of the descriptor is a secret reference
+func (r *Resolver) HasReferences(descriptor *types.Descriptor) bool {
+	if r == nil {
+		return false
+	}
+	for _, container := range descriptor.PodSpec.Containers {
+		for _, env := range container.Env {
+			if _, isReference := r.Parse(env.Value); isReference {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// References returns the secret references of the descriptor, by their key in the provider secret of the app
+func (r *Resolver) References(descriptor *types.Descriptor) map[string]Reference {
+	references := map[string]Reference{}
+	for _, container := range descriptor.PodSpec.Containers {
+		for _, env := range container.Env {
+			if reference, isReference := r.Parse(env.Value); isReference {
+				references[SecretKey(container.Name, env.Name)] = reference
+			}
+		}
+	}
+	return references
+}
+
+// Resolve reads the values of all secret references of the descriptor, by their key in the provider secret of the app
+func (r *Resolver) Resolve(descriptor *types.Descriptor) (map[string]string, error) {
+	values := map[string]string{}
+	// every path is read once
+	read := map[string]map[string]string{}
+
+	for _, container := range descriptor.PodSpec.Containers {
+		for _, env := range container.Env {
+			reference, isReference := r.Parse(env.Value)
+			if !isReference {
+				continue
+			}
+			readKey := reference.Provider + ":" + reference.Path
+			data, found := read[readKey]
+			if !found {
+				var err error
+				data, err = r.providers[reference.Provider].Read(reference.Path)
+				if err != nil {
+					return nil, fmt.Errorf("Error reading secret %v: %v", readKey, err)
+				}
+				read[readKey] = data
+			}
+			value, found := data[reference.Key]
+			if !found {
+				return nil, fmt.Errorf("Key %v not found in secret %v", reference.Key, readKey)
+			}
+			values[SecretKey(container.Name, env.Name)] = value
+		}
+	}
+	return values, nil
+}
+
+// SecretKey returns the key of an env var of a container in the provider secret of the app
+func SecretKey(containerName string, envName string) string {
+	return containerName + "-" + envName
+}
+
+// Hash returns a hash of the secret values, for detecting changes without storing the values
+func Hash(values map[string]string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%v=%v\x00", key, values[key])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
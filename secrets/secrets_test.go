@@ -0,0 +1,165 @@
+package secrets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/events"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+type staticProvider struct {
+	secrets map[string]map[string]string
+	reads   int
+}
+
+func (p *staticProvider) Read(path string) (map[string]string, error) {
+	p.reads++
+	data, found := p.secrets[path]
+	if !found {
+		return nil, ErrSecretNotFound
+	}
+	return data, nil
+}
+
+func newDescriptor(values ...string) *types.Descriptor {
+	env := []v1.EnvVar{}
+	for i, value := range values {
+		env = append(env, v1.EnvVar{Name: string('A' + rune(i)), Value: value})
+	}
+	return &types.Descriptor{AppName: "myapp", PodSpec: v1.PodSpec{Containers: []v1.Container{{Name: "myapp", Env: env}}}}
+}
+
+func TestParse(t *testing.T) {
+	resolver := NewResolver()
+	resolver.Register("vault", &staticProvider{})
+
+	reference, ok := resolver.Parse("vault:secret/data/app#dbpass")
+	if !ok || reference.Provider != "vault" || reference.Path != "secret/data/app" || reference.Key != "dbpass" {
+		t.Errorf("Unexpected reference %+v %v", reference, ok)
+	}
+
+	for _, value := range []string{"plain", "other:secret/data/app#dbpass", "vault:secret/data/app", "vault:#key", "vault:path#", "http://example.com/#anchor"} {
+		if _, ok := resolver.Parse(value); ok {
+			t.Errorf("Expected %v not to be a reference", value)
+		}
+	}
+
+	var nilResolver *Resolver
+	if _, ok := nilResolver.Parse("vault:secret/data/app#dbpass"); ok {
+		t.Error("Expected no references without resolver")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	provider := &staticProvider{secrets: map[string]map[string]string{"secret/data/app": {"user": "admin", "pass": "s3cret"}}}
+	resolver := NewResolver()
+	resolver.Register("vault", provider)
+
+	values, err := resolver.Resolve(newDescriptor("plain", "vault:secret/data/app#user", "vault:secret/data/app#pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values["myapp-B"] != "admin" || values["myapp-C"] != "s3cret" {
+		t.Errorf("Unexpected values %v", values)
+	}
+	if provider.reads != 1 {
+		t.Errorf("Expected a single read, got %v", provider.reads)
+	}
+
+	if _, err := resolver.Resolve(newDescriptor("vault:secret/data/app#missing")); err == nil {
+		t.Error("Expected error for missing key")
+	}
+	if _, err := resolver.Resolve(newDescriptor("vault:secret/data/other#user")); err == nil {
+		t.Error("Expected error for missing secret")
+	}
+}
+
+func TestHash(t *testing.T) {
+	if Hash(nil) != "" {
+		t.Error("Expected empty hash without values")
+	}
+	if Hash(map[string]string{"a": "1", "b": "2"}) != Hash(map[string]string{"b": "2", "a": "1"}) {
+		t.Error("Expected hash independent of map order")
+	}
+	if Hash(map[string]string{"a": "1"}) == Hash(map[string]string{"a": "2"}) {
+		t.Error("Expected different hash for different values")
+	}
+}
+
+func TestVaultProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Vault-Token") != "token" {
+			writer.WriteHeader(403)
+			return
+		}
+		if req.URL.Path != "/v1/secret/data/app" {
+			writer.WriteHeader(404)
+			return
+		}
+		writer.Write([]byte(`{"data": {"data": {"pass": "s3cret", "port": 5432}, "metadata": {"version": 2}}}`))
+	}))
+	defer server.Close()
+
+	data, err := NewVaultProvider(server.URL+"/", "token").Read("secret/data/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["pass"] != "s3cret" || data["port"] != "5432" {
+		t.Errorf("Unexpected data %v", data)
+	}
+
+	if _, err := NewVaultProvider(server.URL, "token").Read("secret/data/other"); err != ErrSecretNotFound {
+		t.Errorf("Expected ErrSecretNotFound, got %v", err)
+	}
+	if _, err := NewVaultProvider(server.URL, "wrong").Read("secret/data/app"); err == nil {
+		t.Error("Expected error for wrong token")
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	provider := &staticProvider{secrets: map[string]map[string]string{"secret/data/app": {"pass": "new"}}}
+	resolver := NewResolver()
+	resolver.Register("vault", provider)
+	bus := events.NewBus()
+	subscriber := bus.Subscribe()
+
+	redeployed := 0
+	redeploy := func(deployment *types.Deployment, logger logger.Logger) (*types.Deployment, error) {
+		redeployed++
+		return deployment, nil
+	}
+	refresher := NewRefresher(nil, resolver, bus, 60, redeploy)
+
+	deployment := &types.Deployment{
+		Id:          "1",
+		Descriptor:  newDescriptor("vault:secret/data/app#pass"),
+		SecretsHash: Hash(map[string]string{"myapp-A": "old"}),
+	}
+
+	changed, err := refresher.Refresh(deployment)
+	if err != nil || !changed {
+		t.Fatalf("Expected change, got %v %v", changed, err)
+	}
+	if redeployed != 1 {
+		t.Errorf("Expected a redeployment, got %v", redeployed)
+	}
+	event := <-subscriber
+	if event.Type != events.EVENT_SECRETS_CHANGED {
+		t.Errorf("Unexpected event %v", event.Type)
+	}
+
+	// the same change is handled only once
+	refresher.Refresh(deployment)
+	if redeployed != 1 {
+		t.Errorf("Expected a single redeployment, got %v", redeployed)
+	}
+
+	deployment.SecretsHash = Hash(map[string]string{"myapp-A": "new"})
+	if changed, _ := refresher.Refresh(deployment); changed {
+		t.Error("Expected no change")
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var ErrSecretNotFound = errors.New("secret not found!")
+
+// VaultProvider reads secrets from the KV version 2 secrets engine of Vault over HTTP.
+// Paths include the mount and the data prefix, e.g. "secret/data/app".
+type VaultProvider struct {
+	address string
+	token   string
+	client  *http.Client
+}
+
+type vaultResponse struct {
+	Data struct {
+		Data map[string]interface{} `json:"data"`
+	} `json:"data"`
+}
+
+func NewVaultProvider(address string, token string) *VaultProvider {
+	return &VaultProvider{
+		address: strings.TrimSuffix(address, "/"),
+		token:   token,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (v *VaultProvider) Read(path string) (map[string]string, error) {
+	req, err := http.NewRequest("GET", v.address+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, ErrSecretNotFound
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Vault returned status %v", resp.StatusCode)
+	}
+
+	vaultResp := vaultResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&vaultResp); err != nil {
+		return nil, err
+	}
+
+	data := map[string]string{}
+	for key, value := range vaultResp.Data.Data {
+		if stringValue, isString := value.(string); isString {
+			data[key] = stringValue
+			continue
+		}
+		bytes, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		data[key] = string(bytes)
+	}
+	return data, nil
+}
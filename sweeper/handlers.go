@@ -0,0 +1,49 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package sweeper
+
+import (
+	"net/http"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+)
+
+type SweeperHandlers struct {
+	sweeper *Sweeper
+}
+
+func NewSweeperHandlers(sweeper *Sweeper) *SweeperHandlers {
+	return &SweeperHandlers{sweeper}
+}
+
+// SweepHandler runs a sweep immediately, optionally limited to a namespace and in dry-run mode
+func (s *SweeperHandlers) SweepHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	//TODO check namespaces of user
+	namespace := req.URL.Query().Get("namespace")
+	dryRun := req.URL.Query().Get("dryrun") == "true"
+
+	report, err := s.sweeper.Sweep(dryRun, namespace, logger)
+	if err != nil {
+		helper.HandleError(writer, logger, 500, "Error during sweep: %v", err)
+		return
+	}
+
+	helper.HandleSuccess(writer, logger, report, "Swept %v deployments, %v logs and %v Kubernetes objects, dry-run: %v",
+		len(report.Deployments), len(report.Logs), len(report.Objects), dryRun)
+}
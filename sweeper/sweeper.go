@@ -0,0 +1,412 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package sweeper
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+)
+
+const (
+	KIND_REPLICATIONCONTROLLER = "ReplicationController"
+	KIND_SERVICE               = "Service"
+	KIND_POD                   = "Pod"
+	KIND_CONFIGMAP             = "ConfigMap"
+)
+
+// Retention configures what the sweeper keeps, 0 means keep everything
+type Retention struct {
+	// number of deployments kept per app, deployed and running deployments are always kept
+	KeepDeployments int `json:"keepDeployments"`
+	// days after which the logs and healthcheck data of deployments which are not deployed are deleted
+	LogDays int `json:"logDays"`
+}
+
+type SweptDeployment struct {
+	Namespace    string `json:"namespace"`
+	AppName      string `json:"appName"`
+	Id           string `json:"id"`
+	Status       string `json:"status"`
+	LastModified string `json:"lastModified"`
+}
+
+type SweptLogs struct {
+	Namespace    string `json:"namespace"`
+	DeploymentId string `json:"deploymentId"`
+	Reason       string `json:"reason"`
+}
+
+type SweptObject struct {
+	Namespace string `json:"namespace"`
+	AppName   string `json:"appName"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Reason    string `json:"reason"`
+}
+
+// Report lists everything a sweep deleted, or would delete in dry-run mode
+type Report struct {
+	DryRun      bool              `json:"dryRun"`
+	Started     string            `json:"started"`
+	Finished    string            `json:"finished"`
+	Retention   Retention         `json:"retention"`
+	Deployments []SweptDeployment `json:"deployments"`
+	Logs        []SweptLogs       `json:"logs"`
+	Objects     []SweptObject     `json:"objects"`
+	Errors      []string          `json:"errors"`
+}
+
+// Sweeper periodically deletes old deployments, stale logs and Kubernetes objects of apps which no deployment owns
+type Sweeper struct {
+	config    helper.DeployerConfig
+	registry  *etcdregistry.EtcdRegistry
+	interval  time.Duration
+	retention Retention
+	dryRun    bool
+	logger    logger.Logger
+
+	// only one sweep at a time
+	mutex sync.Mutex
+}
+
+func NewSweeper(config helper.DeployerConfig, interval int, retention Retention, dryRun bool) *Sweeper {
+	return &Sweeper{
+		config:    config,
+		registry:  config.EtcdRegistry,
+		interval:  time.Duration(interval) * time.Second,
+		retention: retention,
+		dryRun:    dryRun,
+		logger:    logger.NewConsoleLogger(),
+	}
+}
+
+func (s *Sweeper) Run() {
+	s.logger.Printf("Starting sweeper with an interval of %v, keeping %v deployments per app and logs for %v days, dry-run: %v",
+		s.interval, s.retention.KeepDeployments, s.retention.LogDays, s.dryRun)
+	for {
+		time.Sleep(s.interval)
+		report, err := s.Sweep(s.dryRun, "", s.logger)
+		if err != nil {
+			s.logger.Printf("Sweeper: error during sweep: %v", err.Error())
+			continue
+		}
+		s.logger.Printf("Sweeper: swept %v deployments, %v logs and %v Kubernetes objects, %v errors",
+			len(report.Deployments), len(report.Logs), len(report.Objects), len(report.Errors))
+	}
+}
+
+// Sweep deletes everything which is not retained, of all namespaces or of the given one.
+// In dry-run mode nothing is deleted, the report contains what would have been deleted.
+func (s *Sweeper) Sweep(dryRun bool, namespace string, logger logger.Logger) (*Report, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	report := &Report{
+		DryRun:      dryRun,
+		Started:     time.Now().Format(time.RFC3339),
+		Retention:   s.retention,
+		Deployments: []SweptDeployment{},
+		Logs:        []SweptLogs{},
+		Objects:     []SweptObject{},
+		Errors:      []string{},
+	}
+
+	deployments, err := s.registry.GetAllDeployments()
+	if err != nil {
+		return nil, err
+	}
+	if namespace != "" {
+		deployments = filterNamespace(deployments, namespace)
+	}
+
+	expired, staleLogs := SelectDeployments(deployments, s.retention, time.Now())
+
+	for _, deployment := range expired {
+		logger.Printf("Sweeper: deleting deployment %v of %v, status %v", deployment.Id, deployment.Descriptor.AppName, deployment.Status)
+		if !dryRun {
+			if err := s.registry.DeleteDeployment(deployment.Descriptor.Namespace, deployment.Id); err != nil {
+				report.addError("Error deleting deployment %v: %v", deployment.Id, err)
+				continue
+			}
+		}
+		report.Deployments = append(report.Deployments, SweptDeployment{
+			Namespace:    deployment.Descriptor.Namespace,
+			AppName:      deployment.Descriptor.AppName,
+			Id:           deployment.Id,
+			Status:       deployment.Status,
+			LastModified: deployment.LastModified,
+		})
+	}
+
+	for _, deployment := range staleLogs {
+		reason := fmt.Sprintf("older than %v days", s.retention.LogDays)
+		s.sweepLogs(report, deployment.Descriptor.Namespace, deployment.Id, reason, logger)
+	}
+
+	s.sweepOrphanedLogs(report, deployments, namespace, logger)
+	s.sweepObjects(report, deployments, logger)
+
+	report.Finished = time.Now().Format(time.RFC3339)
+	return report, nil
+}
+
+// SelectDeployments returns the deployments which exceed the number of kept deployments per app, and the kept deployments
+// with logs older than the retention. Deployed, deploying and undeploying deployments are never selected.
+func SelectDeployments(deployments []*types.Deployment, retention Retention, now time.Time) ([]*types.Deployment, []*types.Deployment) {
+	expired := []*types.Deployment{}
+	staleLogs := []*types.Deployment{}
+
+	perApp := map[string][]*types.Deployment{}
+	for _, deployment := range deployments {
+		key := appKey(deployment.Descriptor.Namespace, deployment.Descriptor.AppName)
+		perApp[key] = append(perApp[key], deployment)
+	}
+
+	logsBefore := now.Add(-time.Duration(retention.LogDays) * 24 * time.Hour)
+
+	for _, appDeployments := range perApp {
+		sort.Sort(newestFirst(appDeployments))
+		for i, deployment := range appDeployments {
+			if isActive(deployment) {
+				continue
+			}
+			if retention.KeepDeployments > 0 && i >= retention.KeepDeployments {
+				expired = append(expired, deployment)
+				continue
+			}
+			if retention.LogDays > 0 && parseTime(deployment.LastModified).Before(logsBefore) {
+				staleLogs = append(staleLogs, deployment)
+			}
+		}
+	}
+
+	return expired, staleLogs
+}
+
+func (s *Sweeper) sweepLogs(report *Report, namespace string, deploymentId string, reason string, logger logger.Logger) {
+	logger.Printf("Sweeper: deleting logs of deployment %v, %v", deploymentId, reason)
+	if !report.DryRun {
+		if err := s.registry.DeleteDeploymentData(namespace, deploymentId); err != nil {
+			report.addError("Error deleting logs of deployment %v: %v", deploymentId, err)
+			return
+		}
+	}
+	report.Logs = append(report.Logs, SweptLogs{Namespace: namespace, DeploymentId: deploymentId, Reason: reason})
+}
+
+// sweepOrphanedLogs deletes logs and healthcheck data of deployments which don't exist anymore
+func (s *Sweeper) sweepOrphanedLogs(report *Report, deployments []*types.Deployment, namespace string, logger logger.Logger) {
+	dataIds, err := s.registry.GetDeploymentDataIds()
+	if err != nil {
+		report.addError("Error getting logs: %v", err)
+		return
+	}
+
+	existing := map[string]bool{}
+	for _, deployment := range deployments {
+		existing[deployment.Descriptor.Namespace+"/"+deployment.Id] = true
+	}
+	// expired deployments are gone now, and their logs with them
+	for _, swept := range report.Deployments {
+		existing[swept.Namespace+"/"+swept.Id] = true
+	}
+
+	for dataNamespace, ids := range dataIds {
+		if namespace != "" && dataNamespace != namespace {
+			continue
+		}
+		for _, id := range ids {
+			if !existing[dataNamespace+"/"+id] {
+				s.sweepLogs(report, dataNamespace, id, "deployment does not exist", logger)
+			}
+		}
+	}
+}
+
+// sweepObjects deletes the replication controllers, services and pods of apps known to the deployer,
+// which don't belong to the deployed version of the app
+func (s *Sweeper) sweepObjects(report *Report, deployments []*types.Deployment, logger logger.Logger) {
+	apps := map[string]*types.Descriptor{}
+	for _, deployment := range deployments {
+		apps[appKey(deployment.Descriptor.Namespace, deployment.Descriptor.AppName)] = deployment.Descriptor
+	}
+
+	for _, descriptor := range apps {
+		s.sweepAppObjects(report, descriptor.Namespace, descriptor.AppName, logger)
+	}
+}
+
+func (s *Sweeper) sweepAppObjects(report *Report, namespace string, appName string, logger logger.Logger) {
+	// don't interfere with (un)deployments, and make sure no deployment starts while sweeping
+	mutex := helper.GetMutex(s.config.Mutexes, namespace+"-"+appName)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	deployments, err := s.registry.GetDeploymentsByAppName(namespace, appName)
+	if err != nil && err != etcdregistry.ErrDeploymentNotFound {
+		report.addError("Error getting deployments of %v: %v", appName, err)
+		return
+	}
+	deployedVersion := ""
+	for _, deployment := range deployments {
+		if deployment.IsBusy() {
+			return
+		}
+		// the objects of a paused version are kept for resuming it
+		if deployment.Status == types.DEPLOYMENTSTATUS_DEPLOYED || deployment.Status == types.DEPLOYMENTSTATUS_PAUSED {
+			deployedVersion = deployment.Version
+		}
+	}
+
+	k8sClient := s.config.K8sClient
+	selector := map[string]string{"app": appName}
+	reason := "not owned by a deployed version"
+
+	controllers, err := k8sClient.ListReplicationControllersWithSelector(namespace, selector)
+	if err != nil {
+		report.addError("Error listing replication controllers of %v: %v", appName, err)
+		return
+	}
+	for i, rc := range controllers.Items {
+		if !IsOrphan(rc.Labels, deployedVersion) {
+			continue
+		}
+		logger.Printf("Sweeper: deleting replication controller %v", rc.Name)
+		if !report.DryRun {
+			if err := k8sClient.ShutdownReplicationController(&controllers.Items[i], logger); err != nil {
+				report.addError("Error deleting replication controller %v: %v", rc.Name, err)
+				continue
+			}
+		}
+		report.Objects = append(report.Objects, SweptObject{namespace, appName, KIND_REPLICATIONCONTROLLER, rc.Name, reason})
+	}
+
+	services, err := k8sClient.ListServicesWithSelector(namespace, selector)
+	if err != nil {
+		report.addError("Error listing services of %v: %v", appName, err)
+		return
+	}
+	for _, service := range services.Items {
+		if !IsOrphan(service.Labels, deployedVersion) {
+			continue
+		}
+		logger.Printf("Sweeper: deleting service %v", service.Name)
+		if !report.DryRun {
+			if err := k8sClient.DeleteService(namespace, service.Name); err != nil {
+				report.addError("Error deleting service %v: %v", service.Name, err)
+				continue
+			}
+		}
+		report.Objects = append(report.Objects, SweptObject{namespace, appName, KIND_SERVICE, service.Name, reason})
+	}
+
+	configMaps, err := k8sClient.ListConfigMapsWithSelector(namespace, selector)
+	if err != nil {
+		report.addError("Error listing config maps of %v: %v", appName, err)
+		return
+	}
+	for _, configMap := range configMaps.Items {
+		if !IsOrphan(configMap.Labels, deployedVersion) {
+			continue
+		}
+		logger.Printf("Sweeper: deleting config map %v", configMap.Name)
+		if !report.DryRun {
+			if err := k8sClient.DeleteConfigMap(namespace, configMap.Name); err != nil {
+				report.addError("Error deleting config map %v: %v", configMap.Name, err)
+				continue
+			}
+		}
+		report.Objects = append(report.Objects, SweptObject{namespace, appName, KIND_CONFIGMAP, configMap.Name, reason})
+	}
+
+	pods, err := k8sClient.ListPodsWithSelector(namespace, selector)
+	if err != nil {
+		report.addError("Error listing pods of %v: %v", appName, err)
+		return
+	}
+	for _, pod := range pods.Items {
+		// pods of deleted replication controllers are already on their way out
+		if pod.DeletionTimestamp != nil || !IsOrphan(pod.Labels, deployedVersion) {
+			continue
+		}
+		logger.Printf("Sweeper: deleting pod %v", pod.Name)
+		if !report.DryRun {
+			if err := k8sClient.DeletePod(namespace, pod.Name); err != nil {
+				report.addError("Error deleting pod %v: %v", pod.Name, err)
+				continue
+			}
+		}
+		report.Objects = append(report.Objects, SweptObject{namespace, appName, KIND_POD, pod.Name, reason})
+	}
+}
+
+// IsOrphan returns whether an object with the given labels does not belong to the deployed version of its app.
+// The persistent service belongs to every deployed version.
+func IsOrphan(labels map[string]string, deployedVersion string) bool {
+	if deployedVersion == "" {
+		return true
+	}
+	if labels["persistent"] == "true" {
+		return false
+	}
+	return labels["version"] != deployedVersion
+}
+
+func (report *Report) addError(msg string, args ...interface{}) {
+	report.Errors = append(report.Errors, fmt.Sprintf(msg, args...))
+}
+
+func isActive(deployment *types.Deployment) bool {
+	return deployment.Status == types.DEPLOYMENTSTATUS_DEPLOYED || deployment.Status == types.DEPLOYMENTSTATUS_PAUSED || deployment.IsBusy()
+}
+
+func filterNamespace(deployments []*types.Deployment, namespace string) []*types.Deployment {
+	result := []*types.Deployment{}
+	for _, deployment := range deployments {
+		if deployment.Descriptor.Namespace == namespace {
+			result = append(result, deployment)
+		}
+	}
+	return result
+}
+
+// unparseable timestamps are treated as very old
+func parseTime(ts string) time.Time {
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+type newestFirst []*types.Deployment
+
+func (d newestFirst) Len() int      { return len(d) }
+func (d newestFirst) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+func (d newestFirst) Less(i, j int) bool {
+	return parseTime(d[i].Created).After(parseTime(d[j].Created))
+}
+
+func appKey(namespace string, appName string) string {
+	return namespace + "/" + appName
+}
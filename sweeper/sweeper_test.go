@@ -0,0 +1,91 @@
+package sweeper
+
+import (
+	"testing"
+	"time"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+)
+
+func newDeployment(id string, appName string, status string, created time.Time) *types.Deployment {
+	ts := created.Format(time.RFC3339)
+	return &types.Deployment{
+		Id:           id,
+		Status:       status,
+		Created:      ts,
+		LastModified: ts,
+		Descriptor:   &types.Descriptor{Namespace: "test", AppName: appName},
+	}
+}
+
+func ids(deployments []*types.Deployment) map[string]bool {
+	result := map[string]bool{}
+	for _, deployment := range deployments {
+		result[deployment.Id] = true
+	}
+	return result
+}
+
+func TestSelectDeployments(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+
+	deployments := []*types.Deployment{
+		newDeployment("1", "myapp", types.DEPLOYMENTSTATUS_DEPLOYED, now.Add(-50*day)),
+		newDeployment("2", "myapp", types.DEPLOYMENTSTATUS_FAILURE, now.Add(-40*day)),
+		newDeployment("3", "myapp", types.DEPLOYMENTSTATUS_FAILURE, now.Add(-30*day)),
+		newDeployment("4", "myapp", types.DEPLOYMENTSTATUS_UNDEPLOYED, now.Add(-20*day)),
+		newDeployment("5", "myapp", types.DEPLOYMENTSTATUS_FAILURE, now.Add(-1*day)),
+		newDeployment("6", "otherapp", types.DEPLOYMENTSTATUS_FAILURE, now.Add(-40*day)),
+	}
+
+	expired, staleLogs := SelectDeployments(deployments, Retention{KeepDeployments: 3, LogDays: 14}, now)
+
+	// 5, 4 and 3 are the last 3, 1 is deployed
+	if expiredIds := ids(expired); len(expiredIds) != 1 || !expiredIds["2"] {
+		t.Errorf("Expected deployment 2 to expire, got %v", expiredIds)
+	}
+	if staleIds := ids(staleLogs); len(staleIds) != 3 || !staleIds["3"] || !staleIds["4"] || !staleIds["6"] {
+		t.Errorf("Expected stale logs of deployments 3, 4 and 6, got %v", staleIds)
+	}
+}
+
+func TestSelectDeploymentsKeepsEverything(t *testing.T) {
+	deployments := []*types.Deployment{
+		newDeployment("1", "myapp", types.DEPLOYMENTSTATUS_FAILURE, time.Now().Add(-1000*time.Hour)),
+		newDeployment("2", "myapp", types.DEPLOYMENTSTATUS_FAILURE, time.Now()),
+	}
+
+	expired, staleLogs := SelectDeployments(deployments, Retention{}, time.Now())
+	if len(expired) != 0 || len(staleLogs) != 0 {
+		t.Errorf("Expected nothing to be selected, got %v and %v", len(expired), len(staleLogs))
+	}
+}
+
+func TestSelectDeploymentsKeepsPaused(t *testing.T) {
+	now := time.Now()
+	deployments := []*types.Deployment{
+		newDeployment("1", "myapp", types.DEPLOYMENTSTATUS_PAUSED, now.Add(-50*24*time.Hour)),
+		newDeployment("2", "myapp", types.DEPLOYMENTSTATUS_FAILURE, now),
+	}
+
+	expired, staleLogs := SelectDeployments(deployments, Retention{KeepDeployments: 1, LogDays: 14}, now)
+	if len(expired) != 0 || len(staleLogs) != 0 {
+		t.Errorf("Expected the paused deployment to be kept, got %v and %v", ids(expired), ids(staleLogs))
+	}
+}
+
+func TestIsOrphan(t *testing.T) {
+	if !IsOrphan(map[string]string{"app": "myapp", "version": "2"}, "") {
+		t.Error("Expected objects of apps without deployed version to be orphans")
+	}
+	if IsOrphan(map[string]string{"app": "myapp", "version": "2"}, "2") {
+		t.Error("Expected objects of the deployed version not to be orphans")
+	}
+	if !IsOrphan(map[string]string{"app": "myapp", "version": "1"}, "2") {
+		t.Error("Expected objects of other versions to be orphans")
+	}
+	if IsOrphan(map[string]string{"app": "myapp", "persistent": "true", "version": "1"}, "2") {
+		t.Error("Expected persistent service of a deployed app not to be an orphan")
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	SENSITIVE_EMAIL    = "email"
+	SENSITIVE_PASSWORD = "password"
+	// all container env values and injected environment vars, "env.NAME" for a single env var
+	SENSITIVE_ENV = "env"
+)
+
+// value returned instead of sensitive values
+const REDACTED = "<redacted>"
+
+// SensitiveFields lists the descriptor fields which are encrypted in the registry and redacted in API responses
+type SensitiveFields []string
+
+var DefaultSensitiveFields = SensitiveFields{SENSITIVE_EMAIL, SENSITIVE_PASSWORD}
+var AllSensitiveFields = SensitiveFields{SENSITIVE_EMAIL, SENSITIVE_PASSWORD, SENSITIVE_ENV}
+
+// ParseSensitiveFields parses a comma separated list of sensitive fields
+func ParseSensitiveFields(value string) (SensitiveFields, error) {
+	fields := SensitiveFields{}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if field != SENSITIVE_EMAIL && field != SENSITIVE_PASSWORD && field != SENSITIVE_ENV && !strings.HasPrefix(field, SENSITIVE_ENV+".") {
+			return nil, fmt.Errorf("Unknown sensitive field %v, use email, password, env or env.NAME", field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func (fields SensitiveFields) includes(field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// IncludesEnv returns if the environment var with the given name is sensitive
+func (fields SensitiveFields) IncludesEnv(name string) bool {
+	return fields.includes(SENSITIVE_ENV) || fields.includes(SENSITIVE_ENV+"."+name)
+}
+
+// Transform replaces the non empty values of the sensitive fields of the descriptor with the result of the given function
+func (fields SensitiveFields) Transform(descriptor *Descriptor, transform func(string) (string, error)) error {
+	if descriptor == nil {
+		return nil
+	}
+	apply := func(value *string) error {
+		if *value == "" {
+			return nil
+		}
+		transformed, err := transform(*value)
+		if err != nil {
+			return err
+		}
+		*value = transformed
+		return nil
+	}
+
+	if fields.includes(SENSITIVE_EMAIL) {
+		if err := apply(&descriptor.Email); err != nil {
+			return err
+		}
+	}
+	if fields.includes(SENSITIVE_PASSWORD) {
+		if err := apply(&descriptor.Password); err != nil {
+			return err
+		}
+	}
+	for i := range descriptor.PodSpec.Containers {
+		env := descriptor.PodSpec.Containers[i].Env
+		for j := range env {
+			if fields.IncludesEnv(env[j].Name) {
+				if err := apply(&env[j].Value); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for name, value := range descriptor.Environment {
+		if fields.IncludesEnv(name) {
+			if err := apply(&value); err != nil {
+				return err
+			}
+			descriptor.Environment[name] = value
+		}
+	}
+	return nil
+}
+
+// Redact replaces the values of the sensitive fields of the descriptor
+func (fields SensitiveFields) Redact(descriptor *Descriptor) {
+	fields.Transform(descriptor, func(string) (string, error) {
+		return REDACTED, nil
+	})
+}
+
+// RestoreRedacted replaces redacted values of the descriptor with the values of the previous descriptor,
+// so a redacted descriptor can be updated without knowing the sensitive values
+func RestoreRedacted(descriptor *Descriptor, previous *Descriptor) {
+	if descriptor.Email == REDACTED {
+		descriptor.Email = previous.Email
+	}
+	if descriptor.Password == REDACTED {
+		descriptor.Password = previous.Password
+	}
+	previousEnv := map[string]string{}
+	for _, container := range previous.PodSpec.Containers {
+		for _, env := range container.Env {
+			previousEnv[container.Name+"/"+env.Name] = env.Value
+		}
+	}
+	for i, container := range descriptor.PodSpec.Containers {
+		for j, env := range container.Env {
+			if env.Value == REDACTED {
+				descriptor.PodSpec.Containers[i].Env[j].Value = previousEnv[container.Name+"/"+env.Name]
+			}
+		}
+	}
+}
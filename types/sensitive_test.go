@@ -0,0 +1,55 @@
+package types
+
+import (
+	"testing"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func sensitiveDescriptor() *Descriptor {
+	return &Descriptor{
+		Email:       "me@example.com",
+		Password:    "secret",
+		Environment: map[string]string{"TOKEN": "t", "PLAIN": "p"},
+		PodSpec: v1.PodSpec{Containers: []v1.Container{{
+			Name: "app",
+			Env:  []v1.EnvVar{{Name: "TOKEN", Value: "t"}, {Name: "PLAIN", Value: "p"}},
+		}}},
+	}
+}
+
+func TestParseSensitiveFields(t *testing.T) {
+	fields, err := ParseSensitiveFields("email, env.TOKEN,")
+	if err != nil || len(fields) != 2 || !fields.IncludesEnv("TOKEN") || fields.IncludesEnv("PLAIN") {
+		t.Errorf("Unexpected fields %v, %v", fields, err)
+	}
+	if _, err := ParseSensitiveFields("email,image"); err == nil {
+		t.Error("Expected error for unknown field")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	descriptor := sensitiveDescriptor()
+
+	SensitiveFields{SENSITIVE_PASSWORD, "env.TOKEN"}.Redact(descriptor)
+
+	if descriptor.Email != "me@example.com" || descriptor.Password != REDACTED {
+		t.Errorf("Unexpected redacted descriptor %+v", descriptor)
+	}
+	env := descriptor.PodSpec.Containers[0].Env
+	if env[0].Value != REDACTED || env[1].Value != "p" || descriptor.Environment["TOKEN"] != REDACTED || descriptor.Environment["PLAIN"] != "p" {
+		t.Errorf("Unexpected redacted env %v, %v", env, descriptor.Environment)
+	}
+}
+
+func TestRestoreRedacted(t *testing.T) {
+	descriptor := sensitiveDescriptor()
+	AllSensitiveFields.Redact(descriptor)
+	descriptor.Email = "new@example.com"
+
+	RestoreRedacted(descriptor, sensitiveDescriptor())
+
+	if descriptor.Email != "new@example.com" || descriptor.Password != "secret" || descriptor.PodSpec.Containers[0].Env[0].Value != "t" {
+		t.Errorf("Unexpected restored descriptor %+v", descriptor)
+	}
+}
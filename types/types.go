@@ -20,9 +20,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/pkg/api/v1"
 )
 
@@ -31,20 +35,39 @@ const DEPLOYMENTSTATUS_DEPLOYED = "DEPLOYED"
 const DEPLOYMENTSTATUS_UNDEPLOYING = "UNDEPLOYING"
 const DEPLOYMENTSTATUS_UNDEPLOYED = "UNDEPLOYED"
 const DEPLOYMENTSTATUS_FAILURE = "FAILURE"
+const DEPLOYMENTSTATUS_PAUSING = "PAUSING"
+const DEPLOYMENTSTATUS_PAUSED = "PAUSED"
+const DEPLOYMENTSTATUS_RESUMING = "RESUMING"
 
 const DNS952LabelFmt string = "[a-z]([-a-z0-9]*[a-z0-9])?"
 
 var dns952LabelRegexp = regexp.MustCompile("^" + DNS952LabelFmt + "$")
 
+var percentageRegexp = regexp.MustCompile(`^([0-9]+)%$`)
+
+// valid names of config files, which are keys of a ConfigMap
+var configFileNameRegexp = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+// max total size of the config files, the size limit of a ConfigMap
+const MAX_CONFIGFILES_SIZE = 1024 * 1024
+
+// a "${VAR}" placeholder, which is resolved at deploy time
+var PlaceholderRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+var replicasPlaceholderRegexp = regexp.MustCompile("^" + PlaceholderRegexp.String() + "$")
+
 type Descriptor struct {
 	Id                         string            `json:"id,omitempty"`
 	Created                    string            `json:"created,omitempty"`
 	LastModified               string            `json:"lastModified,omitempty"`
+	Revision                   int               `json:"revision,omitempty"`
+	ModifiedBy                 string            `json:"modifiedBy,omitempty"`
 	WebHooks                   []WebHook         `json:"webhooks,omitempty"`
 	DeploymentType             string            `json:"deploymentType,omitempty"`
 	NewVersion                 string            `json:"newVersion,omitempty"`
 	AppName                    string            `json:"appName,omitempty"`
 	Replicas                   int               `json:"replicas,omitempty"`
+	Autoscaling                *Autoscaling      `json:"autoscaling,omitempty"`
+	DisruptionBudget           *DisruptionBudget `json:"disruptionBudget,omitempty"`
 	Frontend                   string            `json:"frontend,omitempty"`
 	RedirectWww                bool              `json:"redirectWww,omitempty"`
 	PodSpec                    v1.PodSpec        `json:"podspec,omitempty"`
@@ -52,6 +75,7 @@ type Descriptor struct {
 	Email                      string            `json:"email,omitempty"`
 	Password                   string            `json:"password,omitempty"`
 	Environment                map[string]string `json:"environment,omitempty"`
+	ConfigFiles                []ConfigFile      `json:"configFiles,omitempty"`
 	UseCompression             bool              `json:"useCompression,omitempty"`
 	UseStickySessions          bool              `json:"useStickySessions,omitempty"`
 	TlsSecretName              string            `json:"tlsSecretName,omitempty"`
@@ -66,6 +90,113 @@ type Descriptor struct {
 	ReadinessPort              int               `json:"readinessPort,omitempty"`
 	Deprecated_DeployedVersion string            `json:"deployedVersion,omitempty"`
 	Deprecated_DeploymentTs    string            `json:"deploymentTs,omitempty"`
+	// replicas given as placeholder, written as "replicas" instead of the number
+	ReplicasPlaceholder string `json:"-"`
+	// booleans and numbers given as false or 0, they are written although they are empty, so they override defaults
+	ExplicitZeros []string `json:"-"`
+	// secret environment vars are injected from a Kubernetes Secret, their values are never stored with the deployment
+	SecretEnvironment map[string]string `json:"-"`
+	// values of the secret references of the container env vars, by their key in the provider secret
+	ProviderSecrets map[string]string `json:"-"`
+}
+
+// plain has the fields of a descriptor, without its JSON methods
+type plain Descriptor
+
+// zeroValues are the JSON values of the boolean and number fields of a descriptor which are omitted when empty, by JSON name
+var zeroValues = func() map[string]string {
+	values := map[string]string{}
+	descriptorType := reflect.TypeOf(plain{})
+	for i := 0; i < descriptorType.NumField(); i++ {
+		field := descriptorType.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		switch field.Type.Kind() {
+		case reflect.Bool:
+			values[name] = "false"
+		case reflect.Int:
+			values[name] = "0"
+		}
+	}
+	return values
+}()
+
+// MarshalJSON writes a replicas placeholder instead of the replicas number, if there is one,
+// and the explicit zeros which are still empty
+func (descriptor Descriptor) MarshalJSON() ([]byte, error) {
+	var data []byte
+	var err error
+	if descriptor.ReplicasPlaceholder == "" {
+		data, err = json.Marshal(plain(descriptor))
+	} else {
+		data, err = json.Marshal(struct {
+			plain
+			Replicas string `json:"replicas"`
+		}{plain(descriptor), descriptor.ReplicasPlaceholder})
+	}
+	if err != nil || len(descriptor.ExplicitZeros) == 0 {
+		return data, err
+	}
+
+	values := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	for _, name := range descriptor.ExplicitZeros {
+		if _, present := values[name]; !present {
+			values[name] = json.RawMessage(zeroValues[name])
+		}
+	}
+	return json.Marshal(values)
+}
+
+// UnmarshalJSON accepts the replicas as number, or as "${VAR}" placeholder
+func (descriptor *Descriptor) UnmarshalJSON(data []byte) error {
+	values := struct {
+		*plain
+		Replicas json.RawMessage `json:"replicas,omitempty"`
+	}{plain: (*plain)(descriptor)}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+
+	// remember explicit zeros, which would be omitted otherwise
+	descriptor.ExplicitZeros = nil
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for name, value := range fields {
+		if zero, ok := zeroValues[name]; ok && isZero(value, zero) {
+			descriptor.ExplicitZeros = append(descriptor.ExplicitZeros, name)
+		}
+	}
+	sort.Strings(descriptor.ExplicitZeros)
+
+	descriptor.ReplicasPlaceholder = ""
+	if len(values.Replicas) == 0 || string(values.Replicas) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(values.Replicas, &descriptor.Replicas); err == nil {
+		return nil
+	}
+
+	var placeholder string
+	if err := json.Unmarshal(values.Replicas, &placeholder); err != nil || !replicasPlaceholderRegexp.MatchString(placeholder) {
+		return fmt.Errorf("replicas must be a number or a ${VAR} placeholder, got %v", string(values.Replicas))
+	}
+	descriptor.ReplicasPlaceholder = placeholder
+	return nil
+}
+
+func isZero(value json.RawMessage, zero string) bool {
+	if zero == "false" {
+		return strings.TrimSpace(string(value)) == "false"
+	}
+	number, err := strconv.ParseFloat(strings.TrimSpace(string(value)), 64)
+	return err == nil && number == 0
 }
 
 func (descriptor *Descriptor) SetDefaults() *Descriptor {
@@ -82,6 +213,10 @@ func (descriptor *Descriptor) SetDefaults() *Descriptor {
 		descriptor.Replicas = 1
 	}
 
+	if descriptor.Autoscaling != nil && descriptor.Autoscaling.MinReplicas <= 0 {
+		descriptor.Autoscaling.MinReplicas = 1
+	}
+
 	if len(descriptor.PodSpec.RestartPolicy) == 0 {
 		descriptor.PodSpec.RestartPolicy = v1.RestartPolicyAlways
 	}
@@ -158,6 +293,54 @@ func (descriptor *Descriptor) Validate() error {
 		messageBuffer.WriteString(fmt.Sprintf("Frontend Url %v must not contain the protocol (e.g. https://)\n", descriptor.Frontend))
 	}
 
+	if autoscaling := descriptor.Autoscaling; autoscaling != nil {
+		if autoscaling.MaxReplicas < 1 || autoscaling.MaxReplicas < autoscaling.MinReplicas {
+			messageBuffer.WriteString(fmt.Sprintf("Autoscaling maxReplicas %v must be at least 1 and at least minReplicas\n", autoscaling.MaxReplicas))
+		}
+		if autoscaling.TargetCPUUtilization < 0 || autoscaling.TargetMemoryUtilization < 0 {
+			messageBuffer.WriteString("Autoscaling targets must be positive percentages\n")
+		} else if autoscaling.TargetCPUUtilization == 0 && autoscaling.TargetMemoryUtilization == 0 {
+			messageBuffer.WriteString("Autoscaling needs a targetCPUUtilization or targetMemoryUtilization\n")
+		}
+	}
+
+	if budget := descriptor.DisruptionBudget; budget != nil {
+		if (budget.MinAvailable == nil) == (budget.MaxUnavailable == nil) {
+			messageBuffer.WriteString("Disruption budget needs either minAvailable or maxUnavailable\n")
+		}
+		for _, value := range []*intstr.IntOrString{budget.MinAvailable, budget.MaxUnavailable} {
+			if value != nil && !isValidIntOrPercentage(value) {
+				messageBuffer.WriteString(fmt.Sprintf("Disruption budget value %v must be a positive number or a percentage\n", value.String()))
+			}
+		}
+		// the Kubernetes API only knows minAvailable, which changes with the replicas for a fixed maxUnavailable
+		if descriptor.Autoscaling != nil && budget.MaxUnavailable != nil && budget.MaxUnavailable.Type == intstr.Int {
+			messageBuffer.WriteString("Disruption budget maxUnavailable must be a percentage for apps with autoscaling\n")
+		}
+	}
+
+	names := map[string]bool{}
+	mountPaths := map[string]bool{}
+	size := 0
+	for _, file := range descriptor.ConfigFiles {
+		if !configFileNameRegexp.MatchString(file.Name) || file.Name == "." || file.Name == ".." {
+			messageBuffer.WriteString(fmt.Sprintf("Config file name '%v' doesn't match pattern [-._a-zA-Z0-9]+\n", file.Name))
+		} else if names[file.Name] {
+			messageBuffer.WriteString(fmt.Sprintf("Duplicate config file name %v\n", file.Name))
+		}
+		if !strings.HasPrefix(file.MountPath, "/") || strings.HasSuffix(file.MountPath, "/") {
+			messageBuffer.WriteString(fmt.Sprintf("Mount path '%v' of config file %v must be an absolute file path\n", file.MountPath, file.Name))
+		} else if mountPaths[file.MountPath] {
+			messageBuffer.WriteString(fmt.Sprintf("Duplicate config file mount path %v\n", file.MountPath))
+		}
+		names[file.Name] = true
+		mountPaths[file.MountPath] = true
+		size += len(file.Content)
+	}
+	if size > MAX_CONFIGFILES_SIZE {
+		messageBuffer.WriteString(fmt.Sprintf("Config files are too large, a maximum of %v bytes is allowed\n", MAX_CONFIGFILES_SIZE))
+	}
+
 	message := messageBuffer.String()
 
 	if len(message) > 0 {
@@ -167,6 +350,18 @@ func (descriptor *Descriptor) Validate() error {
 	return nil
 }
 
+func isValidIntOrPercentage(value *intstr.IntOrString) bool {
+	if value.Type == intstr.Int {
+		return value.IntVal >= 0
+	}
+	match := percentageRegexp.FindStringSubmatch(value.StrVal)
+	if match == nil {
+		return false
+	}
+	percentage, err := strconv.Atoi(match[1])
+	return err == nil && percentage <= 100
+}
+
 func (descriptor *Descriptor) String() string {
 	b, err := json.MarshalIndent(descriptor, "", "    ")
 
@@ -177,6 +372,29 @@ func (descriptor *Descriptor) String() string {
 	return string(b)
 }
 
+// SetEnvironment sets the environment vars which are injected by the deployer, split into plain and secret vars
+func (descriptor *Descriptor) SetEnvironment(vars map[string]EnvironmentVar) {
+	descriptor.Environment = map[string]string{}
+	descriptor.SecretEnvironment = map[string]string{}
+	for name, envVar := range vars {
+		if envVar.Secret {
+			descriptor.SecretEnvironment[name] = envVar.Value
+		} else {
+			descriptor.Environment[name] = envVar.Value
+		}
+	}
+}
+
+// EnvironmentSecretName returns the name of the Kubernetes Secret with the secret environment vars of the app
+func (descriptor *Descriptor) EnvironmentSecretName() string {
+	return descriptor.AppName + "-environment"
+}
+
+// ProviderSecretName returns the name of the Kubernetes Secret with the values of the secret references of the app
+func (descriptor *Descriptor) ProviderSecretName() string {
+	return descriptor.AppName + "-secrets"
+}
+
 type Deployment struct {
 	Id           string      `json:"id,omitempty"`
 	Created      string      `json:"created,omitempty"`
@@ -184,7 +402,17 @@ type Deployment struct {
 	Version      string      `json:"version,omitempty"`
 	Status       string      `json:"status,omitempty"`
 	Descriptor   *Descriptor `json:"descriptor,omitempty"`
-	OldVersion   string
+	// the revision of the descriptor this deployment was started with
+	DescriptorRevision int `json:"descriptorRevision,omitempty"`
+	// the parameters for resolving the descriptor's placeholders
+	Parameters map[string]string `json:"parameters,omitempty"`
+	OldVersion string
+	// hash of the values of the referenced provider secrets, for detecting changes
+	SecretsHash string `json:"secretsHash,omitempty"`
+	// the replicas the deployment was scaled to, overriding the replicas of the descriptor
+	Replicas int `json:"replicas,omitempty"`
+	// the replicas of the replication controller when the deployment was paused, restored on resume
+	PausedReplicas int `json:"pausedReplicas,omitempty"`
 }
 
 func (deployment *Deployment) SetVersion() {
@@ -200,6 +428,23 @@ func (deployment *Deployment) SetVersion() {
 	}
 }
 
+// GetReplicas returns the replicas the deployment was scaled to, or the replicas of the descriptor if it wasn't scaled
+func (deployment *Deployment) GetReplicas() int {
+	if deployment.Replicas > 0 {
+		return deployment.Replicas
+	}
+	return deployment.Descriptor.Replicas
+}
+
+// IsBusy returns whether the deployment is being deployed, undeployed, paused or resumed
+func (deployment *Deployment) IsBusy() bool {
+	switch deployment.Status {
+	case DEPLOYMENTSTATUS_DEPLOYING, DEPLOYMENTSTATUS_UNDEPLOYING, DEPLOYMENTSTATUS_PAUSING, DEPLOYMENTSTATUS_RESUMING:
+		return true
+	}
+	return false
+}
+
 func (deployment *Deployment) GetVersionedName() string {
 	return deployment.Descriptor.AppName + "-" + deployment.Version
 }
@@ -219,6 +464,35 @@ type WebHook struct {
 	Key         string `json:"key,omitempty"`
 }
 
+// EnvironmentVar is an environment var injected by the deployer
+type EnvironmentVar struct {
+	Value  string `json:"value"`
+	Secret bool   `json:"secret,omitempty"`
+}
+
+// Autoscaling configures a Horizontal Pod Autoscaler, which manages the replicas of the app instead of the replicas property.
+// Targets are percentages of the requested resources of the containers.
+type Autoscaling struct {
+	MinReplicas             int `json:"minReplicas,omitempty"`
+	MaxReplicas             int `json:"maxReplicas"`
+	TargetCPUUtilization    int `json:"targetCPUUtilization,omitempty"`
+	TargetMemoryUtilization int `json:"targetMemoryUtilization,omitempty"`
+}
+
+// DisruptionBudget configures a PodDisruptionBudget for the pods of all versions of the app, with either minAvailable
+// or maxUnavailable, as number of pods or as percentage like "50%"
+type DisruptionBudget struct {
+	MinAvailable   *intstr.IntOrString `json:"minAvailable,omitempty"`
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// ConfigFile is mounted read only at its mount path into all containers, from the versioned ConfigMap of the deployment
+type ConfigFile struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+	Content   string `json:"content"`
+}
+
 type HttpHeader struct {
 	Header string `json:"Header,omitempty"`
 	Value  string `json:"Value,omitempty"`
@@ -228,3 +502,54 @@ type HealthData struct {
 	PodName string `json:"podName"`
 	Value   string `json:"value"`
 }
+
+// Notification is an outgoing webhook, which is called on deployment lifecycle events.
+// Without namespace it's global, without appName it's valid for all apps in the namespace.
+type Notification struct {
+	Id           string   `json:"id,omitempty"`
+	Created      string   `json:"created,omitempty"`
+	LastModified string   `json:"lastModified,omitempty"`
+	Namespace    string   `json:"namespace,omitempty"`
+	AppName      string   `json:"appName,omitempty"`
+	Url          string   `json:"url,omitempty"`
+	Secret       string   `json:"secret,omitempty"`
+	Events       []string `json:"events,omitempty"`
+	Description  string   `json:"description,omitempty"`
+}
+
+func (notification *Notification) Validate() error {
+
+	var messageBuffer bytes.Buffer
+
+	if !strings.HasPrefix(notification.Url, "http://") && !strings.HasPrefix(notification.Url, "https://") {
+		messageBuffer.WriteString(fmt.Sprintf("Url '%v' must be an absolute http(s) url\n", notification.Url))
+	}
+
+	if notification.AppName != "" && notification.Namespace == "" {
+		messageBuffer.WriteString("Namespace is required when appName is set\n")
+	}
+
+	message := messageBuffer.String()
+
+	if len(message) > 0 {
+		return errors.New(message)
+	}
+
+	return nil
+}
+
+type NotificationDelivery struct {
+	Id             string                `json:"id"`
+	NotificationId string                `json:"notificationId"`
+	Event          string                `json:"event"`
+	DeploymentId   string                `json:"deploymentId,omitempty"`
+	Url            string                `json:"url"`
+	Success        bool                  `json:"success"`
+	Attempts       []NotificationAttempt `json:"attempts"`
+}
+
+type NotificationAttempt struct {
+	Time       string `json:"time"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
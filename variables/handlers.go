@@ -0,0 +1,117 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package variables
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/helper"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/logger"
+	"github.com/gorilla/mux"
+)
+
+type Variable struct {
+	Value string `json:"value"`
+}
+
+type VariableHandlers struct {
+	registry *etcdregistry.EtcdRegistry
+}
+
+func NewVariableHandlers(registry *etcdregistry.EtcdRegistry) *VariableHandlers {
+	return &VariableHandlers{registry}
+}
+
+func (v *VariableHandlers) ListVariablesHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	//TODO check namespaces of user
+	namespace := req.URL.Query().Get("namespace")
+	if namespace == "" {
+		helper.HandleError(writer, logger, 400, "Namespace parameter missing")
+		return
+	}
+
+	variables, err := v.registry.GetVariables(namespace)
+	if err != nil {
+		helper.HandleError(writer, logger, 500, "Error getting variables of namespace %v: %v", namespace, err)
+		return
+	}
+
+	helper.HandleSuccess(writer, logger, variables, "Listed variables of namespace %v", namespace)
+}
+
+func (v *VariableHandlers) SetVariableHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	//TODO check namespaces of user
+	namespace := req.URL.Query().Get("namespace")
+	if namespace == "" {
+		helper.HandleError(writer, logger, 400, "Namespace parameter missing")
+		return
+	}
+
+	name := mux.Vars(req)["name"]
+	if !IsValidName(name) {
+		helper.HandleError(writer, logger, 400, "Invalid variable name %v, use letters, digits and underscores", name)
+		return
+	}
+
+	defer req.Body.Close()
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		helper.HandleError(writer, logger, 500, "Error reading body: %v", err)
+		return
+	}
+	variable := Variable{}
+	if err := json.Unmarshal(body, &variable); err != nil {
+		helper.HandleError(writer, logger, 400, "Error parsing body: %v", err)
+		return
+	}
+
+	if err := v.registry.SetVariable(namespace, name, variable.Value); err != nil {
+		helper.HandleError(writer, logger, 500, "Error storing variable %v: %v", name, err)
+		return
+	}
+
+	helper.HandleSuccess(writer, logger, "", "Variable %v of namespace %v set", name, namespace)
+}
+
+func (v *VariableHandlers) DeleteVariableHandler(writer http.ResponseWriter, req *http.Request) {
+	logger := logger.NewConsoleLogger()
+
+	//TODO check namespaces of user
+	namespace := req.URL.Query().Get("namespace")
+	if namespace == "" {
+		helper.HandleError(writer, logger, 400, "Namespace parameter missing")
+		return
+	}
+
+	name := mux.Vars(req)["name"]
+	err := v.registry.DeleteVariable(namespace, name)
+	if err == etcdregistry.ErrVariableNotFound {
+		helper.HandleNotFound(writer, logger, "Variable %v not found", name)
+		return
+	} else if err != nil {
+		helper.HandleError(writer, logger, 500, "Error deleting variable %v: %v", name, err)
+		return
+	}
+
+	helper.HandleSuccess(writer, logger, "", "Variable %v of namespace %v deleted", name, namespace)
+}
@@ -0,0 +1,118 @@
+/*
+Copyright (c) 2016 The Amdatu Foundation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package variables
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/etcdregistry"
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+)
+
+// query parameters with this prefix are deployment parameters, e.g. param.TAG=1.0
+const PARAMETER_PREFIX = "param."
+
+var nameRegexp = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+
+// UnresolvedError is returned when placeholders have no value
+type UnresolvedError struct {
+	Names []string
+}
+
+func (e *UnresolvedError) Error() string {
+	return "Unresolved variables: " + strings.Join(e.Names, ", ")
+}
+
+func IsValidName(name string) bool {
+	return nameRegexp.MatchString(name)
+}
+
+// Parameters returns the deployment parameters of the query
+func Parameters(query url.Values) map[string]string {
+	parameters := map[string]string{}
+	for key, values := range query {
+		if strings.HasPrefix(key, PARAMETER_PREFIX) && len(values) > 0 {
+			parameters[strings.TrimPrefix(key, PARAMETER_PREFIX)] = values[0]
+		}
+	}
+	return parameters
+}
+
+// ResolveWithRegistry resolves the placeholders of the descriptor with the variables of its namespace,
+// overridden by the given deployment parameters
+func ResolveWithRegistry(registry *etcdregistry.EtcdRegistry, descriptor *types.Descriptor, parameters map[string]string) error {
+	variables, err := registry.GetVariables(descriptor.Namespace)
+	if err != nil {
+		return err
+	}
+	for name, value := range parameters {
+		variables[name] = value
+	}
+	return Resolve(descriptor, variables)
+}
+
+// Resolve replaces the placeholders in the frontend, container images, container env values and replicas of the descriptor.
+// It returns an UnresolvedError listing all placeholders without value.
+func Resolve(descriptor *types.Descriptor, variables map[string]string) error {
+	unresolved := map[string]bool{}
+	replace := func(value string) string {
+		return types.PlaceholderRegexp.ReplaceAllStringFunc(value, func(placeholder string) string {
+			name := placeholder[2 : len(placeholder)-1]
+			if resolved, ok := variables[name]; ok {
+				return resolved
+			}
+			unresolved[name] = true
+			return placeholder
+		})
+	}
+
+	descriptor.Frontend = replace(descriptor.Frontend)
+
+	for i := range descriptor.PodSpec.Containers {
+		container := &descriptor.PodSpec.Containers[i]
+		container.Image = replace(container.Image)
+		for j := range container.Env {
+			container.Env[j].Value = replace(container.Env[j].Value)
+		}
+	}
+
+	if descriptor.ReplicasPlaceholder != "" {
+		value := replace(descriptor.ReplicasPlaceholder)
+		if !types.PlaceholderRegexp.MatchString(value) {
+			replicas, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("Replicas %v is not a number", value)
+			}
+			descriptor.Replicas = replicas
+			descriptor.ReplicasPlaceholder = ""
+		}
+	}
+
+	if len(unresolved) > 0 {
+		names := []string{}
+		for name := range unresolved {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return &UnresolvedError{names}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package variables
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"bitbucket.org/amdatulabs/amdatu-kubernetes-deployer/types"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestResolve(t *testing.T) {
+	descriptor := &types.Descriptor{
+		Frontend:            "${APP}.${DOMAIN}",
+		ReplicasPlaceholder: "${REPLICAS}",
+		PodSpec: v1.PodSpec{Containers: []v1.Container{{
+			Image: "user/myapp:${TAG}",
+			Env:   []v1.EnvVar{{Name: "DB", Value: "db.${DOMAIN}"}, {Name: "PLAIN", Value: "plain"}},
+		}}},
+	}
+
+	err := Resolve(descriptor, map[string]string{"APP": "myapp", "DOMAIN": "example.com", "TAG": "1.0", "REPLICAS": "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if descriptor.Frontend != "myapp.example.com" {
+		t.Errorf("Unexpected frontend %v", descriptor.Frontend)
+	}
+	if descriptor.PodSpec.Containers[0].Image != "user/myapp:1.0" {
+		t.Errorf("Unexpected image %v", descriptor.PodSpec.Containers[0].Image)
+	}
+	if descriptor.PodSpec.Containers[0].Env[0].Value != "db.example.com" || descriptor.PodSpec.Containers[0].Env[1].Value != "plain" {
+		t.Errorf("Unexpected env %v", descriptor.PodSpec.Containers[0].Env)
+	}
+	if descriptor.Replicas != 3 || descriptor.ReplicasPlaceholder != "" {
+		t.Errorf("Unexpected replicas %v %v", descriptor.Replicas, descriptor.ReplicasPlaceholder)
+	}
+}
+
+func TestResolveUnresolved(t *testing.T) {
+	descriptor := &types.Descriptor{
+		Frontend: "${HOST}",
+		PodSpec:  v1.PodSpec{Containers: []v1.Container{{Image: "user/myapp:${TAG}"}}},
+	}
+
+	err := Resolve(descriptor, map[string]string{})
+	unresolved, ok := err.(*UnresolvedError)
+	if !ok {
+		t.Fatalf("Expected UnresolvedError, got %v", err)
+	}
+	if !reflect.DeepEqual(unresolved.Names, []string{"HOST", "TAG"}) {
+		t.Errorf("Unexpected unresolved names %v", unresolved.Names)
+	}
+}
+
+func TestResolveInvalidReplicas(t *testing.T) {
+	descriptor := &types.Descriptor{ReplicasPlaceholder: "${REPLICAS}"}
+
+	if err := Resolve(descriptor, map[string]string{"REPLICAS": "many"}); err == nil {
+		t.Error("Expected error for non numeric replicas")
+	}
+}
+
+func TestParameters(t *testing.T) {
+	query := url.Values{"namespace": {"test"}, "param.TAG": {"1.0"}, "param.HOST": {"a", "b"}}
+
+	parameters := Parameters(query)
+
+	if !reflect.DeepEqual(parameters, map[string]string{"TAG": "1.0", "HOST": "a"}) {
+		t.Errorf("Unexpected parameters %v", parameters)
+	}
+}
+
+func TestReplicasPlaceholderJson(t *testing.T) {
+	descriptor := &types.Descriptor{}
+	if err := json.Unmarshal([]byte(`{"appName":"myapp","replicas":"${REPLICAS}"}`), descriptor); err != nil {
+		t.Fatal(err)
+	}
+	if descriptor.ReplicasPlaceholder != "${REPLICAS}" {
+		t.Errorf("Unexpected replicas placeholder %v", descriptor.ReplicasPlaceholder)
+	}
+
+	data, err := json.Marshal(descriptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundtrip := &types.Descriptor{}
+	if err := json.Unmarshal(data, roundtrip); err != nil {
+		t.Fatal(err)
+	}
+	if roundtrip.ReplicasPlaceholder != "${REPLICAS}" || roundtrip.AppName != "myapp" {
+		t.Errorf("Unexpected roundtrip %v", string(data))
+	}
+
+	if err := json.Unmarshal([]byte(`{"replicas":"three"}`), &types.Descriptor{}); err == nil {
+		t.Error("Expected error for invalid replicas")
+	}
+}